@@ -0,0 +1,48 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbbreviatedMetadata(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	registry.abbreviated = true
+
+	// Licenses are absent from abbreviated metadata but still come from
+	// the version documents.
+	packages := getLicenseFilter(t, registry, "licenseFilter=GPL-3.0")
+	require.Len(t, packages, 1)
+	assert.Equal(t, "gpl-lib", packages[0].Name)
+	assert.Equal(t, 5, registry.AbbreviatedServed())
+}
+
+func TestAbbreviatedMetadataNotAcceptable(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.1.0": {}},
+	})
+	target, err := url.Parse(registry.URL)
+	require.Nil(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	strict := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "application/vnd.npm.install-v1+json") {
+			http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer strict.Close()
+	strictRegistry := &registryServer{Server: strict}
+
+	tree := getTree(t, strictRegistry, "/package/app/1.0.0")
+	assert.Equal(t, "1.1.0", tree.Dependencies["lib"].Version)
+	assert.Zero(t, registry.AbbreviatedServed())
+}
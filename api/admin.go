@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// cacheStats describes the use of one cache. Bytes approximates the memory
+// held by its entries as the size of their JSON encoding.
+type cacheStats struct {
+	Entries  int     `json:"entries"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+	Bytes    int64   `json:"approximateBytes"`
+}
+
+func newCacheStats(entries int, hits, misses uint64) cacheStats {
+	stats := cacheStats{Entries: entries, Hits: hits, Misses: misses}
+	if hits+misses > 0 {
+		stats.HitRatio = float64(hits) / float64(hits+misses)
+	}
+	return stats
+}
+
+func jsonSize(v any) int {
+	data, _ := json.Marshal(v)
+	return len(data)
+}
+
+type cacheStatsResponse struct {
+	Metadata cacheStats  `json:"metadata"`
+	Versions cacheStats  `json:"versions"`
+	NotFound cacheStats  `json:"notFound"`
+	Trees    cacheStats  `json:"trees"`
+	Disk     *cacheStats `json:"disk,omitempty"`
+}
+
+// cacheStatsHandler reports the use of each in-process cache, and of the
+// disk cache if there is one. Sizing the entries encodes each of them, so
+// it is meant for occasional operator use.
+func (s *server) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := &cacheStatsResponse{
+		Metadata: s.metaCache.entries.stats(func(meta *npmPackageMetaResponse) int { return jsonSize(meta) }),
+		Versions: s.metaCache.versions.stats(func(pkg *npmPackageResponse) int { return jsonSize(pkg) }),
+		NotFound: s.metaCache.notFound.stats(func(err error) int { return len(err.Error()) }),
+		Trees:    s.treeCache.stats(),
+	}
+	if s.disk != nil {
+		disk := s.disk.stats()
+		resp.Disk = &disk
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// cachePurgeHandler evicts everything cached about one package, such as a
+// bad or stale packument, from every cache.
+func (s *server) cachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("package")
+	resp := s.invalidatePackages(r.Context(), []string{name})
+	s.logger.Info("Cache purged", "package", name, "metadata", resp.Metadata, "trees", resp.Trees)
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+type cacheFlushResponse struct {
+	Metadata int `json:"metadata"`
+	Versions int `json:"versions"`
+	NotFound int `json:"notFound"`
+	Trees    int `json:"trees"`
+	Disk     int `json:"disk"`
+}
+
+// cacheFlushHandler empties the in-process and disk caches. The shared
+// cache is left alone, since other replicas rely on it; purge packages
+// individually to evict them from it.
+func (s *server) cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	resp := &cacheFlushResponse{
+		Metadata: s.metaCache.entries.clear(),
+		Versions: s.metaCache.versions.clear(),
+		NotFound: s.metaCache.notFound.clear(),
+		Trees:    s.treeCache.clear(),
+	}
+	if s.disk != nil {
+		resp.Disk = s.disk.clear()
+	}
+	s.logger.Info("Cache flushed", "metadata", resp.Metadata, "versions", resp.Versions, "trees", resp.Trees, "disk", resp.Disk)
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// requireAdmin serves requests bearing the admin token with handler and
+// answers others with a 401.
+func (s *server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeProblem(w, r, newProblem(problemUnauthorized, http.StatusUnauthorized, "This endpoint requires the admin token"))
+			return
+		}
+		handler(w, r)
+	}
+}
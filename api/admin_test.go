@@ -0,0 +1,119 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type cacheStats struct {
+	Entries  int     `json:"entries"`
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+	Bytes    int     `json:"approximateBytes"`
+}
+
+// adminToken is the token the tests' servers require for the cache
+// administration endpoints.
+const adminToken = "s3cret"
+
+// adminRequest sends a request bearing the admin token.
+func adminRequest(t *testing.T, server *httptest.Server, method, path string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, body)
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := server.Client().Do(req)
+	require.Nil(t, err)
+	return resp
+}
+
+func adminCache(t *testing.T, server *httptest.Server, method, path string, v any) {
+	t.Helper()
+	resp := adminRequest(t, server, method, path, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func TestAdminCache(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResultCacheTTL(time.Minute), api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	getTreeFrom(t, server, "/package/lib/1.0.0")
+
+	var stats struct {
+		Metadata cacheStats `json:"metadata"`
+		Versions cacheStats `json:"versions"`
+		Trees    cacheStats `json:"trees"`
+	}
+	adminCache(t, server, http.MethodGet, "/admin/cache", &stats)
+	assert.Equal(t, 2, stats.Metadata.Entries)
+	assert.Equal(t, 1, stats.Metadata.Hits)
+	assert.Equal(t, 2, stats.Metadata.Misses)
+	assert.InDelta(t, 1.0/3, stats.Metadata.HitRatio, 0.001)
+	assert.Positive(t, stats.Metadata.Bytes)
+	assert.Equal(t, 2, stats.Versions.Entries)
+	assert.Equal(t, 2, stats.Trees.Entries)
+
+	var purged struct {
+		Metadata int `json:"metadata"`
+		Trees    int `json:"trees"`
+	}
+	adminCache(t, server, http.MethodDelete, "/admin/cache/lib", &purged)
+	assert.Equal(t, 1, purged.Metadata)
+	assert.Equal(t, 2, purged.Trees)
+	fetched := len(registry.Requests())
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, []string{"/lib", "/lib/1.0.0"}, registry.Requests()[fetched:])
+
+	var flushed struct {
+		Metadata int `json:"metadata"`
+		Versions int `json:"versions"`
+		Trees    int `json:"trees"`
+	}
+	adminCache(t, server, http.MethodDelete, "/admin/cache", &flushed)
+	assert.Equal(t, 2, flushed.Metadata)
+	assert.Equal(t, 2, flushed.Versions)
+	assert.Equal(t, 1, flushed.Trees)
+	adminCache(t, server, http.MethodGet, "/admin/cache", &stats)
+	assert.Zero(t, stats.Metadata.Entries)
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + "/admin/cache")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "served without a token configured")
+
+	server = httptest.NewServer(api.New(api.WithAdminToken(adminToken)))
+	defer server.Close()
+	for _, auth := range []string{"", "Bearer wrong", adminToken} {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/cache/invalidate", strings.NewReader(`{"packages":["app"]}`))
+		require.Nil(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := server.Client().Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import "strings"
+
+const npmAliasProtocol = "npm:"
+
+// parseAlias splits an "npm:name@range" specifier, which installs the
+// named package under the dependency's own name, into the package and its
+// range. A specifier without a range, such as "npm:name", takes the
+// package's latest version.
+func parseAlias(spec string) (name, versionConstraint string, ok bool) {
+	aliased, ok := strings.CutPrefix(spec, npmAliasProtocol)
+	if !ok {
+		return "", "", false
+	}
+	// The "@" of a scope is not a version separator.
+	if i := strings.LastIndex(aliased, "@"); i > 0 && i < len(aliased)-1 {
+		return aliased[:i], aliased[i+1:], true
+	}
+	aliased = strings.TrimSuffix(aliased, "@")
+	return aliased, "latest", true
+}
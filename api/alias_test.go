@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestNpmAliases(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{
+			"lodash":        "^4.0.0",
+			"lodash-legacy": "npm:lodash@^3.0.0",
+			"core":          "npm:@babel/core@^7.0.0",
+			"latest-react":  "npm:react",
+		})},
+		"lodash":      {"3.10.1": {}, "4.17.21": {}},
+		"@babel/core": {"7.24.0": deps(map[string]string{"lodash": "^4.0.0"})},
+		"react":       {"18.2.0": {}, "18.3.1": {}},
+	})
+	registry.distTags = map[string]map[string]string{"react": {"latest": "18.2.0"}}
+
+	tree := getTree(t, registry, "/package/app/1.0.0")
+	require.Len(t, tree.Dependencies, 4)
+
+	assert.Equal(t, "lodash", tree.Dependencies["lodash"].Name)
+	assert.Equal(t, "4.17.21", tree.Dependencies["lodash"].Version)
+	assert.Empty(t, tree.Dependencies["lodash"].Alias)
+
+	legacy := tree.Dependencies["lodash-legacy"]
+	assert.Equal(t, "lodash", legacy.Name)
+	assert.Equal(t, "3.10.1", legacy.Version)
+	assert.Equal(t, "lodash-legacy", legacy.Alias)
+
+	core := tree.Dependencies["core"]
+	assert.Equal(t, "@babel/core", core.Name)
+	assert.Equal(t, "7.24.0", core.Version)
+	assert.Equal(t, "core", core.Alias)
+	assert.Equal(t, "4.17.21", core.Dependencies["lodash"].Version)
+
+	assert.Equal(t, "react", tree.Dependencies["latest-react"].Name)
+	assert.Equal(t, "18.2.0", tree.Dependencies["latest-react"].Version)
+}
+
+func TestNpmAliasesOfManifest(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"lodash": {"3.10.1": {}, "4.17.21": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(`{
+		"name": "my-app",
+		"dependencies": {"lodash-legacy": "npm:lodash@~3.10.0"}
+	}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	assert.Equal(t, "lodash", tree.Dependencies["lodash-legacy"].Name)
+	assert.Equal(t, "3.10.1", tree.Dependencies["lodash-legacy"].Version)
+	assert.Equal(t, "lodash-legacy", tree.Dependencies["lodash-legacy"].Alias)
+
+	resp, err = server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(`{
+		"name": "my-app",
+		"dependencies": {"lodash-legacy": "npm:lodash@~~3"}
+	}`))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
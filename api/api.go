@@ -1,98 +1,690 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 )
 
-func New() http.Handler {
+func New(opts ...Option) http.Handler {
+	return newServer(opts...).handler()
+}
+
+// NewWithGRPC returns the HTTP API and, as a separate handler to serve on
+// a port of its own, the gRPC service of proto/resolver.proto. The two
+// share their caches and registry limits.
+func NewWithGRPC(opts ...Option) (handler, grpcHandler http.Handler) {
+	s := newServer(opts...)
+	return s.handler(), s.grpcHandler()
+}
+
+// handler returns the HTTP API served by s.
+func (s *server) handler() http.Handler {
 	mux := http.NewServeMux()
 
-	handleInvalidPath(mux)
-	mux.HandleFunc("GET /package/{package}/{version}", packageHandler)
+	metrics := newRequestMetrics()
+	s.handleInvalidPath(mux)
+	for _, rt := range s.routes(metrics) {
+		mux.HandleFunc(rt.pattern(), rt.handler)
+	}
+	if s.profiling {
+		handleProfiling(mux)
+	}
+
+	return honorCacheBypass(joinScopedNames(metrics.instrument(mux)))
+}
+
+const (
+	defaultRegistryURL       = "https://registry.npmjs.org"
+	defaultMaxRecursionDepth = 1000
+)
+
+var (
+	errMaxRecursionDepth = errors.New("maximum recursion depth")
+	errMaxUniquePackages = errors.New("maximum unique packages")
+	// errPackageNotFound reports that the requested package, rather than
+	// one of its dependencies, does not exist.
+	errPackageNotFound = errors.New("package not found")
+	// errVersionNotFound reports that no published version of the
+	// requested package satisfies the requested constraint.
+	errVersionNotFound     = errors.New("version not found")
+	errNoCompatibleVersion = errors.New("no compatible versions found")
+)
+
+type server struct {
+	registryURLs []string
+	// scopeRegistries maps scopes, such as "@acme", to the registry that
+	// serves them; scopeFallback also consults registryURLs after it.
+	scopeRegistries map[string]string
+	scopeFallback   bool
+	// registryTokens maps registries, in the scheme-less form .npmrc uses,
+	// to the bearer tokens sent to them; "" holds the token for the
+	// default registries.
+	registryTokens map[string]string
+	// mirrors maps registries to the mirrors tried, in order, when they
+	// fail with a server error or time out.
+	mirrors map[string][]string
+	// registryTimeout bounds each request to a registry or mirror.
+	registryTimeout time.Duration
+	// retries is how many times a transient registry failure is retried,
+	// after backoff that starts at retryBackoff.
+	retries      int
+	retryBackoff time.Duration
+	client       *http.Client
+	// proxy, if set, carries registry requests in place of the proxy the
+	// environment names.
+	proxy       *url.URL
+	logger      *slog.Logger
+	logSampling logSampling
+	metaCache   *metaCache
+	// fetches shares registry requests between concurrent callers.
+	fetches   flightGroup[*registryResponse]
+	treeCache *treeCache
+	redis     *redisCache
+	disk      *diskCache
+	fetchSem  semaphore
+	// resolveSem, if set, lets dependencies be resolved in parallel and
+	// caps the goroutines doing so across all resolutions; without it they
+	// are resolved one after another.
+	resolveSem  semaphore
+	rateLimiter *rateLimiter
+	breakers    *circuitBreakers
+	profiling   bool
+	graphql     bool
+	// adminToken, if set, is the bearer token the cache administration
+	// endpoints require; without one they are not served.
+	adminToken string
+	// sortSelection selects versions by sorting every compatible version
+	// rather than scanning for the highest.
+	sortSelection bool
+	// strategy selects among compatible versions, unless
+	// strategyOverrides names a strategy for the package.
+	strategy          SelectionStrategy
+	strategyOverrides map[string]SelectionStrategy
+	// licensePolicy is checked against the trees of requests asking for
+	// it with ?policy=.
+	licensePolicy *LicensePolicy
+	// vulnerabilitySource is the database trees are scanned for
+	// vulnerabilities against, caching the findings for each package
+	// version in auditCache. osvURL is the OSV API it may query.
+	vulnerabilitySource VulnerabilitySource
+	osvURL              string
+	auditCache          *lruCache[[]vulnerability]
+
+	// resolveTimeout bounds how long a single resolution may take.
+	resolveTimeout time.Duration
+	// downloadBudget bounds the bytes a single resolution may download
+	// from the registry.
+	downloadBudget int64
+	// flattenThreshold is the node count above which a nested tree is
+	// returned as a flat list of unique packages instead.
+	flattenThreshold int
+
+	// maxRecursionDepth protects the process from pathologically deep
+	// dependency chains, independently of any client-requested depth.
+	maxRecursionDepth int
+	// maxUniquePackages protects the process from pathologically wide
+	// graphs by capping the distinct name@version pairs of a resolution.
+	maxUniquePackages int
+}
+
+func newServer(opts ...Option) *server {
+	s := &server{
+		registryURLs: []string{defaultRegistryURL},
+		logger:       slog.Default(),
+		metaCache:    newMetaCache(defaultCacheTTL, defaultCacheSize),
+		osvURL:       defaultOSVURL,
+		auditCache:   newLRUCache[[]vulnerability](auditCacheSize),
+
+		maxRecursionDepth: defaultMaxRecursionDepth,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.client == nil {
+		s.client = newRegistryClient(s.proxy)
+	}
+	return s
+}
+
+// newRegistryClient returns the default client for registry requests. It
+// sends them through proxy if there is one, and otherwise through the
+// proxy named by HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func newRegistryClient(proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// resolveOptions are the per-request knobs that shape a resolution.
+type resolveOptions struct {
+	// stopAt names a package whose version is resolved but whose
+	// dependencies are not expanded.
+	stopAt string
+	// excludeScopes lists scopes, such as "@types", whose packages are
+	// left unresolved.
+	excludeScopes []string
+	// partialOnTimeout returns the tree built so far, rather than an
+	// error, when the resolution deadline passes.
+	partialOnTimeout bool
+	// seed, when set, selects a reproducible but not necessarily highest
+	// compatible version of each package.
+	seed string
+	// requireIntegrity fails the resolution if any package version lacks a
+	// dist.integrity hash.
+	requireIntegrity bool
+	// resolutions forces the version of the named packages wherever they
+	// appear in the tree, whatever their dependents ask for.
+	resolutions map[string]string
+	// workspace maps the names of local workspace members to their
+	// manifests.
+	workspace map[string]*packageManifest
+	// fallbackUnpublished substitutes another release for an exact version
+	// that is no longer available, rather than failing.
+	fallbackUnpublished bool
+	// maxDepth, when positive, leaves the dependencies of packages that
+	// many levels below the root unresolved, marked as truncated.
+	maxDepth int
+	// dev also resolves the devDependencies of the root package.
+	dev bool
+	// strategy, when set, replaces the server's selection strategy.
+	strategy SelectionStrategy
+	// os and cpu, when set, name the platform the tree is resolved for, as
+	// process.platform and process.arch do.
+	os  string
+	cpu string
+}
+
+func parseResolveOptions(r *http.Request) (resolveOptions, error) {
+	query := r.URL.Query()
+	var maxDepth int
+	if raw := query.Get("depth"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return resolveOptions{}, fmt.Errorf("invalid depth %q: expected a positive number of levels", raw)
+		}
+		maxDepth = n
+	}
+	strategy := SelectionStrategy(query.Get("strategy"))
+	switch strategy {
+	case "", StrategyHighest, StrategyLowest, StrategyExact:
+	default:
+		return resolveOptions{}, fmt.Errorf("invalid strategy %q: expected highest, lowest or exact", strategy)
+	}
+	if strategy != "" && query.Get("seed") != "" {
+		return resolveOptions{}, errors.New("strategy and seed select versions differently: choose one")
+	}
+	return resolveOptions{
+		stopAt:        query.Get("stopAt"),
+		excludeScopes: parseScopes(query.Get("excludeScopes")),
+
+		partialOnTimeout: query.Get("partialOnTimeout") == "true",
+		seed:             query.Get("seed"),
+		requireIntegrity: query.Get("requireIntegrity") == "true",
+
+		fallbackUnpublished: query.Get("fallbackUnpublished") == "true",
+		maxDepth:            maxDepth,
+		dev:                 query.Get("dev") == "true",
+		strategy:            strategy,
+		os:                  query.Get("os"),
+		cpu:                 query.Get("cpu"),
+	}, nil
+}
+
+func parseScopes(list string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(list, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !strings.HasPrefix(scope, "@") {
+			scope = "@" + scope
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// excluded reports whether name belongs to one of the excluded scopes.
+func (opts resolveOptions) excluded(name string) bool {
+	for _, scope := range opts.excludeScopes {
+		if strings.HasPrefix(name, scope+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver carries the state of a single resolution request.
+type resolver struct {
+	*server
+	opts resolveOptions
+	log  *depLogger
+
+	mu         sync.Mutex
+	unresolved []unresolvedPackage
+	warnings   []string
+	// violations are the tree's violations of the license policy, when
+	// the request asked for a report of them.
+	violations []licenseViolation
+	// incompatible are the packages that don't run on the Node.js version
+	// the request asked for.
+	incompatible []engineIncompatibility
+	// vulnerabilities are the known vulnerabilities of the tree's package
+	// versions by name@version, when the request asked for an audit.
+	vulnerabilities map[string][]vulnerability
+	// unique is the set of name@version pairs resolved so far.
+	unique map[string]bool
+	// selected maps name@constraint, with the constraint in canonical
+	// form, to the version selected for it.
+	selected map[string]string
+	// trace records the registry fetches of the resolution, when the
+	// request asked for them.
+	trace *fetchTrace
+}
+
+// unresolvedPackage is a dependency left out of a partial tree.
+type unresolvedPackage struct {
+	Name       string `json:"name"`
+	Constraint string `json:"constraint"`
+	Parent     string `json:"parent"`
+}
+
+// markUnresolved records that dep, required by parent, was abandoned for
+// reason. The abandoned dependencies are kept ordered by parent and name,
+// whatever order they were abandoned in.
+func (res *resolver) markUnresolved(parent, dep *NpmPackageVersion, constraint, reason string) {
+	dep.Unresolved = reason
+	dep.Version = ""
+	dep.Dependencies = map[string]*NpmPackageVersion{}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.unresolved = append(res.unresolved, unresolvedPackage{
+		Name:       dep.Name,
+		Constraint: constraint,
+		Parent:     parent.Name + "@" + parent.Version,
+	})
+	sort.SliceStable(res.unresolved, func(i, j int) bool {
+		a, b := res.unresolved[i], res.unresolved[j]
+		return a.Parent < b.Parent || a.Parent == b.Parent && a.Name < b.Name
+	})
+}
+
+// countUnique records pkg as resolved, failing once the resolution has
+// more distinct packages than the server allows.
+func (res *resolver) countUnique(pkg *NpmPackageVersion) error {
+	if res.maxUniquePackages <= 0 {
+		return nil
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.unique == nil {
+		res.unique = map[string]bool{}
+	}
+	res.unique[pkg.Name+"@"+pkg.Version] = true
+	if len(res.unique) > res.maxUniquePackages {
+		return fmt.Errorf("%w of %d exceeded at %s@%s: %d unique packages resolved",
+			errMaxUniquePackages, res.maxUniquePackages, pkg.Name, pkg.Version, len(res.unique))
+	}
+	return nil
+}
 
-	return mux
+func (s *server) newResolver(opts resolveOptions) *resolver {
+	return &resolver{server: s, opts: opts, log: newDepLogger(s.logger, s.logSampling)}
 }
 
 const (
 	packageDoesNotExistMsg = "Package does not exist"
 	internalServerErrorMsg = "Internal server error"
-	invalidRequestPathMsg  = "Invalid request path. Expected format: /package/{name}/{version}, but got %s"
+	registryUnavailableMsg = "The npm registry is temporarily unavailable"
+	invalidRequestPathMsg  = "Invalid request path. Expected format: /v1/package/{name}/{version}, but got %s"
 )
 
+// acceptAbbreviatedMetadata asks for the abbreviated ("corgi") metadata
+// document, which leaves out readmes and most other fields, preferring it
+// to the full document. Both decode into npmPackageMetaResponse.
+const acceptAbbreviatedMetadata = "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8, */*"
+
 type npmPackageMetaResponse struct {
+	Name     string                        `json:"name"`
+	DistTags map[string]string             `json:"dist-tags"`
 	Versions map[string]npmPackageResponse `json:"versions"`
+
+	// etag is the registry's ETag for the metadata, used to revalidate it
+	// once it expires.
+	etag string
+	// registry is the registry or mirror the metadata was fetched from.
+	registry string
 }
 
 type npmPackageResponse struct {
 	Name         string            `json:"name"`
 	Version      string            `json:"version"`
+	License      licenseList       `json:"license"`
+	Licenses     licenseList       `json:"licenses"`
+	Maintainers  people            `json:"maintainers"`
+	Author       people            `json:"author"`
+	Dist         *PackageDist      `json:"dist"`
 	Dependencies map[string]string `json:"dependencies"`
+	// DevDependencies are only resolved for the root package, on request.
+	DevDependencies      map[string]string             `json:"devDependencies"`
+	PeerDependencies     map[string]string             `json:"peerDependencies"`
+	PeerDependenciesMeta map[string]peerDependencyMeta `json:"peerDependenciesMeta"`
+	OptionalDependencies map[string]string             `json:"optionalDependencies"`
+	OS                   []string                      `json:"os"`
+	CPU                  []string                      `json:"cpu"`
+	// Deprecated is the registry's deprecation message for the version.
+	Deprecated deprecationNotice `json:"deprecated"`
+	Engines    packageEngines    `json:"engines"`
+
+	// registry is the registry or mirror the document was fetched from.
+	registry string
 }
 
 type NpmPackageVersion struct {
-	Name         string                        `json:"name"`
-	Version      string                        `json:"version"`
-	Dependencies map[string]*NpmPackageVersion `json:"dependencies"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	License     string   `json:"-"`
+	Maintainers []person `json:"-"`
+	Author      *person  `json:"-"`
+	// Engines are the runtime versions the package runs on.
+	Engines map[string]string `json:"-"`
+	// Spec is the specifier its dependent requires the package with, such
+	// as "^1.2.0" or "npm:lodash@^3.0.0".
+	Spec       string       `json:"-"`
+	Excluded   bool         `json:"excluded,omitempty"`
+	Unresolved string       `json:"unresolved,omitempty"`
+	Dist       *PackageDist `json:"dist,omitempty"`
+	Registry   string       `json:"registry,omitempty"`
+	// Deprecated is the registry's deprecation message for the version.
+	Deprecated string `json:"deprecated,omitempty"`
+	// Vulnerabilities are the known vulnerabilities of the version, when
+	// the request asked for an audit.
+	Vulnerabilities []vulnerability `json:"vulnerabilities,omitempty"`
+	// Truncated marks a package left unresolved because it lies deeper
+	// than the requested depth.
+	Truncated bool `json:"truncated,omitempty"`
+	// Circular marks a package that is its own ancestor; its dependencies
+	// are those of the ancestor and are not repeated.
+	Circular bool `json:"circular,omitempty"`
+	// Dev marks a package needed only for developing the root package: one
+	// of its devDependencies, or beneath one.
+	Dev bool `json:"dev,omitempty"`
+	// Optional marks one of a package's optionalDependencies, or a package
+	// beneath one. Skipped gives the reason one was left out of the tree.
+	Optional bool   `json:"optional,omitempty"`
+	Skipped  string `json:"skipped,omitempty"`
+	// Alias is the name the package is installed under, when its dependent
+	// requires it through an "npm:" alias.
+	Alias string `json:"alias,omitempty"`
+	// PeerDependencies are the package's peer requirements, each with the
+	// version found for it in the tree.
+	PeerDependencies map[string]*PeerDependency    `json:"peerDependencies,omitempty"`
+	Dependencies     map[string]*NpmPackageVersion `json:"dependencies"`
+
+	// parent is the package that depends on this one during resolution.
+	parent *NpmPackageVersion
 }
 
-func packageHandler(w http.ResponseWriter, r *http.Request) {
+// treeResponse is the resolved tree together with optional details of how
+// it was built.
+type treeResponse struct {
+	*NpmPackageVersion
+	// Dependencies replaces the tree's own dependencies, which refs mode
+	// serializes differently.
+	Dependencies    any                 `json:"dependencies"`
+	Trace           []traceEntry        `json:"trace,omitempty"`
+	Truncated       bool                `json:"truncated,omitempty"`
+	TruncatedReason string              `json:"truncatedReason,omitempty"`
+	Unresolved      []unresolvedPackage `json:"unresolved,omitempty"`
+	Warnings        []string            `json:"warnings,omitempty"`
+	Violations      []licenseViolation  `json:"violations,omitempty"`
+	// Deprecations lists the deprecated package versions in the tree.
+	Deprecations []deprecatedPackage `json:"deprecations,omitempty"`
+	// Incompatible lists the packages that don't run on the Node.js
+	// version the request asked for.
+	Incompatible []engineIncompatibility `json:"incompatible,omitempty"`
+	// Audit summarizes the tree's known vulnerabilities, when the request
+	// asked for an audit.
+	Audit *auditSummary `json:"audit,omitempty"`
+}
 
-	pkgName := r.PathValue("package")
-	pkgVersion := r.PathValue("version")
+// newTreeResponse returns the tree resolved by res as the package
+// endpoint returns it, with the dist and registry fields only if query
+// asks for them and the dependencies as references if it asks for refs.
+// Every endpoint that answers with a tree builds it here.
+func newTreeResponse(query url.Values, rootPkg *NpmPackageVersion, res *resolver) *treeResponse {
+	tree := rootPkg
+	if !included(query, "dist") {
+		tree = withoutDist(tree)
+	}
+	if !included(query, "registry") {
+		tree = withoutRegistry(tree)
+	}
+	if res.vulnerabilities != nil {
+		tree = withVulnerabilities(tree, res.vulnerabilities)
+	}
+	body := &treeResponse{NpmPackageVersion: tree, Dependencies: tree.Dependencies, Warnings: res.warnings, Violations: res.violations, Deprecations: deprecatedPackages(rootPkg), Incompatible: res.incompatible}
+	if query.Get("refs") == "true" {
+		body.Dependencies = refDependencies(tree)
+	}
+	if res.trace != nil {
+		body.Trace = res.trace.list()
+	}
+	if res.vulnerabilities != nil {
+		body.Audit = summarizeVulnerabilities(res.vulnerabilities)
+	}
+	if len(res.unresolved) > 0 {
+		body.Truncated = true
+		body.TruncatedReason = "timeout"
+		body.Unresolved = res.unresolved
+	}
+	return body
+}
+
+func (s *server) packageHandler(w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "nested":
+	case "flat":
+		s.installSetHandler(w, r)
+		return
+	case "package-lock":
+		s.packageLockHandler(w, r)
+		return
+	case "yarn-lock":
+		s.yarnLockHandler(w, r)
+		return
+	case "pnpm-lock":
+		s.pnpmLockHandler(w, r)
+		return
+	case "cyclonedx":
+		s.cycloneDXHandler(w, r)
+		return
+	case "spdx":
+		s.spdxHandler(w, r)
+		return
+	case "dot":
+		s.dotHandler(w, r)
+		return
+	case "mermaid":
+		s.mermaidHandler(w, r)
+		return
+	case "csv":
+		s.csvHandler(w, r)
+		return
+	case "ndjson":
+		s.ndjsonHandler(w, r)
+		return
+	case "node_modules":
+		s.nodeModulesHandler(w, r)
+		return
+	default:
+		s.badRequest(w, r, fmt.Sprintf("Unknown format %q: expected nested, flat, package-lock, yarn-lock, pnpm-lock, cyclonedx, spdx, dot, mermaid, csv, ndjson or node_modules", format))
+		return
+	}
 
-	rootPkg := &NpmPackageVersion{Name: pkgName, Dependencies: map[string]*NpmPackageVersion{}}
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
 
-	if err := resolveDependencies(rootPkg, pkgVersion); err != nil {
-		println(err.Error())
-		w.WriteHeader(500)
+	query := r.URL.Query()
+	if filter := query.Get("licenseFilter"); filter != "" {
+		includeUnknown := query.Get("includeUnknown") == "true"
+		s.writeJSON(w, http.StatusOK, filterByLicense(rootPkg, filter, includeUnknown))
 		return
 	}
 
-	/* get unique dependencies
-	dependencyMap := make(map[string]string)
-	if err := resolveDependenciesUnique(rootPkg, pkgVersion, dependencyMap); err != nil {
-		log.Println(err.Error() + " in request " + r.URL.Path)
-		http.Error(w, err.Error()+" in request "+r.URL.Path, http.StatusInternalServerError)
+	if len(res.unresolved) == 0 && !wantsHTML(r) && s.writeFlattened(w, rootPkg) {
 		return
 	}
 
+	body := newTreeResponse(query, rootPkg, res)
+	status := http.StatusOK
+	if body.Truncated {
+		status = http.StatusPartialContent
+	}
+
+	write := s.writeJSON
+	if wantsHTML(r) {
+		write = func(w http.ResponseWriter, status int, _ any) bool {
+			return s.writeHTML(w, status, body.NpmPackageVersion)
+		}
+	}
+	if write(w, status, body) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "resolved", res.log.count())
+	}
+}
 
-	stringified, err := json.MarshalIndent(map[string]interface{}{
-		"name":         rootPkg.Name,
-		"version":      rootPkg.Version,
-		"dependencies": dependencyMap,
-	}, "", "  ")
+// resolveRequest resolves the package and version named in the request
+// path. On failure it writes the error response and returns a nil tree.
+func (s *server) resolveRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) (*NpmPackageVersion, *resolver) {
+	mode, err := parsePolicyMode(r)
 	if err != nil {
-		log.Println(err.Error())
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		s.badRequest(w, r, err.Error())
+		return nil, nil
+	}
+	node, err := parseNodeVersion(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return nil, nil
+	}
+	rootPkg, res := s.resolveTree(ctx, w, r)
+	if rootPkg == nil || s.checkPolicy(w, r, mode, rootPkg, res) || s.auditTree(w, r, rootPkg, res) {
+		return nil, nil
 	}
-	*/
+	res.incompatible = incompatibleEngines(rootPkg, node)
+	return rootPkg, res
+}
+
+// resolveTree resolves the tree resolveRequest returns, before it is
+// checked against the license policy.
+func (s *server) resolveTree(ctx context.Context, w http.ResponseWriter, r *http.Request) (*NpmPackageVersion, *resolver) {
+	pkgName := r.PathValue("package")
+	pkgVersion := r.PathValue("version")
 
-	stringified, err := json.MarshalIndent(rootPkg, "", "  ")
+	timeout, err := s.resolutionTimeout(r)
 	if err != nil {
-		println(err.Error())
-		w.WriteHeader(500)
-		return
+		s.badRequest(w, r, err.Error())
+		return nil, nil
+	}
+	ctx, cancel := withResolutionTimeout(ctx, timeout)
+	defer cancel()
+
+	opts, err := parseResolveOptions(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return nil, nil
+	}
+	res := s.newResolver(opts)
+	ctx = res.traceFetches(ctx, r)
+	// A traced request reports the fetches its resolution needed, so it is
+	// always resolved afresh.
+	useCache := res.trace == nil && !cacheBypassed(ctx)
+	key := treeCacheKey(pkgName, pkgVersion, res.opts)
+	if tree, ok := s.treeCache.get(key); ok && useCache {
+		return tree, res
+	}
+	var shared sharedTree
+	if s.treeCache != nil && useCache && s.loadShared(ctx, treeSharedKey(key), &shared) {
+		tree := shared.tree()
+		s.treeCache.set(key, tree)
+		return tree, res
+	}
+	rootPkg, err := res.resolve(ctx, pkgName, pkgVersion)
+	if err != nil && r.Context().Err() != nil {
+		// Nobody is left to read an error.
+		s.logger.Info("Client went away during resolution", "package", pkgName, "version", pkgVersion)
+		return nil, nil
+	}
+	if err != nil {
+		err = res.timedOut(err, timeout)
+		s.logger.Error("resolution failed", "package", pkgName, "version", pkgVersion, "error", err)
+		s.writeResolveError(w, r, err)
+		return nil, nil
+	}
+	if len(res.unresolved) == 0 && len(res.warnings) == 0 {
+		s.treeCache.set(key, rootPkg)
+		if s.treeCache != nil {
+			s.storeShared(ctx, treeSharedKey(key), toSharedTree(rootPkg), s.treeCache.ttl, treePackages(rootPkg)...)
+		}
+	}
+	return rootPkg, res
+}
+
+// writeJSON writes v as an indented JSON response and reports whether it
+// was written successfully.
+func (s *server) writeJSON(w http.ResponseWriter, status int, v any) bool {
+	stringified, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		s.logger.Error(err.Error())
+		s.writeProblem(w, nil, newProblem(problemInternal, http.StatusInternalServerError, ""))
+		return false
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	if _, err := w.Write(stringified); err != nil {
-		log.Println("Error writing response:", err)
+		s.logger.Error("Error writing response", "error", err)
 		http.Error(w, internalServerErrorMsg, http.StatusInternalServerError)
-		return
+		return false
 	}
-	log.Printf("Successfully handled request for package: %s, version: %s", rootPkg.Name, rootPkg.Version)
+	return true
+}
+
+var (
+	errInvalidConstraint = errors.New("invalid version constraint")
+
+	// distTagPattern matches names such as "latest" or "next", which are
+	// resolved through the registry's dist-tags rather than as semver.
+	distTagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+)
+
+// validateConstraint rejects a version constraint that is neither a semver
+// range nor a dist-tag name, before any registry request is made.
+func validateConstraint(versionConstraint string) error {
+	_, err := semver.NewConstraint(versionConstraint)
+	if err == nil || distTagPattern.MatchString(versionConstraint) {
+		return nil
+	}
+	return fmt.Errorf("%w %q: %v", errInvalidConstraint, versionConstraint, err)
 }
 
 func highestCompatibleVersion(constraintStr string, versions *npmPackageMetaResponse) (string, error) {
@@ -103,7 +695,7 @@ func highestCompatibleVersion(constraintStr string, versions *npmPackageMetaResp
 	filtered := filterCompatibleVersions(constraint, versions)
 	sort.Sort(filtered)
 	if len(filtered) == 0 {
-		return "", errors.New("no compatible versions found")
+		return "", errNoCompatibleVersion
 	}
 	return filtered[len(filtered)-1].String(), nil
 }
@@ -122,179 +714,460 @@ func filterCompatibleVersions(constraint *semver.Constraints, pkgMeta *npmPackag
 	return compatible
 }
 
-func fetchPackage(name, version string) (*npmPackageResponse, error) {
-	resp, err := http.Get(fmt.Sprintf("https://registry.npmjs.org/%s/%s", name, version))
-	if err != nil {
-		return nil, err
+func (s *server) fetchPackage(ctx context.Context, name, version string) (*npmPackageResponse, error) {
+	// Not every registry serves the documents of single versions of scoped
+	// packages, so take them from the package's metadata.
+	if packageScope(name) != "" {
+		meta, err := s.fetchPackageMeta(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if doc, ok := meta.Versions[version]; ok {
+			doc.registry = meta.registry
+			return &doc, nil
+		}
+	}
+
+	path := registryPath(name) + "/" + version
+	if cached, ok := s.metaCache.getVersion(name, version); ok && !cacheBypassed(ctx) {
+		recordFetch(ctx, s.registriesFor(name)[0]+"/"+path, true)
+		return cached, nil
+	}
+	var stored npmPackageResponse
+	if !cacheBypassed(ctx) {
+		if ttl, _, ok := s.loadStored(ctx, versionKey(name, version), &stored); ok && ttl > 0 {
+			recordFetch(ctx, s.registriesFor(name)[0]+"/"+path, true)
+			s.metaCache.setVersion(name, version, &stored, ttl)
+			return &stored, nil
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := s.fetchShared(ctx, name, path, "", "")
 	if err != nil {
 		return nil, err
 	}
 
 	var parsed npmPackageResponse
-	if err := json.Unmarshal(body, &parsed); err != nil {
+	if err := json.Unmarshal(resp.body, &parsed); err != nil {
 		return nil, err
 	}
+	parsed.registry = resp.registry
+
+	ttl := s.metaCache.ttlFor(resp.header)
+	s.metaCache.setVersion(name, version, &parsed, ttl)
+	s.store(ctx, versionKey(name, version), &parsed, ttl, "")
 	return &parsed, nil
 }
 
-func fetchPackageMeta(p string) (*npmPackageMetaResponse, error) {
+func (s *server) fetchPackageMeta(ctx context.Context, p string) (*npmPackageMetaResponse, error) {
+	if cached, ok := s.metaCache.get(p); ok && !cacheBypassed(ctx) {
+		recordFetch(ctx, s.registriesFor(p)[0]+"/"+registryPath(p), true)
+		return cached, nil
+	}
+	// Stored metadata is served like cached metadata while it is fresh.
+	var stale *npmPackageMetaResponse
+	if !cacheBypassed(ctx) {
+		var stored npmPackageMetaResponse
+		if ttl, etag, ok := s.loadStored(ctx, metaKey(p), &stored); ok {
+			stored.etag = etag
+			if ttl > 0 {
+				recordFetch(ctx, s.registriesFor(p)[0]+"/"+registryPath(p), true)
+				s.metaCache.set(p, &stored, ttl)
+				return &stored, nil
+			}
+			stale = &stored
+		}
+	}
 
-	resp, err := http.Get(fmt.Sprintf("https://registry.npmjs.org/%s", p))
-	if err != nil {
+	if err, ok := s.metaCache.getNotFound(p); ok && !cacheBypassed(ctx) {
+		recordFetch(ctx, s.registriesFor(p)[0]+"/"+registryPath(p), true)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	// Expired metadata, cached or stored, is revalidated rather than
+	// downloaded again.
+	if cached, ok := s.metaCache.stale(p); ok {
+		stale = cached
+	}
+	var etag string
+	if stale != nil {
+		etag = stale.etag
+	}
+	// Unscoped packages take their version documents, with the licenses
+	// and maintainers the abbreviated metadata lacks, from the registry,
+	// so only their metadata may be abbreviated.
+	accept := ""
+	if packageScope(p) == "" {
+		accept = acceptAbbreviatedMetadata
+	}
+	resp, err := s.fetchShared(ctx, p, registryPath(p), etag, accept)
+	if hasStatus(err, http.StatusNotAcceptable) && accept != "" {
+		resp, err = s.fetchShared(ctx, p, registryPath(p), etag, "")
+	}
+	if isNotFound(err) {
+		s.metaCache.setNotFound(p, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	ttl := s.metaCache.ttlFor(resp.header)
+	if resp.notModified {
+		s.metaCache.set(p, stale, ttl)
+		s.store(ctx, metaKey(p), stale, ttl, stale.etag)
+		return stale, nil
+	}
 
 	var parsed npmPackageMetaResponse
-	if err := json.Unmarshal(body, &parsed); err != nil {
+	if err := json.Unmarshal(resp.body, &parsed); err != nil {
 		return nil, err
 	}
+	// Selection is keyed by package name; don't trust mirrors to echo it.
+	parsed.Name = p
+	parsed.etag = resp.header.Get("ETag")
+	parsed.registry = resp.registry
 
+	s.metaCache.set(p, &parsed, ttl)
+	s.store(ctx, metaKey(p), &parsed, ttl, parsed.etag)
 	return &parsed, nil
 }
 
-func handleInvalidPath(mux *http.ServeMux) {
-	mux.HandleFunc("/", invalidPath)
-	mux.HandleFunc("/package", invalidPath)
-	mux.HandleFunc("/package/", invalidPath)
-	mux.HandleFunc("/package/{package}", invalidPath)
+// fetchFromRegistries requests path, a document of the named package,
+// from each registry configured for the package in order, moving on to the
+// next only when a registry reports the document as not found. A non-empty
+// etag is sent as If-None-Match.
+func (s *server) fetchFromRegistries(ctx context.Context, name, path, etag, accept string) (*registryResponse, error) {
+	registries := s.registriesFor(name)
+	var err error
+	for i, registry := range registries {
+		var resp *registryResponse
+		resp, err = s.getFromRegistry(ctx, registry, path, etag, accept)
+		if !isNotFound(err) {
+			return resp, s.explainScopedFailure(name, registries, i, err)
+		}
+	}
+	return nil, s.explainScopedFailure(name, registries, len(registries)-1, err)
 }
 
-func invalidPath(w http.ResponseWriter, r *http.Request) {
-
-	log.Printf("invalid request path: %s\n", r.URL.Path)
-	http.Error(w, fmt.Sprintf("Invalid request path. Expected format: /package/{name}/{version}, but got %s", r.URL.Path), http.StatusBadRequest)
+type registryResponse struct {
+	body   []byte
+	header http.Header
+	// notModified reports a 304 in answer to If-None-Match; body is empty.
+	notModified bool
+	// registry is the registry or mirror that answered.
+	registry string
+	// size is the bytes downloaded for the response, before decoding.
+	size int64
 }
 
-func resolveDependenciesAsync(pkg *NpmPackageVersion, versionConstraint string, dependencyMap map[string]string) error {
-	pkgMeta, err := fetchPackageMeta(pkg.Name)
+// get performs a registry GET request and returns the response body and
+// headers. A 304 answer to a request with an etag is returned as not
+// modified.
+func (s *server) get(ctx context.Context, url, etag, accept string) (*registryResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
 	}
-	concreteVersion, err := highestCompatibleVersion(versionConstraint, pkgMeta)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	var body *registryResponse
+	err = s.registryRequest(req, func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNotModified {
+			body = &registryResponse{header: resp.Header, notModified: true}
+			return nil
+		}
+		counted := &countingReader{r: budgetedReader(ctx, resp.Body)}
+		reader, err := decodedBody(resp, counted)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		body = &registryResponse{body: data, header: resp.Header, size: counted.n}
+		return nil
+	})
 	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// registryRequest sends req, with the registry's token, through the
+// server's concurrency and rate limits and the circuit breaker of the
+// registry, and hands the response to read. Any answer but a 200, or a
+// 304 to a conditional request, is returned as a registryError without
+// being read. The breaker records the outcome once the response has been
+// read, so that server errors and failed reads count against the registry.
+func (s *server) registryRequest(req *http.Request, read func(*http.Response) error) error {
+	ctx, url := req.Context(), req.URL.String()
+	if err := s.fetchSem.acquire(ctx); err != nil {
 		return err
 	}
-	pkg.Version = concreteVersion
+	defer s.fetchSem.release()
+	if err := s.rateLimiter.wait(ctx); err != nil {
+		return err
+	}
+
+	breaker := s.breakers.forURL(url)
+	if err := breaker.allow(); err != nil {
+		return err
+	}
+	if token := s.tokenFor(url); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recordFetch(ctx, url, false)
+	err := s.sendRegistryRequest(req, url, read)
+	breaker.record(ctx, err)
+	return err
+}
 
-	// Fetch package details
-	npmPkg, err := fetchPackage(pkg.Name, pkg.Version)
+func (s *server) sendRegistryRequest(req *http.Request, url string, read func(*http.Response) error) error {
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	notModified := resp.StatusCode == http.StatusNotModified && req.Header.Get("If-None-Match") != ""
+	if resp.StatusCode != http.StatusOK && !notModified {
+		return &registryError{StatusCode: resp.StatusCode, URL: url}
+	}
+	return read(resp)
+}
 
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(npmPkg.Dependencies))
-	depChan := make(chan *NpmPackageVersion, len(npmPkg.Dependencies))
+// registryError reports a non-200 response from the registry.
+type registryError struct {
+	StatusCode int
+	URL        string
+}
 
-	// Log when goroutines start
-	log.Printf("Starting to resolve dependencies for package: %s, version: %s", pkg.Name, pkg.Version)
+func (e *registryError) Error() string {
+	return fmt.Sprintf("registry returned %d %s for %s", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
 
-	for dependencyName, dependencyVersionConstraint := range npmPkg.Dependencies {
-		wg.Add(1)
-		go func(depName, depVersionConstraint string) {
-			defer wg.Done()
-			log.Printf("Fetching and resolving dependency: %s", depName)
-
-			if _, exists := dependencyMap[depName]; !exists {
-				dep := &NpmPackageVersion{Name: depName, Dependencies: map[string]*NpmPackageVersion{}}
-				log.Printf("Resolving dependencies for %s", depName)
-				if err := resolveDependenciesAsync(dep, depVersionConstraint, dependencyMap); err != nil {
-					log.Printf("Error resolving dependency %s: %v", depName, err)
-					errChan <- err
-					return
-				}
-				dependencyMap[depName] = dep.Version
-				depChan <- dep
-				log.Printf("Successfully resolved dependency: %s, version: %s", dep.Name, dep.Version)
-			} else {
-				log.Printf("Dependency %s already resolved with version %s", depName, dependencyMap[depName])
-			}
-		}(dependencyName, dependencyVersionConstraint)
-	}
+func isNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
 
-	// Wait for all goroutines to complete
-	wg.Wait()
-	close(errChan)
-	close(depChan)
+// hasStatus reports whether err is a registry response with status.
+func hasStatus(err error, status int) bool {
+	var regErr *registryError
+	return errors.As(err, &regErr) && regErr.StatusCode == status
+}
 
-	// Check if there were any errors
-	if len(errChan) > 0 {
-		return <-errChan
+func (s *server) handleInvalidPath(mux *http.ServeMux) {
+	mux.HandleFunc("/", s.invalidPath)
+	mux.HandleFunc("/package", s.invalidPath)
+	mux.HandleFunc("/package/", s.invalidPath)
+	mux.HandleFunc("/package/{package}", s.invalidPath)
+	for _, version := range apiVersions {
+		mux.HandleFunc(version.prefix+"/package", s.invalidPath)
+		mux.HandleFunc(version.prefix+"/package/", s.invalidPath)
+		mux.HandleFunc(version.prefix+"/package/{package}", s.invalidPath)
 	}
+}
 
-	// Collect results from depChan
-	for dep := range depChan {
-		pkg.Dependencies[dep.Name] = dep
-		log.Printf("Added dependency %s to package %s", dep.Name, pkg.Name)
-	}
+func (s *server) invalidPath(w http.ResponseWriter, r *http.Request) {
 
-	log.Printf("Finished resolving dependencies for package: %s, version: %s", pkg.Name, pkg.Version)
-	return nil
+	s.logger.Info("invalid request path", "path", r.URL.Path)
+	s.badRequest(w, r, fmt.Sprintf("Invalid request path. Expected format: /v1/package/{name}/{version}, but got %s", r.URL.Path))
+}
+
+// resolve builds the dependency tree of the named package.
+func (res *resolver) resolve(ctx context.Context, name, versionConstraint string) (*NpmPackageVersion, error) {
+	if err := validateConstraint(versionConstraint); err != nil {
+		return nil, err
+	}
+	ctx = withDownloadBudget(ctx, res.downloadBudget)
+	root := &NpmPackageVersion{Name: name, Dependencies: map[string]*NpmPackageVersion{}}
+	if err := res.resolveDependencies(ctx, root, versionConstraint, 0); err != nil {
+		return nil, err
+	}
+	checkPeers(root)
+	return root, nil
 }
 
-func resolveDependencies(pkg *NpmPackageVersion, versionConstraint string) error {
-	pkgMeta, err := fetchPackageMeta(pkg.Name)
+func (res *resolver) resolveDependencies(ctx context.Context, pkg *NpmPackageVersion, versionConstraint string, depth int) error {
+	if depth > res.maxRecursionDepth {
+		return fmt.Errorf("%w of %d exceeded at %s", errMaxRecursionDepth, res.maxRecursionDepth, pkg.Name)
+	}
+	// Cached metadata never reaches the network, so notice a client that
+	// went away before walking further.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if pinned, ok := res.opts.resolutions[pkg.Name]; ok {
+		versionConstraint = pinned
+	}
+	if strings.HasPrefix(versionConstraint, workspaceProtocol) {
+		return res.resolveWorkspace(ctx, pkg, versionConstraint, depth)
+	}
+	pkgMeta, err := res.fetchPackageMeta(ctx, pkg.Name)
 	if err != nil {
+		if depth == 0 && isNotFound(err) {
+			return fmt.Errorf("%w: %w", errPackageNotFound, err)
+		}
 		return err
 	}
-	concreteVersion, err := highestCompatibleVersion(versionConstraint, pkgMeta)
+	concreteVersion, npmPkg, err := res.resolveVersion(ctx, pkg.Name, versionConstraint, pkgMeta)
 	if err != nil {
+		if depth == 0 && (errors.Is(err, errNoCompatibleVersion) || isNotFound(err)) {
+			return fmt.Errorf("%w: no published version of %s satisfies %q", errVersionNotFound, pkg.Name, versionConstraint)
+		}
 		return err
 	}
 	pkg.Version = concreteVersion
-
-	npmPkg, err := fetchPackage(pkg.Name, pkg.Version)
-	if err != nil {
+	if err := res.countUnique(pkg); err != nil {
 		return err
 	}
-	for dependencyName, dependencyVersionConstraint := range npmPkg.Dependencies {
-		dep := &NpmPackageVersion{Name: dependencyName, Dependencies: map[string]*NpmPackageVersion{}}
-		pkg.Dependencies[dependencyName] = dep
-		if err := resolveDependencies(dep, dependencyVersionConstraint); err != nil {
+	pkg.License = npmPkg.license()
+	pkg.Dist = npmPkg.Dist
+	pkg.Deprecated = string(npmPkg.Deprecated)
+	pkg.Engines = npmPkg.Engines
+	pkg.Registry = npmPkg.registry
+	pkg.PeerDependencies = npmPkg.peerDependencies()
+	pkg.Maintainers = npmPkg.Maintainers
+	if len(npmPkg.Author) > 0 {
+		pkg.Author = &npmPkg.Author[0]
+	}
+	if res.opts.requireIntegrity {
+		if err := checkIntegrity(pkg.Name, pkg.Version, pkg.Dist); err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-func resolveDependenciesUnique(pkg *NpmPackageVersion, versionConstraint string, dependencyMap map[string]string) error {
-	pkgMeta, err := fetchPackageMeta(pkg.Name)
-	if err != nil {
+	if err := res.opts.checkPlatform(pkg.Name, pkg.Version, npmPkg); err != nil {
 		return err
 	}
-	concreteVersion, err := highestCompatibleVersion(versionConstraint, pkgMeta)
-	if err != nil {
-		return err
+	visit(ctx, pkg)
+	if pkg.Name == res.opts.stopAt {
+		return nil
 	}
-	pkg.Version = concreteVersion
-
-	// Fetch package details
-	npmPkg, err := fetchPackage(pkg.Name, pkg.Version)
-	if err != nil {
+	if err := res.resolveChildren(ctx, pkg, npmPkg.Dependencies, npmPkg.OptionalDependencies, depth); err != nil {
 		return err
 	}
+	if depth == 0 && res.opts.dev {
+		return res.resolveDevDependencies(ctx, pkg, npmPkg.DevDependencies)
+	}
+	return nil
+}
 
-	for dependencyName, dependencyVersionConstraint := range npmPkg.Dependencies {
-		if _, exists := dependencyMap[dependencyName]; !exists {
-			dep := &NpmPackageVersion{Name: dependencyName, Dependencies: map[string]*NpmPackageVersion{}}
-			pkg.Dependencies[dependencyName] = dep
-			if err := resolveDependenciesUnique(dep, dependencyVersionConstraint, dependencyMap); err != nil {
+// resolveChildren resolves the dependencies of pkg, which sits at depth in
+// the tree. Its optionalDependencies, which npm also lists among its
+// dependencies, are left out rather than failing the tree when they cannot
+// be installed.
+func (res *resolver) resolveChildren(ctx context.Context, pkg *NpmPackageVersion, dependencies, optionalDependencies map[string]string, depth int) error {
+	if circular(pkg) {
+		pkg.Circular = true
+		return nil
+	}
+	if len(optionalDependencies) > 0 {
+		dependencies = mergeDependencies(optionalDependencies, dependencies)
+	}
+	// Resolve in name order so the registry requests and any error are the
+	// same on every run, whatever the map iteration order. Resolved in
+	// parallel, the dependencies are settled in name order once they are
+	// all done, so the tree and any error are still the same.
+	parallel := res.parallel(ctx)
+	names := sortedKeys(dependencies)
+	deps := make([]*NpmPackageVersion, len(names))
+	constraints := make([]string, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, dependencyName := range names {
+		dependencyVersionConstraint := dependencies[dependencyName]
+		_, optional := optionalDependencies[dependencyName]
+		dep := &NpmPackageVersion{Name: dependencyName, Spec: dependencyVersionConstraint, Optional: optional, Dependencies: map[string]*NpmPackageVersion{}, parent: pkg}
+		if name, versionConstraint, ok := parseAlias(dependencyVersionConstraint); ok {
+			dep.Name, dep.Alias = name, dependencyName
+			dependencyVersionConstraint = versionConstraint
+		}
+		pkg.Dependencies[dependencyName] = dep
+		if isNonRegistrySpec(dependencyVersionConstraint) {
+			dep.Unresolved = nonRegistrySpecifier
+			visit(ctx, dep)
+			continue
+		}
+		if res.opts.excluded(dep.Name) {
+			dep.Excluded = true
+			visit(ctx, dep)
+			continue
+		}
+		if res.opts.maxDepth > 0 && depth >= res.opts.maxDepth {
+			dep.Truncated = true
+			visit(ctx, dep)
+			continue
+		}
+		deps[i], constraints[i] = dep, dependencyVersionConstraint
+		if !parallel {
+			err := res.resolveDependencies(ctx, dep, dependencyVersionConstraint, depth+1)
+			if err := res.settleDependency(ctx, pkg, dep, dependencyVersionConstraint, err); err != nil {
 				return err
 			}
-			// Add to dependencyMap if it's a transitive dependency
-			dependencyMap[dependencyName] = dep.Version
+			continue
+		}
+		// Waiting for a worker could deadlock, as every worker may be a
+		// parent waiting on its children.
+		if !res.resolveSem.tryAcquire() {
+			errs[i] = res.resolveDependencies(ctx, dep, dependencyVersionConstraint, depth+1)
+			continue
 		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer res.resolveSem.release()
+			errs[i] = res.resolveDependencies(ctx, deps[i], constraints[i], depth+1)
+		}(i)
 	}
+	if !parallel {
+		return nil
+	}
+	wg.Wait()
+	for i, dep := range deps {
+		if dep == nil {
+			continue
+		}
+		if err := res.settleDependency(ctx, pkg, dep, constraints[i], errs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// settleDependency records the outcome of resolving dep, a dependency of
+// pkg. An optional dependency that cannot be installed is left out, as is,
+// with partialOnTimeout, one that ran out of time; any other failure is
+// returned.
+func (res *resolver) settleDependency(ctx context.Context, pkg, dep *NpmPackageVersion, constraint string, err error) error {
+	if err != nil {
+		if dep.Optional && skippable(err) {
+			res.log.dependency("Skipped optional dependency", "parent", pkg.Name, "dependency", dep.Name, "error", err)
+			skipOptional(dep, err)
+			visit(ctx, dep)
+			return nil
+		}
+		if res.opts.partialOnTimeout && errors.Is(err, context.DeadlineExceeded) {
+			res.markUnresolved(pkg, dep, constraint, "timeout")
+			visit(ctx, dep)
+			return nil
+		}
+		return err
+	}
+	if dep.Optional {
+		markOptional(dep)
+	}
+	res.log.resolvedDependency("Resolved dependency", "parent", pkg.Name, "dependency", dep.Name, "version", dep.Version)
 	return nil
 }
+
+// parallel reports whether dependencies are resolved in parallel: when the
+// server allows it and no visitor, which sees packages in order as they
+// are resolved, is watching.
+func (res *resolver) parallel(ctx context.Context) bool {
+	return res.resolveSem != nil && !hasVisitor(ctx)
+}
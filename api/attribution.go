@@ -0,0 +1,87 @@
+package api
+
+import "net/http"
+
+type dependencyAttribution struct {
+	Name      string       `json:"name"`
+	Version   string       `json:"version"`
+	Exclusive []packageRef `json:"exclusive"`
+}
+
+type attributionResponse struct {
+	Name         string                  `json:"name"`
+	Version      string                  `json:"version"`
+	Dependencies []dependencyAttribution `json:"dependencies"`
+	Shared       []packageRef            `json:"shared"`
+}
+
+// attribute splits the packages reachable from the root's direct
+// dependencies into those only one direct dependency brings in, including
+// the direct dependency itself, and those reachable from several.
+func (g *packageGraph) attribute() *attributionResponse {
+	direct := g.root.sortedDeps()
+	reachedBy := map[*graphNode]int{}
+	closures := make([][]*graphNode, len(direct))
+	for i, dep := range direct {
+		closures[i] = g.closure(dep)
+		for _, n := range closures[i] {
+			reachedBy[n]++
+		}
+	}
+
+	resp := &attributionResponse{
+		Name:         g.root.name,
+		Version:      g.root.version,
+		Dependencies: make([]dependencyAttribution, 0, len(direct)),
+		Shared:       []packageRef{},
+	}
+	for i, dep := range direct {
+		attribution := dependencyAttribution{Name: dep.name, Version: dep.version, Exclusive: []packageRef{}}
+		for _, n := range closures[i] {
+			if reachedBy[n] == 1 {
+				attribution.Exclusive = append(attribution.Exclusive, packageRef{Name: n.name, Version: n.version})
+			}
+		}
+		resp.Dependencies = append(resp.Dependencies, attribution)
+	}
+	for _, n := range g.sortedNodes() {
+		if reachedBy[n] > 1 {
+			resp.Shared = append(resp.Shared, packageRef{Name: n.name, Version: n.version})
+		}
+	}
+	return resp
+}
+
+// closure returns from and every node reachable from it, except the root,
+// ordered by key.
+func (g *packageGraph) closure(from *graphNode) []*graphNode {
+	seen := map[string]*graphNode{}
+	var visit func(n *graphNode)
+	visit = func(n *graphNode) {
+		if _, ok := seen[n.key()]; ok || n == g.root {
+			return
+		}
+		seen[n.key()] = n
+		for _, dep := range n.deps {
+			visit(dep)
+		}
+	}
+	visit(from)
+
+	nodes := make([]*graphNode, 0, len(seen))
+	for _, key := range sortedKeys(seen) {
+		nodes = append(nodes, seen[key])
+	}
+	return nodes
+}
+
+// attributionHandler reports, for each direct dependency of the resolved
+// package, the transitive packages that only it brings in, and the
+// packages shared between several direct dependencies.
+func (s *server) attributionHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, _ := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newPackageGraph(rootPkg).attribute())
+}
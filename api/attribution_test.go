@@ -0,0 +1,63 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestAttribution(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"web": "^1.0.0", "cli": "^1.0.0"})},
+		"web":    {"1.0.0": deps(map[string]string{"http": "^1.0.0", "router": "^1.0.0"})},
+		"cli":    {"1.0.0": deps(map[string]string{"http": "^1.0.0", "args": "^1.0.0"})},
+		"http":   {"1.0.0": deps(map[string]string{"buffer": "^1.0.0"})},
+		"router": {"1.0.0": deps(map[string]string{"path": "^1.0.0"})},
+		"args":   {"1.0.0": {}},
+		"buffer": {"1.0.0": {}},
+		"path":   {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0/attribution")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Dependencies []struct {
+			Name      string `json:"name"`
+			Exclusive []struct {
+				Name string `json:"name"`
+			} `json:"exclusive"`
+		} `json:"dependencies"`
+		Shared []struct {
+			Name string `json:"name"`
+		} `json:"shared"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	exclusive := map[string][]string{}
+	for _, dep := range body.Dependencies {
+		exclusive[dep.Name] = []string{}
+		for _, p := range dep.Exclusive {
+			exclusive[dep.Name] = append(exclusive[dep.Name], p.Name)
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"cli": {"args", "cli"},
+		"web": {"path", "router", "web"},
+	}, exclusive)
+
+	var shared []string
+	for _, p := range body.Shared {
+		shared = append(shared, p.Name)
+	}
+	assert.Equal(t, []string{"buffer", "http"}, shared)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"strings"
+)
+
+// nerfDart reduces a registry URL to the scheme-less form, such as
+// "//npm.acme.test/private/", under which .npmrc files key credentials.
+func nerfDart(url string) string {
+	if i := strings.Index(url, "//"); i >= 0 {
+		url = url[i:]
+	}
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return url
+}
+
+// tokenFor returns the bearer token to send with a request for url: the
+// token of the most specific registry configured for it, or failing that
+// the default registries' token if url is on one of them.
+func (s *server) tokenFor(url string) string {
+	dart := nerfDart(url)
+	var best, token string
+	for registry, t := range s.registryTokens {
+		if registry != "" && strings.HasPrefix(dart, registry) && len(registry) > len(best) {
+			best, token = registry, t
+		}
+	}
+	if token != "" {
+		return token
+	}
+	if t, ok := s.registryTokens[""]; ok {
+		for _, registry := range s.registryURLs {
+			if strings.HasPrefix(dart, nerfDart(registry)) {
+				return t
+			}
+		}
+	}
+	return ""
+}
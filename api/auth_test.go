@@ -0,0 +1,38 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestRegistryTokens(t *testing.T) {
+	public := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"@acme/ui": "^1.0.0"})},
+	})
+	private := newMockRegistry(t, mockRegistry{"@acme/ui": {"1.0.0": {}}})
+	private.token = "secret"
+
+	resolve := func(opts ...api.Option) int {
+		opts = append([]api.Option{api.WithRegistryURL(public.URL), api.WithScopedRegistry("@acme", private.URL)}, opts...)
+		server := httptest.NewServer(api.New(opts...))
+		defer server.Close()
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, resolve())
+	assert.Equal(t, http.StatusOK, resolve(api.WithRegistryToken(private.URL, "secret")))
+	// The private registry's token is not sent to the public registry.
+	assert.Empty(t, public.Authorized())
+
+	// A default token goes to the default registries only.
+	assert.Equal(t, http.StatusInternalServerError, resolve(api.WithRegistryToken("", "secret")))
+	assert.Equal(t, []string{"/app", "/app/1.0.0"}, public.Authorized())
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// A batch may name at most maxBatchPackages packages, in a body of at most
+// maxBatchBodySize bytes, which batchWorkers resolve.
+const (
+	maxBatchPackages = 100
+	maxBatchBodySize = 1 << 20
+	batchWorkers     = 8
+)
+
+// batchResult is the outcome of resolving one package of a batch: its
+// tree as the package endpoint returns it, or the problem that failed it.
+type batchResult struct {
+	Tree    *treeResponse   `json:"tree,omitempty"`
+	Problem json.RawMessage `json:"problem,omitempty"`
+}
+
+// batchHandler resolves a JSON array of packages concurrently, a few at a
+// time, with the options of the request's query, and answers with the
+// result of each, keyed by name@version. A package that fails to resolve doesn't fail the
+// others: its result holds the problem details.
+func (s *server) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var refs []packageRef
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&refs); err != nil {
+		s.badRequest(w, r, "Invalid request body: "+err.Error())
+		return
+	}
+	if len(refs) > maxBatchPackages {
+		s.badRequest(w, r, fmt.Sprintf("Too many packages: at most %d may be resolved at once", maxBatchPackages))
+		return
+	}
+	for _, ref := range refs {
+		if ref.Name == "" || ref.Version == "" {
+			s.badRequest(w, r, "Expected each package to have a name and a version")
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	results := make(map[string]*batchResult, len(refs))
+	var wg sync.WaitGroup
+	sem := newSemaphore(batchWorkers)
+	for _, ref := range refs {
+		key := ref.Name + "@" + ref.Version
+		if _, ok := results[key]; ok {
+			continue
+		}
+		if sem.acquire(r.Context()) != nil {
+			break
+		}
+		result := &batchResult{}
+		results[key] = result
+		wg.Add(1)
+		go func(ref packageRef) {
+			defer wg.Done()
+			defer sem.release()
+			rootPkg, res, problem := s.resolvePackage(r.Context(), r, ref.Name, ref.Version, query)
+			if rootPkg == nil {
+				result.Problem = problem
+				return
+			}
+			result.Tree = newTreeResponse(query, rootPkg, res)
+		}(ref)
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		s.logger.Info("Client went away during batch resolution", "packages", len(results))
+		return
+	}
+
+	if s.writeJSON(w, http.StatusOK, results) {
+		s.logger.Info("Successfully handled request", "packages", len(results))
+	}
+}
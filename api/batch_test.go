@@ -0,0 +1,138 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type batchResult struct {
+	Tree    *api.NpmPackageVersion `json:"tree"`
+	Problem *problem               `json:"problem"`
+}
+
+func postBatch(t *testing.T, server *httptest.Server, path, body string) (int, map[string]batchResult) {
+	t.Helper()
+	resp, err := server.Client().Post(server.URL+path, "application/json", strings.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	var results map[string]batchResult
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&results))
+	return resp.StatusCode, results
+}
+
+func TestBatchResolution(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"tool": {"2.0.0": deps(map[string]string{"lib": "^1.1.0"})},
+		"lib":  {"1.0.0": {}, "1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	status, results := postBatch(t, server, "/v1/packages?depth=1", `[
+		{"name": "app", "version": "1.0.0"},
+		{"name": "tool", "version": "^2.0.0"},
+		{"name": "missing", "version": "1.0.0"},
+		{"name": "app", "version": "1.0.0"}
+	]`)
+	require.Equal(t, http.StatusOK, status)
+	assert.ElementsMatch(t, []string{"app@1.0.0", "tool@^2.0.0", "missing@1.0.0"}, keys(results))
+
+	app := results["app@1.0.0"]
+	require.NotNil(t, app.Tree)
+	assert.Nil(t, app.Problem)
+	assert.Equal(t, "1.2.0", app.Tree.Dependencies["lib"].Version)
+	assert.Equal(t, "2.0.0", results["tool@^2.0.0"].Tree.Version)
+
+	missing := results["missing@1.0.0"]
+	assert.Nil(t, missing.Tree)
+	require.NotNil(t, missing.Problem)
+	assert.Equal(t, http.StatusNotFound, missing.Problem.Status)
+}
+
+func TestBatchResolutionTreeOptions(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"b":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"shared": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	// A batch tree is the tree the package endpoint returns for the same
+	// query, references and trace included.
+	resp, err := server.Client().Post(server.URL+"/v1/packages?refs=true&trace=true", "application/json", strings.NewReader(`[{"name": "app", "version": "1.0.0"}]`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var results map[string]struct {
+		Tree struct {
+			Dependencies map[string]struct {
+				Dependencies map[string]json.RawMessage `json:"dependencies"`
+			} `json:"dependencies"`
+			Trace []traceEntry `json:"trace"`
+		} `json:"tree"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&results))
+	tree := results["app@1.0.0"].Tree
+	assert.JSONEq(t, `{"$ref": "shared@1.0.0"}`, string(tree.Dependencies["b"].Dependencies["shared"]))
+	assert.Contains(t, tree.Trace, traceEntry{URL: registry.URL + "/app/1.0.0"})
+}
+
+func TestBatchResolutionInvalid(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	for _, body := range []string{`{"name": "app"}`, `[{"name": "app"}]`, `not json`} {
+		status, _ := postBatch(t, server, "/v1/packages", body)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	}
+	status, results := postBatch(t, server, "/v1/packages", `[]`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, results)
+
+	// Endpoints added since the API was versioned have no unversioned alias.
+	status, _ = postBatch(t, server, "/packages", `[]`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestBatchResolutionLimits(t *testing.T) {
+	const width = 30
+	pkgs := mockRegistry{}
+	var refs []string
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("pkg-%d", i)
+		pkgs[name] = map[string]manifest{"1.0.0": {}}
+		refs = append(refs, fmt.Sprintf(`{"name":%q,"version":"1.0.0"}`, name))
+	}
+	registry := newMockRegistry(t, pkgs)
+	registry.delay = 10 * time.Millisecond
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	status, results := postBatch(t, server, "/v1/packages", "["+strings.Join(refs, ",")+"]")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, results, width)
+	// At most eight packages are resolved at once.
+	assert.LessOrEqual(t, registry.MaxInFlight(), 8)
+
+	tooMany := strings.Repeat(`{"name":"pkg-0","version":"1.0.0"},`, 100)
+	status, _ = postBatch(t, server, "/v1/packages", "["+tooMany+`{"name":"pkg-1","version":"1.0.0"}]`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = postBatch(t, server, "/v1/packages", `[{"name":"`+strings.Repeat("x", 2<<20)+`","version":"1.0.0"}]`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
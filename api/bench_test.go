@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// memoryRegistry serves registry documents from memory, so benchmarks
+// measure the resolver rather than the network stack.
+type memoryRegistry map[string]string
+
+func (m memoryRegistry) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok := m[req.URL.Path]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newBenchRegistry builds a registry where the root depends on width
+// packages, each with a chain of depth further packages. Every package
+// publishes versions releases.
+func newBenchRegistry(width, depth, versions int) memoryRegistry {
+	m := memoryRegistry{}
+	add := func(name string, deps map[string]string) {
+		var all []string
+		for v := 0; v < versions; v++ {
+			doc := fmt.Sprintf(`{"name":%q,"version":"1.%d.0","license":"MIT","dependencies":%s}`, name, v, jsonObject(deps))
+			m["/"+name+fmt.Sprintf("/1.%d.0", v)] = doc
+			all = append(all, fmt.Sprintf(`"1.%d.0":%s`, v, doc))
+		}
+		m["/"+name] = fmt.Sprintf(`{"name":%q,"versions":{%s}}`, name, strings.Join(all, ","))
+	}
+
+	root := map[string]string{}
+	for i := 0; i < width; i++ {
+		root[fmt.Sprintf("dep-%d-0", i)] = "^1.0.0"
+		for d := 0; d < depth; d++ {
+			next := map[string]string{}
+			if d < depth-1 {
+				next[fmt.Sprintf("dep-%d-%d", i, d+1)] = "^1.0.0"
+			}
+			add(fmt.Sprintf("dep-%d-%d", i, d), next)
+		}
+	}
+	add("root", root)
+	return m
+}
+
+func jsonObject(m map[string]string) string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, fmt.Sprintf("%q:%q", k, v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func newBenchServer(registry memoryRegistry) *server {
+	return newServer(
+		WithRegistryURL("http://registry.invalid"),
+		WithHTTPClient(&http.Client{Transport: registry}),
+		WithCacheTTL(0),
+	)
+}
+
+func BenchmarkResolveDependencies(b *testing.B) {
+	s := newBenchServer(newBenchRegistry(20, 5, 30))
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.newResolver(resolveOptions{}).resolve(ctx, "root", "^1.0.0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFetchPackageMeta(b *testing.B) {
+	s := newBenchServer(newBenchRegistry(1, 1, 500))
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.fetchPackageMeta(ctx, "root"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFetchPackage(b *testing.B) {
+	s := newBenchServer(newBenchRegistry(1, 1, 1))
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.fetchPackage(ctx, "root", "1.0.0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// probeRetryAfter is the Retry-After given to requests turned away while
+// a half-open breaker waits for its probe.
+const probeRetryAfter = time.Second
+
+// circuitBreakers keeps a circuitBreaker for each registry host, so that
+// an outage of one registry does not fail requests to its mirrors. A nil
+// circuitBreakers never opens.
+type circuitBreakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu     sync.Mutex
+	byHost map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(threshold int, cooldown time.Duration) *circuitBreakers {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreakers{threshold: threshold, cooldown: cooldown, byHost: map[string]*circuitBreaker{}}
+}
+
+// forURL returns the breaker of the host of rawURL.
+func (bs *circuitBreakers) forURL(rawURL string) *circuitBreaker {
+	if bs == nil {
+		return nil
+	}
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.byHost[host]
+	if !ok {
+		b = &circuitBreaker{host: host, threshold: bs.threshold, cooldown: bs.cooldown}
+		bs.byHost[host] = b
+	}
+	return b
+}
+
+// circuitBreaker stops sending requests to a registry after threshold
+// consecutive failures, failing fast until cooldown has elapsed. It then
+// lets a single probe request through: the circuit closes if the probe
+// succeeds and opens for another cooldown if it fails. A nil
+// circuitBreaker never opens.
+type circuitBreaker struct {
+	host      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// errCircuitOpen is returned while the breaker is failing fast.
+type errCircuitOpen struct {
+	host       string
+	retryAfter time.Duration
+}
+
+func (e *errCircuitOpen) Error() string {
+	return fmt.Sprintf("registry circuit breaker for %s is open, retry in %s", e.host, e.retryAfter.Round(time.Second))
+}
+
+// retryAfterSeconds rounds the remaining cooldown up to whole seconds, as
+// required by the Retry-After header.
+func (e *errCircuitOpen) retryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.retryAfter.Seconds())))
+}
+
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if remaining := time.Until(b.openUntil); remaining > 0 {
+		return &errCircuitOpen{host: b.host, retryAfter: remaining}
+	}
+	if b.probing {
+		return &errCircuitOpen{host: b.host, retryAfter: probeRetryAfter}
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the outcome of a registry request made
+// under ctx. Any answer below 500, such as a missing document, shows the
+// registry to be up. Requests their caller gave up on, cancelled or out of
+// the time the resolution allowed, and exhausted download budgets say
+// nothing about its health, but do end a probe, so that the next request
+// probes again. Only the registry timeout, network failures and server
+// errors count against the registry.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var regErr *registryError
+	switch {
+	case abandoned(ctx, err) || errors.Is(err, errDownloadBudgetExceeded):
+		b.probing = false
+	case err == nil || (errors.As(err, &regErr) && regErr.StatusCode < 500):
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+	case b.probing:
+		b.probing = false
+		b.openUntil = time.Now().Add(b.cooldown)
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+			b.failures = 0
+		}
+	}
+}
+
+// abandoned reports whether a request under ctx failed with err because
+// its caller gave up on it, rather than because the registry timeout ran
+// out.
+func abandoned(ctx context.Context, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	return ctx.Err() != nil && !errors.Is(context.Cause(ctx), errRegistryTimeout)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerIgnoresAbandonedRequests(t *testing.T) {
+	b := newCircuitBreakers(1, time.Minute).forURL("https://registry.example/app")
+
+	// The resolution ran out of time: the registry isn't to blame.
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	b.record(ctx, context.DeadlineExceeded)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	b.record(ctx, context.Canceled)
+	assert.Nil(t, b.allow())
+
+	// The registry timeout ran out: it is.
+	ctx, cancel = context.WithTimeoutCause(context.Background(), 0, errRegistryTimeout)
+	defer cancel()
+	b.record(ctx, context.DeadlineExceeded)
+	assert.NotNil(t, b.allow())
+}
+
+func TestCircuitBreakerCountsNetworkFailures(t *testing.T) {
+	b := newCircuitBreakers(1, time.Minute).forURL("https://registry.example/app")
+	b.record(context.Background(), &net.OpError{Op: "dial", Err: assert.AnError})
+	assert.NotNil(t, b.allow())
+}
@@ -0,0 +1,121 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestCircuitBreakerOpenReturnsRetryAfter(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{})
+	registry.status = http.StatusBadGateway
+
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithCircuitBreaker(2, 30*time.Second),
+	))
+	defer server.Close()
+
+	get := func() *http.Response {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+		require.Nil(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusBadGateway, get().StatusCode)
+	}
+
+	resp := get()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.Nil(t, err)
+	assert.Greater(t, retryAfter, 0)
+	assert.LessOrEqual(t, retryAfter, 30)
+
+	var body problem
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "/problems/registry-unavailable", body.Type)
+	assert.Contains(t, body.Title, "temporarily unavailable")
+	assert.Equal(t, retryAfter, body.RetryAfterSeconds)
+	assert.Len(t, registry.Requests(), 2)
+}
+
+func TestCircuitBreakerProbesForRecovery(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	registry.flaky = 1
+
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithCircuitBreaker(1, 50*time.Millisecond),
+	))
+	defer server.Close()
+
+	status := func() int {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusBadGateway, status())
+	assert.Equal(t, http.StatusServiceUnavailable, status())
+
+	// Once the cooldown has passed, a probe finds the registry recovered
+	// and closes the circuit.
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, status())
+	assert.Equal(t, http.StatusOK, status())
+}
+
+func TestCircuitBreakerIgnoresClientTimeouts(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	registry.delay = 50 * time.Millisecond
+
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithCacheTTL(0),
+		api.WithCircuitBreaker(2, time.Minute),
+	))
+	defer server.Close()
+
+	status := func(query string) int {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0" + query)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Requests giving up before the registry answers don't count against it.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusGatewayTimeout, status("?timeout=1ms"))
+	}
+	assert.Equal(t, http.StatusOK, status(""))
+}
+
+func TestCircuitBreakerPerRegistry(t *testing.T) {
+	primary := newMockRegistry(t, mockRegistry{})
+	primary.status = http.StatusBadGateway
+	mirror := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {}},
+		"lib": {"1.0.0": {}},
+	})
+
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(primary.URL),
+		api.WithRegistryMirrors("", mirror.URL),
+		api.WithCircuitBreaker(1, time.Minute),
+	))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/1.0.0").Version)
+	// The primary's open circuit does not keep requests from its mirror.
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/lib/1.0.0").Version)
+	assert.Equal(t, []string{"/app"}, primary.Requests())
+}
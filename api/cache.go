@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL         = 5 * time.Minute
+	defaultCacheSize        = 1000
+	defaultNotFoundCacheTTL = 30 * time.Second
+)
+
+// metaCache holds package metadata, and the documents of individual
+// versions, fetched from the registry for a limited time, evicting the
+// least recently used beyond its size. Entries live for the registry's
+// Cache-Control max-age, clamped to [minTTL, maxTTL], or for ttl when the
+// registry sends none. Packages the registry reports as not found are
+// remembered for notFoundTTL.
+type metaCache struct {
+	ttl         time.Duration
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	notFoundTTL time.Duration
+	entries     *lruCache[*npmPackageMetaResponse]
+	versions    *lruCache[*npmPackageResponse]
+	notFound    *lruCache[error]
+}
+
+func newMetaCache(ttl time.Duration, size int) *metaCache {
+	return &metaCache{
+		ttl:         ttl,
+		notFoundTTL: defaultNotFoundCacheTTL,
+		entries:     newLRUCache[*npmPackageMetaResponse](size),
+		versions:    newLRUCache[*npmPackageResponse](size),
+		notFound:    newLRUCache[error](size),
+	}
+}
+
+type cacheBypassKey struct{}
+
+// withCacheBypass marks ctx as wanting fresh registry data: cached entries
+// are ignored, though fresh results still replace them.
+func withCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// wantsFresh reports whether the client asked to bypass the caches, with
+// Cache-Control: no-cache or ?fresh=true.
+func wantsFresh(r *http.Request) bool {
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return r.URL.Query().Get("fresh") == "true"
+}
+
+// honorCacheBypass marks the context of requests that want fresh data.
+func honorCacheBypass(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wantsFresh(r) {
+			r = r.WithContext(withCacheBypass(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *metaCache) get(name string) (*npmPackageMetaResponse, bool) {
+	return c.entries.get(name)
+}
+
+func (c *metaCache) set(name string, meta *npmPackageMetaResponse, ttl time.Duration) {
+	if c.ttl <= 0 || ttl <= 0 {
+		return
+	}
+	c.entries.set(name, meta, ttl)
+}
+
+// stale returns the metadata of name even if it has expired, so that it
+// can be revalidated with the registry.
+func (c *metaCache) stale(name string) (*npmPackageMetaResponse, bool) {
+	return c.entries.peek(name)
+}
+
+// delete evicts the metadata of name, or the record of it not being
+// found, and reports whether either was cached.
+func (c *metaCache) delete(name string) bool {
+	notFound := c.notFound.delete(name)
+	return c.entries.delete(name) || notFound
+}
+
+// getNotFound returns the error with which the registry reported name as
+// not found, if that was recently cached.
+func (c *metaCache) getNotFound(name string) (error, bool) {
+	return c.notFound.get(name)
+}
+
+func (c *metaCache) setNotFound(name string, err error) {
+	if c.notFoundTTL <= 0 {
+		return
+	}
+	c.notFound.set(name, err, c.notFoundTTL)
+}
+
+func (c *metaCache) getVersion(name, version string) (*npmPackageResponse, bool) {
+	return c.versions.get(name + "@" + version)
+}
+
+func (c *metaCache) setVersion(name, version string, pkg *npmPackageResponse, ttl time.Duration) {
+	if c.ttl <= 0 || ttl <= 0 {
+		return
+	}
+	c.versions.set(name+"@"+version, pkg, ttl)
+}
+
+// ttlFor returns how long a registry response with the given headers
+// should be cached, or zero if caching is disabled.
+func (c *metaCache) ttlFor(header http.Header) time.Duration {
+	if c.ttl <= 0 {
+		return 0
+	}
+	ttl, ok := maxAge(header.Get("Cache-Control"))
+	if !ok {
+		return c.ttl
+	}
+	if c.minTTL > 0 && ttl < c.minTTL {
+		ttl = c.minTTL
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+	return ttl
+}
+
+// maxAge extracts the max-age directive from a Cache-Control header.
+func maxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+// A cache warm request may name at most maxWarmPackages packages, which
+// warmWorkers fetch.
+const (
+	maxWarmPackages = 1000
+	warmWorkers     = 8
+)
+
+type cacheWarmRequest struct {
+	Packages []string `json:"packages"`
+}
+
+type cacheWarmResponse struct {
+	Requested int               `json:"requested"`
+	Warmed    int               `json:"warmed"`
+	Failed    map[string]string `json:"failed"`
+}
+
+// cacheWarmHandler fetches metadata for a list of packages into the cache.
+// Fetches go through the same concurrency and rate limits as resolution.
+func (s *server) cacheWarmHandler(w http.ResponseWriter, r *http.Request) {
+	var req cacheWarmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.badRequest(w, r, "Invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Packages) > maxWarmPackages {
+		s.badRequest(w, r, fmt.Sprintf("Too many packages: at most %d may be warmed at once", maxWarmPackages))
+		return
+	}
+
+	resp := &cacheWarmResponse{Requested: len(req.Packages), Failed: map[string]string{}}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	names := make(chan string)
+	for i := 0; i < min(warmWorkers, len(req.Packages)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				_, err := s.fetchPackageMeta(r.Context(), name)
+
+				mu.Lock()
+				if err != nil {
+					resp.Failed[name] = err.Error()
+				} else {
+					resp.Warmed++
+				}
+				s.logger.Info("Cache warm progress", "package", name, "done", resp.Warmed+len(resp.Failed), "total", resp.Requested)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range req.Packages {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	s.writeJSON(w, http.StatusOK, resp)
+}
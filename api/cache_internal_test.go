@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheTTLFromCacheControl(t *testing.T) {
+	cacheControl := ""
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Write([]byte(`{"versions":{"1.0.0":{"name":"pkg","version":"1.0.0"}}}`))
+	}))
+	defer registry.Close()
+
+	s := newServer(
+		WithRegistryURL(registry.URL),
+		WithCacheTTL(10*time.Minute),
+		WithCacheTTLBounds(time.Minute, time.Hour),
+	)
+
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"public, max-age=300", 5 * time.Minute},
+		{"max-age=5", time.Minute},
+		{"max-age=86400", time.Hour},
+		{"", 10 * time.Minute},
+		{"no-cache", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			cacheControl = tt.cacheControl
+			s.metaCache.entries = newLRUCache[*npmPackageMetaResponse](defaultCacheSize)
+
+			before := time.Now()
+			_, err := s.fetchPackageMeta(context.Background(), "pkg")
+			require.Nil(t, err)
+
+			expires, ok := s.metaCache.entries.expires("pkg")
+			require.True(t, ok)
+			assert.WithinDuration(t, before.Add(tt.want), expires, time.Second)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestCacheWarmRespectsConcurrency(t *testing.T) {
+	const packages, concurrency = 40, 4
+
+	pkgs := mockRegistry{}
+	var names []string
+	for i := 0; i < packages; i++ {
+		name := fmt.Sprintf("pkg-%d", i)
+		pkgs[name] = map[string]manifest{"1.0.0": {}}
+		names = append(names, name)
+	}
+	names = append(names, "missing")
+	registry := newMockRegistry(t, pkgs)
+	registry.delay = 10 * time.Millisecond
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithConcurrency(concurrency), api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	reqBody, err := json.Marshal(map[string][]string{"packages": names})
+	require.Nil(t, err)
+	resp := adminRequest(t, server, http.MethodPost, "/cache/warm", bytes.NewReader(reqBody))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Requested int `json:"requested"`
+		Warmed    int `json:"warmed"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, packages+1, body.Requested)
+	assert.Equal(t, packages, body.Warmed)
+	assert.LessOrEqual(t, registry.MaxInFlight(), concurrency)
+	assert.Len(t, registry.Requests(), packages+1)
+
+	// Warmed metadata is served from the cache; only the version is fetched.
+	resp, err = server.Client().Get(server.URL + "/package/pkg-0/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/pkg-0/1.0.0", registry.Requests()[packages+1])
+	assert.Len(t, registry.Requests(), packages+2)
+}
+
+func TestCacheWarmLimitsPackages(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	names := make([]string, 1001)
+	for i := range names {
+		names[i] = fmt.Sprintf("pkg-%d", i)
+	}
+	reqBody, err := json.Marshal(map[string][]string{"packages": names})
+	require.Nil(t, err)
+	resp := adminRequest(t, server, http.MethodPost, "/cache/warm", bytes.NewReader(reqBody))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, registry.Requests())
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":   {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib":   {"1.0.0": deps(map[string]string{"leaf": "^1.0.0"})},
+		"leaf":  {"1.0.0": {}},
+		"other": {"1.0.0": deps(map[string]string{"util": "^1.0.0"})},
+		"util":  {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResultCacheTTL(time.Minute), api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	getTreeFrom(t, server, "/package/other/1.0.0")
+	cached := len(registry.Requests())
+
+	// Both trees are served from the result cache.
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	getTreeFrom(t, server, "/package/other/1.0.0")
+	require.Len(t, registry.Requests(), cached)
+
+	reqBody, err := json.Marshal(map[string][]string{"packages": {"leaf"}})
+	require.Nil(t, err)
+	resp := adminRequest(t, server, http.MethodPost, "/cache/invalidate", bytes.NewReader(reqBody))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Metadata int `json:"metadata"`
+		Trees    int `json:"trees"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, 1, body.Metadata)
+	assert.Equal(t, 1, body.Trees)
+
+	// The unrelated tree is still cached.
+	getTreeFrom(t, server, "/package/other/1.0.0")
+	require.Len(t, registry.Requests(), cached)
+
+	// The tree including leaf is resolved again, refetching leaf's metadata.
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Contains(t, registry.Requests()[cached:], "/leaf")
+}
+
+func TestCacheBypass(t *testing.T) {
+	pkgs := mockRegistry{"app": {"1.0.0": {}}}
+	registry := newMockRegistry(t, pkgs)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResultCacheTTL(time.Minute)))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	cached := len(registry.Requests())
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	require.Len(t, registry.Requests(), cached)
+
+	pkgs["app"]["1.1.0"] = manifest{}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/package/app/^1.0.0", nil)
+	require.Nil(t, err)
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := server.Client().Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	assert.Equal(t, "1.1.0", tree.Version)
+	assert.Equal(t, []string{"/app", "/app/1.1.0"}, registry.Requests()[cached:])
+
+	// The fresh results replaced the cached ones.
+	fresh := len(registry.Requests())
+	assert.Equal(t, "1.1.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	assert.Len(t, registry.Requests(), fresh)
+
+	assert.Equal(t, "1.1.0", getTreeFrom(t, server, "/package/app/1.1.0?fresh=true").Version)
+	assert.Equal(t, []string{"/app", "/app/1.1.0"}, registry.Requests()[fresh:])
+}
+
+func TestCacheSize(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":   {"1.0.0": {}},
+		"other": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithCacheSize(1)))
+	defer server.Close()
+
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, []string{"/app", "/app/1.0.0"}, registry.Requests())
+
+	// Caching other evicts app.
+	getTreeFrom(t, server, "/package/other/1.0.0")
+	getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, []string{"/other", "/other/1.0.0", "/app", "/app/1.0.0"}, registry.Requests()[2:])
+}
+
+func TestCacheRevalidatesWithETag(t *testing.T) {
+	pkgs := mockRegistry{"app": {"1.0.0": {}}}
+	registry := newMockRegistry(t, pkgs)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithCacheTTL(time.Nanosecond)))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	assert.Equal(t, []string{"/app", "/app/1.0.0", "/app", "/app/1.0.0"}, registry.Requests())
+	assert.Equal(t, 1, registry.NotModified())
+
+	pkgs["app"]["1.1.0"] = manifest{}
+	assert.Equal(t, "1.1.0", getTreeFrom(t, server, "/package/app/^1.0.0").Version)
+	assert.Equal(t, 1, registry.NotModified())
+}
+
+func TestCacheNotFound(t *testing.T) {
+	pkgs := mockRegistry{}
+	registry := newMockRegistry(t, pkgs)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	status := func() int {
+		resp, err := server.Client().Get(server.URL + "/package/ghost/1.0.0")
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusNotFound, status())
+	assert.Equal(t, http.StatusNotFound, status())
+	assert.Equal(t, []string{"/ghost"}, registry.Requests())
+
+	// Publishing the package and invalidating it ends the negative entry.
+	pkgs["ghost"] = map[string]manifest{"1.0.0": {}}
+	resp := adminRequest(t, server, http.MethodPost, "/cache/invalidate", strings.NewReader(`{"packages":["ghost"]}`))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, status())
+}
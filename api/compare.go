@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type packageRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type sharedPackage struct {
+	Name   string   `json:"name"`
+	A      []string `json:"a"`
+	B      []string `json:"b"`
+	Agreed bool     `json:"agreed"`
+}
+
+type packageVersions struct {
+	Name     string   `json:"name"`
+	Versions []string `json:"versions"`
+}
+
+type compareResponse struct {
+	A      packageRef        `json:"a"`
+	B      packageRef        `json:"b"`
+	Shared []sharedPackage   `json:"shared"`
+	OnlyA  []packageVersions `json:"onlyA"`
+	OnlyB  []packageVersions `json:"onlyB"`
+}
+
+// compareHandler resolves two packages and reports which dependencies
+// they share and which are unique to each.
+func (s *server) compareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	specs := []string{query.Get("a"), query.Get("b")}
+	for _, spec := range specs {
+		if _, _, err := splitPackageSpec(spec); err != nil {
+			s.badRequest(w, r, "Expected query parameters a and b in the form name@version")
+			return
+		}
+	}
+
+	opts, err := parseResolveOptions(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+
+	refs := make([]packageRef, len(specs))
+	for i, spec := range specs {
+		refs[i].Name, refs[i].Version, _ = splitPackageSpec(spec)
+	}
+	trees, err := s.resolveAll(r, opts, refs)
+	if err != nil {
+		s.logger.Error("resolution failed", "a", specs[0], "b", specs[1], "error", err)
+		s.writeResolveError(w, r, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, compareTrees(trees[0], trees[1]))
+}
+
+// resolveAll resolves packages concurrently, failing if any fails.
+func (s *server) resolveAll(r *http.Request, opts resolveOptions, refs []packageRef) ([]*NpmPackageVersion, error) {
+	var wg sync.WaitGroup
+	trees := make([]*NpmPackageVersion, len(refs))
+	errs := make([]error, len(refs))
+	for i, ref := range refs {
+		wg.Add(1)
+		go func(i int, ref packageRef) {
+			defer wg.Done()
+			trees[i], errs[i] = s.newResolver(opts).resolve(r.Context(), ref.Name, ref.Version)
+		}(i, ref)
+	}
+	wg.Wait()
+	return trees, errors.Join(errs...)
+}
+
+func compareTrees(a, b *NpmPackageVersion) *compareResponse {
+	versionsA, versionsB := collectVersions(a), collectVersions(b)
+	resp := &compareResponse{
+		A:      packageRef{Name: a.Name, Version: a.Version},
+		B:      packageRef{Name: b.Name, Version: b.Version},
+		Shared: []sharedPackage{},
+		OnlyA:  []packageVersions{},
+		OnlyB:  []packageVersions{},
+	}
+	for _, name := range sortedKeys(versionsA) {
+		inB, ok := versionsB[name]
+		if !ok {
+			resp.OnlyA = append(resp.OnlyA, packageVersions{Name: name, Versions: versionsA[name]})
+			continue
+		}
+		resp.Shared = append(resp.Shared, sharedPackage{
+			Name:   name,
+			A:      versionsA[name],
+			B:      inB,
+			Agreed: slices.Equal(versionsA[name], inB),
+		})
+	}
+	for _, name := range sortedKeys(versionsB) {
+		if _, ok := versionsA[name]; !ok {
+			resp.OnlyB = append(resp.OnlyB, packageVersions{Name: name, Versions: versionsB[name]})
+		}
+	}
+	return resp
+}
+
+// collectVersions flattens a tree, excluding its root, into the sorted set
+// of resolved versions of each package.
+func collectVersions(root *NpmPackageVersion) map[string][]string {
+	seen := map[string]map[string]bool{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		for _, dep := range pkg.Dependencies {
+			if seen[dep.Name] == nil {
+				seen[dep.Name] = map[string]bool{}
+			}
+			seen[dep.Name][dep.Version] = true
+			walk(dep)
+		}
+	}
+	walk(root)
+
+	versions := make(map[string][]string, len(seen))
+	for name, set := range seen {
+		versions[name] = sortedKeys(set)
+	}
+	return versions
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitPackageSpec splits "name@version" into its parts. Scoped names
+// such as "@babel/core@7.0.0" keep their leading "@".
+func splitPackageSpec(spec string) (name, version string, err error) {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 || i == len(spec)-1 {
+		return "", "", fmt.Errorf("invalid package spec %q", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
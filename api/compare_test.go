@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type packageVersions struct {
+	Name     string   `json:"name"`
+	Versions []string `json:"versions"`
+}
+
+type sharedPackage struct {
+	Name   string   `json:"name"`
+	A      []string `json:"a"`
+	B      []string `json:"b"`
+	Agreed bool     `json:"agreed"`
+}
+
+func TestCompare(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"lib-a":  {"1.0.0": deps(map[string]string{"shared": "^1.0.0", "only-a": "^1.0.0", "skew": "^1.0.0"})},
+		"lib-b":  {"2.0.0": deps(map[string]string{"shared": "^1.0.0", "only-b": "^3.0.0", "skew": "^2.0.0"})},
+		"shared": {"1.2.0": {}},
+		"only-a": {"1.0.0": {}},
+		"only-b": {"3.1.0": {}},
+		"skew":   {"1.9.0": {}, "2.0.1": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/compare?a=lib-a@1.0.0&b=lib-b@2.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Shared []sharedPackage   `json:"shared"`
+		OnlyA  []packageVersions `json:"onlyA"`
+		OnlyB  []packageVersions `json:"onlyB"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, []sharedPackage{
+		{Name: "shared", A: []string{"1.2.0"}, B: []string{"1.2.0"}, Agreed: true},
+		{Name: "skew", A: []string{"1.9.0"}, B: []string{"2.0.1"}, Agreed: false},
+	}, body.Shared)
+	assert.Equal(t, []packageVersions{{Name: "only-a", Versions: []string{"1.0.0"}}}, body.OnlyA)
+	assert.Equal(t, []packageVersions{{Name: "only-b", Versions: []string{"3.1.0"}}}, body.OnlyB)
+}
+
+func TestCompareRejectsMalformedSpecs(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/compare?a=lib-a&b=lib-b@2.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
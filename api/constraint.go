@@ -0,0 +1,125 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// releasePattern matches a full release version, without prerelease or
+// build metadata, which is all canonicalConstraint understands.
+var releasePattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)$`)
+
+// versionBound is one end of a version interval.
+type versionBound struct {
+	version   *semver.Version
+	inclusive bool
+}
+
+// canonicalConstraint rewrites a constraint into an equivalent canonical
+// form, so that different spellings of the same range, such as "^1.2.3"
+// and ">=1.2.3 <2.0.0", share a selection. It only rewrites constraints
+// that are a single intersection of comparators against full release
+// versions, whose meaning is an interval of releases; anything else, such
+// as unions, x-ranges or prereleases, is returned unchanged.
+func canonicalConstraint(constraint string) string {
+	tokens := strings.FieldsFunc(constraint, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(tokens) == 0 {
+		return constraint
+	}
+	lower := versionBound{version: semver.New(0, 0, 0, "", ""), inclusive: true}
+	var upper *versionBound
+	for i := 0; i < len(tokens); i++ {
+		op, version := splitComparator(tokens[i])
+		if version == "" && i+1 < len(tokens) {
+			i++
+			version = tokens[i]
+		}
+		if !releasePattern.MatchString(version) {
+			return constraint
+		}
+		v := semver.MustParse(version)
+		lo, hi, ok := comparatorBounds(op, v)
+		if !ok {
+			return constraint
+		}
+		if lo != nil && (lo.version.GreaterThan(lower.version) || (lo.version.Equal(lower.version) && !lo.inclusive)) {
+			lower = *lo
+		}
+		if hi != nil && (upper == nil || hi.version.LessThan(upper.version) || (hi.version.Equal(upper.version) && !hi.inclusive)) {
+			upper = hi
+		}
+	}
+
+	if upper == nil {
+		return lowerString(lower)
+	}
+	if lower.version.GreaterThan(upper.version) {
+		return constraint
+	}
+	if lower.version.Equal(upper.version) {
+		if lower.inclusive && upper.inclusive {
+			return lower.version.String()
+		}
+		return constraint
+	}
+	upperOp := "<"
+	if upper.inclusive {
+		upperOp = "<="
+	}
+	return lowerString(lower) + " " + upperOp + upper.version.String()
+}
+
+func lowerString(b versionBound) string {
+	if b.inclusive {
+		return ">=" + b.version.String()
+	}
+	return ">" + b.version.String()
+}
+
+// splitComparator splits a comparator such as ">=1.2.3" into its operator
+// and version.
+func splitComparator(token string) (op, version string) {
+	i := strings.IndexFunc(token, func(r rune) bool { return !strings.ContainsRune("=<>~^", r) })
+	if i < 0 {
+		return token, ""
+	}
+	return token[:i], token[i:]
+}
+
+// comparatorBounds returns the interval of releases matched by a single
+// comparator against the full release v, following the semantics of
+// github.com/Masterminds/semver/v3.
+func comparatorBounds(op string, v *semver.Version) (lower, upper *versionBound, ok bool) {
+	at := func(v semver.Version, inclusive bool) *versionBound {
+		return &versionBound{version: &v, inclusive: inclusive}
+	}
+	switch op {
+	case "", "=":
+		return at(*v, true), at(*v, true), true
+	case ">=", "=>":
+		return at(*v, true), nil, true
+	case ">":
+		return at(*v, false), nil, true
+	case "<=", "=<":
+		return nil, at(*v, true), true
+	case "<":
+		return nil, at(*v, false), true
+	case "~", "~>":
+		if v.Major() == 0 && v.Minor() == 0 && v.Patch() == 0 {
+			return at(*v, true), nil, true
+		}
+		return at(*v, true), at(v.IncMinor(), false), true
+	case "^":
+		switch {
+		case v.Major() > 0:
+			return at(*v, true), at(v.IncMajor(), false), true
+		case v.Minor() > 0:
+			return at(*v, true), at(v.IncMinor(), false), true
+		default:
+			return at(*v, true), at(v.IncPatch(), false), true
+		}
+	}
+	return nil, nil, false
+}
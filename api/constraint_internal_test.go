@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalConstraintIsEquivalent(t *testing.T) {
+	var versions []*semver.Version
+	for ma := 0; ma < 4; ma++ {
+		for mi := 0; mi < 4; mi++ {
+			for pa := 0; pa < 4; pa++ {
+				versions = append(versions, semver.MustParse(fmt.Sprintf("%d.%d.%d", ma, mi, pa)))
+				versions = append(versions, semver.MustParse(fmt.Sprintf("%d.%d.%d-rc.1", ma, mi, pa)))
+			}
+		}
+	}
+
+	for _, constraint := range []string{
+		"^1.2.3", "^0.2.3", "^0.0.3", "^0.0.0", "~1.2.3", "~0.0.0", "~>2.1.0", "1.2.3", "=1.2.3", "v1.2.3",
+		">=1.0.0 <2.0.0", ">= 1.0.0, < 2.0.0", ">1.0.0 <=2.0.0", "<3.0.0", ">=0.0.0", "^1.0.0 <1.2.0",
+		">2.0.0 <1.0.0", "^1.x", "1.2", "*", "^1.0.0 || ^2.0.0", ">=1.0.0-rc.1", "!=1.2.3",
+	} {
+		canonical := canonicalConstraint(constraint)
+		want, err := semver.NewConstraint(constraint)
+		require.Nil(t, err, constraint)
+		got, err := semver.NewConstraint(canonical)
+		require.Nil(t, err, canonical)
+		for _, v := range versions {
+			assert.Equal(t, want.Check(v), got.Check(v), "%q (canonical %q) against %s", constraint, canonical, v)
+		}
+	}
+}
+
+func TestCanonicalConstraintMergesEquivalentSpellings(t *testing.T) {
+	for _, equivalent := range [][]string{
+		{"^1.0.0", ">=1.0.0 <2.0.0", ">= 1.0.0, <2.0.0", ">=1.0.0 <2.0.0 <3.0.0"},
+		{"~1.2.3", "^1.2.3 <1.3.0", ">=1.2.3 <1.3.0"},
+		{"1.2.3", "=1.2.3", "^1.2.3 <=1.2.3", "v1.2.3"},
+	} {
+		for _, constraint := range equivalent[1:] {
+			assert.Equal(t, canonicalConstraint(equivalent[0]), canonicalConstraint(constraint), constraint)
+		}
+	}
+	// Not provably the same interval of releases, so left alone.
+	assert.Equal(t, "^1.x", canonicalConstraint("^1.x"))
+	assert.Equal(t, "^1.0.0 || ^2.0.0", canonicalConstraint("^1.0.0 || ^2.0.0"))
+	assert.Equal(t, ">=1.0.0-rc.1", canonicalConstraint(">=1.0.0-rc.1"))
+}
+
+func TestEquivalentConstraintsShareSelection(t *testing.T) {
+	res := newServer().newResolver(resolveOptions{})
+	meta := &npmPackageMetaResponse{Name: "lib", Versions: map[string]npmPackageResponse{"1.0.0": {}, "1.5.0": {}}}
+
+	version, err := res.selectVersion("^1.0.0", meta)
+	require.Nil(t, err)
+	assert.Equal(t, "1.5.0", version)
+
+	// A newer release appearing mid-resolution does not change the
+	// selection for an equivalent constraint: it is served from the
+	// resolution's selections rather than selected again.
+	meta.Versions["1.9.0"] = npmPackageResponse{}
+	version, err = res.selectVersion(">=1.0.0 <2.0.0", meta)
+	require.Nil(t, err)
+	assert.Equal(t, "1.5.0", version)
+	assert.Len(t, res.selected, 1)
+
+	version, err = res.selectVersion("^1.5.0", meta)
+	require.Nil(t, err)
+	assert.Equal(t, "1.9.0", version)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+)
+
+var csvHeader = []string{"name", "version", "constraint", "parent", "depth", "license"}
+
+// csvRows flattens the tree into one row per package in it, depth first,
+// each with the constraint and name@version of the package requiring it.
+// The root is the row at depth 0.
+func csvRows(root *NpmPackageVersion) [][]string {
+	rows := [][]string{csvHeader}
+	var walk func(pkg *NpmPackageVersion, parent string, depth int)
+	walk = func(pkg *NpmPackageVersion, parent string, depth int) {
+		rows = append(rows, []string{pkg.Name, pkg.Version, pkg.Spec, parent, strconv.Itoa(depth), pkg.License})
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			walk(pkg.Dependencies[key], pkg.Name+"@"+pkg.Version, depth+1)
+		}
+	}
+	walk(root, "", 0)
+	return rows
+}
+
+// csvHandler answers ?format=csv with the resolved tree as CSV, for
+// spreadsheets and BI tools.
+func (s *server) csvHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(status)
+	if err := csv.NewWriter(w).WriteAll(csvRows(rootPkg)); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "csv")
+}
@@ -0,0 +1,40 @@
+package api_test
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestCSVFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": manifest{"license": "MIT", "dependencies": map[string]string{"a": "^1.0.0", "b": "~2.0.0"}}},
+		"a":      {"1.0.0": manifest{"license": "ISC", "dependencies": map[string]string{"shared": "^1.0.0"}}},
+		"b":      {"2.0.1": manifest{"license": "(MIT OR Apache-2.0)", "dependencies": map[string]string{"shared": "1.x"}}},
+		"shared": {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=csv")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	require.Nil(t, err)
+
+	assert.Equal(t, [][]string{
+		{"name", "version", "constraint", "parent", "depth", "license"},
+		{"app", "1.0.0", "", "", "0", "MIT"},
+		{"a", "1.0.0", "^1.0.0", "app@1.0.0", "1", "ISC"},
+		{"shared", "1.2.0", "^1.0.0", "a@1.0.0", "2", ""},
+		{"b", "2.0.1", "~2.0.0", "app@1.0.0", "1", "(MIT OR Apache-2.0)"},
+		{"shared", "1.2.0", "1.x", "b@2.0.1", "2", ""},
+	}, rows)
+}
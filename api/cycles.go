@@ -0,0 +1,13 @@
+package api
+
+// circular reports whether pkg, once its version is selected, appears
+// among its own ancestors, as packages that depend on each other do on
+// npm. Resolving its dependencies again would never end.
+func circular(pkg *NpmPackageVersion) bool {
+	for ancestor := pkg.parent; ancestor != nil; ancestor = ancestor.parent {
+		if ancestor.Name == pkg.Name && ancestor.Version == pkg.Version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cycloneDXBOM is a CycloneDX 1.5 software bill of materials, in its JSON
+// encoding.
+type cycloneDXBOM struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDXMetadata     `json:"metadata"`
+	Components   []*cycloneDXComponent `json:"components"`
+	Dependencies []cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string              `json:"timestamp"`
+	Component *cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXComponent struct {
+	Type     string             `json:"type"`
+	BOMRef   string             `json:"bom-ref"`
+	Group    string             `json:"group,omitempty"`
+	Name     string             `json:"name"`
+	Version  string             `json:"version"`
+	PURL     string             `json:"purl"`
+	Scope    string             `json:"scope,omitempty"`
+	Licenses []cycloneDXLicense `json:"licenses,omitempty"`
+	Hashes   []cycloneDXHash    `json:"hashes,omitempty"`
+}
+
+// cycloneDXLicense holds either a single license or an SPDX expression
+// combining several.
+type cycloneDXLicense struct {
+	License    *cycloneDXLicenseID `json:"license,omitempty"`
+	Expression string              `json:"expression,omitempty"`
+}
+
+type cycloneDXLicenseID struct {
+	ID string `json:"id"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cycloneDXHashAlgorithms maps Subresource Integrity algorithms to their
+// CycloneDX names.
+var cycloneDXHashAlgorithms = map[string]string{
+	"sha1":   "SHA-1",
+	"sha256": "SHA-256",
+	"sha384": "SHA-384",
+	"sha512": "SHA-512",
+}
+
+// newCycloneDXBOM describes the tree as a CycloneDX BOM: the root as the
+// subject of the BOM, every other package version as a component, and
+// what each depends on.
+func newCycloneDXBOM(root *NpmPackageVersion) *cycloneDXBOM {
+	packages, dependsOn := bomPackages(root)
+	rootID := root.Name + "@" + root.Version
+	bom := &cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Component: newCycloneDXComponent(root),
+		},
+		Components: []*cycloneDXComponent{},
+	}
+	for _, id := range sortedKeys(packages) {
+		ref := packageURL(packages[id].Name, packages[id].Version)
+		if id != rootID {
+			bom.Components = append(bom.Components, newCycloneDXComponent(packages[id]))
+		}
+		refs := make([]string, len(dependsOn[id]))
+		for i, dep := range dependsOn[id] {
+			refs[i] = packageURL(packages[dep].Name, packages[dep].Version)
+		}
+		bom.Dependencies = append(bom.Dependencies, cycloneDXDependency{Ref: ref, DependsOn: refs})
+	}
+	return bom
+}
+
+func newCycloneDXComponent(pkg *NpmPackageVersion) *cycloneDXComponent {
+	purl := packageURL(pkg.Name, pkg.Version)
+	component := &cycloneDXComponent{Type: "library", BOMRef: purl, Name: pkg.Name, Version: pkg.Version, PURL: purl}
+	if scope, name, ok := strings.Cut(pkg.Name, "/"); ok && strings.HasPrefix(scope, "@") {
+		component.Group, component.Name = scope, name
+	}
+	if pkg.Dev {
+		component.Scope = "excluded"
+	} else if pkg.Optional {
+		component.Scope = "optional"
+	}
+	switch {
+	case pkg.License == "":
+	case strings.ContainsAny(pkg.License, " ()"):
+		component.Licenses = []cycloneDXLicense{{Expression: pkg.License}}
+	default:
+		component.Licenses = []cycloneDXLicense{{License: &cycloneDXLicenseID{ID: pkg.License}}}
+	}
+	for _, digest := range distDigests(pkg.Dist) {
+		component.Hashes = append(component.Hashes, cycloneDXHash{Alg: cycloneDXHashAlgorithms[digest.algorithm], Content: digest.hex})
+	}
+	return component
+}
+
+// cycloneDXHandler answers ?format=cyclonedx with the resolved tree as a
+// CycloneDX BOM.
+func (s *server) cycloneDXHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, newCycloneDXBOM(rootPkg)) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "cyclonedx")
+	}
+}
@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type cycloneDXComponent struct {
+	BOMRef   string `json:"bom-ref"`
+	Group    string `json:"group"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	PURL     string `json:"purl"`
+	Licenses []struct {
+		License *struct {
+			ID string `json:"id"`
+		} `json:"license"`
+		Expression string `json:"expression"`
+	} `json:"licenses"`
+	Hashes []struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	} `json:"hashes"`
+}
+
+type cycloneDXBOM struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Metadata     struct {
+		Component cycloneDXComponent `json:"component"`
+	} `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+	Dependencies []struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	} `json:"dependencies"`
+}
+
+func TestCycloneDXFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{"license": "MIT", "dependencies": map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0"}}},
+		"a": {"1.0.0": manifest{
+			"license":      "(MIT OR Apache-2.0)",
+			"dependencies": map[string]string{"shared": "^1.0.0"},
+			"dist":         map[string]string{"tarball": "https://registry.test/a/-/a-1.0.0.tgz", "integrity": "sha512-AAEC"},
+		}},
+		"@scope/c": {"2.1.0": manifest{
+			"license":      "ISC",
+			"dependencies": map[string]string{"shared": "^1.0.0"},
+			"dist":         map[string]string{"tarball": "https://registry.test/@scope/c/-/c-2.1.0.tgz", "shasum": "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"},
+		}},
+		"shared": {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=cyclonedx")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var bom cycloneDXBOM
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&bom))
+
+	assert.Equal(t, "CycloneDX", bom.BOMFormat)
+	assert.Equal(t, "1.5", bom.SpecVersion)
+	assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, bom.SerialNumber)
+	assert.Equal(t, "pkg:npm/app@1.0.0", bom.Metadata.Component.PURL)
+	assert.Equal(t, "MIT", bom.Metadata.Component.Licenses[0].License.ID)
+
+	require.Len(t, bom.Components, 3, "shared is listed once")
+	c, a, shared := bom.Components[0], bom.Components[1], bom.Components[2]
+	assert.Equal(t, "pkg:npm/%40scope/c@2.1.0", c.PURL)
+	assert.Equal(t, c.PURL, c.BOMRef)
+	assert.Equal(t, "@scope", c.Group)
+	assert.Equal(t, "c", c.Name)
+	assert.Equal(t, "ISC", c.Licenses[0].License.ID)
+	assert.Equal(t, "SHA-1", c.Hashes[0].Alg)
+	assert.Equal(t, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33", c.Hashes[0].Content)
+
+	assert.Equal(t, "(MIT OR Apache-2.0)", a.Licenses[0].Expression)
+	assert.Equal(t, "SHA-512", a.Hashes[0].Alg)
+	assert.Equal(t, "000102", a.Hashes[0].Content)
+	assert.Equal(t, "pkg:npm/shared@1.2.0", shared.PURL)
+	assert.Empty(t, shared.Licenses)
+
+	dependsOn := map[string][]string{}
+	for _, dep := range bom.Dependencies {
+		dependsOn[dep.Ref] = dep.DependsOn
+	}
+	assert.Equal(t, map[string][]string{
+		"pkg:npm/app@1.0.0":        {"pkg:npm/%40scope/c@2.1.0", "pkg:npm/a@1.0.0"},
+		"pkg:npm/%40scope/c@2.1.0": {"pkg:npm/shared@1.2.0"},
+		"pkg:npm/a@1.0.0":          {"pkg:npm/shared@1.2.0"},
+		"pkg:npm/shared@1.2.0":     {},
+	}, dependsOn)
+}
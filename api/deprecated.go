@@ -0,0 +1,14 @@
+package api
+
+// deprecatedPackages returns the deprecated package versions in the tree,
+// the root included, by name and version.
+func deprecatedPackages(root *NpmPackageVersion) []deprecatedPackage {
+	var deprecated []deprecatedPackage
+	packages, _ := bomPackages(root)
+	for _, id := range sortedKeys(packages) {
+		if pkg := packages[id]; pkg.Deprecated != "" {
+			deprecated = append(deprecated, deprecatedPackage{Name: pkg.Name, Version: pkg.Version, Message: pkg.Deprecated})
+		}
+	}
+	return deprecated
+}
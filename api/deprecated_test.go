@@ -0,0 +1,56 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type deprecation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Message string `json:"message"`
+}
+
+func TestDeprecations(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"old": "^1.0.0", "lib": "^1.0.0"})},
+		"lib":  {"1.0.0": deps(map[string]string{"old": "^1.0.0"})},
+		"old":  {"1.0.0": {"deprecated": "use new instead"}},
+		"flag": {"1.0.0": {"deprecated": true}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Dependencies map[string]struct {
+			Deprecated   string `json:"deprecated"`
+			Dependencies map[string]struct {
+				Deprecated string `json:"deprecated"`
+			} `json:"dependencies"`
+		} `json:"dependencies"`
+		Deprecations []deprecation `json:"deprecations"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, "use new instead", body.Dependencies["old"].Deprecated)
+	assert.Equal(t, "use new instead", body.Dependencies["lib"].Dependencies["old"].Deprecated)
+	assert.Empty(t, body.Dependencies["lib"].Deprecated)
+	// A package deprecated twice in the tree is listed once.
+	assert.Equal(t, []deprecation{{Name: "old", Version: "1.0.0", Message: "use new instead"}}, body.Deprecations)
+
+	// A deprecation published as a boolean has no message to report.
+	tree := getTreeFrom(t, server, "/v1/package/flag/1.0.0")
+	assert.Equal(t, "flag", tree.Name)
+	assert.Empty(t, tree.Deprecated)
+}
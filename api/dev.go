@@ -0,0 +1,43 @@
+package api
+
+import "context"
+
+// resolveDevDependencies resolves the devDependencies of the root package
+// alongside its dependencies and marks them, and everything beneath them,
+// as dev. A package listed under both is a production dependency, as it
+// is for npm.
+func (res *resolver) resolveDevDependencies(ctx context.Context, root *NpmPackageVersion, devDependencies map[string]string) error {
+	dev := make(map[string]string, len(devDependencies))
+	for name, constraint := range devDependencies {
+		if _, ok := root.Dependencies[name]; !ok {
+			dev[name] = constraint
+		}
+	}
+	if err := res.resolveChildren(ctx, root, dev, nil, 0); err != nil {
+		return err
+	}
+	for name := range dev {
+		markDev(root.Dependencies[name])
+	}
+	return nil
+}
+
+func markDev(pkg *NpmPackageVersion) {
+	pkg.Dev = true
+	for _, dep := range pkg.Dependencies {
+		markDev(dep)
+	}
+}
+
+// mergeDependencies returns dependencies together with those of others
+// that it does not already list.
+func mergeDependencies(dependencies, others map[string]string) map[string]string {
+	all := make(map[string]string, len(dependencies)+len(others))
+	for name, constraint := range others {
+		all[name] = constraint
+	}
+	for name, constraint := range dependencies {
+		all[name] = constraint
+	}
+	return all
+}
@@ -0,0 +1,88 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestDevDependencies(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{
+			"dependencies":    map[string]string{"lib": "^1.0.0"},
+			"devDependencies": map[string]string{"jest": "^29.0.0", "lib": "^1.0.0"},
+		}},
+		"lib":    {"1.0.0": {}},
+		"jest":   {"29.1.0": deps(map[string]string{"expect": "^29.0.0"})},
+		"expect": {"29.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.NotContains(t, tree.Dependencies, "jest")
+	assert.NotContains(t, registry.Requests(), "/jest")
+
+	tree = getTreeFrom(t, server, "/package/app/1.0.0?dev=true")
+	require.Contains(t, tree.Dependencies, "jest")
+	assert.Equal(t, "29.1.0", tree.Dependencies["jest"].Version)
+	assert.True(t, tree.Dependencies["jest"].Dev)
+	assert.True(t, tree.Dependencies["jest"].Dependencies["expect"].Dev)
+	// Listed under both, lib is a production dependency.
+	assert.False(t, tree.Dependencies["lib"].Dev)
+	assert.False(t, tree.Dev)
+}
+
+func TestDevDependenciesOfManifest(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"lib":  {"1.0.0": {}},
+		"jest": {"29.1.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	body := `{
+		"name": "my-app",
+		"version": "0.1.0",
+		"dependencies": {"lib": "^1.0.0"},
+		"devDependencies": {"jest": "^29.0.0", "local": "file:../local"}
+	}`
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	assert.NotContains(t, tree.Dependencies, "jest")
+
+	body = strings.Replace(body, `, "local": "file:../local"`, "", 1)
+	resp, err = server.Client().Post(server.URL+"/resolve?dev=true", "application/json", strings.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tree = api.NpmPackageVersion{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	require.Contains(t, tree.Dependencies, "jest")
+	assert.True(t, tree.Dependencies["jest"].Dev)
+	assert.False(t, tree.Dependencies["lib"].Dev)
+}
+
+func TestDevDependenciesInFlatFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{
+			"dependencies":    map[string]string{"lib": "^1.0.0"},
+			"devDependencies": map[string]string{"jest": "^29.0.0"},
+		}},
+		"lib":  {"1.0.0": {}},
+		"jest": {"29.1.0": {}},
+	})
+
+	set := getInstallSet(t, registry, "/package/app/1.0.0?format=flat&dev=true")
+	assert.Equal(t, map[string]string{"lib": "1.0.0", "jest": "29.1.0"}, set.Packages)
+}
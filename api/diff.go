@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+)
+
+// versionChange is a dependency both trees have, at different versions.
+type versionChange struct {
+	Name string   `json:"name"`
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+type diffResponse struct {
+	Name    string            `json:"name"`
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Added   []packageVersions `json:"added"`
+	Removed []packageVersions `json:"removed"`
+	Changed []versionChange   `json:"changed"`
+}
+
+// diffHandler resolves two versions of a package and reports how its
+// transitive dependencies change from one to the other: those added,
+// those removed and those whose versions changed.
+func (s *server) diffHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("package")
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" || to == "" {
+		s.badRequest(w, r, "Expected query parameters from and to, the versions to compare")
+		return
+	}
+
+	opts, err := parseResolveOptions(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	trees, err := s.resolveAll(r, opts, []packageRef{{Name: name, Version: from}, {Name: name, Version: to}})
+	if err != nil {
+		s.logger.Error("resolution failed", "package", name, "from", from, "to", to, "error", err)
+		s.writeResolveError(w, r, err)
+		return
+	}
+
+	if s.writeJSON(w, http.StatusOK, diffTrees(trees[0], trees[1])) {
+		s.logger.Info("Successfully handled request", "package", name, "from", trees[0].Version, "to", trees[1].Version)
+	}
+}
+
+func diffTrees(from, to *NpmPackageVersion) *diffResponse {
+	versionsFrom, versionsTo := collectVersions(from), collectVersions(to)
+	resp := &diffResponse{
+		Name:    from.Name,
+		From:    from.Version,
+		To:      to.Version,
+		Added:   []packageVersions{},
+		Removed: []packageVersions{},
+		Changed: []versionChange{},
+	}
+	for _, name := range sortedKeys(versionsFrom) {
+		inTo, ok := versionsTo[name]
+		switch {
+		case !ok:
+			resp.Removed = append(resp.Removed, packageVersions{Name: name, Versions: versionsFrom[name]})
+		case !slices.Equal(versionsFrom[name], inTo):
+			resp.Changed = append(resp.Changed, versionChange{Name: name, From: versionsFrom[name], To: inTo})
+		}
+	}
+	for _, name := range sortedKeys(versionsTo) {
+		if _, ok := versionsFrom[name]; !ok {
+			resp.Added = append(resp.Added, packageVersions{Name: name, Versions: versionsTo[name]})
+		}
+	}
+	return resp
+}
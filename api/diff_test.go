@@ -0,0 +1,67 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type versionChange struct {
+	Name string   `json:"name"`
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+func TestDiff(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"@org/app": {
+			"1.2.0": deps(map[string]string{"kept": "^1.0.0", "dropped": "^1.0.0", "bumped": "^1.0.0"}),
+			"2.0.0": deps(map[string]string{"kept": "^1.0.0", "bumped": "^2.0.0", "new": "^1.0.0"}),
+		},
+		"kept":    {"1.1.0": {}},
+		"dropped": {"1.0.0": deps(map[string]string{"leaf": "^1.0.0"})},
+		"bumped":  {"1.5.0": {}, "2.0.1": deps(map[string]string{"leaf": "^1.0.0"})},
+		"new":     {"1.0.0": {}},
+		"leaf":    {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/@org/app/diff?from=1.2.0&to=^2.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Name    string            `json:"name"`
+		From    string            `json:"from"`
+		To      string            `json:"to"`
+		Added   []packageVersions `json:"added"`
+		Removed []packageVersions `json:"removed"`
+		Changed []versionChange   `json:"changed"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, "@org/app", body.Name)
+	assert.Equal(t, "1.2.0", body.From)
+	assert.Equal(t, "2.0.0", body.To)
+	assert.Equal(t, []packageVersions{{Name: "new", Versions: []string{"1.0.0"}}}, body.Added)
+	assert.Equal(t, []packageVersions{{Name: "dropped", Versions: []string{"1.0.0"}}}, body.Removed)
+	assert.Equal(t, []versionChange{{Name: "bumped", From: []string{"1.5.0"}, To: []string{"2.0.1"}}}, body.Changed)
+}
+
+func TestDiffErrors(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/v1/package/app/diff?from=1.0.0").Status)
+	assert.Equal(t, http.StatusNotFound, getProblem(t, server, "/v1/package/app/diff?from=1.0.0&to=2.0.0").Status)
+}
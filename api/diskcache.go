@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultDiskCacheTTL = 24 * time.Hour
+
+// diskCache keeps registry metadata in a directory of JSON files, one per
+// entry, so that it survives restarts. Entries are kept for ttl after they
+// are written, though they are only fresh for as long as the registry
+// allows, so that stale metadata can be revalidated by its ETag; beyond
+// maxBytes, the least recently read are removed. A nil diskCache stores
+// nothing.
+type diskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+	// size is the total size of the entries, counted when the directory is
+	// first used and kept up to date as entries are written and removed.
+	size    int64
+	counted bool
+}
+
+type diskEntry struct {
+	Key     string          `json:"key"`
+	Expires time.Time       `json:"expires"`
+	Fresh   time.Time       `json:"fresh"`
+	ETag    string          `json:"etag,omitempty"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// storedValue is an entry read from the disk cache. It is stale once
+// Fresh has passed.
+type storedValue struct {
+	Value []byte
+	Fresh time.Time
+	ETag  string
+}
+
+func newDiskCache(dir string, maxBytes int64, ttl time.Duration) *diskCache {
+	if ttl <= 0 {
+		ttl = defaultDiskCacheTTL
+	}
+	return &diskCache{dir: dir, maxBytes: maxBytes, ttl: ttl}
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the entry stored under key, fresh or stale, or nil if there
+// is none or it has expired.
+func (c *diskCache) get(key string) (*storedValue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key || time.Now().After(entry.Expires) {
+		c.remove(path)
+		return nil, err
+	}
+	// The modification time orders entries for eviction.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &storedValue{Value: entry.Value, Fresh: entry.Fresh, ETag: entry.ETag}, nil
+}
+
+// set stores value under key, fresh for ttl and revalidated with etag
+// after that.
+func (c *diskCache) set(key string, value []byte, ttl time.Duration, etag string) error {
+	now := time.Now()
+	expires := now.Add(c.ttl)
+	fresh := now.Add(ttl)
+	if fresh.After(expires) {
+		fresh = expires
+	}
+	data, err := json.Marshal(diskEntry{Key: key, Expires: expires, Fresh: fresh, ETag: etag, Value: value})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	c.count()
+	path := c.path(key)
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	// Write and rename, so that a crash never leaves a torn entry.
+	tmp, err := os.CreateTemp(c.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.size += int64(len(data)) - replaced
+	if c.maxBytes > 0 && c.size > c.maxBytes {
+		return c.evict()
+	}
+	return nil
+}
+
+// delete removes key and reports whether it was stored.
+func (c *diskCache) delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count()
+	return c.remove(c.path(key))
+}
+
+// remove removes the entry at path, keeping the size in step, and reports
+// whether there was one; c.mu must be held.
+func (c *diskCache) remove(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || os.Remove(path) != nil {
+		return false
+	}
+	if c.counted {
+		c.size -= info.Size()
+	}
+	return true
+}
+
+// count totals the size of the entries already in the directory, once;
+// c.mu must be held.
+func (c *diskCache) count() {
+	if c.counted {
+		return
+	}
+	c.counted = true
+	c.size = c.stat().Bytes
+}
+
+// clear removes every entry and returns how many there were.
+func (c *diskCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	matches, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	n := 0
+	for _, path := range matches {
+		if os.Remove(path) == nil {
+			n++
+		}
+	}
+	c.size, c.counted = 0, true
+	return n
+}
+
+// stats reports the entries on disk and their size. The disk cache does
+// not count hits.
+func (c *diskCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stat()
+}
+
+// stat counts the entries on disk and their size; c.mu must be held.
+func (c *diskCache) stat() cacheStats {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	var stats cacheStats
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil {
+			stats.Entries++
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats
+}
+
+// evict removes the least recently read entries until the directory holds
+// at most nine tenths of maxBytes, so that the directory is only listed
+// once in a while rather than on every write; c.mu must be held.
+func (c *diskCache) evict() error {
+	matches, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var (
+		files []fs.FileInfo
+		total int64
+	)
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	target := c.maxBytes - c.maxBytes/10
+	for _, info := range files {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+	c.size = total
+	return nil
+}
+
+// loadStored decodes the entry for key from the disk cache, or failing
+// that the shared cache, into v. It returns how much longer the entry is
+// fresh, zero or less once it is stale, and the ETag to revalidate it
+// with, and reports whether there was one. Shared entries are fresh for
+// the metadata cache's TTL, as Redis expires them. Disk failures, like
+// shared cache failures, are logged and treated as misses.
+func (s *server) loadStored(ctx context.Context, key string, v any) (time.Duration, string, bool) {
+	if s.disk != nil {
+		stored, err := s.disk.get(key)
+		if err != nil {
+			s.logger.Warn("Disk cache read failed", "key", key, "error", err)
+		}
+		if stored != nil && json.Unmarshal(stored.Value, v) == nil {
+			return time.Until(stored.Fresh), stored.ETag, true
+		}
+	}
+	if s.loadShared(ctx, key, v) {
+		return s.metaCache.ttl, "", true
+	}
+	return 0, "", false
+}
+
+// store writes v under key to the disk cache, fresh for ttl and then
+// revalidated with etag, and for ttl to the shared cache.
+func (s *server) store(ctx context.Context, key string, v any, ttl time.Duration, etag string) {
+	if s.disk != nil && ttl > 0 {
+		data, err := json.Marshal(v)
+		if err == nil {
+			err = s.disk.set(key, data, ttl, etag)
+		}
+		if err != nil {
+			s.logger.Warn("Disk cache write failed", "key", key, "error", err)
+		}
+	}
+	s.storeShared(ctx, key, v, ttl)
+}
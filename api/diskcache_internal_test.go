@@ -0,0 +1,56 @@
+package api
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCacheEvictsLeastRecentlyRead(t *testing.T) {
+	c := newDiskCache(t.TempDir(), 0, time.Hour)
+	value := []byte(`"0123456789"`)
+	require.Nil(t, c.set("a", value, time.Hour, ""))
+	info, err := os.Stat(c.path("a"))
+	require.Nil(t, err)
+	// Entries vary by a few bytes with the encoding of their expiry, and
+	// eviction frees a tenth of maxBytes beyond what it must.
+	c.maxBytes = (2*info.Size()+16)*10/9 + 1
+
+	require.Nil(t, c.set("b", value, time.Hour, ""))
+	past := time.Now().Add(-time.Minute)
+	require.Nil(t, os.Chtimes(c.path("b"), past, past))
+	_, err = c.get("a")
+	require.Nil(t, err)
+	require.Nil(t, c.set("c", value, time.Hour, ""))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		got, err := c.get(key)
+		require.Nil(t, err)
+		assert.Equal(t, want, got != nil, key)
+	}
+}
+
+func TestDiskCacheCountsSize(t *testing.T) {
+	dir := t.TempDir()
+	c := newDiskCache(dir, 1<<20, time.Hour)
+	require.Nil(t, c.set("a", []byte(`"a"`), time.Hour, ""))
+	require.Nil(t, c.set("b", []byte(`"b"`), time.Hour, ""))
+	require.Nil(t, c.set("a", []byte(`"aaaa"`), time.Hour, `"v2"`))
+	assert.Equal(t, c.stats().Bytes, c.size)
+
+	assert.True(t, c.delete("b"))
+	assert.Equal(t, c.stats().Bytes, c.size)
+
+	// A cache opened on the same directory counts what is already there.
+	reopened := newDiskCache(dir, 1<<20, time.Hour)
+	require.Nil(t, reopened.set("c", []byte(`"c"`), time.Hour, ""))
+	assert.Equal(t, reopened.stats().Bytes, reopened.size)
+
+	stored, err := reopened.get("a")
+	require.Nil(t, err)
+	assert.Equal(t, `"aaaa"`, string(stored.Value))
+	assert.Equal(t, `"v2"`, stored.ETag)
+}
@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {}},
+	})
+	dir := t.TempDir()
+	start := func() *httptest.Server {
+		server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithDiskCache(dir, 0, time.Hour)))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	want := getTreeFrom(t, start(), "/package/app/1.0.0")
+	fetched := len(registry.Requests())
+	files, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, files, 4)
+
+	assert.Equal(t, want, getTreeFrom(t, start(), "/package/app/1.0.0"))
+	assert.Len(t, registry.Requests(), fetched)
+}
+
+func TestDiskCacheExpires(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	dir := t.TempDir()
+	start := func() *httptest.Server {
+		server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithDiskCache(dir, 0, time.Nanosecond)))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	getTreeFrom(t, start(), "/package/app/1.0.0")
+	getTreeFrom(t, start(), "/package/app/1.0.0")
+	assert.Equal(t, []string{"/app", "/app/1.0.0", "/app", "/app/1.0.0"}, registry.Requests())
+}
+
+func TestDiskCacheRevalidatesStaleMetadata(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	dir := t.TempDir()
+	start := func() *httptest.Server {
+		server := httptest.NewServer(api.New(
+			api.WithRegistryURL(registry.URL),
+			api.WithCacheTTL(time.Nanosecond),
+			api.WithDiskCache(dir, 0, time.Hour),
+		))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	getTreeFrom(t, start(), "/package/app/1.0.0")
+	// The stored metadata is kept but no longer fresh, so the restarted
+	// server revalidates it with its ETag.
+	getTreeFrom(t, start(), "/package/app/1.0.0")
+	assert.Equal(t, []string{"/app", "/app/1.0.0", "/app", "/app/1.0.0"}, registry.Requests())
+	assert.Equal(t, 1, registry.NotModified())
+}
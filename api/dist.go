@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var errMissingIntegrity = errors.New("missing integrity hash")
+
+// PackageDist describes the published tarball of a package version.
+type PackageDist struct {
+	Tarball      string `json:"tarball,omitempty"`
+	Shasum       string `json:"shasum,omitempty"`
+	Integrity    string `json:"integrity,omitempty"`
+	FileCount    int    `json:"fileCount,omitempty"`
+	UnpackedSize int64  `json:"unpackedSize,omitempty"`
+}
+
+// withoutDist returns a copy of the tree with the dist fields removed, so
+// responses stay lean unless the client asks for them.
+func withoutDist(pkg *NpmPackageVersion) *NpmPackageVersion {
+	stripped := *pkg
+	stripped.Dist = nil
+	stripped.Dependencies = make(map[string]*NpmPackageVersion, len(pkg.Dependencies))
+	for name, dep := range pkg.Dependencies {
+		stripped.Dependencies[name] = withoutDist(dep)
+	}
+	return &stripped
+}
+
+// checkIntegrity fails for a package version published without a
+// dist.integrity hash.
+func checkIntegrity(name, version string, dist *PackageDist) error {
+	if dist == nil || dist.Integrity == "" {
+		return fmt.Errorf("%w: %s@%s has no dist.integrity", errMissingIntegrity, name, version)
+	}
+	return nil
+}
+
+// included reports whether query asks for the optional field of each
+// node, such as "dist" or "registry", as ?dist=true or as one of the
+// comma-separated fields of ?include=.
+func included(query url.Values, field string) bool {
+	if query.Get(field) == "true" {
+		return true
+	}
+	for _, name := range strings.Split(query.Get("include"), ",") {
+		if strings.TrimSpace(name) == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func distRegistry(t *testing.T) *registryServer {
+	return newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {
+			"dependencies": map[string]string{"lib": "^1.0.0"},
+			"dist":         map[string]any{"tarball": "https://example.test/app-1.0.0.tgz", "integrity": "sha512-app", "shasum": "aaa"},
+		}},
+		"lib": {"1.1.0": {
+			"dist": map[string]any{"tarball": "https://example.test/lib-1.1.0.tgz", "integrity": "sha512-lib", "unpackedSize": 2048},
+		}},
+	})
+}
+
+func TestDistOmittedByDefault(t *testing.T) {
+	registry := distRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]any
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotContains(t, body, "dist")
+	lib := body["dependencies"].(map[string]any)["lib"].(map[string]any)
+	assert.NotContains(t, lib, "dist")
+}
+
+func TestDistIncludedOnRequest(t *testing.T) {
+	registry := distRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for _, query := range []string{"?dist=true", "?include=dist", "?include=registry,%20dist"} {
+		tree := getTreeFrom(t, server, "/v1/package/app/1.0.0"+query)
+
+		require.NotNil(t, tree.Dist, query)
+		assert.Equal(t, "sha512-app", tree.Dist.Integrity, query)
+		assert.Equal(t, "aaa", tree.Dist.Shasum, query)
+		assert.Equal(t, "https://example.test/app-1.0.0.tgz", tree.Dist.Tarball, query)
+
+		lib := tree.Dependencies["lib"]
+		require.NotNil(t, lib.Dist, query)
+		assert.Equal(t, "sha512-lib", lib.Dist.Integrity, query)
+		assert.Equal(t, int64(2048), lib.Dist.UnpackedSize, query)
+	}
+	assert.Nil(t, getTreeFrom(t, server, "/v1/package/app/1.0.0?include=registry").Dist)
+}
+
+func TestDistOmittedFromTracedResponse(t *testing.T) {
+	registry := distRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?trace=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]any
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Contains(t, body, "trace")
+	assert.NotContains(t, body, "dist")
+}
+
+func TestRequireIntegrity(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": {"dependencies": map[string]string{"lib": "^1.0.0"}, "dist": map[string]any{"integrity": "sha512-app"}}},
+		"lib":  {"1.1.0": {"dependencies": map[string]string{"leaf": "^2.0.0"}, "dist": map[string]any{"integrity": "sha512-lib"}}},
+		"leaf": {"2.0.0": {"dist": map[string]any{"tarball": "https://example.test/leaf-2.0.0.tgz"}}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	getTreeFrom(t, server, "/package/app/1.0.0")
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?requireIntegrity=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Contains(t, string(body), "leaf@2.0.0")
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var errBadDistTag = errors.New("invalid dist-tag")
+
+// isDistTag reports whether a constraint names a dist-tag, such as
+// "latest" or "next", rather than a semver range.
+func isDistTag(versionConstraint string) bool {
+	if _, err := semver.NewConstraint(versionConstraint); err == nil {
+		return false
+	}
+	return distTagPattern.MatchString(versionConstraint)
+}
+
+// resolveDistTag checks that a dist-tag target is a valid semver version
+// published in the packument. Targets such as "v1.2.0" are normalized to
+// the published form.
+func resolveDistTag(tag, target string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	if _, ok := pkgMeta.Versions[target]; ok {
+		if _, err := semver.NewVersion(target); err == nil {
+			return target, nil
+		}
+	}
+	version, err := semver.NewVersion(strings.TrimLeft(strings.TrimSpace(target), "=v"))
+	if err != nil {
+		return "", fmt.Errorf("%w %q of %s: target %q is not a semver version", errBadDistTag, tag, pkgMeta.Name, target)
+	}
+	if _, ok := pkgMeta.Versions[version.String()]; !ok {
+		return "", fmt.Errorf("%w %q of %s: target %q is not a published version", errBadDistTag, tag, pkgMeta.Name, target)
+	}
+	return version.String(), nil
+}
@@ -0,0 +1,64 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zen37/npm_packages/api"
+)
+
+func taggedRegistry(t *testing.T, tags map[string]string) *registryServer {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {}, "1.2.0": {}, "2.0.0-beta.1": {}},
+	})
+	registry.distTags = map[string]map[string]string{"app": tags}
+	return registry
+}
+
+func TestDistTagResolution(t *testing.T) {
+	registry := taggedRegistry(t, map[string]string{"latest": "v1.2.0", "next": "2.0.0-beta.1"})
+
+	assert.Equal(t, "1.2.0", getTree(t, registry, "/package/app/latest").Version)
+	assert.Equal(t, "2.0.0-beta.1", getTree(t, registry, "/package/app/next").Version)
+}
+
+func TestDistTagPointingToMissingVersion(t *testing.T) {
+	registry := taggedRegistry(t, map[string]string{"latest": "9.9.9", "broken": "not-a-version"})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for tag, want := range map[string]string{
+		"latest": `invalid dist-tag "latest" of app: target "9.9.9" is not a published version`,
+		"broken": `invalid dist-tag "broken" of app: target "not-a-version" is not a semver version`,
+	} {
+		assert.Equal(t, want, getProblem(t, server, "/package/app/"+tag).Detail)
+	}
+}
+
+func TestDistTagOfDependency(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"root": {"1.0.0": deps(map[string]string{"app": "next"})},
+		"app":  {"1.0.0": {}, "2.0.0-beta.1": {}},
+	})
+	registry.distTags = map[string]map[string]string{"app": {"latest": "1.0.0", "next": "2.0.0-beta.1"}}
+
+	assert.Equal(t, "2.0.0-beta.1", getTree(t, registry, "/package/root/1.0.0").Dependencies["app"].Version)
+}
+
+func TestUnknownDistTag(t *testing.T) {
+	registry := taggedRegistry(t, map[string]string{"latest": "1.0.0"})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/package/app/canary")
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, `version not found: no published version of app satisfies "canary"`, p.Detail)
+}
+
+func TestLatestWithoutDistTags(t *testing.T) {
+	registry := taggedRegistry(t, nil)
+
+	assert.Equal(t, "1.2.0", getTree(t, registry, "/package/app/latest").Version)
+}
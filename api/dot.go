@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// dot renders the graph in Graphviz's DOT language, one node per unique
+// package version and one edge per dependency, the root drawn in bold.
+func (g *packageGraph) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.root.key()))
+	b.WriteString("  rankdir=LR;\n  node [shape=box];\n")
+	fmt.Fprintf(&b, "  %s [style=bold];\n", dotQuote(g.root.key()))
+	for _, n := range g.sortedNodes() {
+		if n != g.root {
+			fmt.Fprintf(&b, "  %s;\n", dotQuote(n.key()))
+		}
+	}
+	for _, n := range g.sortedNodes() {
+		for _, dep := range n.sortedDeps() {
+			fmt.Fprintf(&b, "  %s -> %s;\n", dotQuote(n.key()), dotQuote(dep.key()))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote returns s as a quoted DOT identifier.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// dotHandler answers ?format=dot with the resolved tree as a DOT graph,
+// ready to be piped into Graphviz.
+func (s *server) dotHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(newPackageGraph(rootPkg).dot())); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "dot")
+}
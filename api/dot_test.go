@@ -0,0 +1,46 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestDotFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":      {"1.0.0": deps(map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0"})},
+		"a":        {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"@scope/c": {"2.1.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"shared":   {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=dot")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/vnd.graphviz; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	// shared is one node, with an edge from each dependent.
+	assert.Equal(t, `digraph "app@1.0.0" {
+  rankdir=LR;
+  node [shape=box];
+  "app@1.0.0" [style=bold];
+  "@scope/c@2.1.0";
+  "a@1.0.0";
+  "shared@1.2.0";
+  "@scope/c@2.1.0" -> "shared@1.2.0";
+  "a@1.0.0" -> "shared@1.2.0";
+  "app@1.0.0" -> "@scope/c@2.1.0";
+  "app@1.0.0" -> "a@1.0.0";
+}
+`, string(body))
+}
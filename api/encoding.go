@@ -0,0 +1,42 @@
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is sent on every registry request. Setting it ourselves
+// stops the transport from decompressing transparently, so responses are
+// decoded by decodedBody instead, which also covers deflate.
+const acceptEncoding = "gzip, deflate"
+
+// decodedBody returns a reader over body, the body of resp, with any gzip
+// or deflate Content-Encoding removed.
+func decodedBody(resp *http.Response, body io.Reader) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but some servers send a raw
+		// DEFLATE stream; the zlib header tells them apart.
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if (uint(header[0])<<8|uint(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
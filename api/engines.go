@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// packageEngines are the runtime versions a package version declares it
+// runs on, such as {"node": ">=18"}. Old packuments sometimes publish a
+// list instead, which decodes to no requirements.
+type packageEngines map[string]string
+
+func (e *packageEngines) UnmarshalJSON(data []byte) error {
+	var engines map[string]string
+	if err := json.Unmarshal(data, &engines); err != nil {
+		*e = nil
+		return nil
+	}
+	*e = engines
+	return nil
+}
+
+// engineIncompatibility is a package whose engines.node range excludes the
+// requested Node.js version.
+type engineIncompatibility struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Node    string `json:"node"`
+}
+
+// parseNodeVersion returns the Node.js version a request asks the tree to
+// be checked against with ?node=, or nil if it asks for none.
+func parseNodeVersion(r *http.Request) (*semver.Version, error) {
+	raw := r.URL.Query().Get("node")
+	if raw == "" {
+		return nil, nil
+	}
+	version, err := semver.NewVersion(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node version %q: expected a version such as 18.19.0", raw)
+	}
+	return version, nil
+}
+
+// incompatibleEngines returns the package versions in the tree, the root
+// included, whose engines.node range excludes the Node.js version. A range
+// that doesn't parse is not held against its package.
+func incompatibleEngines(root *NpmPackageVersion, node *semver.Version) []engineIncompatibility {
+	if node == nil {
+		return nil
+	}
+	incompatible := []engineIncompatibility{}
+	packages, _ := bomPackages(root)
+	for _, id := range sortedKeys(packages) {
+		pkg := packages[id]
+		required := pkg.Engines["node"]
+		if required == "" {
+			continue
+		}
+		constraint, err := semver.NewConstraint(canonicalConstraint(required))
+		if err != nil || constraint.Check(node) {
+			continue
+		}
+		incompatible = append(incompatible, engineIncompatibility{Name: pkg.Name, Version: pkg.Version, Node: required})
+	}
+	return incompatible
+}
@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type engineIncompatibility struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Node    string `json:"node"`
+}
+
+func enginesRegistry(t *testing.T) *registryServer {
+	return newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": {"engines": map[string]string{"node": ">=16"}, "dependencies": map[string]string{"modern": "^1.0.0", "legacy": "^1.0.0", "any": "^1.0.0", "old": "^1.0.0"}}},
+		"modern": {"1.0.0": {"engines": map[string]string{"node": "^20.0.0 || >=22"}}},
+		"legacy": {"1.0.0": {"engines": map[string]string{"node": ">=0.10 <16"}}},
+		"any":    {"1.0.0": {}},
+		"old":    {"1.0.0": {"engines": []string{"node >=0.4"}}},
+	})
+}
+
+func TestEnginesCompatibility(t *testing.T) {
+	registry := enginesRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for node, want := range map[string][]engineIncompatibility{
+		"18.19.0": {{Name: "legacy", Version: "1.0.0", Node: ">=0.10 <16"}, {Name: "modern", Version: "1.0.0", Node: "^20.0.0 || >=22"}},
+		"14.21.3": {{Name: "app", Version: "1.0.0", Node: ">=16"}, {Name: "modern", Version: "1.0.0", Node: "^20.0.0 || >=22"}},
+		"22.1.0":  {{Name: "legacy", Version: "1.0.0", Node: ">=0.10 <16"}},
+	} {
+		resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?node=" + node)
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			Name         string                  `json:"name"`
+			Incompatible []engineIncompatibility `json:"incompatible"`
+		}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		resp.Body.Close()
+		assert.Equal(t, "app", body.Name)
+		assert.Equal(t, want, body.Incompatible, node)
+	}
+}
+
+func TestEnginesCompatibleTree(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {"engines": map[string]string{"node": ">=16"}}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/v1/resolve?node=20.11.0", "application/json", strings.NewReader(`{"dependencies": {"app": "^1.0.0"}}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Incompatible []engineIncompatibility `json:"incompatible"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Empty(t, body.Incompatible)
+}
+
+func TestEnginesInvalidNodeVersion(t *testing.T) {
+	registry := enginesRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/v1/package/app/1.0.0?node=hydrogen")
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Contains(t, p.Detail, `invalid node version "hydrogen"`)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// progressEvent is the data of a package-resolved event.
+type progressEvent struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Parent  string `json:"parent,omitempty"`
+	Depth   int    `json:"depth"`
+	// Resolved counts the packages resolved so far, this one included,
+	// and MaxDepth the deepest of them.
+	Resolved int `json:"resolved"`
+	MaxDepth int `json:"maxDepth"`
+}
+
+// eventStream writes Server-Sent Events. It is also the
+// http.ResponseWriter of the request being streamed, so that an error
+// response written after the first event becomes an error event rather
+// than a second response.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	mu       sync.Mutex
+	started  bool
+	progress progressCounter
+	err      error
+}
+
+// progressCounter numbers the packages of a resolution as they are
+// resolved and tracks the deepest.
+type progressCounter struct {
+	resolved int
+	maxDepth int
+}
+
+// next counts pkg and returns its progressEvent.
+func (c *progressCounter) next(pkg *NpmPackageVersion) progressEvent {
+	event := progressEvent{Name: pkg.Name, Version: pkg.Version}
+	if pkg.parent != nil {
+		event.Parent = pkg.parent.Name + "@" + pkg.parent.Version
+	}
+	for ancestor := pkg.parent; ancestor != nil; ancestor = ancestor.parent {
+		event.Depth++
+	}
+	c.resolved++
+	c.maxDepth = max(c.maxDepth, event.Depth)
+	event.Resolved, event.MaxDepth = c.resolved, c.maxDepth
+	return event
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	flusher, _ := w.(http.Flusher)
+	return &eventStream{w: w, flusher: flusher}
+}
+
+func (es *eventStream) Header() http.Header {
+	return es.w.Header()
+}
+
+func (es *eventStream) WriteHeader(status int) {
+	if !es.started {
+		es.w.WriteHeader(status)
+	}
+}
+
+func (es *eventStream) Write(data []byte) (int, error) {
+	if !es.started {
+		return es.w.Write(data)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return 0, err
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.send("error", compact.Bytes())
+	if es.err != nil {
+		return 0, es.err
+	}
+	return len(data), nil
+}
+
+// send writes one event, starting the response with the first. The
+// caller holds es.mu.
+func (es *eventStream) send(event string, data []byte) {
+	if es.err != nil {
+		return
+	}
+	if !es.started {
+		es.started = true
+		es.w.Header().Set("Content-Type", "text/event-stream")
+		es.w.Header().Set("Cache-Control", "no-cache")
+		es.w.WriteHeader(http.StatusOK)
+	}
+	if _, es.err = fmt.Fprintf(es.w, "event: %s\ndata: %s\n\n", event, data); es.err != nil {
+		return
+	}
+	if es.flusher != nil {
+		es.flusher.Flush()
+	}
+}
+
+// packageResolved sends a package-resolved event for pkg. Dependencies
+// left out of the tree are not reported.
+func (es *eventStream) packageResolved(pkg *NpmPackageVersion) {
+	if pkg.Version == "" {
+		return
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	data, err := json.Marshal(es.progress.next(pkg))
+	if err != nil {
+		es.err = err
+		return
+	}
+	es.send("package-resolved", data)
+}
+
+// complete sends the final event, carrying v.
+func (es *eventStream) complete(v any) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	data, err := json.Marshal(v)
+	if err != nil {
+		es.err = err
+		return
+	}
+	es.send("complete", data)
+}
+
+// eventsHandler resolves a package while reporting its progress as
+// Server-Sent Events: a package-resolved event as each package's version
+// is selected, then a complete event with the tree as the package
+// endpoint returns it. A failure after the first event is an error event
+// holding the problem details. A tree served from the cache is sent as a
+// complete event alone.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	es := newEventStream(w)
+	rootPkg, res := s.resolveRequest(withVisitor(r.Context(), es.packageResolved), es, r)
+	if rootPkg == nil {
+		return
+	}
+
+	es.complete(newTreeResponse(r.URL.Query(), rootPkg, res))
+	if es.err != nil {
+		s.logger.Error("Error writing response", "error", es.err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "events", es.progress.resolved+1)
+}
@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type serverSentEvent struct {
+	event string
+	data  string
+}
+
+func getEvents(t *testing.T, server *httptest.Server, path string) []serverSentEvent {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	var events []serverSentEvent
+	var current serverSentEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, current)
+			current = serverSentEvent{}
+		}
+	}
+	require.Nil(t, scanner.Err())
+	return events
+}
+
+func TestEventsReportProgressThenTree(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"web": "^1.0.0", "cli": "^1.0.0"})},
+		"web":  {"1.0.0": deps(map[string]string{"http": "^1.0.0"})},
+		"cli":  {"1.0.0": {}},
+		"http": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResultCacheTTL(time.Minute)))
+	defer server.Close()
+
+	events := getEvents(t, server, "/package/app/1.0.0/events")
+	require.Len(t, events, 5)
+	type progress struct {
+		Name     string `json:"name"`
+		Parent   string `json:"parent"`
+		Depth    int    `json:"depth"`
+		Resolved int    `json:"resolved"`
+		MaxDepth int    `json:"maxDepth"`
+	}
+	var got []progress
+	for _, event := range events[:4] {
+		assert.Equal(t, "package-resolved", event.event)
+		var p progress
+		require.Nil(t, json.Unmarshal([]byte(event.data), &p))
+		got = append(got, p)
+	}
+	assert.Equal(t, []progress{
+		{Name: "app", Depth: 0, Resolved: 1, MaxDepth: 0},
+		{Name: "cli", Parent: "app@1.0.0", Depth: 1, Resolved: 2, MaxDepth: 1},
+		{Name: "web", Parent: "app@1.0.0", Depth: 1, Resolved: 3, MaxDepth: 1},
+		{Name: "http", Parent: "web@1.0.0", Depth: 2, Resolved: 4, MaxDepth: 2},
+	}, got)
+
+	assert.Equal(t, "complete", events[4].event)
+	var tree *api.NpmPackageVersion
+	require.Nil(t, json.Unmarshal([]byte(events[4].data), &tree))
+	assert.Equal(t, getTreeFrom(t, server, "/package/app/1.0.0"), tree)
+
+	// A cached tree has no progress to report.
+	cached := getEvents(t, server, "/package/app/1.0.0/events")
+	require.Len(t, cached, 1)
+	assert.Equal(t, "complete", cached[0].event)
+}
+
+func TestEventsReportFailureAsErrorEvent(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"http": "^2.0.0"})},
+		"http": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	events := getEvents(t, server, "/package/app/1.0.0/events")
+	require.Len(t, events, 2)
+	assert.Equal(t, "package-resolved", events[0].event)
+	assert.Equal(t, "error", events[1].event)
+	var p struct {
+		Status int `json:"status"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(events[1].data), &p))
+	assert.NotZero(t, p.Status)
+
+	assert.Equal(t, http.StatusNotFound, getProblem(t, server, "/package/missing/1.0.0/events").Status)
+}
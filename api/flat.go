@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// flatResponse lists the unique packages of a tree too large to return
+// nested.
+type flatResponse struct {
+	Name      string       `json:"name"`
+	Version   string       `json:"version"`
+	Flattened bool         `json:"flattened"`
+	NodeCount int          `json:"nodeCount"`
+	Packages  []packageRef `json:"packages"`
+}
+
+// countNodes returns the number of nodes in the nested tree, counting
+// every occurrence of a repeated package.
+func countNodes(pkg *NpmPackageVersion) int {
+	n := 1
+	for _, dep := range pkg.Dependencies {
+		n += countNodes(dep)
+	}
+	return n
+}
+
+// flatten returns the unique packages of the tree, excluding its root, in
+// name@version order.
+func flatten(root *NpmPackageVersion, nodeCount int) *flatResponse {
+	g := newPackageGraph(root)
+	resp := &flatResponse{Name: root.Name, Version: root.Version, Flattened: true, NodeCount: nodeCount, Packages: []packageRef{}}
+	for _, n := range g.sortedNodes() {
+		if n != g.root {
+			resp.Packages = append(resp.Packages, packageRef{Name: n.name, Version: n.version})
+		}
+	}
+	return resp
+}
+
+// writeFlattened writes the flat form of tree instead of the nested one
+// when the nested tree has more nodes than the server's flatten threshold,
+// and reports whether it did.
+func (s *server) writeFlattened(w http.ResponseWriter, tree *NpmPackageVersion) bool {
+	if s.flattenThreshold <= 0 {
+		return false
+	}
+	nodeCount := countNodes(tree)
+	if nodeCount <= s.flattenThreshold {
+		return false
+	}
+	s.logger.Info("Flattening large tree", "package", tree.Name, "version", tree.Version, "nodes", nodeCount, "threshold", s.flattenThreshold)
+	w.Header().Set("X-Tree-Format", "flat")
+	s.writeJSON(w, http.StatusOK, flatten(tree, nodeCount))
+	return true
+}
+
+// maxInstallSetPasses bounds the passes resolveInstallSet makes before
+// settling for the selections it has.
+const maxInstallSetPasses = 10
+
+// installSetResponse is the install set of a package: one version of each
+// package in its tree.
+type installSetResponse struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Format    string            `json:"format"`
+	Packages  map[string]string `json:"packages"`
+	Conflicts []installConflict `json:"conflicts,omitempty"`
+}
+
+// installConflict reports a package whose dependents' constraints no
+// single version satisfies. Version is the one selected regardless.
+type installConflict struct {
+	Name        string              `json:"name"`
+	Version     string              `json:"version"`
+	Constraints []dependentRequired `json:"constraints"`
+}
+
+type dependentRequired struct {
+	Constraint string `json:"constraint"`
+	RequiredBy string `json:"requiredBy"`
+}
+
+// resolveInstallSet selects one version of every package in the tree of
+// the named package, as a flat install would: the highest version that
+// satisfies the constraints of all its dependents, or the lowest under the
+// lowest and exact strategies. Changing a selection
+// changes the dependencies, and so the constraints, of the tree, so the
+// tree is walked again until the selections settle.
+func (res *resolver) resolveInstallSet(ctx context.Context, name, versionConstraint string) (*installSetResponse, error) {
+	if err := validateConstraint(versionConstraint); err != nil {
+		return nil, err
+	}
+	ctx = withDownloadBudget(ctx, res.downloadBudget)
+	rootMeta, err := res.fetchPackageMeta(ctx, name)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: %w", errPackageNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rootVersion, _, err := res.resolveVersion(ctx, name, versionConstraint, rootMeta)
+	if errors.Is(err, errNoCompatibleVersion) || isNotFound(err) {
+		return nil, fmt.Errorf("%w: no published version of %s satisfies %q", errVersionNotFound, name, versionConstraint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]string{name: rootVersion}
+	var required map[string][]dependentRequired
+	for pass := 0; pass < maxInstallSetPasses; pass++ {
+		if required, err = res.walkInstallSet(ctx, name, selected); err != nil {
+			return nil, err
+		}
+		changed := false
+		for _, dep := range sortedKeys(required) {
+			if dep == name {
+				continue
+			}
+			meta, err := res.fetchPackageMeta(ctx, dep)
+			if err != nil {
+				return nil, err
+			}
+			strategy := res.strategyFor(dep)
+			lowest := strategy == StrategyLowest || strategy == StrategyExact
+			if version, ok := satisfyingAll(meta, required[dep], lowest); ok && version != selected[dep] {
+				selected[dep] = version
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	resp := &installSetResponse{Name: name, Version: rootVersion, Format: "flat", Packages: map[string]string{}}
+	for _, dep := range sortedKeys(required) {
+		version := selected[dep]
+		if dep != name {
+			resp.Packages[dep] = version
+		}
+		meta, err := res.fetchPackageMeta(ctx, dep)
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range required[dep] {
+			if !satisfiesMeta(version, req.Constraint, meta) {
+				resp.Conflicts = append(resp.Conflicts, installConflict{Name: dep, Version: version, Constraints: required[dep]})
+				break
+			}
+		}
+	}
+	return resp, nil
+}
+
+// walkInstallSet walks the tree of root breadth first, taking the selected
+// version of each package it reaches and selecting one for any it reaches
+// for the first time, and returns the constraints on each package. Only
+// packages still reached keep their selections. Optional dependencies
+// with no installable version are left out.
+func (res *resolver) walkInstallSet(ctx context.Context, root string, selected map[string]string) (map[string][]dependentRequired, error) {
+	required := map[string][]dependentRequired{}
+	reached := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := queue[0]
+		queue = queue[1:]
+		pkg := &NpmPackageVersion{Name: name, Version: selected[name]}
+		if err := res.countUnique(pkg); err != nil {
+			return nil, err
+		}
+		if name == res.opts.stopAt && name != root {
+			continue
+		}
+		npmPkg, err := res.fetchPackage(ctx, name, pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		dependencies := mergeDependencies(npmPkg.OptionalDependencies, npmPkg.Dependencies)
+		if name == root && res.opts.dev {
+			dependencies = mergeDependencies(dependencies, npmPkg.DevDependencies)
+		}
+		for _, key := range sortedKeys(dependencies) {
+			// An "npm:" alias installs another package under the key.
+			dep, constraint := key, dependencies[key]
+			if aliased, versionConstraint, ok := parseAlias(constraint); ok {
+				dep, constraint = aliased, versionConstraint
+			}
+			if res.opts.excluded(dep) || isNonRegistrySpec(constraint) {
+				continue
+			}
+			if !reached[dep] {
+				if _, ok := selected[dep]; !ok {
+					version, err := res.selectFirst(ctx, dep, constraint)
+					if _, optional := npmPkg.OptionalDependencies[key]; optional && skippable(err) {
+						continue
+					}
+					if err != nil {
+						return nil, err
+					}
+					selected[dep] = version
+				}
+				reached[dep] = true
+				queue = append(queue, dep)
+			}
+			required[dep] = append(required[dep], dependentRequired{Constraint: constraint, RequiredBy: name + "@" + pkg.Version})
+		}
+	}
+	for name := range selected {
+		if !reached[name] {
+			delete(selected, name)
+		}
+	}
+	return required, nil
+}
+
+// selectFirst selects the version of the named package for the first
+// constraint on it the walk reaches.
+func (res *resolver) selectFirst(ctx context.Context, name, constraint string) (string, error) {
+	meta, err := res.fetchPackageMeta(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return res.selectVersion(constraint, meta)
+}
+
+// satisfyingAll returns the highest, or with lowest set the lowest,
+// published version of a package that satisfies every constraint on it.
+func satisfyingAll(meta *npmPackageMetaResponse, required []dependentRequired, lowest bool) (string, bool) {
+	var versions semver.Collection
+	for version := range meta.Versions {
+		if v, err := semver.NewVersion(version); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if lowest {
+		sort.Sort(versions)
+	} else {
+		sort.Sort(sort.Reverse(versions))
+	}
+	for _, v := range versions {
+		ok := true
+		for _, req := range required {
+			if !satisfiesMeta(v.String(), req.Constraint, meta) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return v.String(), true
+		}
+	}
+	return "", false
+}
+
+// satisfiesMeta reports whether version meets constraint, a semver range
+// or one of the package's dist-tags.
+func satisfiesMeta(version, constraint string, meta *npmPackageMetaResponse) bool {
+	if target, ok := meta.DistTags[constraint]; ok {
+		tagged, err := resolveDistTag(constraint, target, meta)
+		return err == nil && tagged == version
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	return err == nil && c.Check(v)
+}
+
+// installSetHandler answers ?format=flat with the install set of the
+// package named in the request path rather than its nested tree.
+func (s *server) installSetHandler(w http.ResponseWriter, r *http.Request) {
+	pkgName := r.PathValue("package")
+	pkgVersion := r.PathValue("version")
+	timeout, err := s.resolutionTimeout(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	opts, err := parseResolveOptions(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	ctx, cancel := withResolutionTimeout(r.Context(), timeout)
+	defer cancel()
+
+	res := s.newResolver(opts)
+	resp, err := res.resolveInstallSet(ctx, pkgName, pkgVersion)
+	if err != nil && r.Context().Err() != nil {
+		s.logger.Info("Client went away during resolution", "package", pkgName, "version", pkgVersion)
+		return
+	}
+	if err != nil {
+		err = res.timedOut(err, timeout)
+		s.logger.Error("resolution failed", "package", pkgName, "version", pkgVersion, "error", err)
+		s.writeResolveError(w, r, err)
+		return
+	}
+	if s.writeJSON(w, http.StatusOK, resp) {
+		s.logger.Info("Successfully handled request", "package", pkgName, "version", resp.Version, "format", "flat", "packages", len(resp.Packages))
+	}
+}
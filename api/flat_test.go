@@ -0,0 +1,157 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// layeredRegistry builds a graph of layers of width packages each, where
+// every package depends on every package of the next layer, so the nested
+// tree grows as width^layers while the unique packages grow linearly.
+func layeredRegistry(t *testing.T, layers, width int) *registryServer {
+	pkgs := mockRegistry{}
+	layer := func(l int) map[string]string {
+		d := map[string]string{}
+		if l < layers {
+			for i := 0; i < width; i++ {
+				d[fmt.Sprintf("pkg-%d-%d", l, i)] = "^1.0.0"
+			}
+		}
+		return d
+	}
+	pkgs["app"] = map[string]manifest{"1.0.0": deps(layer(0))}
+	for l := 0; l < layers; l++ {
+		for i := 0; i < width; i++ {
+			pkgs[fmt.Sprintf("pkg-%d-%d", l, i)] = map[string]manifest{"1.0.0": deps(layer(l + 1))}
+		}
+	}
+	return newMockRegistry(t, pkgs)
+}
+
+func TestFlattenThreshold(t *testing.T) {
+	registry := layeredRegistry(t, 4, 3)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithFlattenThreshold(50)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "flat", resp.Header.Get("X-Tree-Format"))
+
+	var body struct {
+		Name      string `json:"name"`
+		Flattened bool   `json:"flattened"`
+		NodeCount int    `json:"nodeCount"`
+		Packages  []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"packages"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "app", body.Name)
+	assert.True(t, body.Flattened)
+	assert.Equal(t, 1+3+9+27+81, body.NodeCount)
+	assert.Len(t, body.Packages, 12)
+}
+
+func TestFlattenThresholdNotReached(t *testing.T) {
+	registry := layeredRegistry(t, 2, 3)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithFlattenThreshold(50)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Len(t, tree.Dependencies, 3)
+	assert.Len(t, tree.Dependencies["pkg-0-0"].Dependencies, 3)
+}
+
+type installSet struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Format    string            `json:"format"`
+	Packages  map[string]string `json:"packages"`
+	Conflicts []struct {
+		Name        string `json:"name"`
+		Version     string `json:"version"`
+		Constraints []struct {
+			Constraint string `json:"constraint"`
+			RequiredBy string `json:"requiredBy"`
+		} `json:"constraints"`
+	} `json:"conflicts"`
+}
+
+func getInstallSet(t *testing.T, registry *registryServer, path string) *installSet {
+	t.Helper()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var set installSet
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&set))
+	return &set
+}
+
+func TestFlatFormatMergesConstraints(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":   {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"b":   {"1.0.0": deps(map[string]string{"shared": "~1.2.0"})},
+		"shared": {
+			"1.2.3": {},
+			"1.5.0": deps(map[string]string{"extra": "^1.0.0"}),
+		},
+		"extra": {"1.0.0": {}},
+	})
+
+	// The nested tree gives a the highest shared, 1.5.0; the install set
+	// needs one version both a and b accept, whose dependencies are then
+	// those of 1.2.3.
+	set := getInstallSet(t, registry, "/package/app/1.0.0?format=flat")
+	assert.Equal(t, "app", set.Name)
+	assert.Equal(t, "1.0.0", set.Version)
+	assert.Equal(t, "flat", set.Format)
+	assert.Equal(t, map[string]string{"a": "1.0.0", "b": "1.0.0", "shared": "1.2.3"}, set.Packages)
+	assert.Empty(t, set.Conflicts)
+}
+
+func TestFlatFormatReportsConflicts(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"b":      {"1.0.0": deps(map[string]string{"shared": "^2.0.0", "a": "^1.0.0"})},
+		"shared": {"1.4.0": {}, "2.1.0": {}},
+	})
+
+	set := getInstallSet(t, registry, "/package/app/1.0.0?format=flat")
+	assert.Equal(t, map[string]string{"a": "1.0.0", "b": "1.0.0", "shared": "1.4.0"}, set.Packages)
+	require.Len(t, set.Conflicts, 1)
+	conflict := set.Conflicts[0]
+	assert.Equal(t, "shared", conflict.Name)
+	assert.Equal(t, "1.4.0", conflict.Version)
+	require.Len(t, conflict.Constraints, 2)
+	assert.Equal(t, "^1.0.0", conflict.Constraints[0].Constraint)
+	assert.Equal(t, "a@1.0.0", conflict.Constraints[0].RequiredBy)
+	assert.Equal(t, "^2.0.0", conflict.Constraints[1].Constraint)
+	assert.Equal(t, "b@1.0.0", conflict.Constraints[1].RequiredBy)
+}
+
+func TestFlatFormatErrors(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	assert.Equal(t, http.StatusNotFound, getProblem(t, server, "/package/missing/1.0.0?format=flat").Status)
+	assert.Equal(t, http.StatusNotFound, getProblem(t, server, "/package/app/^2.0.0?format=flat").Status)
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/package/app/1.0.0?format=tree").Status)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// sharedFetchTimeout bounds a shared registry fetch, with its retries and
+// mirrors, when the server sets no registry timeout.
+const sharedFetchTimeout = 2 * time.Minute
+
+// flightGroup collapses concurrent calls with the same key into one, whose
+// result every caller receives, in the manner of
+// golang.org/x/sync/singleflight. The zero value is ready to use.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+
+	// waiters counts the callers still waiting for the result; the call is
+	// cancelled once the last of them gives up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do calls fn in a goroutine of its own, unless a call with the same key is
+// already in flight, and waits for the call's result, giving up once ctx
+// is done. fn runs under a context that keeps the values of the first
+// caller's but is cancelled only when every caller has given up. shared
+// reports whether the call was another caller's.
+func (g *flightGroup[V]) do(ctx context.Context, key string, fn func(ctx context.Context) (V, error)) (v V, err error, shared bool) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		if g.calls == nil {
+			g.calls = map[string]*flightCall[V]{}
+		}
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			defer func() {
+				g.forget(key, call)
+				cancel()
+				close(call.done)
+			}()
+			call.val, call.err = fn(callCtx)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			// Nobody wants the result any more: stop the call, and let the
+			// next caller start afresh rather than join a cancelled one.
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return v, ctx.Err(), shared
+	}
+}
+
+// forget removes call from the group, unless it was already replaced.
+func (g *flightGroup[V]) forget(key string, call *flightCall[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// fetchShared is fetchFromRegistries, except that concurrent requests for
+// the same document share a single registry request. The request goes on
+// while any of its callers still waits for it, so that one giving up or
+// running out of time does not fail it for the others, and is cancelled
+// once none does. It is bounded by the registry timeout, or by
+// sharedFetchTimeout without one.
+//
+// The caller leading the fetch is charged for the download as it streams
+// in; those sharing it are charged its size once it is done. A fetch cut
+// short by its leader's budget is made again for the others.
+func (s *server) fetchShared(ctx context.Context, name, path, etag, accept string) (*registryResponse, error) {
+	resp, err, shared := s.fetches.do(ctx, path+"\x00"+etag+"\x00"+accept, func(ctx context.Context) (*registryResponse, error) {
+		if s.registryTimeout <= 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeoutCause(ctx, sharedFetchTimeout, errRegistryTimeout)
+			defer cancel()
+		}
+		return s.fetchFromRegistries(ctx, name, path, etag, accept)
+	})
+	if shared && errors.Is(err, errDownloadBudgetExceeded) && ctx.Err() == nil {
+		return s.fetchShared(ctx, name, path, etag, accept)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !shared {
+		return resp, nil
+	}
+	recordFetch(ctx, s.registriesFor(name)[0]+"/"+path, true)
+	if err := chargeDownload(ctx, resp.size); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
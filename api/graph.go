@@ -0,0 +1,125 @@
+package api
+
+import "net/http"
+
+// graphNode is a unique name@version in a resolved tree.
+type graphNode struct {
+	name    string
+	version string
+	deps    map[string]*graphNode
+}
+
+func (n *graphNode) key() string {
+	return n.name + "@" + n.version
+}
+
+// sortedDeps returns the node's dependencies ordered by key.
+func (n *graphNode) sortedDeps() []*graphNode {
+	deps := make([]*graphNode, 0, len(n.deps))
+	for _, key := range sortedKeys(n.deps) {
+		deps = append(deps, n.deps[key])
+	}
+	return deps
+}
+
+// packageGraph collapses repeated subtrees of a resolved tree into a
+// graph of unique packages.
+type packageGraph struct {
+	root  *graphNode
+	nodes map[string]*graphNode
+}
+
+func newPackageGraph(tree *NpmPackageVersion) *packageGraph {
+	g := &packageGraph{nodes: map[string]*graphNode{}}
+	g.root = g.add(tree)
+	return g
+}
+
+func (g *packageGraph) add(pkg *NpmPackageVersion) *graphNode {
+	key := pkg.Name + "@" + pkg.Version
+	node, ok := g.nodes[key]
+	if !ok {
+		node = &graphNode{name: pkg.Name, version: pkg.Version, deps: map[string]*graphNode{}}
+		g.nodes[key] = node
+	}
+	for _, dep := range pkg.Dependencies {
+		if dep.Excluded || dep.Truncated || dep.Skipped != "" {
+			continue
+		}
+		child := g.add(dep)
+		node.deps[child.key()] = child
+	}
+	return node
+}
+
+// sortedNodes returns every node ordered by key.
+func (g *packageGraph) sortedNodes() []*graphNode {
+	nodes := make([]*graphNode, 0, len(g.nodes))
+	for _, key := range sortedKeys(g.nodes) {
+		nodes = append(nodes, g.nodes[key])
+	}
+	return nodes
+}
+
+// installOrder returns the nodes with every package after all of its
+// dependencies. If the graph has a cycle no such order exists; the nodes
+// are then returned in key order and ok is false.
+func (g *packageGraph) installOrder() (order []*graphNode, ok bool) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[*graphNode]int{}
+	acyclic := true
+	var visit func(n *graphNode)
+	visit = func(n *graphNode) {
+		switch state[n] {
+		case visiting:
+			acyclic = false
+			return
+		case done:
+			return
+		}
+		state[n] = visiting
+		for _, dep := range n.sortedDeps() {
+			visit(dep)
+		}
+		state[n] = done
+		order = append(order, n)
+	}
+	for _, n := range g.sortedNodes() {
+		visit(n)
+	}
+	if !acyclic {
+		order = g.sortedNodes()
+	}
+	return order, acyclic
+}
+
+type installOrderResponse struct {
+	Name    string       `json:"name"`
+	Version string       `json:"version"`
+	Order   []packageRef `json:"order"`
+	Warning string       `json:"warning,omitempty"`
+}
+
+// installOrderHandler returns the unique packages of a resolved tree in an
+// order where dependencies come before their dependents.
+func (s *server) installOrderHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, _ := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+
+	order, ok := newPackageGraph(rootPkg).installOrder()
+	resp := &installOrderResponse{Name: rootPkg.Name, Version: rootPkg.Version, Order: make([]packageRef, 0, len(order))}
+	for _, n := range order {
+		resp.Order = append(resp.Order, packageRef{Name: n.name, Version: n.version})
+	}
+	if !ok {
+		resp.Warning = "dependency cycle detected; packages are listed in arbitrary order"
+		s.logger.Warn("install order requested for cyclic graph", "package", rootPkg.Name, "version", rootPkg.Version)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,59 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestInstallOrder(t *testing.T) {
+	graph := mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"web": "^1.0.0", "cli": "^1.0.0", "util": "^1.0.0"})},
+		"web":  {"1.0.0": deps(map[string]string{"util": "^1.0.0", "http": "^2.0.0"})},
+		"cli":  {"1.0.0": deps(map[string]string{"http": "^2.0.0", "util": "^2.0.0"})},
+		"http": {"2.0.0": deps(map[string]string{"util": "^1.0.0"})},
+		"util": {"1.0.0": {}, "2.0.0": {}},
+	}
+	registry := newMockRegistry(t, graph)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0/install-order")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Order []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"order"`
+		Warning string `json:"warning"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Empty(t, body.Warning)
+
+	position := map[string]int{}
+	for i, p := range body.Order {
+		key := p.Name + "@" + p.Version
+		assert.NotContains(t, position, key, "duplicate %s", key)
+		position[key] = i
+	}
+	assert.Len(t, position, 6)
+
+	edges := [][2]string{
+		{"app@1.0.0", "web@1.0.0"}, {"app@1.0.0", "cli@1.0.0"}, {"app@1.0.0", "util@1.0.0"},
+		{"web@1.0.0", "util@1.0.0"}, {"web@1.0.0", "http@2.0.0"},
+		{"cli@1.0.0", "http@2.0.0"}, {"cli@1.0.0", "util@2.0.0"},
+		{"http@2.0.0", "util@1.0.0"},
+	}
+	for _, e := range edges {
+		assert.Less(t, position[e[1]], position[e[0]], "%s must precede %s", e[1], e[0])
+	}
+	assert.Equal(t, "app", body.Order[len(body.Order)-1].Name)
+}
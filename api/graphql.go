@@ -0,0 +1,572 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The /graphql endpoint exposes resolution through a small GraphQL schema:
+//
+//	type Query {
+//	  package(name: String!, version: String!): Package
+//	}
+//	type Package {
+//	  name: String!
+//	  version: String!
+//	  constraint: String!
+//	  alias: String
+//	  license: String
+//	  dependencies: [Package]!
+//	}
+//
+// A package's constraint is the range or tag it was required with, and
+// its alias the name a dependent requires it under with "npm:". A
+// dependency that fails to resolve is null, with an error naming its
+// index.
+//
+// Queries are resolved within the same limits as the other endpoints:
+// the resolution timeout, download budget and unique package limit, and
+// selections may nest no deeper than the maximum recursion depth.
+//
+// Only the query subset needed for this schema is supported: a single
+// query operation with variables, aliases and nested selections, but no
+// fragments or directives. Dependencies are resolved lazily, only for the
+// packages whose dependencies field is selected.
+
+// maxGraphQLBodySize bounds the body of a posted query.
+const maxGraphQLBodySize = 1 << 20
+
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   any            `json:"data"`
+	Errors []graphqlError `json:"errors,omitempty"`
+}
+
+func (s *server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				s.writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: "invalid variables: " + err.Error()}}})
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBodySize)).Decode(&req); err != nil {
+		s.writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: "invalid request body: " + err.Error()}}})
+		return
+	}
+
+	selections, err := parseGraphQL(req.Query)
+	if err != nil {
+		s.writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+
+	// Each level of dependencies selected is a level of the tree, which
+	// resolutions keep within maxRecursionDepth.
+	if depth := dependencyDepth(selections); depth > s.maxRecursionDepth {
+		s.writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: fmt.Sprintf("query depth %d exceeds the maximum of %d", depth, s.maxRecursionDepth)}}})
+		return
+	}
+	timeout, err := s.resolutionTimeout(r)
+	if err != nil {
+		s.writeJSON(w, http.StatusBadRequest, graphqlResponse{Errors: []graphqlError{{Message: err.Error()}}})
+		return
+	}
+	ctx, cancel := withResolutionTimeout(r.Context(), timeout)
+	defer cancel()
+	res := s.newResolver(resolveOptions{})
+	exec := &graphqlExecutor{
+		ctx:       withDownloadBudget(ctx, res.downloadBudget),
+		res:       res,
+		variables: req.Variables,
+	}
+	data := exec.query(selections)
+	s.writeJSON(w, http.StatusOK, graphqlResponse{Data: data, Errors: exec.errors})
+}
+
+// graphqlField is one selected field of a query.
+type graphqlField struct {
+	alias      string
+	name       string
+	arguments  map[string]graphqlValue
+	selections []*graphqlField
+}
+
+func (f *graphqlField) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// graphqlValue is an argument value: a literal or a variable reference.
+type graphqlValue struct {
+	literal  any
+	variable string
+}
+
+// graphqlObject is a response object that keeps fields in selection order.
+type graphqlObject []graphqlEntry
+
+type graphqlEntry struct {
+	key   string
+	value any
+}
+
+func (o graphqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// graphqlPackage is a resolved package whose dependencies are expanded
+// only when selected.
+type graphqlPackage struct {
+	name       string
+	version    string
+	constraint string
+	alias      string
+	manifest   *npmPackageResponse
+}
+
+type graphqlExecutor struct {
+	ctx       context.Context
+	res       *resolver
+	variables map[string]any
+	errors    []graphqlError
+}
+
+func (e *graphqlExecutor) fail(path []any, err error) {
+	e.errors = append(e.errors, graphqlError{Message: err.Error(), Path: append([]any(nil), path...)})
+}
+
+func (e *graphqlExecutor) query(selections []*graphqlField) graphqlObject {
+	data := graphqlObject{}
+	for _, f := range selections {
+		path := []any{f.responseKey()}
+		switch f.name {
+		case "__typename":
+			data = append(data, graphqlEntry{f.responseKey(), "Query"})
+		case "package":
+			name, errName := e.stringArg(f, "name")
+			version, errVersion := e.stringArg(f, "version")
+			if errName != nil || errVersion != nil {
+				e.fail(path, firstError(errName, errVersion))
+				data = append(data, graphqlEntry{f.responseKey(), nil})
+				continue
+			}
+			pkg, err := e.resolvePackage(name, version)
+			if err != nil {
+				e.fail(path, err)
+				data = append(data, graphqlEntry{f.responseKey(), nil})
+				continue
+			}
+			data = append(data, graphqlEntry{f.responseKey(), e.pkg(pkg, f.selections, path)})
+		default:
+			e.fail(path, fmt.Errorf("cannot query field %q on type Query", f.name))
+			data = append(data, graphqlEntry{f.responseKey(), nil})
+		}
+	}
+	return data
+}
+
+func (e *graphqlExecutor) pkg(pkg *graphqlPackage, selections []*graphqlField, path []any) any {
+	if len(selections) == 0 {
+		e.fail(path, fmt.Errorf("field of type Package must have a selection of subfields"))
+		return nil
+	}
+	obj := graphqlObject{}
+	for _, f := range selections {
+		var value any
+		switch f.name {
+		case "__typename":
+			value = "Package"
+		case "name":
+			value = pkg.name
+		case "version":
+			value = pkg.version
+		case "constraint":
+			value = pkg.constraint
+		case "alias":
+			if pkg.alias != "" {
+				value = pkg.alias
+			}
+		case "license":
+			if license := pkg.manifest.license(); license != "" {
+				value = license
+			}
+		case "dependencies":
+			deps := []any{}
+			for i, depName := range sortedKeys(pkg.manifest.Dependencies) {
+				depPath := append(path[:len(path):len(path)], f.responseKey(), i)
+				name, versionConstraint := depName, pkg.manifest.Dependencies[depName]
+				alias := ""
+				if aliased, aliasedConstraint, ok := parseAlias(versionConstraint); ok {
+					name, alias, versionConstraint = aliased, depName, aliasedConstraint
+				}
+				dep, err := e.resolvePackage(name, versionConstraint)
+				if err != nil {
+					// The null keeps the other dependencies at the indexes
+					// error paths name.
+					e.fail(depPath, err)
+					deps = append(deps, nil)
+					continue
+				}
+				dep.alias = alias
+				deps = append(deps, e.pkg(dep, f.selections, depPath))
+			}
+			value = deps
+		default:
+			e.fail(append(path, f.responseKey()), fmt.Errorf("cannot query field %q on type Package", f.name))
+		}
+		obj = append(obj, graphqlEntry{f.responseKey(), value})
+	}
+	return obj
+}
+
+func (e *graphqlExecutor) resolvePackage(name, versionConstraint string) (*graphqlPackage, error) {
+	if err := validateConstraint(versionConstraint); err != nil {
+		return nil, err
+	}
+	meta, err := e.res.fetchPackageMeta(e.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	version, err := e.res.selectVersion(versionConstraint, meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.res.countUnique(&NpmPackageVersion{Name: name, Version: version}); err != nil {
+		return nil, err
+	}
+	manifest, err := e.res.fetchPackage(e.ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlPackage{name: name, version: version, constraint: versionConstraint, manifest: manifest}, nil
+}
+
+func (e *graphqlExecutor) stringArg(f *graphqlField, name string) (string, error) {
+	arg, ok := f.arguments[name]
+	if !ok {
+		return "", fmt.Errorf("field %q argument %q is required", f.name, name)
+	}
+	value := arg.literal
+	if arg.variable != "" {
+		if value, ok = e.variables[arg.variable]; !ok {
+			return "", fmt.Errorf("variable $%s is not defined", arg.variable)
+		}
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q argument %q must be a String", f.name, name)
+	}
+	return str, nil
+}
+
+// dependencyDepth returns how many levels of dependencies selections
+// nest, the depth of the tree they walk.
+func dependencyDepth(selections []*graphqlField) int {
+	depth := 0
+	for _, f := range selections {
+		d := dependencyDepth(f.selections)
+		if f.name == "dependencies" {
+			d++
+		}
+		depth = max(depth, d)
+	}
+	return depth
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphqlParser parses the supported query subset.
+type graphqlParser struct {
+	src string
+	pos int
+}
+
+func parseGraphQL(src string) ([]*graphqlField, error) {
+	p := &graphqlParser{src: src}
+	if p.peekName() == "query" {
+		p.name()
+		if p.peek() != '{' && p.peek() != '(' {
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek() == '(' {
+			if err := p.skipVariableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	} else if name := p.peekName(); name != "" {
+		return nil, fmt.Errorf("unsupported operation %q", name)
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipIgnored(); p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:p.pos+1])
+	}
+	return selections, nil
+}
+
+func (p *graphqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipIgnored skips whitespace, commas and comments.
+func (p *graphqlParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ',' || unicode.IsSpace(rune(c)):
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *graphqlParser) peek() byte {
+	p.skipIgnored()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *graphqlParser) expect(c byte) error {
+	if p.peek() != c {
+		if p.pos >= len(p.src) {
+			return p.errorf("expected %q, got end of query", c)
+		}
+		return p.errorf("expected %q, got %q", c, p.src[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (p *graphqlParser) peekName() string {
+	p.skipIgnored()
+	end := p.pos
+	for end < len(p.src) && isNameByte(p.src[end], end == p.pos) {
+		end++
+	}
+	return p.src[p.pos:end]
+}
+
+func (p *graphqlParser) name() (string, error) {
+	name := p.peekName()
+	if name == "" {
+		return "", p.errorf("expected a name")
+	}
+	p.pos += len(name)
+	return name, nil
+}
+
+func (p *graphqlParser) selectionSet() ([]*graphqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []*graphqlField
+	for p.peek() != '}' {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated selection set")
+		}
+		if strings.HasPrefix(p.src[p.pos:], "...") {
+			return nil, p.errorf("fragments are not supported")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.pos++
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return fields, nil
+}
+
+func (p *graphqlParser) field() (*graphqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &graphqlField{name: name}
+	if p.peek() == ':' {
+		p.pos++
+		f.alias = name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == '(' {
+		if f.arguments, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == '{' {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *graphqlParser) arguments() (map[string]graphqlValue, error) {
+	p.pos++ // (
+	args := map[string]graphqlValue{}
+	for p.peek() != ')' {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+	p.pos++
+	return args, nil
+}
+
+func (p *graphqlParser) value() (graphqlValue, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name, err := p.name()
+		return graphqlValue{variable: name}, err
+	case c == '"':
+		return p.stringValue()
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil {
+			return graphqlValue{}, p.errorf("invalid integer %q", p.src[start:p.pos])
+		}
+		return graphqlValue{literal: n}, nil
+	}
+	switch name, _ := p.name(); name {
+	case "true":
+		return graphqlValue{literal: true}, nil
+	case "false":
+		return graphqlValue{literal: false}, nil
+	case "null":
+		return graphqlValue{}, nil
+	default:
+		return graphqlValue{}, p.errorf("unsupported value %q", name)
+	}
+}
+
+func (p *graphqlParser) stringValue() (graphqlValue, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return graphqlValue{}, p.errorf("unterminated string")
+	}
+	p.pos++
+	str, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		return graphqlValue{}, p.errorf("invalid string %s", p.src[start:p.pos])
+	}
+	return graphqlValue{literal: str}, nil
+}
+
+// skipVariableDefinitions skips "($name: Type = default, ...)"; variable
+// values are checked when they are used.
+func (p *graphqlParser) skipVariableDefinitions() error {
+	p.pos++ // (
+	for p.peek() != ')' {
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated variable definitions")
+		}
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		for strings.ContainsRune("[]!", rune(p.peek())) || isNameByte(p.peek(), true) {
+			if p.peek() == '[' || p.peek() == ']' || p.peek() == '!' {
+				p.pos++
+			} else {
+				p.name()
+			}
+		}
+		if p.peek() == '=' {
+			p.pos++
+			if _, err := p.value(); err != nil {
+				return err
+			}
+		}
+	}
+	p.pos++
+	return nil
+}
@@ -0,0 +1,180 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func postGraphQL(t *testing.T, server *httptest.Server, query string, variables map[string]any) (int, string) {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	require.Nil(t, err)
+	resp, err := server.Client().Post(server.URL+"/graphql", "application/json", bytes.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	require.Nil(t, err)
+	return resp.StatusCode, buf.String()
+}
+
+func TestGraphQLPackage(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib":  {"1.0.0": {}, "1.2.0": deps(map[string]string{"leaf": "~2.0.0"})},
+		"leaf": {"2.0.3": {"license": "MIT"}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithGraphQL(true)))
+	defer server.Close()
+
+	status, body := postGraphQL(t, server, `
+		query Tree($name: String!) {
+			package(name: $name, version: "1.0.0") {
+				name
+				version
+				dependencies { name version dependencies { name license } }
+			}
+		}`, map[string]any{"name": "app"})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"data": {"package": {
+		"name": "app",
+		"version": "1.0.0",
+		"dependencies": [{"name": "lib", "version": "1.2.0", "dependencies": [{"name": "leaf", "license": "MIT"}]}]
+	}}}`, body)
+}
+
+func TestGraphQLResolvesLazily(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithGraphQL(true)))
+	defer server.Close()
+
+	status, body := postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { version } }`, nil)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"data": {"package": {"version": "1.0.0"}}}`, body)
+	assert.Equal(t, []string{"/app", "/app/1.0.0"}, registry.Requests())
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithGraphQL(true)))
+	defer server.Close()
+
+	status, body := postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { size } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"data": {"package": {"size": null}}, "errors": [{"message": "cannot query field \"size\" on type Package", "path": ["package", "size"]}]}`, body)
+
+	status, _ = postGraphQL(t, server, `{ package(name: "app"`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestGraphQLDisabledByDefault(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	status, _ := postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { name } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestGraphQLConstraintAndAlias(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0", "legacy": "npm:lib@1.0.0"})},
+		"lib": {"1.0.0": {}, "1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithGraphQL(true)))
+	defer server.Close()
+
+	status, body := postGraphQL(t, server, `{ package(name: "app", version: "1.x") { constraint alias dependencies { name version constraint alias } } }`, nil)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"data": {"package": {
+		"constraint": "1.x",
+		"alias": null,
+		"dependencies": [
+			{"name": "lib", "version": "1.0.0", "constraint": "1.0.0", "alias": "legacy"},
+			{"name": "lib", "version": "1.2.0", "constraint": "^1.0.0", "alias": null}
+		]
+	}}}`, body)
+}
+
+func TestGraphQLFailedDependencyKeepsIndex(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^9.0.0", "c": "^1.0.0"})},
+		"a":   {"1.0.0": {}},
+		"b":   {"1.0.0": {}},
+		"c":   {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithGraphQL(true)))
+	defer server.Close()
+
+	status, body := postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { dependencies { name } } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	var resp struct {
+		Data struct {
+			Package struct {
+				Dependencies []*struct {
+					Name string `json:"name"`
+				} `json:"dependencies"`
+			} `json:"package"`
+		} `json:"data"`
+		Errors []struct {
+			Path []any `json:"path"`
+		} `json:"errors"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(body), &resp))
+	deps := resp.Data.Package.Dependencies
+	require.Len(t, deps, 3)
+	assert.Equal(t, "a", deps[0].Name)
+	assert.Nil(t, deps[1])
+	assert.Equal(t, "c", deps[2].Name)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, []any{"package", "dependencies", float64(1)}, resp.Errors[0].Path)
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":   {"1.0.0": {}},
+		"b":   {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithGraphQL(true),
+		api.WithMaxRecursionDepth(1),
+		api.WithMaxUniquePackages(2),
+	))
+	defer server.Close()
+
+	// Selections nested deeper than the recursion limit are rejected
+	// before anything is fetched.
+	status, body := postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { dependencies { dependencies { name } } } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "query depth 2 exceeds the maximum of 1")
+	assert.Empty(t, registry.Requests())
+
+	// The third unique package exceeds the limit.
+	status, body = postGraphQL(t, server, `{ package(name: "app", version: "1.0.0") { dependencies { name } } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "maximum unique packages")
+
+	// Posted queries are bounded in size.
+	big := `{"query": "{ package(name: \"app\", version: \"1.0.0\") { name } }` + strings.Repeat(" ", 1<<20) + `"}`
+	resp, err := server.Client().Post(server.URL+"/graphql", "application/json", strings.NewReader(big))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,500 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// The resolver is served over gRPC too, for internal services that want
+// typed clients: the Resolver service of proto/resolver.proto. Its methods
+// take the package endpoint's query parameters as options and are held to
+// the same limits as the HTTP API, sharing its caches.
+//
+// gRPC runs over HTTP/2, which the standard library serves over TLS only,
+// so the service answers nothing else. Messages may not be compressed.
+
+// grpcService is the service's full name, which its method paths begin
+// with.
+const grpcService = "npm_packages.resolver.v1.Resolver"
+
+// maxGRPCMessageSize bounds request messages, as gRPC servers do by
+// default.
+const maxGRPCMessageSize = 4 << 20
+
+// gRPC status codes.
+const (
+	grpcOK                 = 0
+	grpcCanceled           = 1
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is a failed call's status: its code and message.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// grpcMethod serves a call with the request message data, sending its
+// response messages, one for a unary method, with send.
+type grpcMethod func(ctx context.Context, r *http.Request, data []byte, send func(*protoMessage) error) error
+
+// grpcHandler returns a handler serving the methods of the gRPC service
+// and nothing else.
+func (s *server) grpcHandler() http.Handler {
+	mux := http.NewServeMux()
+	methods := map[string]grpcMethod{
+		"ResolveTree": s.grpcResolveTree,
+		"StreamTree":  s.grpcStreamTree,
+		"ResolveFlat": s.grpcResolveFlat,
+		"GetVersions": s.grpcGetVersions,
+	}
+	for name, method := range methods {
+		mux.HandleFunc("POST /"+grpcService+"/"+name, s.grpcMethodHandler(method))
+	}
+	return honorCacheBypass(mux)
+}
+
+// grpcMethodHandler serves a gRPC method: it reads the request message,
+// runs the method within any grpc-timeout the client set and ends the
+// response with the call's status in the trailers.
+func (s *server) grpcMethodHandler(method grpcMethod) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			s.writeProblem(w, r, newProblem(problemBadRequest, http.StatusHTTPVersionNotSupported, "gRPC requires HTTP/2"))
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+") {
+			s.writeProblem(w, r, newProblem(problemBadRequest, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported content type %q: expected application/grpc", contentType)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+
+		ctx := r.Context()
+		err := func() error {
+			if raw := r.Header.Get("Grpc-Timeout"); raw != "" {
+				timeout, err := parseGRPCTimeout(raw)
+				if err != nil {
+					return &grpcError{code: grpcInvalidArgument, message: err.Error()}
+				}
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			data, err := readGRPCMessage(r.Body)
+			if err != nil {
+				return err
+			}
+			return method(ctx, r, data, func(m *protoMessage) error { return writeGRPCMessage(w, m) })
+		}()
+
+		status := &grpcError{code: grpcOK}
+		switch {
+		case err == nil:
+		case errors.As(err, &status):
+		case ctx.Err() != nil && r.Context().Err() == nil:
+			status = &grpcError{code: grpcDeadlineExceeded, message: err.Error()}
+		case r.Context().Err() != nil:
+			s.logger.Info("Client went away during gRPC call", "method", r.URL.Path)
+			return
+		default:
+			status = &grpcError{code: grpcUnknown, message: err.Error()}
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(status.code))
+		if status.message != "" {
+			w.Header().Set("Grpc-Message", encodeGRPCMessage(status.message))
+		}
+	}
+}
+
+// readGRPCMessage reads the single request message of a call: a
+// compression flag and a length, then the message.
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: "missing request message"}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{code: grpcUnimplemented, message: "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessageSize {
+		return nil, &grpcError{code: grpcResourceExhausted, message: fmt.Sprintf("request message of %d bytes exceeds the maximum of %d", size, maxGRPCMessageSize)}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: "truncated request message"}
+	}
+	return data, nil
+}
+
+// writeGRPCMessage writes a response message and flushes it to the client.
+func writeGRPCMessage(w http.ResponseWriter, m *protoMessage) error {
+	frame := make([]byte, 5, 5+len(m.buf))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(m.buf)))
+	if _, err := w.Write(append(frame, m.buf...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// parseGRPCTimeout parses a grpc-timeout header: at most eight digits and
+// a unit, such as "30S" or "250m".
+func parseGRPCTimeout(raw string) (time.Duration, error) {
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	if len(raw) < 2 || len(raw) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", raw)
+	}
+	unit, ok := units[raw[len(raw)-1]]
+	n, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", raw)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeGRPCMessage percent-encodes a status message, as the grpc-message
+// trailer requires.
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// problemStatus returns the status of a call that failed with the
+// problem details an HTTP request would have answered with.
+func problemStatus(p *problem) *grpcError {
+	code := grpcUnknown
+	switch {
+	case p.Type == problemResolutionLimit:
+		code = grpcResourceExhausted
+	case p.Status == http.StatusBadRequest || p.Status == http.StatusUnprocessableEntity:
+		code = grpcInvalidArgument
+	case p.Status == http.StatusUnauthorized:
+		code = grpcUnauthenticated
+	case p.Status == http.StatusForbidden:
+		code = grpcPermissionDenied
+	case p.Status == http.StatusNotFound:
+		code = grpcNotFound
+	case p.Status == http.StatusConflict:
+		code = grpcFailedPrecondition
+	case p.Status == http.StatusTooManyRequests:
+		code = grpcResourceExhausted
+	case p.Status == http.StatusBadGateway || p.Status == http.StatusServiceUnavailable:
+		code = grpcUnavailable
+	case p.Status == http.StatusGatewayTimeout:
+		code = grpcDeadlineExceeded
+	case p.Status >= http.StatusInternalServerError:
+		code = grpcInternal
+	}
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	return &grpcError{code: code, message: message}
+}
+
+// grpcResolveRequest is a ResolveRequest message.
+type grpcResolveRequest struct {
+	name    string
+	version string
+	options url.Values
+}
+
+func decodeResolveRequest(data []byte) (*grpcResolveRequest, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	req := &grpcResolveRequest{options: url.Values{}}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			req.name, err = protoString(field)
+		case 2:
+			req.version, err = protoString(field)
+		case 3:
+			var key, value string
+			if key, value, err = decodeStringMapEntry(field); err == nil {
+				req.options.Set(key, value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.name == "" || req.version == "" {
+		return nil, errors.New("a name and a version are required")
+	}
+	return req, nil
+}
+
+// resolveCall decodes the ResolveRequest of a call.
+func resolveCall(data []byte) (*grpcResolveRequest, error) {
+	req, err := decodeResolveRequest(data)
+	if err != nil {
+		return nil, &grpcError{code: grpcInvalidArgument, message: err.Error()}
+	}
+	return req, nil
+}
+
+// grpcResolve resolves the tree a ResolveRequest names, as the package
+// endpoint would.
+func (s *server) grpcResolve(ctx context.Context, r *http.Request, req *grpcResolveRequest) (*NpmPackageVersion, *resolver, error) {
+	rootPkg, res, problemData := s.resolvePackage(ctx, r, req.name, req.version, req.options)
+	if rootPkg != nil {
+		return rootPkg, res, nil
+	}
+	var p problem
+	if problemData == nil || json.Unmarshal(problemData, &p) != nil {
+		return nil, nil, &grpcError{code: grpcCanceled, message: "the client went away"}
+	}
+	return nil, nil, problemStatus(&p)
+}
+
+// grpcResolveTree serves ResolveTree: the resolved tree as one Package.
+func (s *server) grpcResolveTree(ctx context.Context, r *http.Request, data []byte, send func(*protoMessage) error) error {
+	req, err := resolveCall(data)
+	if err != nil {
+		return err
+	}
+	rootPkg, res, err := s.grpcResolve(ctx, r, req)
+	if err != nil {
+		return err
+	}
+	tree := newTreeResponse(req.options, rootPkg, res).NpmPackageVersion
+	if err := send(encodePackage(tree, included(req.options, "dist"), true)); err != nil {
+		return err
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "transport", "grpc")
+	return nil
+}
+
+// grpcStreamTree serves StreamTree: each package as soon as its version is
+// selected, numbered as ?format=ndjson numbers them. A tree served from
+// the cache is sent parents first and siblings in name order.
+func (s *server) grpcStreamTree(ctx context.Context, r *http.Request, data []byte, send func(*protoMessage) error) error {
+	req, err := resolveCall(data)
+	if err != nil {
+		return err
+	}
+	withDist := included(req.options, "dist")
+	var (
+		mu      sync.Mutex
+		ids     = map[*NpmPackageVersion]int{}
+		sendErr error
+	)
+	write := func(pkg, parent *NpmPackageVersion) {
+		mu.Lock()
+		defer mu.Unlock()
+		if sendErr != nil {
+			return
+		}
+		id, ok := ids[pkg]
+		if !ok {
+			id = len(ids)
+			ids[pkg] = id
+		}
+		var msg protoMessage
+		msg.int32(1, id)
+		if parent != nil {
+			msg.optionalInt32(2, ids[parent])
+		}
+		msg.message(3, encodePackage(pkg, withDist, false))
+		sendErr = send(&msg)
+	}
+	var writeTree func(pkg, parent *NpmPackageVersion)
+	writeTree = func(pkg, parent *NpmPackageVersion) {
+		write(pkg, parent)
+		for _, name := range sortedKeys(pkg.Dependencies) {
+			writeTree(pkg.Dependencies[name], pkg)
+		}
+	}
+
+	rootPkg, _, err := s.grpcResolve(withVisitor(ctx, func(pkg *NpmPackageVersion) { write(pkg, pkg.parent) }), r, req)
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		writeTree(rootPkg, nil)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "transport", "grpc", "nodes", len(ids))
+	return nil
+}
+
+// grpcResolveFlat serves ResolveFlat: the install set, as ?format=flat
+// returns it.
+func (s *server) grpcResolveFlat(ctx context.Context, r *http.Request, data []byte, send func(*protoMessage) error) error {
+	req, err := resolveCall(data)
+	if err != nil {
+		return err
+	}
+	resolveReq := packageRequest(ctx, r, req.name, req.version, req.options)
+	timeout, err := s.resolutionTimeout(resolveReq)
+	if err != nil {
+		return &grpcError{code: grpcInvalidArgument, message: err.Error()}
+	}
+	opts, err := parseResolveOptions(resolveReq)
+	if err != nil {
+		return &grpcError{code: grpcInvalidArgument, message: err.Error()}
+	}
+	ctx, cancel := withResolutionTimeout(ctx, timeout)
+	defer cancel()
+
+	res := s.newResolver(opts)
+	set, err := res.resolveInstallSet(ctx, req.name, req.version)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return err
+		}
+		return problemStatus(resolveProblem(res.timedOut(err, timeout)))
+	}
+	var msg protoMessage
+	msg.string(1, set.Name)
+	msg.string(2, set.Version)
+	msg.stringMap(3, set.Packages)
+	for _, conflict := range set.Conflicts {
+		var c protoMessage
+		c.string(1, conflict.Name)
+		c.string(2, conflict.Version)
+		for _, required := range conflict.Constraints {
+			var dr protoMessage
+			dr.string(1, required.Constraint)
+			dr.string(2, required.RequiredBy)
+			c.message(3, &dr)
+		}
+		msg.message(4, &c)
+	}
+	if err := send(&msg); err != nil {
+		return err
+	}
+	s.logger.Info("Successfully handled request", "package", req.name, "version", set.Version, "format", "flat", "transport", "grpc", "packages", len(set.Packages))
+	return nil
+}
+
+// grpcGetVersions serves GetVersions: a package's published versions, in
+// ascending order, and its dist-tags.
+func (s *server) grpcGetVersions(ctx context.Context, r *http.Request, data []byte, send func(*protoMessage) error) error {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return &grpcError{code: grpcInvalidArgument, message: err.Error()}
+	}
+	var name string
+	for _, field := range fields {
+		if field.number == 1 {
+			if name, err = protoString(field); err != nil {
+				return &grpcError{code: grpcInvalidArgument, message: err.Error()}
+			}
+		}
+	}
+	if name == "" {
+		return &grpcError{code: grpcInvalidArgument, message: "a name is required"}
+	}
+
+	meta, err := s.fetchPackageMeta(ctx, name)
+	if isNotFound(err) {
+		return &grpcError{code: grpcNotFound, message: fmt.Sprintf("package %s not found", name)}
+	}
+	if err != nil {
+		if r.Context().Err() != nil {
+			return err
+		}
+		return problemStatus(resolveProblem(err))
+	}
+	var versions semver.Collection
+	for version := range meta.Versions {
+		if v, err := semver.NewVersion(version); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(versions)
+
+	var msg protoMessage
+	msg.string(1, name)
+	for _, v := range versions {
+		msg.bytes(2, []byte(v.Original()))
+	}
+	msg.stringMap(3, meta.DistTags)
+	if err := send(&msg); err != nil {
+		return err
+	}
+	s.logger.Info("Successfully handled request", "package", name, "versions", len(versions), "transport", "grpc")
+	return nil
+}
+
+// encodePackage encodes pkg as a Package message, with its dist if
+// withDist is set and, if nested is, its dependencies beneath it.
+func encodePackage(pkg *NpmPackageVersion, withDist, nested bool) *protoMessage {
+	var msg protoMessage
+	msg.string(1, pkg.Name)
+	msg.string(2, pkg.Version)
+	msg.string(3, pkg.License)
+	msg.string(4, pkg.Spec)
+	msg.string(5, pkg.Alias)
+	msg.bool(6, pkg.Dev)
+	msg.bool(7, pkg.Optional)
+	msg.bool(8, pkg.Excluded)
+	msg.bool(9, pkg.Truncated)
+	msg.bool(10, pkg.Circular || circular(pkg))
+	msg.string(11, pkg.Skipped)
+	msg.string(12, pkg.Unresolved)
+	if withDist && pkg.Dist != nil {
+		var dist protoMessage
+		dist.string(1, pkg.Dist.Tarball)
+		dist.string(2, pkg.Dist.Shasum)
+		dist.string(3, pkg.Dist.Integrity)
+		msg.message(13, &dist)
+	}
+	if nested {
+		for _, name := range sortedKeys(pkg.Dependencies) {
+			var entry protoMessage
+			entry.string(1, name)
+			entry.message(2, encodePackage(pkg.Dependencies[name], withDist, true))
+			msg.message(14, &entry)
+		}
+	}
+	return &msg
+}
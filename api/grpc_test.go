@@ -0,0 +1,293 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// pbField is a decoded protobuf field: a varint or length-delimited bytes.
+type pbField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// pbMessage decodes the fields of a message holding only varint and
+// length-delimited fields, by number.
+func pbMessage(t *testing.T, data []byte) map[int][]pbField {
+	t.Helper()
+	fields := map[int][]pbField{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		require.Greater(t, n, 0)
+		data = data[n:]
+		field := pbField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.varint, n = binary.Uvarint(data)
+			require.Greater(t, n, 0)
+			data = data[n:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			require.Greater(t, n, 0)
+			field.bytes, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[field.number] = append(fields[field.number], field)
+	}
+	return fields
+}
+
+func pbString(fields map[int][]pbField, number int) string {
+	if len(fields[number]) == 0 {
+		return ""
+	}
+	return string(fields[number][0].bytes)
+}
+
+func pbStringMap(t *testing.T, fields map[int][]pbField, number int) map[string]string {
+	entries := map[string]string{}
+	for _, field := range fields[number] {
+		entry := pbMessage(t, field.bytes)
+		entries[pbString(entry, 1)] = pbString(entry, 2)
+	}
+	return entries
+}
+
+// pbAppendString appends a string field to a message.
+func pbAppendString(msg []byte, number int, s string) []byte {
+	msg = binary.AppendUvarint(msg, uint64(number)<<3|2)
+	msg = binary.AppendUvarint(msg, uint64(len(s)))
+	return append(msg, s...)
+}
+
+// resolveRequest encodes a ResolveRequest message.
+func resolveRequest(name, version string, options map[string]string) []byte {
+	msg := pbAppendString(pbAppendString(nil, 1, name), 2, version)
+	for key, value := range options {
+		msg = pbAppendString(msg, 3, string(pbAppendString(pbAppendString(nil, 1, key), 2, value)))
+	}
+	return msg
+}
+
+// grpcPackage is a decoded Package message.
+type grpcPackage struct {
+	Name, Version, License, Spec string
+	Tarball                      string
+	Dependencies                 map[string]*grpcPackage
+}
+
+func decodePackage(t *testing.T, data []byte) *grpcPackage {
+	fields := pbMessage(t, data)
+	pkg := &grpcPackage{
+		Name:    pbString(fields, 1),
+		Version: pbString(fields, 2),
+		License: pbString(fields, 3),
+		Spec:    pbString(fields, 4),
+	}
+	if len(fields[13]) > 0 {
+		pkg.Tarball = pbString(pbMessage(t, fields[13][0].bytes), 1)
+	}
+	for _, field := range fields[14] {
+		entry := pbMessage(t, field.bytes)
+		if pkg.Dependencies == nil {
+			pkg.Dependencies = map[string]*grpcPackage{}
+		}
+		pkg.Dependencies[pbString(entry, 1)] = decodePackage(t, entry[2][0].bytes)
+	}
+	return pkg
+}
+
+// grpcResult is the outcome of a call: its response messages and status.
+type grpcResult struct {
+	messages [][]byte
+	status   int
+	message  string
+}
+
+// newGRPCServer serves the gRPC service over HTTP/2.
+func newGRPCServer(t *testing.T, opts ...api.Option) *httptest.Server {
+	t.Helper()
+	_, handler := api.NewWithGRPC(opts...)
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// callGRPC calls a method of the resolver service with a request message.
+func callGRPC(t *testing.T, server *httptest.Server, method string, req []byte) *grpcResult {
+	t.Helper()
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(req)))
+	httpReq, err := http.NewRequest(http.MethodPost, server.URL+"/npm_packages.resolver.v1.Resolver/"+method, bytes.NewReader(append(frame, req...)))
+	require.Nil(t, err)
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	httpReq.Header.Set("TE", "trailers")
+	resp, err := server.Client().Do(httpReq)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.Equal(t, "application/grpc", resp.Header.Get("Content-Type"))
+	// The status is sent in the trailers alone.
+	require.Empty(t, resp.Header.Get("Grpc-Status"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	result := &grpcResult{}
+	for len(body) > 0 {
+		require.GreaterOrEqual(t, len(body), 5)
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		result.messages = append(result.messages, body[5:5+size])
+		body = body[5+size:]
+	}
+	result.status, err = strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	require.Nil(t, err)
+	result.message = resp.Trailer.Get("Grpc-Message")
+	return result
+}
+
+func grpcRegistry(t *testing.T) *registryServer {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {"license": "MIT", "dependencies": map[string]string{"lib": "^1.0.0"}}},
+		"lib": {
+			"1.0.0": {"license": "ISC", "dist": map[string]any{"tarball": "https://registry.test/lib-1.0.0.tgz"}},
+			"1.2.0": {"license": "ISC", "dist": map[string]any{"tarball": "https://registry.test/lib-1.2.0.tgz"}},
+			"2.0.0": {"license": "ISC"},
+		},
+	})
+	registry.distTags = map[string]map[string]string{"lib": {"latest": "1.2.0", "next": "2.0.0"}}
+	return registry
+}
+
+func TestGRPCResolveTree(t *testing.T) {
+	server := newGRPCServer(t, api.WithRegistryURL(grpcRegistry(t).URL))
+
+	result := callGRPC(t, server, "ResolveTree", resolveRequest("app", "1.0.0", nil))
+	require.Equal(t, 0, result.status, result.message)
+	require.Len(t, result.messages, 1)
+	assert.Equal(t, &grpcPackage{
+		Name: "app", Version: "1.0.0", License: "MIT",
+		Dependencies: map[string]*grpcPackage{"lib": {Name: "lib", Version: "1.2.0", License: "ISC", Spec: "^1.0.0"}},
+	}, decodePackage(t, result.messages[0]))
+
+	// Options are the package endpoint's query parameters.
+	result = callGRPC(t, server, "ResolveTree", resolveRequest("app", "1.0.0", map[string]string{"dist": "true", "strategy": "lowest"}))
+	require.Equal(t, 0, result.status, result.message)
+	lib := decodePackage(t, result.messages[0]).Dependencies["lib"]
+	assert.Equal(t, "1.0.0", lib.Version)
+	assert.Equal(t, "https://registry.test/lib-1.0.0.tgz", lib.Tarball)
+}
+
+func TestGRPCStreamTree(t *testing.T) {
+	server := newGRPCServer(t, api.WithRegistryURL(grpcRegistry(t).URL))
+
+	result := callGRPC(t, server, "StreamTree", resolveRequest("app", "1.0.0", nil))
+	require.Equal(t, 0, result.status, result.message)
+	require.Len(t, result.messages, 2)
+	root, lib := pbMessage(t, result.messages[0]), pbMessage(t, result.messages[1])
+
+	// The root is package 0, without a parent, and its packages carry no
+	// dependencies of their own.
+	assert.Empty(t, root[1])
+	assert.Empty(t, root[2])
+	assert.Equal(t, &grpcPackage{Name: "app", Version: "1.0.0", License: "MIT"}, decodePackage(t, root[3][0].bytes))
+	assert.Equal(t, uint64(1), lib[1][0].varint)
+	require.Len(t, lib[2], 1)
+	assert.Equal(t, uint64(0), lib[2][0].varint)
+	assert.Equal(t, "1.2.0", decodePackage(t, lib[3][0].bytes).Version)
+}
+
+func TestGRPCResolveFlat(t *testing.T) {
+	server := newGRPCServer(t, api.WithRegistryURL(grpcRegistry(t).URL))
+
+	result := callGRPC(t, server, "ResolveFlat", resolveRequest("app", "1.0.0", nil))
+	require.Equal(t, 0, result.status, result.message)
+	require.Len(t, result.messages, 1)
+	set := pbMessage(t, result.messages[0])
+	assert.Equal(t, "app", pbString(set, 1))
+	assert.Equal(t, "1.0.0", pbString(set, 2))
+	assert.Equal(t, map[string]string{"lib": "1.2.0"}, pbStringMap(t, set, 3))
+	assert.Empty(t, set[4])
+}
+
+func TestGRPCGetVersions(t *testing.T) {
+	server := newGRPCServer(t, api.WithRegistryURL(grpcRegistry(t).URL))
+
+	result := callGRPC(t, server, "GetVersions", pbAppendString(nil, 1, "lib"))
+	require.Equal(t, 0, result.status, result.message)
+	require.Len(t, result.messages, 1)
+	versions := pbMessage(t, result.messages[0])
+	assert.Equal(t, "lib", pbString(versions, 1))
+	var listed []string
+	for _, field := range versions[2] {
+		listed = append(listed, string(field.bytes))
+	}
+	assert.Equal(t, []string{"1.0.0", "1.2.0", "2.0.0"}, listed)
+	assert.Equal(t, map[string]string{"latest": "1.2.0", "next": "2.0.0"}, pbStringMap(t, versions, 3))
+}
+
+func TestGRPCErrors(t *testing.T) {
+	server := newGRPCServer(t, api.WithRegistryURL(grpcRegistry(t).URL))
+
+	for _, tc := range []struct {
+		method string
+		req    []byte
+		status int
+	}{
+		{"ResolveTree", resolveRequest("missing", "1.0.0", nil), 5},
+		{"ResolveTree", resolveRequest("app", "", nil), 3},
+		{"ResolveTree", resolveRequest("app", "1.0.0", map[string]string{"depth": "none"}), 3},
+		{"StreamTree", resolveRequest("lib", "^9.0.0", nil), 5},
+		{"ResolveFlat", resolveRequest("app", "1.0.0", map[string]string{"strategy": "newest"}), 3},
+		{"GetVersions", pbAppendString(nil, 1, "missing"), 5},
+		{"GetVersions", []byte{0xff}, 3},
+	} {
+		result := callGRPC(t, server, tc.method, tc.req)
+		assert.Equal(t, tc.status, result.status, "%s: %s", tc.method, result.message)
+		assert.NotEmpty(t, result.message, tc.method)
+		assert.Empty(t, result.messages, tc.method)
+	}
+
+	// gRPC needs HTTP/2.
+	_, handler := api.NewWithGRPC()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	resp, err := plain.Client().Post(plain.URL+"/npm_packages.resolver.v1.Resolver/ResolveTree", "application/grpc", bytes.NewReader(nil))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, resp.StatusCode)
+}
+
+func TestGRPCHandlerServesServiceAlone(t *testing.T) {
+	handler, grpcHandler := api.NewWithGRPC(api.WithProfiling(true))
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+	grpcServer := httptest.NewServer(grpcHandler)
+	defer grpcServer.Close()
+
+	// The gRPC port answers neither the HTTP API nor its debug endpoints.
+	for _, path := range []string{"/package/app/1.0.0", "/debug/pprof/", "/metrics"} {
+		resp, err := grpcServer.Client().Get(grpcServer.URL + path)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+	// Nor does the HTTP API serve the gRPC methods, whose path it rejects
+	// as it rejects any unknown one.
+	resp, err := httpServer.Client().Post(httpServer.URL+"/npm_packages.resolver.v1.Resolver/ResolveTree", "application/grpc", bytes.NewReader(nil))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
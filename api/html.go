@@ -0,0 +1,47 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+//go:embed templates/tree.html
+var templateFS embed.FS
+
+var treeTemplate = template.Must(template.ParseFS(templateFS, "templates/tree.html"))
+
+// wantsHTML reports whether the client prefers an HTML page, as browsers
+// do, over JSON.
+func wantsHTML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		switch mediaType {
+		case "text/html":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// writeHTML renders the tree as a collapsible nested list.
+func (s *server) writeHTML(w http.ResponseWriter, status int, tree *NpmPackageVersion) bool {
+	var buf bytes.Buffer
+	if err := treeTemplate.Execute(&buf, tree); err != nil {
+		s.logger.Error(err.Error())
+		s.writeProblem(w, nil, newProblem(problemInternal, http.StatusInternalServerError, ""))
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return false
+	}
+	return true
+}
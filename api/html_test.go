@@ -0,0 +1,51 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestHTMLRepresentation(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/package/app/1.0.0", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	resp, err := server.Client().Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "<!DOCTYPE html>")
+	assert.Contains(t, string(body), "<summary>app@1.0.0</summary>")
+	assert.Contains(t, string(body), "lib@1.2.0")
+}
+
+func TestJSONRemainsDefault(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/package/app/1.0.0", nil)
+	require.Nil(t, err)
+	req.Header.Set("Accept", "*/*")
+	resp, err := server.Client().Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+}
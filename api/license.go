@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// unknownLicense is reported for packages whose metadata carries no
+// usable license.
+const unknownLicense = "UNKNOWN"
+
+// licenseList decodes the license fields of a version manifest, which
+// come in several shapes:
+//
+//	"license": "MIT"
+//	"license": {"type": "MIT", "url": "..."}
+//	"licenses": [{"type": "MIT", "url": "..."}, "Apache-2.0"]
+//
+// Unrecognised shapes decode to an empty list rather than failing, so an
+// odd license never breaks a resolution.
+type licenseList []string
+
+func (l *licenseList) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		*l = nil
+		return nil
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		items = []any{raw}
+	}
+	*l = nil
+	for _, item := range items {
+		if license := licenseName(item); license != "" {
+			*l = append(*l, license)
+		}
+	}
+	return nil
+}
+
+// licenseName returns the license named by a string or by the "type" (or,
+// rarely, "name") of an object.
+func licenseName(item any) string {
+	switch v := item.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]any:
+		for _, key := range []string{"type", "name"} {
+			if name, ok := v[key].(string); ok && strings.TrimSpace(name) != "" {
+				return strings.TrimSpace(name)
+			}
+		}
+	}
+	return ""
+}
+
+// license returns the manifest's license as a single SPDX expression,
+// joining several licenses with OR, as npm does.
+func (p *npmPackageResponse) license() string {
+	licenses := p.License
+	if len(licenses) == 0 {
+		licenses = p.Licenses
+	}
+	switch len(licenses) {
+	case 0:
+		return ""
+	case 1:
+		return licenses[0]
+	default:
+		return "(" + strings.Join(licenses, " OR ") + ")"
+	}
+}
+
+type licenseMatch struct {
+	Name    string     `json:"name"`
+	Version string     `json:"version"`
+	License string     `json:"license"`
+	Paths   [][]string `json:"paths"`
+}
+
+type licenseFilterResponse struct {
+	Name     string          `json:"name"`
+	Version  string          `json:"version"`
+	Filter   string          `json:"licenseFilter"`
+	Packages []*licenseMatch `json:"packages"`
+}
+
+// filterByLicense returns every package in the tree whose license matches
+// filter, together with the dependency paths leading to it from the root.
+func filterByLicense(root *NpmPackageVersion, filter string, includeUnknown bool) *licenseFilterResponse {
+	matches := map[string]*licenseMatch{}
+	var walk func(pkg *NpmPackageVersion, trail []string)
+	walk = func(pkg *NpmPackageVersion, trail []string) {
+		trail = append(trail[:len(trail):len(trail)], pkg.Name)
+		if len(trail) > 1 && (licenseMatches(pkg.License, filter) || (includeUnknown && pkg.License == "")) {
+			key := pkg.Name + "@" + pkg.Version
+			m, ok := matches[key]
+			if !ok {
+				license := pkg.License
+				if license == "" {
+					license = unknownLicense
+				}
+				m = &licenseMatch{Name: pkg.Name, Version: pkg.Version, License: license}
+				matches[key] = m
+			}
+			m.Paths = append(m.Paths, trail)
+		}
+		for _, dep := range pkg.Dependencies {
+			walk(dep, trail)
+		}
+	}
+	walk(root, nil)
+
+	resp := &licenseFilterResponse{Name: root.Name, Version: root.Version, Filter: filter, Packages: []*licenseMatch{}}
+	for _, m := range matches {
+		sort.Slice(m.Paths, func(i, j int) bool {
+			return strings.Join(m.Paths[i], "/") < strings.Join(m.Paths[j], "/")
+		})
+		resp.Packages = append(resp.Packages, m)
+	}
+	sort.Slice(resp.Packages, func(i, j int) bool {
+		if resp.Packages[i].Name != resp.Packages[j].Name {
+			return resp.Packages[i].Name < resp.Packages[j].Name
+		}
+		return resp.Packages[i].Version < resp.Packages[j].Version
+	})
+	return resp
+}
+
+// licenseMatches reports whether any license identifier in the SPDX
+// expression matches the glob pattern. Matching is case-insensitive.
+func licenseMatches(expression, pattern string) bool {
+	pattern = strings.ToUpper(pattern)
+	for _, id := range licenseIdentifiers(expression) {
+		if ok, _ := path.Match(pattern, strings.ToUpper(id)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseIdentifiers splits an SPDX expression such as
+// "(MIT OR GPL-3.0-only)" into its license identifiers.
+func licenseIdentifiers(expression string) []string {
+	fields := strings.FieldsFunc(expression, func(r rune) bool {
+		return r == '(' || r == ')' || r == ' '
+	})
+	var ids []string
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "OR", "AND":
+			continue
+		case "WITH":
+			i++ // skip the exception identifier
+			continue
+		}
+		ids = append(ids, fields[i])
+	}
+	return ids
+}
+
+type packageLicense struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	License string `json:"license"`
+}
+
+type licensesResponse struct {
+	Name     string           `json:"name"`
+	Version  string           `json:"version"`
+	Packages []packageLicense `json:"packages"`
+	// Summary counts the packages under each license expression.
+	Summary map[string]int `json:"summary"`
+}
+
+// summarizeLicenses lists the license of every unique package version in
+// the tree but the root, and counts the packages under each.
+func summarizeLicenses(root *NpmPackageVersion) *licensesResponse {
+	packages, _ := bomPackages(root)
+	delete(packages, root.Name+"@"+root.Version)
+	resp := &licensesResponse{Name: root.Name, Version: root.Version, Packages: []packageLicense{}, Summary: map[string]int{}}
+	for _, id := range sortedKeys(packages) {
+		pkg := packages[id]
+		license := pkg.License
+		if license == "" {
+			license = unknownLicense
+		}
+		resp.Packages = append(resp.Packages, packageLicense{Name: pkg.Name, Version: pkg.Version, License: license})
+		resp.Summary[license]++
+	}
+	return resp
+}
+
+// licensesHandler reports the license of each package in the resolved
+// tree, for compliance reviews.
+func (s *server) licensesHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	summary := summarizeLicenses(rootPkg)
+	if s.writeJSON(w, status, summary) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "packages", len(summary.Packages))
+	}
+}
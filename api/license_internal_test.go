@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestLicenseShapes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{"string", `{"license": "MIT"}`, "MIT"},
+		{"expression", `{"license": "(MIT OR Apache-2.0)"}`, "(MIT OR Apache-2.0)"},
+		{"object", `{"license": {"type": "ISC", "url": "https://opensource.org/licenses/ISC"}}`, "ISC"},
+		{"array of objects", `{"licenses": [{"type": "MIT", "url": "u1"}, {"type": "Apache-2.0", "url": "u2"}]}`, "(MIT OR Apache-2.0)"},
+		{"array of strings", `{"licenses": ["BSD-3-Clause"]}`, "BSD-3-Clause"},
+		{"array in license", `{"license": [{"type": "MIT"}]}`, "MIT"},
+		{"license wins over licenses", `{"license": "MIT", "licenses": [{"type": "GPL-2.0"}]}`, "MIT"},
+		{"unrecognised", `{"license": 42}`, ""},
+		{"missing", `{}`, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var manifest npmPackageResponse
+			require.Nil(t, json.Unmarshal([]byte(tc.manifest), &manifest))
+			assert.Equal(t, tc.want, manifest.license())
+		})
+	}
+}
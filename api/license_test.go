@@ -0,0 +1,176 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type licensePackage struct {
+	Name    string     `json:"name"`
+	Version string     `json:"version"`
+	License string     `json:"license"`
+	Paths   [][]string `json:"paths"`
+}
+
+func mixedLicenseRegistry(t *testing.T) *registryServer {
+	return newMockRegistry(t, mockRegistry{
+		"app":     {"1.0.0": {"license": "MIT", "dependencies": map[string]string{"gpl-lib": "^1.0.0", "mit-lib": "^1.0.0", "dual": "^2.0.0"}}},
+		"gpl-lib": {"1.2.0": {"license": "GPL-3.0", "dependencies": map[string]string{"mystery": "^1.0.0"}}},
+		"mit-lib": {"1.0.0": {"license": "MIT", "dependencies": map[string]string{"gpl-lib": "^1.0.0"}}},
+		"dual":    {"2.1.0": {"license": "(MIT OR GPL-3.0-or-later)"}},
+		"mystery": {"1.0.0": {}},
+	})
+}
+
+func getLicenseFilter(t *testing.T, registry *registryServer, query string) []licensePackage {
+	t.Helper()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?" + query)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Packages []licensePackage `json:"packages"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body.Packages
+}
+
+func TestLicenseFilter(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+
+	packages := getLicenseFilter(t, registry, "licenseFilter=GPL-3.0")
+	require.Len(t, packages, 1)
+	assert.Equal(t, "gpl-lib", packages[0].Name)
+	assert.Equal(t, "1.2.0", packages[0].Version)
+	assert.Equal(t, [][]string{{"app", "gpl-lib"}, {"app", "mit-lib", "gpl-lib"}}, packages[0].Paths)
+}
+
+func TestLicenseFilterGlobMatchesExpressions(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+
+	packages := getLicenseFilter(t, registry, "licenseFilter=gpl-*")
+	var names []string
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"dual", "gpl-lib"}, names)
+}
+
+func TestLicenseFilterIncludeUnknown(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+
+	packages := getLicenseFilter(t, registry, "licenseFilter=GPL-3.0&includeUnknown=true")
+	require.Len(t, packages, 2)
+	assert.Equal(t, "gpl-lib", packages[0].Name)
+	assert.Equal(t, "mystery", packages[1].Name)
+	assert.Equal(t, "UNKNOWN", packages[1].License)
+}
+
+func TestLicenses(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0/licenses")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Packages []licensePackage `json:"packages"`
+		Summary  map[string]int   `json:"summary"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, []licensePackage{
+		{Name: "dual", Version: "2.1.0", License: "(MIT OR GPL-3.0-or-later)"},
+		{Name: "gpl-lib", Version: "1.2.0", License: "GPL-3.0"},
+		{Name: "mit-lib", Version: "1.0.0", License: "MIT"},
+		{Name: "mystery", Version: "1.0.0", License: "UNKNOWN"},
+	}, body.Packages)
+	assert.Equal(t, map[string]int{"(MIT OR GPL-3.0-or-later)": 1, "GPL-3.0": 1, "MIT": 1, "UNKNOWN": 1}, body.Summary)
+}
+
+type licenseViolation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	License string `json:"license"`
+}
+
+func TestLicensePolicyEnforce(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithLicensePolicy(api.LicensePolicy{Denied: []string{"gpl-*"}})))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?policy=enforce")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	var p struct {
+		Type       string             `json:"type"`
+		Violations []licenseViolation `json:"violations"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, "/problems/license-policy", p.Type)
+	// dual may be used under MIT, and mystery has no license to deny.
+	assert.Equal(t, []licenseViolation{{Name: "gpl-lib", Version: "1.2.0", License: "GPL-3.0"}}, p.Violations)
+
+	// Without ?policy, the policy isn't checked.
+	tree := getTreeFrom(t, server, "/v1/package/app/1.0.0")
+	assert.Equal(t, "app", tree.Name)
+}
+
+func TestLicensePolicyReport(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithLicensePolicy(api.LicensePolicy{Allowed: []string{"MIT"}})))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?policy=report")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Name       string             `json:"name"`
+		Violations []licenseViolation `json:"violations"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "app", body.Name)
+	assert.Equal(t, []licenseViolation{
+		{Name: "gpl-lib", Version: "1.2.0", License: "GPL-3.0"},
+		{Name: "mystery", Version: "1.0.0", License: "UNKNOWN"},
+	}, body.Violations)
+}
+
+func TestLicensePolicyResolve(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithLicensePolicy(api.LicensePolicy{Denied: []string{"GPL-3.0"}})))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/v1/resolve?policy=enforce", "application/json", strings.NewReader(`{"dependencies": {"mit-lib": "^1.0.0"}}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestLicensePolicyInvalidMode(t *testing.T) {
+	registry := mixedLicenseRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/v1/package/app/1.0.0?policy=strict")
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Contains(t, p.Detail, `invalid policy "strict"`)
+}
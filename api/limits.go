@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// semaphore caps the number of registry requests in flight. A nil
+// semaphore imposes no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire waits for a slot, giving up if ctx is done first.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryAcquire takes a slot if one is free and reports whether it did.
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// rateLimiter spaces registry requests evenly so no more than a fixed
+// number start per second. A nil rateLimiter imposes no limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next request may start, giving up if ctx is done
+// first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var errDownloadBudgetExceeded = errors.New("registry download budget exceeded")
+
+// downloadBudget caps the bytes a single resolution may download from the
+// registry, across all of its fetches.
+type downloadBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+type downloadBudgetKey struct{}
+
+// withDownloadBudget attaches a budget of limit bytes to ctx. A limit of
+// zero or less leaves downloads unbounded.
+func withDownloadBudget(ctx context.Context, limit int64) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, downloadBudgetKey{}, &downloadBudget{limit: limit})
+}
+
+// budgetedReader charges everything read from r to the budget carried by
+// ctx, if any, failing once the budget is exhausted.
+func budgetedReader(ctx context.Context, r io.Reader) io.Reader {
+	if _, ok := ctx.Value(downloadBudgetKey{}).(*downloadBudget); !ok {
+		return r
+	}
+	return &budgetReader{ctx: ctx, r: r}
+}
+
+type budgetReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	if err := chargeDownload(br.ctx, int64(n)); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+// chargeDownload charges n downloaded bytes to the budget carried by ctx,
+// if any, failing once the budget is exhausted.
+func chargeDownload(ctx context.Context, n int64) error {
+	budget, ok := ctx.Value(downloadBudgetKey{}).(*downloadBudget)
+	if !ok {
+		return nil
+	}
+	if used := budget.used.Add(n); used > budget.limit {
+		return fmt.Errorf("%w: downloaded %d bytes, budget is %d", errDownloadBudgetExceeded, used, budget.limit)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// deprecationNotice is a version's deprecation message. Registries
+// occasionally publish it as a boolean, which decodes to no message.
+type deprecationNotice string
+
+func (d *deprecationNotice) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err != nil {
+		*d = ""
+		return nil
+	}
+	*d = deprecationNotice(message)
+	return nil
+}
+
+// uploadedLock is a posted package-lock.json: version 2 and 3 lockfiles
+// list packages by location, version 1 lockfiles nest them under
+// dependencies.
+type uploadedLock struct {
+	packageLock
+	Dependencies map[string]*lockDependency `json:"dependencies"`
+}
+
+// lockDependency is an entry of a version 1 lockfile.
+type lockDependency struct {
+	Version      string                     `json:"version"`
+	Requires     map[string]string          `json:"requires"`
+	Dependencies map[string]*lockDependency `json:"dependencies"`
+}
+
+// lockedPackage is a package installed by a lockfile, at its location.
+type lockedPackage struct {
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+// outdatedPackage is a locked package with newer releases: Wanted is the
+// highest satisfying the ranges its dependents declare, and Latest the
+// registry's latest release.
+type outdatedPackage struct {
+	lockedPackage
+	Wanted string `json:"wanted,omitempty"`
+	Latest string `json:"latest"`
+}
+
+type deprecatedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Message string `json:"message"`
+}
+
+type lockAnalysis struct {
+	Packages int `json:"packages"`
+	// Outdated are the packages locked below the latest release.
+	Outdated []outdatedPackage `json:"outdated"`
+	// Updatable are the packages locked below a release their dependents'
+	// ranges allow, which a fresh install would select.
+	Updatable  []outdatedPackage   `json:"updatable"`
+	Duplicates []packageVersions   `json:"duplicates"`
+	Deprecated []deprecatedPackage `json:"deprecated"`
+	// Failed are the packages whose metadata could not be fetched, which
+	// are left out of the analysis.
+	Failed map[string]string `json:"failed"`
+}
+
+// lockPackages returns the lockfile's packages by location, converting a
+// version 1 lockfile into the locations of later versions.
+func (lock *uploadedLock) lockPackages() map[string]*lockPackage {
+	if len(lock.Packages) > 0 || len(lock.Dependencies) == 0 {
+		return lock.Packages
+	}
+	packages := map[string]*lockPackage{}
+	var add func(dir string, deps map[string]*lockDependency)
+	add = func(dir string, deps map[string]*lockDependency) {
+		for name, dep := range deps {
+			location := nodeModulesPath(dir, name)
+			entry := &lockPackage{Version: dep.Version, Dependencies: dep.Requires}
+			if aliased, version, ok := parseAlias(dep.Version); ok {
+				entry.Name, entry.Version = aliased, version
+			}
+			packages[location] = entry
+			add(location, dep.Dependencies)
+		}
+	}
+	add("", lock.Dependencies)
+	return packages
+}
+
+// installedFrom returns the location a package at dir finds name at, as
+// Node's module resolution would: in its own node_modules directory, or
+// else in that of the nearest ancestor having it.
+func installedFrom(packages map[string]*lockPackage, dir, name string) (string, bool) {
+	for ; ; dir = parentLocation(dir) {
+		location := nodeModulesPath(dir, name)
+		if _, ok := packages[location]; ok {
+			return location, true
+		}
+		if dir == "" {
+			return "", false
+		}
+	}
+}
+
+// lockedPackages returns the registry packages a lockfile installs, by
+// location, with the ranges their dependents declare for them. Links,
+// workspace members and packages from outside the registry are left out.
+func lockedPackages(packages map[string]*lockPackage) (map[string]*lockedPackage, map[string][]string) {
+	locked := map[string]*lockedPackage{}
+	for location, entry := range packages {
+		i := strings.LastIndex(location, "node_modules/")
+		if i < 0 {
+			continue
+		}
+		if _, err := semver.StrictNewVersion(entry.Version); err != nil {
+			continue
+		}
+		name := entry.Name
+		if name == "" {
+			name = location[i+len("node_modules/"):]
+		}
+		locked[location] = &lockedPackage{Location: location, Name: name, Version: entry.Version}
+	}
+
+	ranges := map[string][]string{}
+	for dir, entry := range packages {
+		requires := []map[string]string{entry.Dependencies, entry.OptionalDependencies}
+		// Only the root's devDependencies are installed.
+		if dir == "" {
+			requires = append(requires, entry.DevDependencies)
+		}
+		for _, deps := range requires {
+			for name, spec := range deps {
+				location, ok := installedFrom(packages, dir, name)
+				if !ok || locked[location] == nil {
+					continue
+				}
+				if _, constraint, ok := parseAlias(spec); ok {
+					spec = constraint
+				}
+				ranges[location] = append(ranges[location], spec)
+			}
+		}
+	}
+	return locked, ranges
+}
+
+// wantedVersion returns the highest published version satisfying every
+// range, or "" if there are none. Ranges other than semver ranges, such as
+// dist-tags, are ignored.
+func wantedVersion(ranges []string, pkgMeta *npmPackageMetaResponse) string {
+	var constraints []*semver.Constraints
+	for _, r := range ranges {
+		if constraint, err := semver.NewConstraint(r); err == nil {
+			constraints = append(constraints, constraint)
+		}
+	}
+	if len(constraints) == 0 {
+		return ""
+	}
+	var best *semver.Version
+	for version := range pkgMeta.Versions {
+		semVer, err := semver.NewVersion(version)
+		if err != nil || (best != nil && !semVer.GreaterThan(best)) {
+			continue
+		}
+		satisfied := true
+		for _, constraint := range constraints {
+			satisfied = satisfied && constraint.Check(semVer)
+		}
+		if satisfied {
+			best = semVer
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.Original()
+}
+
+// latestVersion returns the target of the package's latest dist-tag or,
+// on registries that keep no dist-tags, its highest release.
+func latestVersion(pkgMeta *npmPackageMetaResponse) string {
+	if target, ok := pkgMeta.DistTags["latest"]; ok {
+		version, _ := resolveDistTag("latest", target, pkgMeta)
+		return version
+	}
+	version, _ := maxCompatibleVersion("*", pkgMeta)
+	return version
+}
+
+// newer reports whether version a is newer than b.
+func newer(a, b string) bool {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	return errA == nil && errB == nil && va.GreaterThan(vb)
+}
+
+// analyzeLock reports on the packages a lockfile installs against their
+// metadata, keyed by name.
+func analyzeLock(locked map[string]*lockedPackage, ranges map[string][]string, metas map[string]*npmPackageMetaResponse) *lockAnalysis {
+	analysis := &lockAnalysis{
+		Packages:   len(locked),
+		Outdated:   []outdatedPackage{},
+		Updatable:  []outdatedPackage{},
+		Duplicates: []packageVersions{},
+		Deprecated: []deprecatedPackage{},
+	}
+	versions := map[string]map[string]bool{}
+	for _, location := range sortedKeys(locked) {
+		pkg := locked[location]
+		if versions[pkg.Name] == nil {
+			versions[pkg.Name] = map[string]bool{}
+		}
+		seen := versions[pkg.Name][pkg.Version]
+		versions[pkg.Name][pkg.Version] = true
+
+		pkgMeta, ok := metas[pkg.Name]
+		if !ok {
+			continue
+		}
+		report := outdatedPackage{lockedPackage: *pkg, Wanted: wantedVersion(ranges[location], pkgMeta), Latest: latestVersion(pkgMeta)}
+		if newer(report.Latest, pkg.Version) {
+			analysis.Outdated = append(analysis.Outdated, report)
+		}
+		if newer(report.Wanted, pkg.Version) {
+			analysis.Updatable = append(analysis.Updatable, report)
+		}
+		if message := pkgMeta.Versions[pkg.Version].Deprecated; message != "" && !seen {
+			analysis.Deprecated = append(analysis.Deprecated, deprecatedPackage{Name: pkg.Name, Version: pkg.Version, Message: string(message)})
+		}
+	}
+	for _, name := range sortedKeys(versions) {
+		if len(versions[name]) > 1 {
+			analysis.Duplicates = append(analysis.Duplicates, packageVersions{Name: name, Versions: sortedKeys(versions[name])})
+		}
+	}
+	sort.Slice(analysis.Deprecated, func(i, j int) bool {
+		a, b := analysis.Deprecated[i], analysis.Deprecated[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Version < b.Version
+	})
+	return analysis
+}
+
+// lockAnalysisHandler analyzes a posted package-lock.json: the packages
+// locked below their latest release or below what their dependents'
+// ranges allow, the packages installed in several versions and the
+// deprecated versions. Metadata comes through the cache, as it does for
+// resolution.
+func (s *server) lockAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxLockfileSize))
+	if bom, err := body.Peek(len(utf8BOM)); err == nil && string(bom) == utf8BOM {
+		body.Discard(len(utf8BOM))
+	}
+	var lock uploadedLock
+	if err := json.NewDecoder(body).Decode(&lock); err != nil {
+		s.badRequest(w, r, "Invalid package-lock.json: "+err.Error())
+		return
+	}
+	packages := lock.lockPackages()
+	if len(packages) == 0 {
+		s.badRequest(w, r, "Expected a package-lock.json with packages or dependencies")
+		return
+	}
+
+	locked, ranges := lockedPackages(packages)
+	names := map[string]bool{}
+	for _, pkg := range locked {
+		names[pkg.Name] = true
+	}
+	metas := make(map[string]*npmPackageMetaResponse, len(names))
+	failed := map[string]string{}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			pkgMeta, err := s.fetchPackageMeta(r.Context(), name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[name] = err.Error()
+				return
+			}
+			metas[name] = pkgMeta
+		}(name)
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		s.logger.Info("Client went away during lockfile analysis", "packages", len(locked))
+		return
+	}
+
+	analysis := analyzeLock(locked, ranges, metas)
+	analysis.Failed = failed
+	if s.writeJSON(w, http.StatusOK, analysis) {
+		s.logger.Info("Successfully handled request", "lockfile", lock.Name, "packages", len(locked), "outdated", len(analysis.Outdated))
+	}
+}
@@ -0,0 +1,144 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type lockAnalysis struct {
+	Packages int `json:"packages"`
+	Outdated []struct {
+		Location string `json:"location"`
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		Wanted   string `json:"wanted"`
+		Latest   string `json:"latest"`
+	} `json:"outdated"`
+	Updatable []struct {
+		Location string `json:"location"`
+		Wanted   string `json:"wanted"`
+	} `json:"updatable"`
+	Duplicates []struct {
+		Name     string   `json:"name"`
+		Versions []string `json:"versions"`
+	} `json:"duplicates"`
+	Deprecated []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Message string `json:"message"`
+	} `json:"deprecated"`
+	Failed map[string]string `json:"failed"`
+}
+
+func postLockfile(t *testing.T, server *httptest.Server, body string) *lockAnalysis {
+	t.Helper()
+	resp, err := server.Client().Post(server.URL+"/v1/lockfile/analysis", "application/json", strings.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var analysis lockAnalysis
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&analysis))
+	return &analysis
+}
+
+func lockAnalysisRegistry(t *testing.T) *httptest.Server {
+	registry := newMockRegistry(t, mockRegistry{
+		"lib":  {"1.0.0": {"deprecated": "upgrade to 1.2.0"}, "1.2.0": {}, "2.0.0": {}},
+		"web":  {"1.0.0": deps(map[string]string{"lib": "^2.0.0"})},
+		"util": {"2.0.0": {}, "2.1.0": {}},
+	})
+	return httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+}
+
+func TestLockfileAnalysis(t *testing.T) {
+	server := lockAnalysisRegistry(t)
+	defer server.Close()
+
+	analysis := postLockfile(t, server, `{
+		"name": "my-app",
+		"lockfileVersion": 3,
+		"packages": {
+			"": {"name": "my-app", "dependencies": {"lib": "^1.0.0", "web": "^1.0.0", "member": "*", "gone": "^1.0.0"}, "devDependencies": {"util": "~2.0.0"}},
+			"node_modules/lib": {"version": "1.0.0"},
+			"node_modules/web": {"version": "1.0.0", "dependencies": {"lib": "^2.0.0"}},
+			"node_modules/web/node_modules/lib": {"version": "2.0.0"},
+			"node_modules/util": {"version": "2.0.0", "dev": true},
+			"node_modules/gone": {"version": "1.0.0"},
+			"node_modules/member": {"resolved": "packages/member", "link": true},
+			"packages/member": {"name": "member", "version": "0.1.0"}
+		}
+	}`)
+
+	assert.Equal(t, 5, analysis.Packages)
+	require.Len(t, analysis.Outdated, 2)
+	assert.Equal(t, "node_modules/lib", analysis.Outdated[0].Location)
+	assert.Equal(t, "lib", analysis.Outdated[0].Name)
+	assert.Equal(t, "1.0.0", analysis.Outdated[0].Version)
+	assert.Equal(t, "1.2.0", analysis.Outdated[0].Wanted)
+	assert.Equal(t, "2.0.0", analysis.Outdated[0].Latest)
+	assert.Equal(t, "node_modules/util", analysis.Outdated[1].Location)
+	assert.Equal(t, "2.0.0", analysis.Outdated[1].Wanted)
+	assert.Equal(t, "2.1.0", analysis.Outdated[1].Latest)
+
+	require.Len(t, analysis.Updatable, 1)
+	assert.Equal(t, "node_modules/lib", analysis.Updatable[0].Location)
+	assert.Equal(t, "1.2.0", analysis.Updatable[0].Wanted)
+
+	require.Len(t, analysis.Duplicates, 1)
+	assert.Equal(t, "lib", analysis.Duplicates[0].Name)
+	assert.Equal(t, []string{"1.0.0", "2.0.0"}, analysis.Duplicates[0].Versions)
+
+	require.Len(t, analysis.Deprecated, 1)
+	assert.Equal(t, "lib", analysis.Deprecated[0].Name)
+	assert.Equal(t, "1.0.0", analysis.Deprecated[0].Version)
+	assert.Equal(t, "upgrade to 1.2.0", analysis.Deprecated[0].Message)
+
+	assert.Equal(t, []string{"gone"}, keys(analysis.Failed))
+}
+
+func TestLockfileAnalysisVersion1(t *testing.T) {
+	server := lockAnalysisRegistry(t)
+	defer server.Close()
+
+	analysis := postLockfile(t, server, `{
+		"name": "my-app",
+		"lockfileVersion": 1,
+		"dependencies": {
+			"lib": {"version": "1.0.0"},
+			"web": {"version": "1.0.0", "requires": {"lib": "^2.0.0"}, "dependencies": {"lib": {"version": "2.0.0"}}},
+			"old-util": {"version": "npm:util@2.0.0"}
+		}
+	}`)
+
+	assert.Equal(t, 4, analysis.Packages)
+	// Without package.json, the root's ranges are unknown.
+	assert.Empty(t, analysis.Updatable)
+	require.Len(t, analysis.Outdated, 2)
+	assert.Equal(t, "node_modules/lib", analysis.Outdated[0].Location)
+	assert.Empty(t, analysis.Outdated[0].Wanted)
+	assert.Equal(t, "node_modules/old-util", analysis.Outdated[1].Location)
+	assert.Equal(t, "util", analysis.Outdated[1].Name)
+	assert.Equal(t, []string{"lib"}, []string{analysis.Duplicates[0].Name})
+	assert.Len(t, analysis.Deprecated, 1)
+	assert.Empty(t, analysis.Failed)
+}
+
+func TestLockfileAnalysisInvalid(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	for _, body := range []string{`not json`, `{"lockfileVersion": 3}`} {
+		resp, err := server.Client().Post(server.URL+"/v1/lockfile/analysis", "application/json", strings.NewReader(body))
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// packageLock is an npm lockfile, version 3, as npm 7 and later write it.
+type packageLock struct {
+	Name            string                  `json:"name"`
+	Version         string                  `json:"version"`
+	LockfileVersion int                     `json:"lockfileVersion"`
+	Requires        bool                    `json:"requires"`
+	Packages        map[string]*lockPackage `json:"packages"`
+}
+
+// lockPackage is an entry of a lockfile's packages section, keyed by its
+// location: "" for the root and "node_modules/name", possibly nested,
+// for the rest.
+type lockPackage struct {
+	// Name is only set for the root and for packages installed under an
+	// alias.
+	Name                 string            `json:"name,omitempty"`
+	Version              string            `json:"version"`
+	Resolved             string            `json:"resolved,omitempty"`
+	Integrity            string            `json:"integrity,omitempty"`
+	Dev                  bool              `json:"dev,omitempty"`
+	Optional             bool              `json:"optional,omitempty"`
+	License              string            `json:"license,omitempty"`
+	Dependencies         map[string]string `json:"dependencies,omitempty"`
+	DevDependencies      map[string]string `json:"devDependencies,omitempty"`
+	OptionalDependencies map[string]string `json:"optionalDependencies,omitempty"`
+	PeerDependencies     map[string]string `json:"peerDependencies,omitempty"`
+}
+
+// lockable reports whether pkg was resolved to a version that can be
+// installed.
+func lockable(pkg *NpmPackageVersion) bool {
+	return pkg.Version != "" && !pkg.Excluded && !pkg.Truncated && pkg.Unresolved == "" && pkg.Skipped == ""
+}
+
+// newPackageLock lays the tree out as npm would install it, hoisting each
+// package to the highest node_modules directory where it doesn't clash
+// with another version of the same name. Packages are placed breadth
+// first, so the shallowest dependents get their versions hoisted.
+func newPackageLock(root *NpmPackageVersion) *packageLock {
+	lock := &packageLock{Name: root.Name, Version: root.Version, LockfileVersion: 3, Requires: true, Packages: map[string]*lockPackage{}}
+	rootEntry := &lockPackage{Name: root.Name, Version: root.Version, License: root.License, PeerDependencies: peerRanges(root)}
+	for key, dep := range root.Dependencies {
+		switch {
+		case dep.Dev:
+			rootEntry.DevDependencies = addRange(rootEntry.DevDependencies, key, dep.Spec)
+		case dep.Optional:
+			rootEntry.OptionalDependencies = addRange(rootEntry.OptionalDependencies, key, dep.Spec)
+		default:
+			rootEntry.Dependencies = addRange(rootEntry.Dependencies, key, dep.Spec)
+		}
+	}
+	lock.Packages[""] = rootEntry
+
+	type placement struct {
+		key    string
+		pkg    *NpmPackageVersion
+		parent string
+	}
+	var queue []placement
+	enqueue := func(pkg *NpmPackageVersion, location string) {
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			queue = append(queue, placement{key: key, pkg: pkg.Dependencies[key], parent: location})
+		}
+	}
+	enqueue(root, "")
+	placed := map[string]string{}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if !lockable(p.pkg) {
+			continue
+		}
+		identity := p.pkg.Name + "@" + p.pkg.Version
+		location := nodeModulesPath(p.parent, p.key)
+		reused := false
+		// Climb while no other version of the package is in the way.
+		for dir := p.parent; ; dir = parentLocation(dir) {
+			candidate := nodeModulesPath(dir, p.key)
+			if existing, ok := placed[candidate]; ok {
+				reused = existing == identity
+				if reused {
+					location = candidate
+				}
+				break
+			}
+			location = candidate
+			if dir == "" {
+				break
+			}
+		}
+		if reused {
+			// A package is only dev or optional if nothing else needs it.
+			entry := lock.Packages[location]
+			entry.Dev = entry.Dev && p.pkg.Dev
+			entry.Optional = entry.Optional && p.pkg.Optional
+			continue
+		}
+		placed[location] = identity
+		lock.Packages[location] = newLockPackage(p.key, p.pkg)
+		enqueue(p.pkg, location)
+	}
+	return lock
+}
+
+func newLockPackage(key string, pkg *NpmPackageVersion) *lockPackage {
+	entry := &lockPackage{
+		Version:          pkg.Version,
+		Dev:              pkg.Dev,
+		Optional:         pkg.Optional,
+		License:          pkg.License,
+		PeerDependencies: peerRanges(pkg),
+	}
+	if pkg.Name != key {
+		entry.Name = pkg.Name
+	}
+	if pkg.Dist != nil {
+		entry.Resolved = pkg.Dist.Tarball
+		entry.Integrity = distIntegrity(pkg.Dist)
+	}
+	for depKey, dep := range pkg.Dependencies {
+		if dep.Optional && !pkg.Optional {
+			entry.OptionalDependencies = addRange(entry.OptionalDependencies, depKey, dep.Spec)
+		} else {
+			entry.Dependencies = addRange(entry.Dependencies, depKey, dep.Spec)
+		}
+	}
+	return entry
+}
+
+func addRange(ranges map[string]string, name, spec string) map[string]string {
+	if ranges == nil {
+		ranges = map[string]string{}
+	}
+	ranges[name] = spec
+	return ranges
+}
+
+func peerRanges(pkg *NpmPackageVersion) map[string]string {
+	var ranges map[string]string
+	for name, peer := range pkg.PeerDependencies {
+		ranges = addRange(ranges, name, peer.Constraint)
+	}
+	return ranges
+}
+
+// distIntegrity returns the Subresource Integrity string of a tarball,
+// deriving a sha1 one from the shasum of packages published before npm
+// recorded integrity.
+func distIntegrity(dist *PackageDist) string {
+	if dist.Integrity != "" || dist.Shasum == "" {
+		return dist.Integrity
+	}
+	sum, err := hex.DecodeString(dist.Shasum)
+	if err != nil {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// nodeModulesPath returns the location of the package installed as name
+// in the node_modules directory of the package at location.
+func nodeModulesPath(location, name string) string {
+	if location == "" {
+		return "node_modules/" + name
+	}
+	return location + "/node_modules/" + name
+}
+
+// parentLocation returns the location of the package whose node_modules
+// directory holds the package at location.
+func parentLocation(location string) string {
+	i := strings.LastIndex(location, "/node_modules/")
+	if i < 0 {
+		return ""
+	}
+	return location[:i]
+}
+
+// packageLockHandler answers ?format=package-lock with the resolved tree
+// as an npm package-lock.json.
+func (s *server) packageLockHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	// A lockfile cut short by the deadline would install an incomplete
+	// tree, so say so as a partial response does.
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, newPackageLock(rootPkg)) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "package-lock")
+	}
+}
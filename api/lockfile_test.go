@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type lockPackage struct {
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Resolved             string            `json:"resolved"`
+	Integrity            string            `json:"integrity"`
+	Dev                  bool              `json:"dev"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+}
+
+type packageLock struct {
+	Name            string                  `json:"name"`
+	Version         string                  `json:"version"`
+	LockfileVersion int                     `json:"lockfileVersion"`
+	Requires        bool                    `json:"requires"`
+	Packages        map[string]*lockPackage `json:"packages"`
+}
+
+func dist(name, version string) map[string]string {
+	return map[string]string{
+		"tarball":   "https://registry.test/" + name + "/-/" + name + "-" + version + ".tgz",
+		"integrity": "sha512-" + name + version,
+	}
+}
+
+func TestPackageLockFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{
+			"dependencies":    map[string]string{"a": "^1.0.0", "b": "^1.0.0", "legacy": "npm:shared@^1.0.0"},
+			"devDependencies": map[string]string{"jest": "^29.0.0"},
+		}},
+		"a":    {"1.0.0": manifest{"dependencies": map[string]string{"shared": "^1.0.0"}, "dist": dist("a", "1.0.0")}},
+		"b":    {"1.0.0": manifest{"dependencies": map[string]string{"shared": "^2.0.0"}, "dist": dist("b", "1.0.0")}},
+		"jest": {"29.0.0": manifest{"dependencies": map[string]string{"shared": "^1.0.0", "dev-only": "^1.0.0"}, "dist": dist("jest", "29.0.0")}},
+		"shared": {
+			"1.0.0": manifest{"dist": dist("shared", "1.0.0")},
+			// Published before npm recorded integrity.
+			"2.0.0": manifest{"dist": map[string]string{"tarball": "https://registry.test/shared/-/shared-2.0.0.tgz", "shasum": "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"}},
+		},
+		"dev-only": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=package-lock&dev=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var lock packageLock
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&lock))
+
+	assert.Equal(t, "app", lock.Name)
+	assert.Equal(t, 3, lock.LockfileVersion)
+	assert.True(t, lock.Requires)
+	assert.ElementsMatch(t, []string{
+		"",
+		"node_modules/a",
+		"node_modules/b",
+		"node_modules/b/node_modules/shared",
+		"node_modules/jest",
+		"node_modules/legacy",
+		"node_modules/shared",
+		"node_modules/dev-only",
+	}, keys(lock.Packages))
+
+	root := lock.Packages[""]
+	assert.Equal(t, "app", root.Name)
+	assert.Equal(t, map[string]string{"a": "^1.0.0", "b": "^1.0.0", "legacy": "npm:shared@^1.0.0"}, root.Dependencies)
+	assert.Equal(t, map[string]string{"jest": "^29.0.0"}, root.DevDependencies)
+
+	a := lock.Packages["node_modules/a"]
+	assert.Equal(t, "1.0.0", a.Version)
+	assert.Equal(t, "https://registry.test/a/-/a-1.0.0.tgz", a.Resolved)
+	assert.Equal(t, "sha512-a1.0.0", a.Integrity)
+	assert.Equal(t, map[string]string{"shared": "^1.0.0"}, a.Dependencies)
+	assert.Empty(t, a.Name)
+
+	// shared@1 is hoisted; b's shared@2 clashes with it and stays nested.
+	assert.Equal(t, "1.0.0", lock.Packages["node_modules/shared"].Version)
+	assert.False(t, lock.Packages["node_modules/shared"].Dev, "needed by a as well as jest")
+	nested := lock.Packages["node_modules/b/node_modules/shared"]
+	assert.Equal(t, "2.0.0", nested.Version)
+	assert.Equal(t, "sha1-C+7Hteo/D9vJXQ3UfzxbwnXaijM=", nested.Integrity)
+
+	assert.True(t, lock.Packages["node_modules/jest"].Dev)
+	assert.True(t, lock.Packages["node_modules/dev-only"].Dev)
+	assert.Equal(t, "shared", lock.Packages["node_modules/legacy"].Name)
+	assert.Equal(t, "1.0.0", lock.Packages["node_modules/legacy"].Version)
+}
+
+func keys[V any](m map[string]V) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
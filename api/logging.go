@@ -0,0 +1,49 @@
+package api
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+type logSampling struct {
+	threshold int
+	every     int
+}
+
+func (ls logSampling) enabled() bool {
+	return ls.threshold > 0 && ls.every > 1
+}
+
+// depLogger emits per-dependency log lines for a single resolution,
+// sampling them once the tree grows past the configured threshold, and
+// counts the dependencies resolved, whether or not their lines are
+// sampled out.
+type depLogger struct {
+	logger   *slog.Logger
+	sampling logSampling
+	lines    atomic.Int64
+	resolved atomic.Int64
+}
+
+func newDepLogger(logger *slog.Logger, sampling logSampling) *depLogger {
+	return &depLogger{logger: logger, sampling: sampling}
+}
+
+func (l *depLogger) dependency(msg string, args ...any) {
+	n := int(l.lines.Add(1))
+	if l.sampling.enabled() && n > l.sampling.threshold && (n-l.sampling.threshold)%l.sampling.every != 0 {
+		return
+	}
+	l.logger.Debug(msg, args...)
+}
+
+// resolvedDependency counts a resolved dependency and logs it.
+func (l *depLogger) resolvedDependency(msg string, args ...any) {
+	l.resolved.Add(1)
+	l.dependency(msg, args...)
+}
+
+// count returns the number of dependencies resolved so far.
+func (l *depLogger) count() int {
+	return int(l.resolved.Load())
+}
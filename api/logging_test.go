@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestLogSampling(t *testing.T) {
+	const width, threshold, every = 500, 20, 10
+
+	rootDeps := map[string]string{}
+	pkgs := mockRegistry{}
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("dep-%d", i)
+		rootDeps[name] = "^1.0.0"
+		pkgs[name] = map[string]manifest{"1.0.0": {}}
+	}
+	pkgs["big"] = map[string]manifest{"1.0.0": deps(rootDeps)}
+	registry := newMockRegistry(t, pkgs)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithLogger(logger),
+		api.WithLogSampling(threshold, every),
+	))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/big/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	perDependency, summaries := 0, 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line struct {
+			Msg      string `json:"msg"`
+			Resolved int    `json:"resolved"`
+		}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		switch line.Msg {
+		case "Resolved dependency":
+			perDependency++
+		case "Successfully handled request":
+			summaries++
+			// The summary counts every dependency, sampled out or not.
+			assert.Equal(t, width, line.Resolved)
+		}
+	}
+
+	assert.Equal(t, threshold+(width-threshold)/every, perDependency)
+	assert.Equal(t, 1, summaries)
+}
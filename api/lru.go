@@ -0,0 +1,147 @@
+package api
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lruCache holds at most capacity entries, each until it expires, evicting
+// the least recently used entry to make room. A capacity of zero or less
+// leaves the cache unbounded.
+type lruCache[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	// order holds *lruEntry values, most recently used first.
+	order  *list.List
+	hits   uint64
+	misses uint64
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{capacity: capacity, items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	if time.Now().After(entry.expires) {
+		c.misses++
+		return zero, false
+	}
+	c.hits++
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// peek returns the value of key even if it has expired, without marking
+// it as used. Expired entries are kept until evicted, so that they can be
+// revalidated.
+func (c *lruCache[V]) peek(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lruCache[V]) set(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry[V]{key: key, value: value, expires: time.Now().Add(ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	if c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// delete evicts key and reports whether it was cached.
+func (c *lruCache[V]) delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+	return ok
+}
+
+// deletePrefix evicts every key starting with prefix and returns how many
+// were evicted.
+func (c *lruCache[V]) deletePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+// clear evicts every entry and returns how many there were.
+func (c *lruCache[V]) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.order.Len()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+	return n
+}
+
+// stats reports the cache's use, sizing each value with sizeOf.
+func (c *lruCache[V]) stats(sizeOf func(V) int) cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := newCacheStats(c.order.Len(), c.hits, c.misses)
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		stats.Bytes += int64(sizeOf(el.Value.(*lruEntry[V]).value))
+	}
+	return stats
+}
+
+// expires returns when key expires, for tests.
+func (c *lruCache[V]) expires(key string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return el.Value.(*lruEntry[V]).expires, true
+}
+
+func (c *lruCache[V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[int](2)
+	c.set("a", 1, time.Minute)
+	c.set("b", 2, time.Minute)
+	_, _ = c.get("a")
+	c.set("c", 3, time.Minute)
+
+	_, ok := c.get("b")
+	assert.False(t, ok)
+	v, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 2, c.len())
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	c := newLRUCache[int](0)
+	c.set("a", 1, -time.Second)
+
+	_, ok := c.get("a")
+	assert.False(t, ok)
+	v, ok := c.peek("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+}
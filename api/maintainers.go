@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// person is a maintainer or author of a package.
+type person struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// personPattern matches the npm shorthand "Name <email> (url)", where
+// every part is optional.
+var personPattern = regexp.MustCompile(`^([^<(]*?)\s*(?:<([^>]*)>)?\s*(?:\(([^)]*)\))?$`)
+
+// identity is the key under which a person is counted: their email, which
+// is stable across packages, or failing that their name.
+func (p person) identity() string {
+	if p.Email != "" {
+		return strings.ToLower(p.Email)
+	}
+	return strings.ToLower(p.Name)
+}
+
+// people decodes a list of persons, or a single one, each given either as
+// an object or as a "Name <email>" string. Unrecognised entries are
+// skipped rather than failing the manifest.
+type people []person
+
+func (ps *people) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		*ps = nil
+		return nil
+	}
+	items, ok := raw.([]any)
+	if !ok {
+		items = []any{raw}
+	}
+	*ps = nil
+	for _, item := range items {
+		if p, ok := parsePerson(item); ok {
+			*ps = append(*ps, p)
+		}
+	}
+	return nil
+}
+
+func parsePerson(item any) (person, bool) {
+	var p person
+	switch v := item.(type) {
+	case string:
+		if m := personPattern.FindStringSubmatch(strings.TrimSpace(v)); m != nil {
+			p = person{Name: m[1], Email: m[2]}
+		}
+	case map[string]any:
+		p.Name, _ = v["name"].(string)
+		p.Email, _ = v["email"].(string)
+	}
+	p.Name, p.Email = strings.TrimSpace(p.Name), strings.TrimSpace(p.Email)
+	return p, p.identity() != ""
+}
+
+type maintainerSummary struct {
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Count    int      `json:"count"`
+	Packages []string `json:"packages"`
+}
+
+type maintainersResponse struct {
+	Name        string               `json:"name"`
+	Version     string               `json:"version"`
+	Packages    int                  `json:"packages"`
+	Maintainers []*maintainerSummary `json:"maintainers"`
+	Authors     []*maintainerSummary `json:"authors"`
+	// Unmaintained lists packages published without maintainer data.
+	Unmaintained []string `json:"unmaintained"`
+}
+
+// summarizeMaintainers counts, across the unique packages of the tree
+// including its root, the packages each distinct maintainer and author is
+// responsible for.
+func summarizeMaintainers(root *NpmPackageVersion) *maintainersResponse {
+	resp := &maintainersResponse{Name: root.Name, Version: root.Version, Unmaintained: []string{}}
+	maintainers := map[string]*maintainerSummary{}
+	authors := map[string]*maintainerSummary{}
+	count := func(summaries map[string]*maintainerSummary, p person, key string) {
+		s, ok := summaries[p.identity()]
+		if !ok {
+			s = &maintainerSummary{Name: p.Name, Email: p.Email}
+			summaries[p.identity()] = s
+		}
+		if s.Name == "" {
+			s.Name = p.Name
+		}
+		s.Count++
+		s.Packages = append(s.Packages, key)
+	}
+
+	seen := map[string]bool{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		key := pkg.Name + "@" + pkg.Version
+		if seen[key] || pkg.Excluded || pkg.Truncated || pkg.Unresolved != "" || pkg.Skipped != "" {
+			return
+		}
+		seen[key] = true
+		resp.Packages++
+
+		counted := map[string]bool{}
+		for _, p := range pkg.Maintainers {
+			if !counted[p.identity()] {
+				counted[p.identity()] = true
+				count(maintainers, p, key)
+			}
+		}
+		if len(counted) == 0 {
+			resp.Unmaintained = append(resp.Unmaintained, key)
+		}
+		if pkg.Author != nil {
+			count(authors, *pkg.Author, key)
+		}
+		for _, name := range sortedKeys(pkg.Dependencies) {
+			walk(pkg.Dependencies[name])
+		}
+	}
+	walk(root)
+
+	resp.Maintainers = sortedSummaries(maintainers)
+	resp.Authors = sortedSummaries(authors)
+	sort.Strings(resp.Unmaintained)
+	return resp
+}
+
+// sortedSummaries orders summaries by descending package count, then by
+// identity.
+func sortedSummaries(summaries map[string]*maintainerSummary) []*maintainerSummary {
+	list := make([]*maintainerSummary, 0, len(summaries))
+	for _, key := range sortedKeys(summaries) {
+		sort.Strings(summaries[key].Packages)
+		list = append(list, summaries[key])
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Count > list[j].Count })
+	return list
+}
+
+// maintainersHandler reports who maintains the packages of a resolved
+// tree.
+func (s *server) maintainersHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, _ := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, summarizeMaintainers(rootPkg))
+}
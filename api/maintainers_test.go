@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestMaintainersReport(t *testing.T) {
+	alice := map[string]any{"name": "alice", "email": "alice@example.test"}
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {
+			"dependencies": map[string]string{"web": "^1.0.0", "cli": "^1.0.0"},
+			"maintainers":  []any{alice},
+			"author":       "Alice Liddell <alice@example.test> (https://example.test)",
+		}},
+		"web": {"1.0.0": {
+			"dependencies": map[string]string{"util": "^1.0.0"},
+			"maintainers":  []any{alice, map[string]any{"name": "bob"}},
+		}},
+		"cli": {"1.0.0": {
+			"dependencies": map[string]string{"util": "^1.0.0"},
+			"maintainers":  []any{"Alice <ALICE@example.test>"},
+			"author":       map[string]any{"name": "carol"},
+		}},
+		"util": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0/maintainers")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	type summary struct {
+		Name     string   `json:"name"`
+		Email    string   `json:"email"`
+		Count    int      `json:"count"`
+		Packages []string `json:"packages"`
+	}
+	var body struct {
+		Packages     int       `json:"packages"`
+		Maintainers  []summary `json:"maintainers"`
+		Authors      []summary `json:"authors"`
+		Unmaintained []string  `json:"unmaintained"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, 4, body.Packages)
+	assert.Equal(t, []summary{
+		{Name: "alice", Email: "alice@example.test", Count: 3, Packages: []string{"app@1.0.0", "cli@1.0.0", "web@1.0.0"}},
+		{Name: "bob", Count: 1, Packages: []string{"web@1.0.0"}},
+	}, body.Maintainers)
+	assert.Equal(t, []summary{
+		{Name: "Alice Liddell", Email: "alice@example.test", Count: 1, Packages: []string{"app@1.0.0"}},
+		{Name: "carol", Count: 1, Packages: []string{"cli@1.0.0"}},
+	}, body.Authors)
+	assert.Equal(t, []string{"util@1.0.0"}, body.Unmaintained)
+}
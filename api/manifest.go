@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// packageManifest is the subset of a posted package.json used for
+// resolution.
+type packageManifest struct {
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	// Resolutions are yarn-style forced versions, keyed by package name or
+	// "**/name".
+	Resolutions map[string]string `json:"resolutions"`
+	// Members are the packages of the workspace, which "workspace:"
+	// dependencies resolve to instead of the registry.
+	Members []*packageManifest `json:"members"`
+}
+
+const workspaceProtocol = "workspace:"
+
+const (
+	// maxManifestSize bounds a posted package.json, and maxLockfileSize a
+	// posted package-lock.json.
+	maxManifestSize = 1 << 20
+	maxLockfileSize = 64 << 20
+	utf8BOM         = "\ufeff"
+)
+
+var errWorkspaceMember = errors.New("workspace dependency")
+
+// workspaceRange returns the version range of a "workspace:" specifier,
+// or "" for the bare "*", "^" and "~" forms, which accept any version of
+// the member.
+func workspaceRange(spec string) string {
+	switch rangeSpec := strings.TrimPrefix(spec, workspaceProtocol); rangeSpec {
+	case "*", "^", "~":
+		return ""
+	default:
+		return rangeSpec
+	}
+}
+
+// validateSpec is validateConstraint extended to "workspace:" and "npm:"
+// specifiers, and to those of packages outside the registry.
+func validateSpec(spec string) error {
+	if isNonRegistrySpec(spec) {
+		return nil
+	}
+	if _, versionConstraint, ok := parseAlias(spec); ok {
+		return validateConstraint(versionConstraint)
+	}
+	if !strings.HasPrefix(spec, workspaceProtocol) {
+		return validateConstraint(spec)
+	}
+	if rangeSpec := workspaceRange(spec); rangeSpec != "" {
+		return validateConstraint(rangeSpec)
+	}
+	return nil
+}
+
+// resolveWorkspace resolves pkg, required as "workspace:" spec, to the
+// workspace member of the same name.
+func (res *resolver) resolveWorkspace(ctx context.Context, pkg *NpmPackageVersion, spec string, depth int) error {
+	member, ok := res.opts.workspace[pkg.Name]
+	if !ok {
+		return fmt.Errorf("%w %s@%s is not among the posted workspace members", errWorkspaceMember, pkg.Name, spec)
+	}
+	if rangeSpec := workspaceRange(spec); rangeSpec != "" {
+		constraint, err := semver.NewConstraint(rangeSpec)
+		if err != nil {
+			return err
+		}
+		version, err := semver.NewVersion(member.Version)
+		if err != nil || !constraint.Check(version) {
+			return fmt.Errorf("%w %s@%s does not match workspace member version %q", errWorkspaceMember, pkg.Name, spec, member.Version)
+		}
+	}
+	pkg.Version = member.Version
+	if err := res.countUnique(pkg); err != nil {
+		return err
+	}
+	visit(ctx, pkg)
+	return res.resolveChildren(ctx, pkg, member.Dependencies, member.OptionalDependencies, depth)
+}
+
+// parseResolutions validates the resolutions of a manifest and returns
+// them keyed by package name. Resolutions scoped to a dependency path,
+// such as "a/b", are not supported.
+func parseResolutions(resolutions map[string]string) (map[string]string, error) {
+	forced := make(map[string]string, len(resolutions))
+	for key, version := range resolutions {
+		name := strings.TrimPrefix(key, "**/")
+		if strings.Count(name, "/") > strings.Count(name, "@") || name == "" {
+			return nil, fmt.Errorf("unsupported resolution %q: only package names and **/name are supported", key)
+		}
+		if err := validateConstraint(version); err != nil {
+			return nil, fmt.Errorf("resolution %q: %w", key, err)
+		}
+		forced[name] = version
+	}
+	return forced, nil
+}
+
+// resolveManifest resolves the dependencies of a package that is not
+// published, such as a project's own package.json.
+func (res *resolver) resolveManifest(ctx context.Context, manifest *packageManifest) (*NpmPackageVersion, error) {
+	for _, m := range append([]*packageManifest{manifest}, manifest.Members...) {
+		dependencies := mergeDependencies(m.Dependencies, m.OptionalDependencies)
+		if res.opts.dev && m == manifest {
+			dependencies = mergeDependencies(dependencies, m.DevDependencies)
+		}
+		for name, spec := range dependencies {
+			if err := validateSpec(spec); err != nil {
+				return nil, fmt.Errorf("dependency %s of %s: %w", name, m.Name, err)
+			}
+		}
+	}
+	ctx = withDownloadBudget(ctx, res.downloadBudget)
+	root := &NpmPackageVersion{Name: manifest.Name, Version: manifest.Version, Dependencies: map[string]*NpmPackageVersion{}}
+	if err := res.resolveChildren(ctx, root, manifest.Dependencies, manifest.OptionalDependencies, 0); err != nil {
+		return nil, err
+	}
+	if res.opts.dev {
+		if err := res.resolveDevDependencies(ctx, root, manifest.DevDependencies); err != nil {
+			return nil, err
+		}
+	}
+	checkPeers(root)
+	return root, nil
+}
+
+// manifestHandler resolves the dependencies of a posted package.json,
+// forcing the versions named in its resolutions field and resolving
+// "workspace:" dependencies to its members.
+func (s *server) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxManifestSize))
+	// Editors on Windows may save package.json with a byte order mark,
+	// which npm ignores.
+	if bom, err := body.Peek(len(utf8BOM)); err == nil && string(bom) == utf8BOM {
+		body.Discard(len(utf8BOM))
+	}
+	var manifest packageManifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		s.badRequest(w, r, "Invalid package.json: "+err.Error())
+		return
+	}
+	resolutions, err := parseResolutions(manifest.Resolutions)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+
+	mode, err := parsePolicyMode(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	node, err := parseNodeVersion(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	timeout, err := s.resolutionTimeout(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	ctx, cancel := withResolutionTimeout(r.Context(), timeout)
+	defer cancel()
+	opts, err := parseResolveOptions(r)
+	if err != nil {
+		s.badRequest(w, r, err.Error())
+		return
+	}
+	opts.resolutions = resolutions
+	opts.workspace = make(map[string]*packageManifest, len(manifest.Members))
+	for _, member := range manifest.Members {
+		opts.workspace[member.Name] = member
+	}
+	res := s.newResolver(opts)
+	ctx = res.traceFetches(ctx, r)
+	tree, err := res.resolveManifest(ctx, &manifest)
+	if err != nil {
+		err = res.timedOut(err, timeout)
+		s.logger.Error("resolution failed", "package", manifest.Name, "version", manifest.Version, "error", err)
+		s.writeResolveError(w, r, err)
+		return
+	}
+
+	if s.checkPolicy(w, r, mode, tree, res) || s.auditTree(w, r, tree, res) {
+		return
+	}
+	res.incompatible = incompatibleEngines(tree, node)
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, newTreeResponse(r.URL.Query(), tree, res)) {
+		s.logger.Info("Successfully handled request", "package", manifest.Name, "version", manifest.Version, "resolved", res.log.count())
+	}
+}
@@ -0,0 +1,149 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestManifestResolutions(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"web":  {"1.0.0": deps(map[string]string{"lib": "^1.0.0", "util": "^2.0.0"})},
+		"lib":  {"1.0.0": {}, "1.2.0": {}},
+		"util": {"2.0.0": {}, "2.5.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(`{
+		"name": "my-app",
+		"version": "0.1.0",
+		"dependencies": {"web": "^1.0.0", "lib": "^1.0.0"},
+		"resolutions": {"lib": "1.0.0", "**/util": "2.0.0"}
+	}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	assert.Equal(t, "my-app", tree.Name)
+	assert.Equal(t, "0.1.0", tree.Version)
+	assert.Equal(t, "1.0.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "1.0.0", tree.Dependencies["web"].Dependencies["lib"].Version)
+	assert.Equal(t, "2.0.0", tree.Dependencies["web"].Dependencies["util"].Version)
+}
+
+func TestManifestRejectsPathResolutions(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json",
+		strings.NewReader(`{"dependencies": {"web": "^1.0.0"}, "resolutions": {"web/lib": "1.0.0"}}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestManifestWorkspaceMembers(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"left-pad": {"1.3.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(`{
+		"name": "monorepo",
+		"dependencies": {"@acme/app": "workspace:*"},
+		"members": [
+			{"name": "@acme/app", "version": "2.0.0", "dependencies": {"@acme/lib": "workspace:^1.0.0", "left-pad": "^1.0.0"}},
+			{"name": "@acme/lib", "version": "1.4.0"}
+		]
+	}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	app := tree.Dependencies["@acme/app"]
+	require.NotNil(t, app)
+	assert.Equal(t, "2.0.0", app.Version)
+	assert.Equal(t, "1.4.0", app.Dependencies["@acme/lib"].Version)
+	assert.Equal(t, "1.3.0", app.Dependencies["left-pad"].Version)
+	assert.Equal(t, []string{"/left-pad", "/left-pad/1.3.0"}, registry.Requests())
+}
+
+func TestManifestWorkspaceErrors(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	for _, body := range []string{
+		`{"dependencies": {"lib": "workspace:*"}, "members": [{"name": "app", "version": "1.0.0"}]}`,
+		`{"dependencies": {"lib": "workspace:^2.0.0"}, "members": [{"name": "lib", "version": "1.0.0"}]}`,
+	} {
+		resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(body))
+		require.Nil(t, err)
+		msg, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Contains(t, string(msg), "workspace dependency lib@workspace:")
+	}
+}
+
+func TestManifestUpload(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"express": {"4.18.2": deps(map[string]string{"debug": "2.6.9"})},
+		"debug":   {"2.6.9": {}},
+		"jest":    {"29.7.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	// A package.json as it is on disk, saved with a byte order mark.
+	packageJSON := "\ufeff" + `{
+		"name": "my-app",
+		"private": true,
+		"scripts": {"test": "jest"},
+		"engines": {"node": ">=18"},
+		"dependencies": {"express": "^4.18.0"},
+		"devDependencies": {"jest": "^29.0.0"}
+	}`
+	for path, wantDev := range map[string]bool{"/v1/resolve": false, "/v1/resolve?dev=true": true} {
+		resp, err := server.Client().Post(server.URL+path, "application/json", strings.NewReader(packageJSON))
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Empty(t, resp.Header.Get("Deprecation"), path)
+
+		var tree api.NpmPackageVersion
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+		assert.Equal(t, "my-app", tree.Name, path)
+		assert.Equal(t, "2.6.9", tree.Dependencies["express"].Dependencies["debug"].Version, path)
+		if wantDev {
+			require.Contains(t, tree.Dependencies, "jest", path)
+			assert.True(t, tree.Dependencies["jest"].Dev, path)
+		} else {
+			assert.NotContains(t, tree.Dependencies, "jest", path)
+		}
+	}
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(packageJSON))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+
+	resp, err = server.Client().Post(server.URL+"/v1/resolve", "application/json", strings.NewReader(`{"dependencies": `+strings.Repeat(" ", 1<<20)+`{}}`))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mermaid renders the graph as a Mermaid flowchart, which GitHub and
+// GitLab draw in Markdown. Mermaid ids can't hold the characters of
+// package names, so nodes are numbered in key order and labelled.
+func (g *packageGraph) mermaid() string {
+	nodes := g.sortedNodes()
+	ids := make(map[*graphNode]string, len(nodes))
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, n := range nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[n], strings.ReplaceAll(n.key(), `"`, "#quot;"))
+	}
+	for _, n := range nodes {
+		for _, dep := range n.sortedDeps() {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[n], ids[dep])
+		}
+	}
+	fmt.Fprintf(&b, "  style %s stroke-width:3px\n", ids[g.root])
+	return b.String()
+}
+
+// mermaidHandler answers ?format=mermaid with the resolved tree as a
+// Mermaid flowchart.
+func (s *server) mermaidHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(newPackageGraph(rootPkg).mermaid())); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "mermaid")
+}
@@ -0,0 +1,42 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestMermaidFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":      {"1.0.0": deps(map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0"})},
+		"a":        {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"@scope/c": {"2.1.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"shared":   {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=mermaid")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, `flowchart LR
+  n0["@scope/c@2.1.0"]
+  n1["a@1.0.0"]
+  n2["app@1.0.0"]
+  n3["shared@1.2.0"]
+  n0 --> n3
+  n1 --> n3
+  n2 --> n0
+  n2 --> n1
+  style n2 stroke-width:3px
+`, string(body))
+}
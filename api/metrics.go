@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type routeKey struct {
+	route  string
+	method string
+}
+
+type routeStats struct {
+	codes    map[int]int
+	count    int
+	duration time.Duration
+}
+
+// requestMetrics counts requests and their latency by the route pattern
+// that matched them, rather than by raw path, so that every package shares
+// one series per endpoint.
+type requestMetrics struct {
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{routes: map[routeKey]*routeStats{}}
+}
+
+func (m *requestMetrics) observe(route, method string, code int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := routeKey{route: route, method: method}
+	stats, ok := m.routes[key]
+	if !ok {
+		stats = &routeStats{codes: map[int]int{}}
+		m.routes[key] = stats
+	}
+	stats.codes[code]++
+	stats.count++
+	stats.duration += elapsed
+}
+
+// instrument records every request served by mux under the path of the
+// pattern it matched, such as "/package/{package}/{version}".
+func (m *requestMetrics) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		route := pattern
+		if _, path, ok := strings.Cut(pattern, " "); ok {
+			route = path
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		m.observe(route, r.Method, rec.status, time.Since(start))
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// metricsHandler exposes the request metrics in the Prometheus text
+// format.
+func (m *requestMetrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	keys := make([]routeKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP http_requests_total Requests served, by route, method and status code.\n")
+	b.WriteString("# TYPE http_requests_total counter\n")
+	for _, key := range keys {
+		stats := m.routes[key]
+		codes := make([]int, 0, len(stats.codes))
+		for code := range stats.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&b, "http_requests_total{route=%s,method=%s,code=\"%d\"} %d\n",
+				labelValue(key.route), labelValue(key.method), code, stats.codes[code])
+		}
+	}
+	b.WriteString("# HELP http_request_duration_seconds Time spent serving requests, by route and method.\n")
+	b.WriteString("# TYPE http_request_duration_seconds summary\n")
+	for _, key := range keys {
+		stats := m.routes[key]
+		labels := fmt.Sprintf("{route=%s,method=%s}", labelValue(key.route), labelValue(key.method))
+		fmt.Fprintf(&b, "http_request_duration_seconds_sum%s %s\n", labels, strconv.FormatFloat(stats.duration.Seconds(), 'f', -1, 64))
+		fmt.Fprintf(&b, "http_request_duration_seconds_count%s %d\n", labels, stats.count)
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// labelValue quotes a Prometheus label value.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
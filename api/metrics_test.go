@@ -0,0 +1,45 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestMetricsByRoute(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	get := func(path string) {
+		resp, err := server.Client().Get(server.URL + path)
+		require.Nil(t, err)
+		resp.Body.Close()
+	}
+	get("/package/app/1.0.0")
+	get("/package/lib/1.0.0")
+	get("/package/app/1.0.0/install-order")
+	get("/package/missing/1.0.0")
+
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	metrics := string(body)
+	assert.Contains(t, metrics, `http_requests_total{route="/package/{package}/{version}",method="GET",code="200"} 2`)
+	assert.Contains(t, metrics, `http_requests_total{route="/package/{package}/{version}",method="GET",code="404"} 1`)
+	assert.Contains(t, metrics, `http_requests_total{route="/package/{package}/{version}/install-order",method="GET",code="200"} 1`)
+	assert.Contains(t, metrics, `http_request_duration_seconds_count{route="/package/{package}/{version}",method="GET"} 3`)
+	assert.Contains(t, metrics, `http_request_duration_seconds_count{route="/package/{package}/{version}/install-order",method="GET"} 1`)
+}
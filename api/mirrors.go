@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// getFromRegistry requests path from registry and, should it fail with a
+// server error or time out, from each of its mirrors in turn. The response
+// records which of them answered.
+func (s *server) getFromRegistry(ctx context.Context, registry, path, etag, accept string) (*registryResponse, error) {
+	endpoints := append([]string{registry}, s.mirrorsFor(registry)...)
+	var err error
+	for i, endpoint := range endpoints {
+		var resp *registryResponse
+		resp, err = s.getWithRetries(ctx, endpoint+"/"+path, etag, accept)
+		if err == nil {
+			resp.registry = endpoint
+			return resp, nil
+		}
+		if i == len(endpoints)-1 || !failsOver(ctx, err) {
+			break
+		}
+		s.logger.Warn("Registry failed, trying mirror", "registry", endpoint, "mirror", endpoints[i+1], "path", path, "error", err)
+	}
+	return nil, err
+}
+
+// mirrorsFor returns the mirrors of registry; mirrors configured without a
+// registry belong to the first default registry.
+func (s *server) mirrorsFor(registry string) []string {
+	if mirrors, ok := s.mirrors[registry]; ok {
+		return mirrors
+	}
+	if registry == s.registryURLs[0] {
+		return s.mirrors[""]
+	}
+	return nil
+}
+
+// errRegistryTimeout is the cause of a registry request cut short by the
+// server's own bound on it, rather than by the resolution it serves.
+var errRegistryTimeout = errors.New("registry request timed out")
+
+// getWithTimeout is get, bounded by the server's per-request registry
+// timeout if there is one.
+func (s *server) getWithTimeout(ctx context.Context, url, etag, accept string) (*registryResponse, error) {
+	if s.registryTimeout <= 0 {
+		return s.get(ctx, url, etag, accept)
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, s.registryTimeout, errRegistryTimeout)
+	defer cancel()
+	return s.get(ctx, url, etag, accept)
+}
+
+// failsOver reports whether a failed registry request should be retried
+// against a mirror: after a server error, a network failure or a timed-out
+// attempt, but not once the resolution itself is over or the registry has
+// answered for the document, as with a 404.
+func failsOver(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, errDownloadBudgetExceeded) {
+		return false
+	}
+	var regErr *registryError
+	if errors.As(err, &regErr) {
+		return regErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// withoutRegistry returns a copy of the tree without the registry each
+// package was fetched from, which clients see only on request.
+func withoutRegistry(pkg *NpmPackageVersion) *NpmPackageVersion {
+	stripped := *pkg
+	stripped.Registry = ""
+	stripped.Dependencies = make(map[string]*NpmPackageVersion, len(pkg.Dependencies))
+	for name, dep := range pkg.Dependencies {
+		stripped.Dependencies[name] = withoutRegistry(dep)
+	}
+	return &stripped
+}
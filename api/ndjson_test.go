@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type ndjsonLine struct {
+	ID       int             `json:"id"`
+	ParentID *int            `json:"parentId"`
+	Name     string          `json:"name"`
+	Version  string          `json:"version"`
+	Skipped  string          `json:"skipped"`
+	Error    json.RawMessage `json:"error"`
+}
+
+func getNDJSON(t *testing.T, server *httptest.Server, path string) []ndjsonLine {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	var lines []ndjsonLine
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line ndjsonLine
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Nil(t, scanner.Err())
+	return lines
+}
+
+func TestNDJSONFormatStreamsWhileResolving(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": manifest{"dependencies": map[string]string{"web": "^1.0.0"}, "optionalDependencies": map[string]string{"native": "^1.0.0"}}},
+		"web":    {"1.0.0": deps(map[string]string{"http": "^1.0.0"})},
+		"http":   {"1.0.0": {}},
+		"native": {"1.0.0": deps(map[string]string{"missing": "^1.0.0"})},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResultCacheTTL(time.Minute)))
+	defer server.Close()
+
+	for _, source := range []string{"resolved", "cached"} {
+		lines := getNDJSON(t, server, "/package/app/1.0.0?format=ndjson")
+		nodes := map[int]ndjsonLine{}
+		for _, line := range lines {
+			if line.ParentID != nil {
+				_, ok := nodes[*line.ParentID]
+				require.True(t, ok, "%s: parent of %s written after it", source, line.Name)
+			}
+			nodes[line.ID] = line
+		}
+		require.Len(t, nodes, 4, source)
+		assert.Equal(t, "app", nodes[0].Name, source)
+
+		var native ndjsonLine
+		for _, node := range nodes {
+			if node.Name == "native" {
+				native = node
+			}
+		}
+		// native was selected, then left out when its dependency failed.
+		assert.NotEmpty(t, native.Skipped, source)
+		assert.Empty(t, native.Version, source)
+	}
+}
+
+func TestNDJSONFormatReportsErrorsAfterFirstLine(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"web": "^1.0.0"})},
+		"web":  {"1.0.0": deps(map[string]string{"http": "^2.0.0"})},
+		"http": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	lines := getNDJSON(t, server, "/package/app/1.0.0?format=ndjson")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "app", lines[0].Name)
+	assert.Equal(t, "web", lines[1].Name)
+	var p struct {
+		Type   string `json:"type"`
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+	}
+	require.Nil(t, json.Unmarshal(lines[2].Error, &p))
+	assert.NotZero(t, p.Status)
+	assert.Contains(t, p.Detail, "no compatible versions")
+}
+
+func TestNDJSONFormatErrorsBeforeFirstLine(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/package/missing/1.0.0?format=ndjson")
+	assert.Equal(t, http.StatusNotFound, p.Status)
+}
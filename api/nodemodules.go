@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// installedPackage is a directory of a simulated node_modules layout.
+type installedPackage struct {
+	// Name is only set for packages installed under an alias.
+	Name        string                       `json:"name,omitempty"`
+	Version     string                       `json:"version"`
+	NodeModules map[string]*installedPackage `json:"node_modules,omitempty"`
+}
+
+// nestedPackage is a package that could not be hoisted to the top-level
+// node_modules directory, because the version at Conflict is in the way.
+type nestedPackage struct {
+	lockedPackage
+	Conflict *lockedPackage `json:"conflict,omitempty"`
+}
+
+// nodeModulesLayout is the node_modules directory npm would install a tree
+// into.
+type nodeModulesLayout struct {
+	Name        string                       `json:"name"`
+	Version     string                       `json:"version"`
+	NodeModules map[string]*installedPackage `json:"node_modules"`
+	Nested      []nestedPackage              `json:"nested"`
+}
+
+// newNodeModulesLayout lays the tree out as npm's hoisting would, as
+// newPackageLock does, and lists the packages left nested along with the
+// versions they clash with.
+func newNodeModulesLayout(root *NpmPackageVersion) *nodeModulesLayout {
+	packages := newPackageLock(root).Packages
+	layout := &nodeModulesLayout{Name: root.Name, Version: root.Version, NodeModules: map[string]*installedPackage{}, Nested: []nestedPackage{}}
+	dirs := map[string]*installedPackage{}
+	locations := sortedKeys(packages)
+	// Parents sort before the packages nested in them.
+	sort.SliceStable(locations, func(i, j int) bool {
+		return strings.Count(locations[i], "node_modules/") < strings.Count(locations[j], "node_modules/")
+	})
+	for _, location := range locations {
+		if location == "" {
+			continue
+		}
+		entry := packages[location]
+		parent, key := parentLocation(location), installedKey(location)
+		dir := &installedPackage{Name: entry.Name, Version: entry.Version}
+		dirs[location] = dir
+		if parent == "" {
+			layout.NodeModules[key] = dir
+			continue
+		}
+		parentDir := dirs[parent]
+		if parentDir.NodeModules == nil {
+			parentDir.NodeModules = map[string]*installedPackage{}
+		}
+		parentDir.NodeModules[key] = dir
+
+		nested := nestedPackage{lockedPackage: lockedPackage{Location: location, Name: installedName(key, entry), Version: entry.Version}}
+		if conflict, ok := installedFrom(packages, parentLocation(parent), key); ok {
+			nested.Conflict = &lockedPackage{Location: conflict, Name: installedName(key, packages[conflict]), Version: packages[conflict].Version}
+		}
+		layout.Nested = append(layout.Nested, nested)
+	}
+	sort.Slice(layout.Nested, func(i, j int) bool { return layout.Nested[i].Location < layout.Nested[j].Location })
+	return layout
+}
+
+// installedKey returns the directory name, such as "lodash" or
+// "@babel/core", of the package at location.
+func installedKey(location string) string {
+	return location[strings.LastIndex(location, "node_modules/")+len("node_modules/"):]
+}
+
+// installedName returns the name of the package installed as key.
+func installedName(key string, entry *lockPackage) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	return key
+}
+
+// nodeModulesHandler answers ?format=node_modules with the node_modules
+// layout npm would install the resolved tree into.
+func (s *server) nodeModulesHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, newNodeModulesLayout(rootPkg)) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "node_modules")
+	}
+}
@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type installedPackage struct {
+	Name        string                       `json:"name,omitempty"`
+	Version     string                       `json:"version"`
+	NodeModules map[string]*installedPackage `json:"node_modules,omitempty"`
+}
+
+type lockedPackage struct {
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+type nestedPackage struct {
+	lockedPackage
+	Conflict *lockedPackage `json:"conflict"`
+}
+
+func TestNodeModulesFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0", "lib": "^1.0.0", "legacy": "npm:lib@^2.0.0"})},
+		"a":      {"1.0.0": deps(map[string]string{"lib": "^2.0.0", "shared": "^1.0.0"})},
+		"b":      {"1.0.0": deps(map[string]string{"lib": "^2.0.0", "shared": "^1.0.0"})},
+		"lib":    {"1.0.0": {}, "2.0.0": {}},
+		"shared": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?format=node_modules")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var layout struct {
+		Name        string                       `json:"name"`
+		NodeModules map[string]*installedPackage `json:"node_modules"`
+		Nested      []nestedPackage              `json:"nested"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&layout))
+
+	assert.Equal(t, "app", layout.Name)
+	nested := map[string]*installedPackage{"lib": {Version: "2.0.0"}}
+	assert.Equal(t, map[string]*installedPackage{
+		"a":      {Version: "1.0.0", NodeModules: nested},
+		"b":      {Version: "1.0.0", NodeModules: nested},
+		"legacy": {Name: "lib", Version: "2.0.0"},
+		"lib":    {Version: "1.0.0"},
+		"shared": {Version: "1.0.0"},
+	}, layout.NodeModules)
+
+	conflict := &lockedPackage{Location: "node_modules/lib", Name: "lib", Version: "1.0.0"}
+	assert.Equal(t, []nestedPackage{
+		{lockedPackage: lockedPackage{Location: "node_modules/a/node_modules/lib", Name: "lib", Version: "2.0.0"}, Conflict: conflict},
+		{lockedPackage: lockedPackage{Location: "node_modules/b/node_modules/lib", Name: "lib", Version: "2.0.0"}, Conflict: conflict},
+	}, layout.Nested)
+}
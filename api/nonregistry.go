@@ -0,0 +1,37 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// nonRegistryProtocols prefix dependency specifiers that name a git
+// repository, a tarball URL or a local path rather than a version the
+// registry serves.
+var nonRegistryProtocols = []string{
+	"git:", "git+", "github:", "gitlab:", "bitbucket:", "gist:",
+	"http:", "https:", "file:", "link:", "portal:", "patch:", "exec:",
+}
+
+// nonRegistrySpecifier is the reason a dependency with a non-registry
+// specifier is left unresolved.
+const nonRegistrySpecifier = "non-registry specifier"
+
+// isNonRegistrySpec reports whether spec names a package outside the
+// registry, such as "git+https://host/repo.git", "file:../local" or the
+// GitHub shorthand "user/repo#v1.0.0". Such packages cannot be resolved,
+// and their dependencies are unknown.
+func isNonRegistrySpec(spec string) bool {
+	for _, protocol := range nonRegistryProtocols {
+		if strings.HasPrefix(spec, protocol) {
+			return true
+		}
+	}
+	// Paths and GitHub shorthands; no semver range contains a slash.
+	if strings.Contains(spec, "/") {
+		_, err := semver.NewConstraint(spec)
+		return err != nil
+	}
+	return false
+}
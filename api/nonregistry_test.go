@@ -0,0 +1,72 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestNonRegistrySpecifiers(t *testing.T) {
+	specs := map[string]string{
+		"from-git":       "git+https://github.com/user/repo.git#v1.0.0",
+		"from-ssh":       "git+ssh://git@github.com/user/repo.git",
+		"from-github":    "github:user/repo",
+		"from-shorthand": "user/repo#main",
+		"from-tarball":   "https://example.com/pkg-1.0.0.tgz",
+		"from-file":      "file:../local",
+		"from-path":      "../local",
+		"from-link":      "link:../linked",
+	}
+	dependencies := map[string]string{"lib": "^1.0.0"}
+	for name, spec := range specs {
+		dependencies[name] = spec
+	}
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(dependencies)},
+		"lib": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	// Unlike a timeout, a non-registry specifier leaves the tree complete.
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+
+	assert.Equal(t, "1.0.0", tree.Dependencies["lib"].Version)
+	for name := range specs {
+		require.Contains(t, tree.Dependencies, name)
+		assert.Equal(t, "non-registry specifier", tree.Dependencies[name].Unresolved, name)
+		assert.Empty(t, tree.Dependencies[name].Version, name)
+		assert.NotContains(t, registry.Requests(), "/"+name)
+	}
+
+	set := getInstallSet(t, registry, "/package/app/1.0.0?format=flat")
+	assert.Equal(t, map[string]string{"lib": "1.0.0"}, set.Packages)
+}
+
+func TestNonRegistrySpecifiersOfManifest(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"lib": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader(`{
+		"name": "my-app",
+		"dependencies": {"lib": "^1.0.0", "local": "file:./packages/local"}
+	}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	assert.Equal(t, "non-registry specifier", tree.Dependencies["local"].Unresolved)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// npmAdvisory is an advisory as the npm bulk advisory endpoint returns it.
+type npmAdvisory struct {
+	ID                 int    `json:"id"`
+	URL                string `json:"url"`
+	Title              string `json:"title"`
+	Severity           string `json:"severity"`
+	VulnerableVersions string `json:"vulnerable_versions"`
+}
+
+// npmAdvisories returns the advisories affecting each package version, by
+// name@version, asking the registry each package was fetched from with one
+// bulk request per registry.
+func (s *server) npmAdvisories(ctx context.Context, packages []*NpmPackageVersion) (map[string][]vulnerability, error) {
+	// Each registry is sent the versions of its packages, by name. Packages
+	// not recording where they came from go to the first registry for
+	// their name.
+	byRegistry := map[string]map[string][]string{}
+	for _, pkg := range packages {
+		registry := pkg.Registry
+		if registry == "" {
+			registry = s.registriesFor(pkg.Name)[0]
+		}
+		if byRegistry[registry] == nil {
+			byRegistry[registry] = map[string][]string{}
+		}
+		byRegistry[registry][pkg.Name] = append(byRegistry[registry][pkg.Name], pkg.Version)
+	}
+
+	vulnerabilities := map[string][]vulnerability{}
+	for _, registry := range sortedKeys(byRegistry) {
+		advisories, err := s.bulkAdvisories(ctx, registry, byRegistry[registry])
+		if err != nil {
+			return nil, err
+		}
+		for name, versions := range byRegistry[registry] {
+			for _, version := range versions {
+				for _, advisory := range advisories[name] {
+					if v, ok := s.newAdvisoryVulnerability(ctx, advisory, name, version); ok {
+						vulnerabilities[name+"@"+version] = append(vulnerabilities[name+"@"+version], v)
+					}
+				}
+			}
+		}
+	}
+	return vulnerabilities, nil
+}
+
+// bulkAdvisories posts package versions to a registry's bulk advisory
+// endpoint, through the same limits as the registry requests of
+// resolution, and returns the advisories it knows of by package name.
+// They may not affect every version sent.
+func (s *server) bulkAdvisories(ctx context.Context, registry string, versions map[string][]string) (map[string][]npmAdvisory, error) {
+	body, err := json.Marshal(versions)
+	if err != nil {
+		return nil, err
+	}
+	url := registry + "/-/npm/v1/security/advisories/bulk"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var advisories map[string][]npmAdvisory
+	err = s.registryRequest(req, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&advisories)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return advisories, nil
+}
+
+// newAdvisoryVulnerability describes an advisory as it affects a package
+// version, reporting whether it does. The advisory is identified by its
+// GitHub advisory ID, where its URL names one, and is fixed in the lowest
+// later release outside its vulnerable versions.
+func (s *server) newAdvisoryVulnerability(ctx context.Context, advisory npmAdvisory, name, version string) (vulnerability, bool) {
+	vulnerable, err := semver.NewConstraint(canonicalConstraint(advisory.VulnerableVersions))
+	if err != nil {
+		return vulnerability{}, false
+	}
+	installed, err := semver.NewVersion(version)
+	if err != nil || !vulnerable.Check(installed) {
+		return vulnerability{}, false
+	}
+	v := vulnerability{ID: strconv.Itoa(advisory.ID), Summary: advisory.Title, Severity: normalizeSeverity(advisory.Severity)}
+	if id := path.Base(advisory.URL); strings.HasPrefix(id, "GHSA-") {
+		v.ID = id
+	}
+	pkgMeta, err := s.fetchPackageMeta(ctx, name)
+	if err != nil {
+		return v, true
+	}
+	var fixedIn *semver.Version
+	for published := range pkgMeta.Versions {
+		candidate, err := semver.NewVersion(published)
+		if err != nil || candidate.Prerelease() != "" || !candidate.GreaterThan(installed) || vulnerable.Check(candidate) {
+			continue
+		}
+		if fixedIn == nil || candidate.LessThan(fixedIn) {
+			fixedIn = candidate
+		}
+	}
+	if fixedIn != nil {
+		v.FixedIn = fixedIn.String()
+	}
+	return v, true
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// openAPIDocument describes the routes as an OpenAPI 3 document, with
+// schemas derived from the types the handlers encode and decode. Every
+// failure is an RFC 7807 problem.
+func (s *server) openAPIDocument() map[string]any {
+	schemas := &schemaSet{defs: map[string]any{}}
+	problemSchema := schemas.of(reflect.TypeOf(problem{}))
+	paths := map[string]map[string]any{}
+	for _, rt := range s.routes(nil) {
+		op := map[string]any{"summary": rt.summary}
+		if rt.deprecated {
+			op["deprecated"] = true
+		}
+		var params []any
+		for _, name := range rt.pathParams() {
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range rt.query {
+			schema := map[string]any{"type": q.kind}
+			if len(q.values) > 0 {
+				schema["enum"] = q.values
+			}
+			params = append(params, map[string]any{"name": q.name, "in": "query", "description": q.description, "schema": schema})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(rt.request))}},
+			}
+		}
+		success := map[string]any{"description": "Success"}
+		switch {
+		case rt.response != nil:
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(rt.response))}}
+		case rt.mediaType != "":
+			success["content"] = map[string]any{rt.mediaType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		status := rt.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		op["responses"] = map[string]any{
+			strconv.Itoa(status): success,
+			"default": map[string]any{
+				"description": "Problem details",
+				"content":     map[string]any{"application/problem+json": map[string]any{"schema": problemSchema}},
+			},
+		}
+		// OpenAPI has no wildcards matching several segments.
+		path := strings.ReplaceAll(rt.path, "...}", "}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(rt.method)] = op
+	}
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "npm packages API", "version": "1.0.0"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas.defs},
+	}
+}
+
+// schemaSet builds JSON schemas for Go types as encoding/json encodes
+// them, defining each struct type once, by name, so recursive types such
+// as the tree can refer to themselves.
+type schemaSet struct {
+	defs map[string]any
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+)
+
+func (set *schemaSet) of(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types encoding themselves, and interfaces, may be anything.
+	if t == rawMessageType || t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": set.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": set.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return set.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := set.defs[name]; !ok {
+			set.defs[name] = nil // placeholder while the fields refer back
+			set.defs[name] = set.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object returns the schema of a struct: its JSON fields, with those of
+// embedded structs promoted unless an outer field has the same name.
+func (set *schemaSet) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		var embedded []reflect.Type
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					embedded = append(embedded, ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if _, ok := properties[name]; ok {
+				continue
+			}
+			properties[name] = set.of(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		for _, e := range embedded {
+			addFields(e)
+		}
+	}
+	addFields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaName names the schema of a named type as an exported Go name.
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// openAPIHandler serves the OpenAPI document.
+func (s *server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.openAPIDocument())
+}
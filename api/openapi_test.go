@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Paths   map[string]map[string]struct {
+		Deprecated bool `json:"deprecated"`
+		Parameters []struct {
+			Name   string `json:"name"`
+			In     string `json:"in"`
+			Schema struct {
+				Enum []string `json:"enum"`
+			} `json:"schema"`
+		} `json:"parameters"`
+		Responses map[string]json.RawMessage `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Required   []string                   `json:"required"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func getOpenAPI(t *testing.T, server *httptest.Server) *openAPIDocument {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + "/openapi.json")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc openAPIDocument
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&doc))
+	return &doc
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server := httptest.NewServer(api.New(api.WithAdminToken(adminToken)))
+	defer server.Close()
+
+	doc := getOpenAPI(t, server)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	for path, methods := range map[string][]string{
+		"/package/{package}/{version}":        {"get"},
+		"/package/{package}/{version}/events": {"get"},
+		"/compare":                            {"get"},
+		"/resolve":                            {"post"},
+		"/admin/cache":                        {"get", "delete"},
+		"/admin/cache/{package}":              {"delete"},
+		"/openapi.json":                       {"get"},
+	} {
+		for _, method := range methods {
+			op, ok := doc.Paths[path][method]
+			if assert.True(t, ok, "%s %s", method, path) {
+				assert.Contains(t, op.Responses, "default", "%s %s", method, path)
+			}
+		}
+	}
+	assert.NotContains(t, doc.Paths, "/graphql")
+
+	var format []string
+	var pathParams []string
+	for _, param := range doc.Paths["/package/{package}/{version}"]["get"].Parameters {
+		if param.In == "path" {
+			pathParams = append(pathParams, param.Name)
+		}
+		if param.Name == "format" {
+			format = param.Schema.Enum
+		}
+	}
+	assert.Equal(t, []string{"package", "version"}, pathParams)
+	assert.Contains(t, format, "cyclonedx")
+	assert.Contains(t, format, "ndjson")
+
+	problem := doc.Components.Schemas["Problem"]
+	assert.Contains(t, problem.Properties, "type")
+	assert.Contains(t, problem.Properties, "detail")
+	assert.Contains(t, problem.Required, "status")
+	assert.NotContains(t, problem.Required, "detail")
+
+	tree := doc.Components.Schemas["TreeResponse"]
+	assert.Contains(t, tree.Properties, "dependencies")
+	assert.Contains(t, tree.Properties, "unresolved")
+	assert.Contains(t, tree.Properties, "name")
+	assert.Contains(t, tree.Properties, "version")
+}
+
+func TestOpenAPIDocumentGraphQL(t *testing.T) {
+	server := httptest.NewServer(api.New(api.WithGraphQL(true)))
+	defer server.Close()
+
+	doc := getOpenAPI(t, server)
+	assert.Contains(t, doc.Paths["/graphql"], "get")
+	assert.Contains(t, doc.Paths["/graphql"], "post")
+	for path := range doc.Paths {
+		assert.True(t, strings.HasPrefix(path, "/"), path)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errPlatformMismatch reports a package version whose os or cpu fields
+// exclude the platform the tree is resolved for.
+var errPlatformMismatch = errors.New("unsupported platform")
+
+// checkPlatform fails for a package version that cannot be installed on
+// the requested os and cpu. Without a requested platform every version
+// is accepted.
+func (opts resolveOptions) checkPlatform(name, version string, doc *npmPackageResponse) error {
+	if !platformAllowed(doc.OS, opts.os) {
+		return fmt.Errorf("%w: %s@%s requires os %s, not %s", errPlatformMismatch, name, version, strings.Join(doc.OS, ","), opts.os)
+	}
+	if !platformAllowed(doc.CPU, opts.cpu) {
+		return fmt.Errorf("%w: %s@%s requires cpu %s, not %s", errPlatformMismatch, name, version, strings.Join(doc.CPU, ","), opts.cpu)
+	}
+	return nil
+}
+
+// platformAllowed reports whether value is allowed by an npm os or cpu
+// list, such as ["darwin", "linux"] or ["!win32"].
+func platformAllowed(list []string, value string) bool {
+	if value == "" || len(list) == 0 {
+		return true
+	}
+	allowed, restricted := false, false
+	for _, entry := range list {
+		if excluded, ok := strings.CutPrefix(entry, "!"); ok {
+			if excluded == value {
+				return false
+			}
+			continue
+		}
+		restricted = true
+		allowed = allowed || entry == value
+	}
+	return allowed || !restricted
+}
+
+// skippable reports whether err, from resolving an optional dependency,
+// only means that the dependency cannot be installed, so that the tree is
+// resolved without it rather than failing.
+func skippable(err error) bool {
+	return isNotFound(err) || errors.Is(err, errNoCompatibleVersion) || errors.Is(err, errPlatformMismatch)
+}
+
+// skipOptional leaves out dep, an optional dependency that failed with
+// err, recording why.
+func skipOptional(dep *NpmPackageVersion, err error) {
+	*dep = NpmPackageVersion{
+		Name:         dep.Name,
+		Alias:        dep.Alias,
+		Spec:         dep.Spec,
+		Optional:     true,
+		Skipped:      err.Error(),
+		Dependencies: map[string]*NpmPackageVersion{},
+		parent:       dep.parent,
+	}
+}
+
+func markOptional(pkg *NpmPackageVersion) {
+	pkg.Optional = true
+	for _, dep := range pkg.Dependencies {
+		markOptional(dep)
+	}
+}
@@ -0,0 +1,87 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestOptionalDependencies(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{
+			// npm lists optionalDependencies among the dependencies too.
+			"dependencies":         map[string]string{"lib": "^1.0.0", "fsevents": "^2.0.0", "gone": "^1.0.0"},
+			"optionalDependencies": map[string]string{"fsevents": "^2.0.0", "gone": "^1.0.0", "old": "^3.0.0", "native": "^1.0.0"},
+		}},
+		"lib":      {"1.0.0": {}},
+		"fsevents": {"2.3.0": manifest{"os": []string{"darwin"}, "dependencies": map[string]string{"bindings": "^1.0.0"}}},
+		"bindings": {"1.5.0": {}},
+		"old":      {"1.0.0": {}},
+		"native":   {"1.0.0": manifest{"dependencies": map[string]string{"missing": "^1.0.0"}}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.False(t, tree.Dependencies["lib"].Optional)
+	fsevents := tree.Dependencies["fsevents"]
+	assert.Equal(t, "2.3.0", fsevents.Version)
+	assert.True(t, fsevents.Optional)
+	assert.True(t, fsevents.Dependencies["bindings"].Optional)
+	assert.Empty(t, fsevents.Skipped)
+
+	gone := tree.Dependencies["gone"]
+	assert.True(t, gone.Optional)
+	assert.Empty(t, gone.Version)
+	assert.Contains(t, gone.Skipped, "404")
+	assert.Contains(t, tree.Dependencies["old"].Skipped, "no compatible versions found")
+	// A failure beneath an optional dependency leaves out the optional
+	// dependency.
+	assert.Contains(t, tree.Dependencies["native"].Skipped, "404")
+	assert.Empty(t, tree.Dependencies["native"].Dependencies)
+
+	tree = getTreeFrom(t, server, "/package/app/1.0.0?os=linux")
+	fsevents = tree.Dependencies["fsevents"]
+	assert.Empty(t, fsevents.Version)
+	assert.Equal(t, "unsupported platform: fsevents@2.3.0 requires os darwin, not linux", fsevents.Skipped)
+
+	tree = getTreeFrom(t, server, "/package/app/1.0.0?os=darwin")
+	assert.Equal(t, "2.3.0", tree.Dependencies["fsevents"].Version)
+}
+
+func TestRequiredDependencyOnUnsupportedPlatform(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"win": "^1.0.0"})},
+		"win": {"1.0.0": manifest{"os": []string{"!linux"}, "cpu": []string{"x64", "arm64"}}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/1.0.0?os=win32&cpu=arm64").Dependencies["win"].Version)
+
+	p := getProblem(t, server, "/package/app/1.0.0?os=linux")
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Contains(t, p.Detail, "win@1.0.0 requires os !linux, not linux")
+
+	p = getProblem(t, server, "/package/app/1.0.0?cpu=ia32")
+	assert.Contains(t, p.Detail, "win@1.0.0 requires cpu x64,arm64, not ia32")
+}
+
+func TestOptionalDependenciesInFlatFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{
+			"dependencies":         map[string]string{"lib": "^1.0.0"},
+			"optionalDependencies": map[string]string{"gone": "^1.0.0", "extra": "^1.0.0"},
+		}},
+		"lib":   {"1.0.0": {}},
+		"extra": {"1.1.0": {}},
+	})
+
+	set := getInstallSet(t, registry, "/package/app/1.0.0?format=flat")
+	require.NotNil(t, set)
+	assert.Equal(t, map[string]string{"lib": "1.0.0", "extra": "1.1.0"}, set.Packages)
+}
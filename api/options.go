@@ -0,0 +1,352 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures the handler returned by New.
+type Option func(*server)
+
+// WithRegistryURL points the resolver at a different npm registry.
+func WithRegistryURL(url string) Option {
+	return WithRegistryURLs(url)
+}
+
+// WithRegistryURLs configures an ordered chain of registries. A package
+// or version missing from one registry is looked up in the next; any other
+// failure is returned without falling through.
+func WithRegistryURLs(urls ...string) Option {
+	return func(s *server) {
+		if len(urls) == 0 {
+			return
+		}
+		s.registryURLs = nil
+		for _, url := range urls {
+			s.registryURLs = append(s.registryURLs, strings.TrimRight(url, "/"))
+		}
+	}
+}
+
+// WithRegistryMirrors configures mirrors of registry, tried in order when
+// it, or the mirror before, fails with a server error or times out. An
+// empty registry means the first default registry.
+func WithRegistryMirrors(registry string, mirrors ...string) Option {
+	return func(s *server) {
+		if s.mirrors == nil {
+			s.mirrors = map[string][]string{}
+		}
+		registry = strings.TrimRight(registry, "/")
+		for _, mirror := range mirrors {
+			s.mirrors[registry] = append(s.mirrors[registry], strings.TrimRight(mirror, "/"))
+		}
+	}
+}
+
+// WithRegistryTimeout bounds each request to a registry or mirror, so that
+// an unresponsive registry fails over to its mirrors in good time. Zero
+// leaves each fetch, with its retries and mirrors, bounded only by two
+// minutes.
+func WithRegistryTimeout(timeout time.Duration) Option {
+	return func(s *server) {
+		s.registryTimeout = timeout
+	}
+}
+
+// WithRetries retries registry requests that time out or fail with 429 or
+// a server error up to n times, backing off exponentially from backoff,
+// with jitter, between attempts. A zero backoff means 100ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(s *server) {
+		s.retries = n
+		s.retryBackoff = backoff
+	}
+}
+
+// WithRegistryToken sends token as a bearer token with every request to
+// registry, or to the default registries if registry is empty. The token
+// for the most specific matching registry wins.
+func WithRegistryToken(registry, token string) Option {
+	return func(s *server) {
+		if s.registryTokens == nil {
+			s.registryTokens = map[string]string{}
+		}
+		if registry != "" {
+			registry = nerfDart(registry)
+		}
+		s.registryTokens[registry] = token
+	}
+}
+
+// WithScopedRegistry routes packages of scope, such as "@acme", to the
+// registry at url instead of the default chain.
+func WithScopedRegistry(scope, url string) Option {
+	return func(s *server) {
+		if !strings.HasPrefix(scope, "@") {
+			scope = "@" + scope
+		}
+		if s.scopeRegistries == nil {
+			s.scopeRegistries = map[string]string{}
+		}
+		s.scopeRegistries[scope] = strings.TrimRight(url, "/")
+	}
+}
+
+// WithScopedFallback makes a scoped package missing from its scope's
+// registry be looked up in the default chain too.
+func WithScopedFallback(enabled bool) Option {
+	return func(s *server) {
+		s.scopeFallback = enabled
+	}
+}
+
+// WithHTTPClient sets the client used for registry requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *server) {
+		s.client = client
+	}
+}
+
+// WithProxy sends registry requests through the HTTP proxy at proxy rather
+// than any proxy named by the environment. It has no effect on a client
+// given with WithHTTPClient.
+func WithProxy(proxy *url.URL) Option {
+	return func(s *server) {
+		s.proxy = proxy
+	}
+}
+
+// WithLogger redirects the handler's logs to the given structured logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *server) {
+		s.logger = logger
+	}
+}
+
+// WithLogSampling limits per-dependency log lines on large resolutions.
+// Once a resolution has logged threshold dependencies, only one line in
+// every is emitted. Summaries and errors are always logged.
+func WithLogSampling(threshold, every int) Option {
+	return func(s *server) {
+		s.logSampling = logSampling{threshold: threshold, every: every}
+	}
+}
+
+// WithCacheTTL sets how long registry metadata is cached. A zero or
+// negative TTL disables caching.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *server) {
+		s.metaCache.ttl = ttl
+	}
+}
+
+// WithNotFoundCacheTTL sets how long packages the registry reports as not
+// found are remembered, answering repeated requests for them without
+// asking the registry again. A zero or negative TTL disables it.
+func WithNotFoundCacheTTL(ttl time.Duration) Option {
+	return func(s *server) {
+		s.metaCache.notFoundTTL = ttl
+	}
+}
+
+// WithResultCacheTTL caches resolved trees for ttl. Trees are not cached
+// by default.
+func WithResultCacheTTL(ttl time.Duration) Option {
+	return func(s *server) {
+		s.treeCache = newTreeCache(ttl)
+	}
+}
+
+// WithCacheSize bounds the metadata cache, and the cache of version
+// documents, to n entries each, evicting the least recently used. Zero
+// leaves them unbounded.
+func WithCacheSize(n int) Option {
+	return func(s *server) {
+		s.metaCache.entries = newLRUCache[*npmPackageMetaResponse](n)
+		s.metaCache.versions = newLRUCache[*npmPackageResponse](n)
+		s.metaCache.notFound = newLRUCache[error](n)
+	}
+}
+
+// WithRedisCache shares cached registry metadata, and resolved trees when
+// WithResultCacheTTL is set, with other replicas through the Redis server
+// at addr, authenticating with password unless it is empty and using
+// database db.
+func WithRedisCache(addr, password string, db int) Option {
+	return func(s *server) {
+		s.redis = newRedisCache(addr, password, db)
+	}
+}
+
+// WithDiskCache also keeps registry metadata in dir, so that it survives
+// restarts. Entries are kept for ttl after they are written, or for a day
+// if ttl is zero, and served while fresh, as the metadata cache would
+// serve them; beyond maxBytes, the least recently read are removed. Zero
+// maxBytes leaves the directory unbounded.
+func WithDiskCache(dir string, maxBytes int64, ttl time.Duration) Option {
+	return func(s *server) {
+		s.disk = newDiskCache(dir, maxBytes, ttl)
+	}
+}
+
+// WithCacheTTLBounds clamps cache lifetimes derived from the registry's
+// Cache-Control max-age to [min, max]. A zero bound is not enforced.
+func WithCacheTTLBounds(min, max time.Duration) Option {
+	return func(s *server) {
+		s.metaCache.minTTL = min
+		s.metaCache.maxTTL = max
+	}
+}
+
+// WithResolveConcurrency resolves the dependencies of each package in
+// parallel, with at most n goroutines doing so across all resolutions;
+// once they are all busy, dependencies are resolved by the goroutine of
+// their parent. The trees are the same as when resolving in order, though
+// the registry is asked in no particular order. Zero or less, the default,
+// resolves dependencies one after another. Streamed resolutions, whose
+// packages are sent in order, are never resolved in parallel.
+func WithResolveConcurrency(n int) Option {
+	return func(s *server) {
+		s.resolveSem = newSemaphore(n)
+	}
+}
+
+// WithConcurrency caps the number of registry requests in flight across
+// all resolutions and cache warming.
+func WithConcurrency(n int) Option {
+	return func(s *server) {
+		s.fetchSem = newSemaphore(n)
+	}
+}
+
+// WithRateLimit caps the number of registry requests started per second.
+func WithRateLimit(perSecond float64) Option {
+	return func(s *server) {
+		s.rateLimiter = newRateLimiter(perSecond)
+	}
+}
+
+// WithMaxRecursionDepth sets the hard ceiling on dependency chain length
+// that aborts a resolution to protect the process.
+func WithMaxRecursionDepth(n int) Option {
+	return func(s *server) {
+		s.maxRecursionDepth = n
+	}
+}
+
+// WithMaxUniquePackages aborts a resolution once it has resolved more than
+// n distinct name@version pairs, however they are spread across the tree.
+// Zero leaves the count unbounded.
+func WithMaxUniquePackages(n int) Option {
+	return func(s *server) {
+		s.maxUniquePackages = n
+	}
+}
+
+// WithCircuitBreaker makes requests to a registry host fail fast for
+// cooldown after threshold consecutive failures, after which a single
+// probe request decides whether the host has recovered.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(s *server) {
+		s.breakers = newCircuitBreakers(threshold, cooldown)
+	}
+}
+
+// WithAdminToken serves the cache administration endpoints, /cache/warm,
+// /cache/invalidate and /admin/cache, to requests sending token as a
+// bearer token. They are not served without one.
+func WithAdminToken(token string) Option {
+	return func(s *server) {
+		s.adminToken = token
+	}
+}
+
+// WithProfiling exposes the net/http/pprof handlers under /debug/pprof.
+func WithProfiling(enabled bool) Option {
+	return func(s *server) {
+		s.profiling = enabled
+	}
+}
+
+// WithDownloadBudget aborts a resolution once it has downloaded more than
+// bytes from the registry in total. Zero leaves downloads unbounded.
+func WithDownloadBudget(bytes int64) Option {
+	return func(s *server) {
+		s.downloadBudget = bytes
+	}
+}
+
+// WithFlattenThreshold returns the unique packages of a tree as a flat
+// list, rather than the nested tree, when the nested tree has more than n
+// nodes. Zero disables flattening.
+func WithFlattenThreshold(n int) Option {
+	return func(s *server) {
+		s.flattenThreshold = n
+	}
+}
+
+// WithGraphQL exposes the /graphql endpoint.
+func WithGraphQL(enabled bool) Option {
+	return func(s *server) {
+		s.graphql = enabled
+	}
+}
+
+// WithResolveTimeout bounds how long a single resolution may take.
+func WithResolveTimeout(timeout time.Duration) Option {
+	return func(s *server) {
+		s.resolveTimeout = timeout
+	}
+}
+
+// WithSortedVersionSelection selects versions by fully sorting every
+// compatible release instead of the default single scan for the highest.
+func WithSortedVersionSelection() Option {
+	return func(s *server) {
+		s.sortSelection = true
+	}
+}
+
+// WithSelectionStrategy sets how a version is selected among those
+// compatible with a constraint. The default is StrategyHighest.
+func WithSelectionStrategy(strategy SelectionStrategy) Option {
+	return func(s *server) {
+		s.strategy = strategy
+	}
+}
+
+// WithSelectionOverrides sets the selection strategy of individual
+// packages, by name, overriding the server's strategy for them.
+func WithSelectionOverrides(overrides map[string]SelectionStrategy) Option {
+	return func(s *server) {
+		s.strategyOverrides = overrides
+	}
+}
+
+// WithLicensePolicy sets the licenses dependencies may use, which requests
+// check their trees against with ?policy=enforce or ?policy=report.
+func WithLicensePolicy(policy LicensePolicy) Option {
+	return func(s *server) {
+		s.licensePolicy = &policy
+	}
+}
+
+// WithOSVURL points the vulnerability scans of ?audit=true at a different
+// OSV API, such as a mirror.
+func WithOSVURL(url string) Option {
+	return func(s *server) {
+		s.osvURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithVulnerabilitySource selects the database ?audit=true scans trees
+// against: OSV, by default, or the bulk advisory endpoint of the npm
+// registries.
+func WithVulnerabilitySource(source VulnerabilitySource) Option {
+	return func(s *server) {
+		s.vulnerabilitySource = source
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import "github.com/Masterminds/semver/v3"
+
+// PeerDependency is a peer requirement of a package: a package it expects
+// the packages around it, rather than itself, to provide.
+type PeerDependency struct {
+	Constraint string `json:"constraint"`
+	// Version is that of the package the requirement finds in the tree,
+	// if any.
+	Version string `json:"version,omitempty"`
+	// Satisfied reports whether Version meets Constraint.
+	Satisfied bool `json:"satisfied"`
+	// Optional peers may be left out, as peerDependenciesMeta allows.
+	Optional bool `json:"optional,omitempty"`
+}
+
+type peerDependencyMeta struct {
+	Optional bool `json:"optional"`
+}
+
+// peerDependencies returns the peer requirements of the document, not yet
+// checked against any tree.
+func (p *npmPackageResponse) peerDependencies() map[string]*PeerDependency {
+	if len(p.PeerDependencies) == 0 {
+		return nil
+	}
+	peers := make(map[string]*PeerDependency, len(p.PeerDependencies))
+	for name, constraint := range p.PeerDependencies {
+		peers[name] = &PeerDependency{Constraint: constraint, Optional: p.PeerDependenciesMeta[name].Optional}
+	}
+	return peers
+}
+
+// checkPeers reports, for the peer requirements of every package in the
+// tree, the version of the package that would meet them. As in a nested
+// node_modules, a package sees its ancestors and their dependencies, so
+// the nearest of those with the peer's name is the one found.
+func checkPeers(pkg *NpmPackageVersion) {
+	for name, peer := range pkg.PeerDependencies {
+		peer.Version = ""
+		if found := visiblePackage(pkg, name); found != nil {
+			peer.Version = found.Version
+		}
+		peer.Satisfied = satisfies(peer.Version, peer.Constraint)
+	}
+	for _, dep := range pkg.Dependencies {
+		checkPeers(dep)
+	}
+}
+
+// visiblePackage returns the package named name that pkg would load, or
+// nil if there is none.
+func visiblePackage(pkg *NpmPackageVersion, name string) *NpmPackageVersion {
+	for ancestor := pkg.parent; ancestor != nil; ancestor = ancestor.parent {
+		if ancestor.Name == name {
+			return ancestor
+		}
+		if dep, ok := ancestor.Dependencies[name]; ok && dep != pkg {
+			return dep
+		}
+	}
+	return nil
+}
+
+// satisfies reports whether version meets constraint, a semver range.
+func satisfies(version, constraint string) bool {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	v, err := semver.NewVersion(version)
+	return err == nil && c.Check(v)
+}
@@ -0,0 +1,39 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestPeerDependencies(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":   {"1.0.0": deps(map[string]string{"react": "^18.0.0", "ui": "^1.0.0", "legacy": "^1.0.0"})},
+		"react": {"18.2.0": {}},
+		"ui": {"1.0.0": manifest{
+			"dependencies":         map[string]string{"hooks": "^1.0.0"},
+			"peerDependencies":     map[string]string{"react": "^17.0.0 || ^18.0.0", "vue": "^3.0.0"},
+			"peerDependenciesMeta": map[string]any{"vue": map[string]bool{"optional": true}},
+		}},
+		// hooks sees react as a dependency of its grandparent.
+		"hooks":  {"1.0.0": manifest{"peerDependencies": map[string]string{"react": ">=16.8.0"}}},
+		"legacy": {"1.0.0": manifest{"peerDependencies": map[string]string{"react": "^16.0.0"}}},
+	})
+
+	tree := getTree(t, registry, "/package/app/1.0.0")
+
+	ui := tree.Dependencies["ui"]
+	require.Len(t, ui.PeerDependencies, 2)
+	assert.Equal(t, &api.PeerDependency{Constraint: "^17.0.0 || ^18.0.0", Version: "18.2.0", Satisfied: true}, ui.PeerDependencies["react"])
+	assert.Equal(t, &api.PeerDependency{Constraint: "^3.0.0", Optional: true}, ui.PeerDependencies["vue"])
+	// Peers are provided by the tree around the package, not resolved
+	// beneath it.
+	assert.NotContains(t, ui.Dependencies, "react")
+	assert.NotContains(t, registry.Requests(), "/vue")
+
+	assert.Equal(t, &api.PeerDependency{Constraint: ">=16.8.0", Version: "18.2.0", Satisfied: true}, ui.Dependencies["hooks"].PeerDependencies["react"])
+	assert.Equal(t, &api.PeerDependency{Constraint: "^16.0.0", Version: "18.2.0"}, tree.Dependencies["legacy"].PeerDependencies["react"])
+	assert.Empty(t, tree.Dependencies["react"].PeerDependencies)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pnpmLock serializes the tree as a pnpm-lock.yaml, lockfile version 9:
+// the root as the "." importer, every package version under packages, and
+// every package version with its dependencies, and the versions of its
+// peers, under snapshots.
+func pnpmLock(root *NpmPackageVersion) string {
+	var b strings.Builder
+	b.WriteString("lockfileVersion: '9.0'\n\n")
+	b.WriteString("settings:\n  autoInstallPeers: true\n  excludeLinksFromLockfile: false\n\n")
+
+	b.WriteString("importers:\n\n  .:\n")
+	sections := map[string]map[string]*NpmPackageVersion{}
+	for key, dep := range root.Dependencies {
+		if !lockable(dep) {
+			continue
+		}
+		section := "dependencies"
+		switch {
+		case dep.Dev:
+			section = "devDependencies"
+		case dep.Optional:
+			section = "optionalDependencies"
+		}
+		if sections[section] == nil {
+			sections[section] = map[string]*NpmPackageVersion{}
+		}
+		sections[section][key] = dep
+	}
+	for _, section := range []string{"dependencies", "devDependencies", "optionalDependencies"} {
+		if len(sections[section]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "    %s:\n", section)
+		for _, key := range sortedKeys(sections[section]) {
+			dep := sections[section][key]
+			fmt.Fprintf(&b, "      %s:\n", yamlScalar(key))
+			fmt.Fprintf(&b, "        specifier: %s\n", yamlScalar(dep.Spec))
+			fmt.Fprintf(&b, "        version: %s\n", yamlScalar(pnpmReference(key, dep)))
+		}
+	}
+
+	packages := map[string]*NpmPackageVersion{}
+	snapshots := map[string]*NpmPackageVersion{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		for _, dep := range pkg.Dependencies {
+			if !lockable(dep) {
+				continue
+			}
+			id := pnpmSnapshotID(dep)
+			if seen, ok := snapshots[id]; ok {
+				seen.Optional = seen.Optional && dep.Optional
+				continue
+			}
+			// Copied, as the tree may be cached and shared.
+			snapshot := *dep
+			snapshots[id] = &snapshot
+			packages[dep.Name+"@"+dep.Version] = dep
+			walk(dep)
+		}
+	}
+	walk(root)
+
+	b.WriteString("\npackages:\n")
+	for _, id := range sortedKeys(packages) {
+		pkg := packages[id]
+		fmt.Fprintf(&b, "\n  %s:\n", yamlScalar(id))
+		fmt.Fprintf(&b, "    resolution: {%s}\n", pnpmResolution(pkg.Dist))
+		if len(pkg.PeerDependencies) > 0 {
+			b.WriteString("    peerDependencies:\n")
+			for _, name := range sortedKeys(pkg.PeerDependencies) {
+				fmt.Fprintf(&b, "      %s: %s\n", yamlScalar(name), yamlScalar(pkg.PeerDependencies[name].Constraint))
+			}
+		}
+	}
+
+	b.WriteString("\nsnapshots:\n")
+	for _, id := range sortedKeys(snapshots) {
+		pkg := snapshots[id]
+		fmt.Fprintf(&b, "\n  %s:", yamlScalar(id))
+		var dependencies, optionalDependencies []string
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			dep := pkg.Dependencies[key]
+			if !lockable(dep) {
+				continue
+			}
+			line := fmt.Sprintf("      %s: %s\n", yamlScalar(key), yamlScalar(pnpmReference(key, dep)))
+			if dep.Optional && !pkg.Optional {
+				optionalDependencies = append(optionalDependencies, line)
+			} else {
+				dependencies = append(dependencies, line)
+			}
+		}
+		if len(dependencies) == 0 && len(optionalDependencies) == 0 && !pkg.Optional {
+			b.WriteString(" {}\n")
+			continue
+		}
+		b.WriteString("\n")
+		if len(dependencies) > 0 {
+			b.WriteString("    dependencies:\n" + strings.Join(dependencies, ""))
+		}
+		if len(optionalDependencies) > 0 {
+			b.WriteString("    optionalDependencies:\n" + strings.Join(optionalDependencies, ""))
+		}
+		if pkg.Optional {
+			b.WriteString("    optional: true\n")
+		}
+	}
+	return b.String()
+}
+
+// pnpmSnapshotID identifies a package version together with the versions
+// of the peers it was resolved with, as "name@1.0.0(peer@2.0.0)".
+func pnpmSnapshotID(pkg *NpmPackageVersion) string {
+	return pkg.Name + "@" + pkg.Version + pnpmPeerSuffix(pkg)
+}
+
+func pnpmPeerSuffix(pkg *NpmPackageVersion) string {
+	var suffix strings.Builder
+	for _, name := range sortedKeys(pkg.PeerDependencies) {
+		if peer := pkg.PeerDependencies[name]; peer.Version != "" {
+			fmt.Fprintf(&suffix, "(%s@%s)", name, peer.Version)
+		}
+	}
+	return suffix.String()
+}
+
+// pnpmReference is how a dependent refers to the snapshot of dep, which
+// it requires as key: by version, or by name and version for an alias.
+func pnpmReference(key string, dep *NpmPackageVersion) string {
+	if dep.Name != key {
+		return pnpmSnapshotID(dep)
+	}
+	return dep.Version + pnpmPeerSuffix(dep)
+}
+
+func pnpmResolution(dist *PackageDist) string {
+	if dist == nil {
+		return ""
+	}
+	if integrity := distIntegrity(dist); integrity != "" {
+		return "integrity: " + integrity
+	}
+	return "tarball: " + yamlScalar(dist.Tarball)
+}
+
+var yamlIndicator = regexp.MustCompile("^[-?:,\\[\\]{}#&*!|>'\"%@`]|: | #|^~$|^(true|false|null|yes|no|on|off)$")
+
+// yamlScalar quotes s, in single quotes, when YAML would otherwise read
+// it as something other than the plain string.
+func yamlScalar(s string) string {
+	if _, err := strconv.ParseFloat(s, 64); err != nil && s != "" && !yamlIndicator.MatchString(strings.ToLower(s)) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// pnpmLockHandler answers ?format=pnpm-lock with the resolved tree as a
+// pnpm-lock.yaml.
+func (s *server) pnpmLockHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(pnpmLock(rootPkg))); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "pnpm-lock")
+}
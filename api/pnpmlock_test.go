@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestPnpmLockFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0", "legacy": "npm:shared@1.2.0", "react": "^18.0.0"})},
+		"a": {"1.0.0": manifest{
+			"dependencies":         map[string]string{"shared": "^1.0.0"},
+			"optionalDependencies": map[string]string{"fsevents": "^2.0.0"},
+			"peerDependencies":     map[string]string{"react": ">=17"},
+			"dist":                 dist("a", "1.0.0"),
+		}},
+		"@scope/c": {"2.1.0": manifest{
+			"dist": map[string]string{"tarball": "https://registry.test/@scope/c/-/c-2.1.0.tgz", "shasum": "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"},
+		}},
+		"shared":   {"1.2.0": manifest{"dist": dist("shared", "1.2.0")}},
+		"react":    {"18.2.0": manifest{"dist": dist("react", "18.2.0")}},
+		"fsevents": {"2.3.0": manifest{"dist": dist("fsevents", "2.3.0")}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=pnpm-lock")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/yaml", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, `lockfileVersion: '9.0'
+
+settings:
+  autoInstallPeers: true
+  excludeLinksFromLockfile: false
+
+importers:
+
+  .:
+    dependencies:
+      '@scope/c':
+        specifier: ^2.0.0
+        version: 2.1.0
+      a:
+        specifier: ^1.0.0
+        version: 1.0.0(react@18.2.0)
+      legacy:
+        specifier: npm:shared@1.2.0
+        version: shared@1.2.0
+      react:
+        specifier: ^18.0.0
+        version: 18.2.0
+
+packages:
+
+  '@scope/c@2.1.0':
+    resolution: {integrity: sha1-C+7Hteo/D9vJXQ3UfzxbwnXaijM=}
+
+  a@1.0.0:
+    resolution: {integrity: sha512-a1.0.0}
+    peerDependencies:
+      react: '>=17'
+
+  fsevents@2.3.0:
+    resolution: {integrity: sha512-fsevents2.3.0}
+
+  react@18.2.0:
+    resolution: {integrity: sha512-react18.2.0}
+
+  shared@1.2.0:
+    resolution: {integrity: sha512-shared1.2.0}
+
+snapshots:
+
+  '@scope/c@2.1.0': {}
+
+  a@1.0.0(react@18.2.0):
+    dependencies:
+      shared: 1.2.0
+    optionalDependencies:
+      fsevents: 2.3.0
+
+  fsevents@2.3.0:
+    optional: true
+
+  react@18.2.0: {}
+
+  shared@1.2.0: {}
+`, string(body))
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// LicensePolicy lists the licenses a tree's dependencies may and may not
+// use, as SPDX identifiers or glob patterns such as "GPL-*". A license is
+// permitted unless it is denied or, when any are allowed, it is not
+// among them.
+type LicensePolicy struct {
+	Allowed []string `json:"allowed"`
+	Denied  []string `json:"denied"`
+}
+
+// Policy modes a request may ask for with ?policy=.
+const (
+	// policyEnforce fails a resolution whose tree violates the license
+	// policy with a 409.
+	policyEnforce = "enforce"
+	// policyReport lists the violations of the license policy alongside
+	// the tree.
+	policyReport = "report"
+)
+
+// licenseViolation is a package whose license the policy doesn't permit.
+type licenseViolation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	License string `json:"license"`
+}
+
+// parsePolicyMode returns the license policy mode a request asks for.
+func parsePolicyMode(r *http.Request) (string, error) {
+	switch mode := r.URL.Query().Get("policy"); mode {
+	case "", policyEnforce, policyReport:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid policy %q: expected enforce or report", mode)
+	}
+}
+
+// violations returns the dependencies of the tree whose license the
+// policy doesn't permit, by name and version. A dependency without a
+// license violates a policy allowing only some.
+func (p *LicensePolicy) violations(root *NpmPackageVersion) []licenseViolation {
+	violations := []licenseViolation{}
+	if p == nil {
+		return violations
+	}
+	packages, _ := bomPackages(root)
+	delete(packages, root.Name+"@"+root.Version)
+	for _, id := range sortedKeys(packages) {
+		pkg := packages[id]
+		if pkg.License == "" && len(p.Allowed) == 0 || pkg.License != "" && p.permits(pkg.License) {
+			continue
+		}
+		license := pkg.License
+		if license == "" {
+			license = unknownLicense
+		}
+		violations = append(violations, licenseViolation{Name: pkg.Name, Version: pkg.Version, License: license})
+	}
+	return violations
+}
+
+// permits reports whether the policy permits an SPDX expression: one of
+// the choices an OR offers must be permitted, and every license an AND
+// combines. An expression that doesn't parse is not permitted.
+func (p *LicensePolicy) permits(expression string) bool {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expression))
+	parser := &spdxParser{tokens: tokens, permitted: p.permitsLicense}
+	permitted, ok := parser.or()
+	return ok && parser.pos == len(tokens) && permitted
+}
+
+// permitsLicense reports whether the policy permits a single license
+// identifier.
+func (p *LicensePolicy) permitsLicense(id string) bool {
+	if matchesAny(id, p.Denied) {
+		return false
+	}
+	return len(p.Allowed) == 0 || matchesAny(id, p.Allowed)
+}
+
+// matchesAny reports whether a license identifier matches one of the glob
+// patterns, case-insensitively.
+func matchesAny(id string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(id)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// spdxParser evaluates an SPDX license expression, split into tokens,
+// against a predicate on license identifiers.
+type spdxParser struct {
+	tokens    []string
+	pos       int
+	permitted func(id string) bool
+}
+
+func (sp *spdxParser) peek(keyword string) bool {
+	return sp.pos < len(sp.tokens) && strings.EqualFold(sp.tokens[sp.pos], keyword)
+}
+
+// or evaluates "term OR term ...".
+func (sp *spdxParser) or() (bool, bool) {
+	permitted, ok := sp.and()
+	for ok && sp.peek("OR") {
+		sp.pos++
+		var next bool
+		next, ok = sp.and()
+		permitted = permitted || next
+	}
+	return permitted, ok
+}
+
+// and evaluates "license AND license ...".
+func (sp *spdxParser) and() (bool, bool) {
+	permitted, ok := sp.license()
+	for ok && sp.peek("AND") {
+		sp.pos++
+		var next bool
+		next, ok = sp.license()
+		permitted = permitted && next
+	}
+	return permitted, ok
+}
+
+// license evaluates an identifier, possibly WITH an exception, or a
+// parenthesized expression.
+func (sp *spdxParser) license() (bool, bool) {
+	if sp.pos == len(sp.tokens) {
+		return false, false
+	}
+	token := sp.tokens[sp.pos]
+	sp.pos++
+	if token == "(" {
+		permitted, ok := sp.or()
+		if !ok || !sp.peek(")") {
+			return false, false
+		}
+		sp.pos++
+		return permitted, true
+	}
+	if token == ")" || strings.EqualFold(token, "OR") || strings.EqualFold(token, "AND") || strings.EqualFold(token, "WITH") {
+		return false, false
+	}
+	if sp.peek("WITH") {
+		sp.pos += 2 // the exception doesn't change the license
+		if sp.pos > len(sp.tokens) {
+			return false, false
+		}
+	}
+	return sp.permitted(token), true
+}
+
+// checkPolicy checks the tree against the license policy in the mode the
+// request asked for: in enforce mode, it writes a 409 problem listing the
+// violations, if there are any, and reports that it did; in report mode,
+// it records them for the response.
+func (s *server) checkPolicy(w http.ResponseWriter, r *http.Request, mode string, root *NpmPackageVersion, res *resolver) bool {
+	if mode == "" {
+		return false
+	}
+	violations := s.licensePolicy.violations(root)
+	if mode == policyReport {
+		res.violations = violations
+		return false
+	}
+	if len(violations) == 0 {
+		return false
+	}
+	s.logger.Info("License policy violated", "package", root.Name, "version", root.Version, "violations", len(violations))
+	p := newProblem(problemLicensePolicy, http.StatusConflict, fmt.Sprintf("%d packages have licenses the license policy doesn't permit", len(violations)))
+	p.Violations = violations
+	s.writeProblem(w, r, p)
+	return true
+}
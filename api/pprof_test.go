@@ -0,0 +1,28 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestProfilingEndpoint(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		server := httptest.NewServer(api.New(api.WithProfiling(enabled)))
+
+		resp, err := server.Client().Get(server.URL + "/debug/pprof/")
+		require.Nil(t, err)
+		resp.Body.Close()
+		server.Close()
+
+		if enabled {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		} else {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
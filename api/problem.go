@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Problem types let clients tell kinds of failure apart without parsing
+// messages. They are relative URIs, as RFC 7807 allows.
+const (
+	problemBadRequest          = "/problems/bad-request"
+	problemInvalidConstraint   = "/problems/invalid-constraint"
+	problemMissingIntegrity    = "/problems/missing-integrity"
+	problemInvalidWorkspace    = "/problems/invalid-workspace"
+	problemLicensePolicy       = "/problems/license-policy"
+	problemPackageNotFound     = "/problems/package-not-found"
+	problemVersionNotFound     = "/problems/version-not-found"
+	problemRegistryUnavailable = "/problems/registry-unavailable"
+	problemUpstreamFailure     = "/problems/upstream-failure"
+	problemAuditFailed         = "/problems/audit-failed"
+	problemResolutionTimeout   = "/problems/resolution-timeout"
+	problemResolutionLimit     = "/problems/resolution-limit"
+	problemResolutionFailed    = "/problems/resolution-failed"
+	problemInternal            = "/problems/internal-error"
+	problemUnauthorized        = "/problems/unauthorized"
+)
+
+var problemTitles = map[string]string{
+	problemBadRequest:          "Bad request",
+	problemInvalidConstraint:   "Invalid version constraint",
+	problemMissingIntegrity:    "Missing integrity hash",
+	problemInvalidWorkspace:    "Invalid workspace",
+	problemLicensePolicy:       "License policy violated",
+	problemPackageNotFound:     "Package not found",
+	problemVersionNotFound:     "Version not found",
+	problemRegistryUnavailable: registryUnavailableMsg,
+	problemUpstreamFailure:     "The npm registry failed",
+	problemAuditFailed:         "Vulnerability scan failed",
+	problemResolutionTimeout:   "Resolution timed out",
+	problemResolutionLimit:     "Resolution limit exceeded",
+	problemResolutionFailed:    "Resolution failed",
+	problemInternal:            internalServerErrorMsg,
+	problemUnauthorized:        "Unauthorized",
+}
+
+// problem is an RFC 7807 problem details response, the body of every
+// error response except those of the GraphQL endpoint, which follows the
+// GraphQL conventions instead.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// RetryAfterSeconds repeats the Retry-After header of a 503.
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+	// Resolved counts the packages resolved before a timeout.
+	Resolved *int `json:"resolved,omitempty"`
+	// Violations lists the packages violating the license policy.
+	Violations []licenseViolation `json:"violations,omitempty"`
+}
+
+func newProblem(typ string, status int, detail string) *problem {
+	return &problem{Type: typ, Title: problemTitles[typ], Status: status, Detail: detail}
+}
+
+// writeProblem writes p as an application/problem+json response. The
+// request, if there is one, is the problem's instance.
+func (s *server) writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	if r != nil {
+		p.Instance = r.URL.RequestURI()
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		s.logger.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if _, err := w.Write(data); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+	}
+}
+
+// badRequest writes a 400 problem with detail.
+func (s *server) badRequest(w http.ResponseWriter, r *http.Request, detail string) {
+	s.writeProblem(w, r, newProblem(problemBadRequest, http.StatusBadRequest, detail))
+}
+
+// resolveProblem classifies a failed resolution.
+func resolveProblem(err error) *problem {
+	var openErr *errCircuitOpen
+	if errors.As(err, &openErr) {
+		p := newProblem(problemRegistryUnavailable, http.StatusServiceUnavailable, err.Error())
+		p.RetryAfterSeconds = openErr.retryAfterSeconds()
+		return p
+	}
+	var timeoutErr *resolveTimeoutError
+	if errors.As(err, &timeoutErr) {
+		p := newProblem(problemResolutionTimeout, http.StatusGatewayTimeout, timeoutErr.Error())
+		p.Resolved = &timeoutErr.resolved
+		return p
+	}
+	switch {
+	case errors.Is(err, errInvalidConstraint):
+		return newProblem(problemInvalidConstraint, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errMissingIntegrity):
+		return newProblem(problemMissingIntegrity, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errWorkspaceMember):
+		return newProblem(problemInvalidWorkspace, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errPackageNotFound):
+		return newProblem(problemPackageNotFound, http.StatusNotFound, err.Error())
+	case errors.Is(err, errVersionNotFound):
+		return newProblem(problemVersionNotFound, http.StatusNotFound, err.Error())
+	case errors.Is(err, errMaxRecursionDepth) || errors.Is(err, errMaxUniquePackages) || errors.Is(err, errDownloadBudgetExceeded):
+		return newProblem(problemResolutionLimit, http.StatusInternalServerError, err.Error())
+	case isUpstreamFailure(err):
+		return newProblem(problemUpstreamFailure, http.StatusBadGateway, err.Error())
+	}
+	return newProblem(problemResolutionFailed, http.StatusInternalServerError, err.Error())
+}
+
+// isUpstreamFailure reports whether err is the registry failing, with a
+// server error or by not answering, rather than answering for a document.
+func isUpstreamFailure(err error) bool {
+	var regErr *registryError
+	if errors.As(err, &regErr) {
+		return regErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// writeResolveError reports a failed resolution to the client.
+func (s *server) writeResolveError(w http.ResponseWriter, r *http.Request, err error) {
+	p := resolveProblem(err)
+	if p.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(p.RetryAfterSeconds))
+	}
+	s.writeProblem(w, r, p)
+}
@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// problem is the RFC 7807 body of an error response.
+type problem struct {
+	Type              string `json:"type"`
+	Title             string `json:"title"`
+	Status            int    `json:"status"`
+	Detail            string `json:"detail"`
+	Instance          string `json:"instance"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds"`
+	Resolved          *int   `json:"resolved"`
+}
+
+// getProblem requests path from server, expecting an error response, and
+// decodes its problem details.
+func getProblem(t *testing.T, server *httptest.Server, path string) *problem {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	var p problem
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, resp.StatusCode, p.Status)
+	return &p
+}
+
+func TestProblemDetails(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+	})
+	down := newMockRegistry(t, mockRegistry{})
+	down.status = http.StatusServiceUnavailable
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithScopedRegistry("@down", down.URL)))
+	defer server.Close()
+
+	for path, want := range map[string]problem{
+		"/package/app/>=abc":     {Type: "/problems/invalid-constraint", Status: http.StatusUnprocessableEntity},
+		"/package/missing/1.0.0": {Type: "/problems/package-not-found", Status: http.StatusNotFound},
+		"/package/app/^2.0.0":    {Type: "/problems/version-not-found", Status: http.StatusNotFound},
+		"/package/app/1.0.0":     {Type: "/problems/resolution-failed", Status: http.StatusInternalServerError},
+		"/package/@down/x/1.0.0": {Type: "/problems/upstream-failure", Status: http.StatusBadGateway},
+		"/package/app":           {Type: "/problems/bad-request", Status: http.StatusBadRequest},
+		"/compare?a=app":         {Type: "/problems/bad-request", Status: http.StatusBadRequest},
+	} {
+		p := getProblem(t, server, path)
+		assert.Equal(t, want.Type, p.Type, path)
+		assert.Equal(t, want.Status, p.Status, path)
+		assert.NotEmpty(t, p.Title, path)
+		assert.NotEmpty(t, p.Detail, path)
+	}
+
+	p := getProblem(t, server, "/package/app/^2.0.0")
+	assert.Equal(t, "Version not found", p.Title)
+	assert.Equal(t, `version not found: no published version of app satisfies "^2.0.0"`, p.Detail)
+	assert.Equal(t, "/package/app/%5E2.0.0", p.Instance)
+
+	resp, err := server.Client().Post(server.URL+"/resolve", "application/json", strings.NewReader("{"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The messages of the gRPC service, defined in proto/resolver.proto, are
+// encoded by hand in the protobuf wire format rather than generated: they
+// are few, and the module depends on no protobuf runtime.
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformedProto = errors.New("malformed protobuf message")
+
+// protoMessage accumulates the encoding of a message. Scalar fields
+// holding their zero value are left out, as proto3 leaves them out.
+type protoMessage struct {
+	buf []byte
+}
+
+func (m *protoMessage) tag(field, wire int) {
+	m.buf = binary.AppendUvarint(m.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (m *protoMessage) bytes(field int, data []byte) {
+	m.tag(field, wireBytes)
+	m.buf = binary.AppendUvarint(m.buf, uint64(len(data)))
+	m.buf = append(m.buf, data...)
+}
+
+func (m *protoMessage) string(field int, s string) {
+	if s != "" {
+		m.bytes(field, []byte(s))
+	}
+}
+
+func (m *protoMessage) bool(field int, b bool) {
+	if b {
+		m.tag(field, wireVarint)
+		m.buf = append(m.buf, 1)
+	}
+}
+
+func (m *protoMessage) int32(field int, n int) {
+	if n != 0 {
+		m.optionalInt32(field, n)
+	}
+}
+
+// optionalInt32 encodes an int32 field declared optional, whose presence
+// is kept even when it is zero.
+func (m *protoMessage) optionalInt32(field int, n int) {
+	m.tag(field, wireVarint)
+	m.buf = binary.AppendUvarint(m.buf, uint64(int64(int32(n))))
+}
+
+// message encodes a message field, which is present even when empty.
+func (m *protoMessage) message(field int, sub *protoMessage) {
+	m.bytes(field, sub.buf)
+}
+
+// stringMap encodes a map<string, string> field as its repeated entries,
+// in key order.
+func (m *protoMessage) stringMap(field int, entries map[string]string) {
+	for _, key := range sortedKeys(entries) {
+		var entry protoMessage
+		entry.string(1, key)
+		entry.string(2, entries[key])
+		m.message(field, &entry)
+	}
+}
+
+// protoField is a field of a decoded message: its number, its wire type
+// and either its varint value or its length-delimited bytes.
+type protoField struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeProto splits a message into its fields, in the order they were
+// encoded. Fixed-width fields are skipped, as no message read needs them.
+func decodeProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return nil, errMalformedProto
+		}
+		data = data[n:]
+		field := protoField{number: int(key >> 3), wire: int(key & 7)}
+		switch field.wire {
+		case wireVarint:
+			if field.varint, n = binary.Uvarint(data); n <= 0 {
+				return nil, errMalformedProto
+			}
+			data = data[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, errMalformedProto
+			}
+			field.bytes, data = data[n:n+int(size)], data[n+int(size):]
+		case wireFixed64, wireFixed32:
+			width := 8
+			if field.wire == wireFixed32 {
+				width = 4
+			}
+			if len(data) < width {
+				return nil, errMalformedProto
+			}
+			data = data[width:]
+			continue
+		default:
+			return nil, fmt.Errorf("%w: unsupported wire type %d", errMalformedProto, field.wire)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// protoString returns the value of a string field.
+func protoString(field protoField) (string, error) {
+	if field.wire != wireBytes {
+		return "", fmt.Errorf("%w: field %d is not a string", errMalformedProto, field.number)
+	}
+	return string(field.bytes), nil
+}
+
+// decodeStringMapEntry decodes an entry of a map<string, string> field.
+func decodeStringMapEntry(field protoField) (key, value string, err error) {
+	if field.wire != wireBytes {
+		return "", "", fmt.Errorf("%w: field %d is not a map entry", errMalformedProto, field.number)
+	}
+	entry, err := decodeProto(field.bytes)
+	if err != nil {
+		return "", "", err
+	}
+	for _, f := range entry {
+		switch f.number {
+		case 1:
+			key, err = protoString(f)
+		case 2:
+			value, err = protoString(f)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return key, value, nil
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	redisKeyPrefix = "npm_packages:"
+	redisTimeout   = time.Second
+	redisMaxIdle   = 16
+)
+
+// redisCache shares registry metadata and resolved trees between replicas
+// through a Redis server, spoken to over RESP. A nil redisCache shares
+// nothing.
+type redisCache struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply from the server; the connection remains
+// usable after one.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func newRedisCache(addr, password string, db int) *redisCache {
+	return &redisCache{addr: addr, password: password, db: db, idle: make(chan *redisConn, redisMaxIdle)}
+}
+
+// do sends a command and returns its reply: a string, an int64, a []byte,
+// a []any of replies, or nil.
+func (c *redisCache) do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.pipeline(ctx, args)
+	if len(replies) == 0 {
+		return nil, err
+	}
+	return replies[0], err
+}
+
+// pipeline sends commands in one write and returns their replies, read in
+// one round trip. The error is the first error reply, if any.
+func (c *redisCache) pipeline(ctx context.Context, cmds ...[]string) ([]any, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.pipeline(ctx, cmds...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return replies, err
+}
+
+// conn returns an idle connection, or dials and authenticates a new one.
+func (c *redisCache) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	nc, err := d.DialContext(dialCtx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.password != "" {
+		if _, err := conn.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (conn *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	replies, err := conn.pipeline(ctx, args)
+	if len(replies) == 0 {
+		return nil, err
+	}
+	return replies[0], err
+}
+
+// pipeline writes every command before reading any reply. Every reply is
+// read, even after an error reply, so that the connection stays in step.
+func (conn *redisConn) pipeline(ctx context.Context, cmds ...[]string) ([]any, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		fmt.Fprintf(conn.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(conn.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(cmds))
+	var firstErr error
+	for i := range replies {
+		reply, err := readRedisReply(conn.r)
+		var replyErr redisError
+		if err != nil && !errors.As(err, &replyErr) {
+			return nil, err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+func (c *redisCache) get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.do(ctx, "GET", redisKeyPrefix+key)
+	data, _ := reply.([]byte)
+	return data, err
+}
+
+// set stores value under key for ttl and records key under each tag, in
+// a single round trip however many tags there are.
+func (c *redisCache) set(ctx context.Context, key string, value []byte, ttl time.Duration, tags []string) error {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	cmds := [][]string{{"SET", redisKeyPrefix + key, string(value), "PX", ms}}
+	for _, tag := range tags {
+		cmds = append(cmds,
+			[]string{"SADD", redisKeyPrefix + "tag:" + tag, key},
+			[]string{"PEXPIRE", redisKeyPrefix + "tag:" + tag, ms})
+	}
+	_, err := c.pipeline(ctx, cmds...)
+	return err
+}
+
+// invalidate deletes key, and every key recorded under tag, and returns
+// how many keys recorded under tag were deleted.
+func (c *redisCache) invalidate(ctx context.Context, key, tag string) (int, error) {
+	reply, err := c.do(ctx, "SMEMBERS", redisKeyPrefix+"tag:"+tag)
+	if err != nil {
+		return 0, err
+	}
+	members, _ := reply.([]any)
+	args := []string{"DEL", redisKeyPrefix + key, redisKeyPrefix + "tag:" + tag}
+	for _, member := range members {
+		if m, ok := member.([]byte); ok {
+			args = append(args, redisKeyPrefix+string(m))
+		}
+	}
+	if _, err := c.do(ctx, args...); err != nil {
+		return 0, err
+	}
+	return len(members), nil
+}
+
+// loadShared decodes the shared entry for key into v and reports whether
+// there was one. Redis failures are logged and treated as misses, so an
+// unavailable Redis only costs the replicas their shared hits.
+func (s *server) loadShared(ctx context.Context, key string, v any) bool {
+	if s.redis == nil {
+		return false
+	}
+	data, err := s.redis.get(ctx, key)
+	if err != nil {
+		s.logger.Warn("Shared cache read failed", "key", key, "error", err)
+		return false
+	}
+	if data == nil {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		s.logger.Warn("Shared cache entry is corrupt", "key", key, "error", err)
+		return false
+	}
+	return true
+}
+
+// storeShared shares v under key for ttl, tagged so that invalidating a
+// tag also evicts it.
+func (s *server) storeShared(ctx context.Context, key string, v any, ttl time.Duration, tags ...string) {
+	if s.redis == nil || ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = s.redis.set(ctx, key, data, ttl, tags)
+	}
+	if err != nil {
+		s.logger.Warn("Shared cache write failed", "key", key, "error", err)
+	}
+}
+
+// invalidateShared evicts the shared metadata of the named package and
+// every shared tree that includes it, returning how many trees were
+// evicted.
+func (s *server) invalidateShared(ctx context.Context, name string) int {
+	if s.redis == nil {
+		return 0
+	}
+	trees, err := s.redis.invalidate(ctx, metaKey(name), name)
+	if err != nil {
+		s.logger.Warn("Shared cache invalidation failed", "package", name, "error", err)
+	}
+	return trees
+}
+
+func metaKey(name string) string { return "meta:" + name }
+
+func versionKey(name, version string) string { return "version:" + name + "@" + version }
+
+// treeSharedKey hashes a treeCacheKey, which holds arbitrary client input,
+// into a compact Redis key.
+func treeSharedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "tree:" + hex.EncodeToString(sum[:])
+}
+
+// sharedTree is the stored form of a resolved tree, keeping the fields
+// that NpmPackageVersion leaves out of its JSON.
+type sharedTree struct {
+	Name             string                     `json:"name"`
+	Version          string                     `json:"version"`
+	License          string                     `json:"license,omitempty"`
+	Maintainers      []person                   `json:"maintainers,omitempty"`
+	Author           *person                    `json:"author,omitempty"`
+	Engines          map[string]string          `json:"engines,omitempty"`
+	Spec             string                     `json:"spec,omitempty"`
+	Excluded         bool                       `json:"excluded,omitempty"`
+	Unresolved       string                     `json:"unresolved,omitempty"`
+	Dist             *PackageDist               `json:"dist,omitempty"`
+	Registry         string                     `json:"registry,omitempty"`
+	Deprecated       string                     `json:"deprecated,omitempty"`
+	Truncated        bool                       `json:"truncated,omitempty"`
+	Circular         bool                       `json:"circular,omitempty"`
+	Dev              bool                       `json:"dev,omitempty"`
+	Optional         bool                       `json:"optional,omitempty"`
+	Skipped          string                     `json:"skipped,omitempty"`
+	Alias            string                     `json:"alias,omitempty"`
+	PeerDependencies map[string]*PeerDependency `json:"peerDependencies,omitempty"`
+	Dependencies     map[string]*sharedTree     `json:"dependencies,omitempty"`
+}
+
+func toSharedTree(pkg *NpmPackageVersion) *sharedTree {
+	t := &sharedTree{
+		Name: pkg.Name, Version: pkg.Version, License: pkg.License, Maintainers: pkg.Maintainers, Author: pkg.Author, Engines: pkg.Engines, Spec: pkg.Spec,
+		Excluded: pkg.Excluded, Unresolved: pkg.Unresolved, Dist: pkg.Dist, Registry: pkg.Registry, Deprecated: pkg.Deprecated, Truncated: pkg.Truncated, Circular: pkg.Circular, Dev: pkg.Dev, Optional: pkg.Optional, Skipped: pkg.Skipped, Alias: pkg.Alias, PeerDependencies: pkg.PeerDependencies,
+		Dependencies: make(map[string]*sharedTree, len(pkg.Dependencies)),
+	}
+	for name, dep := range pkg.Dependencies {
+		t.Dependencies[name] = toSharedTree(dep)
+	}
+	return t
+}
+
+func (t *sharedTree) tree() *NpmPackageVersion {
+	pkg := &NpmPackageVersion{
+		Name: t.Name, Version: t.Version, License: t.License, Maintainers: t.Maintainers, Author: t.Author, Engines: t.Engines, Spec: t.Spec,
+		Excluded: t.Excluded, Unresolved: t.Unresolved, Dist: t.Dist, Registry: t.Registry, Deprecated: t.Deprecated, Truncated: t.Truncated, Circular: t.Circular, Dev: t.Dev, Optional: t.Optional, Skipped: t.Skipped, Alias: t.Alias, PeerDependencies: t.PeerDependencies,
+		Dependencies: make(map[string]*NpmPackageVersion, len(t.Dependencies)),
+	}
+	for name, dep := range t.Dependencies {
+		pkg.Dependencies[name] = dep.tree()
+	}
+	return pkg
+}
@@ -0,0 +1,212 @@
+package api_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// fakeRedis speaks just enough RESP for the shared cache: GET, SET, DEL,
+// SADD, SMEMBERS and PEXPIRE, ignoring expiry.
+type fakeRedis struct {
+	net.Listener
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	// batches counts the commands of each round trip: those that arrived
+	// together, before the first was answered.
+	batches []int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	fr := &fakeRedis{Listener: l, strings: map[string]string{}, sets: map[string]map[string]bool{}}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	batch := 0
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		conn.Write(fr.exec(args))
+		if batch++; r.Buffered() == 0 {
+			fr.mu.Lock()
+			fr.batches = append(fr.batches, batch)
+			fr.mu.Unlock()
+			batch = 0
+		}
+	}
+}
+
+func (fr *fakeRedis) exec(args []string) []byte {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		v, ok := fr.strings[args[1]]
+		if !ok {
+			return []byte("$-1\r\n")
+		}
+		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+	case "SET":
+		fr.strings[args[1]] = args[2]
+		return []byte("+OK\r\n")
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := fr.strings[key]; ok {
+				n++
+			}
+			if _, ok := fr.sets[key]; ok {
+				n++
+			}
+			delete(fr.strings, key)
+			delete(fr.sets, key)
+		}
+		return []byte(":" + strconv.Itoa(n) + "\r\n")
+	case "SADD":
+		if fr.sets[args[1]] == nil {
+			fr.sets[args[1]] = map[string]bool{}
+		}
+		for _, member := range args[2:] {
+			fr.sets[args[1]][member] = true
+		}
+		return []byte(":1\r\n")
+	case "SMEMBERS":
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "*%d\r\n", len(fr.sets[args[1]]))
+		for member := range fr.sets[args[1]] {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(member), member)
+		}
+		return buf.Bytes()
+	case "PEXPIRE":
+		return []byte(":1\r\n")
+	}
+	return []byte("-ERR unknown command\r\n")
+}
+
+func (fr *fakeRedis) keys() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return len(fr.strings)
+}
+
+func TestRedisCacheSharedBetweenReplicas(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {"license": "MIT"}},
+	})
+	redis := newFakeRedis(t)
+	newReplica := func() *httptest.Server {
+		server := httptest.NewServer(api.New(
+			api.WithRegistryURL(registry.URL),
+			api.WithResultCacheTTL(time.Minute),
+			api.WithRedisCache(redis.Addr().String(), "", 0),
+			api.WithAdminToken(adminToken),
+		))
+		t.Cleanup(server.Close)
+		return server
+	}
+	first, second := newReplica(), newReplica()
+
+	want := getTreeFrom(t, first, "/package/app/1.0.0")
+	fetched := len(registry.Requests())
+	assert.Equal(t, 5, redis.keys())
+
+	assert.Equal(t, want, getTreeFrom(t, second, "/package/app/1.0.0"))
+	assert.Len(t, registry.Requests(), fetched)
+
+	// Metadata is shared even when the tree is not.
+	assert.Equal(t, "1.0.0", getTreeFrom(t, second, "/package/lib/^1.0.0").Version)
+	assert.Len(t, registry.Requests(), fetched)
+
+	// Invalidating on one replica evicts the shared trees that include lib.
+	resp := adminRequest(t, second, http.MethodPost, "/cache/invalidate", strings.NewReader(`{"packages":["lib"]}`))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	third := newReplica()
+	getTreeFrom(t, third, "/package/app/1.0.0")
+	assert.Contains(t, registry.Requests()[fetched:], "/lib")
+}
+
+func TestRedisCachePipelinesTags(t *testing.T) {
+	const width = 20
+	rootDeps := map[string]string{}
+	pkgs := mockRegistry{}
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("dep-%d", i)
+		rootDeps[name] = "^1.0.0"
+		pkgs[name] = map[string]manifest{"1.0.0": {}}
+	}
+	pkgs["app"] = map[string]manifest{"1.0.0": deps(rootDeps)}
+	registry := newMockRegistry(t, pkgs)
+	redis := newFakeRedis(t)
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithResultCacheTTL(time.Minute),
+		api.WithRedisCache(redis.Addr().String(), "", 0),
+	))
+	defer server.Close()
+
+	getTreeFrom(t, server, "/package/app/1.0.0")
+
+	// The tree is stored, and tagged with each of its packages, in one
+	// round trip: a SET, then an SADD and a PEXPIRE per package.
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	assert.Contains(t, redis.batches, 1+2*(width+1))
+}
+
+func TestRedisCacheUnavailable(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithRedisCache(addr, "", 0)))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/1.0.0").Version)
+}
@@ -0,0 +1,41 @@
+package api
+
+// refPackage is a package whose dependencies may be references to a
+// package expanded earlier in the document.
+type refPackage struct {
+	*NpmPackageVersion
+	Dependencies map[string]any `json:"dependencies"`
+}
+
+// packageRefMarker stands in for a package already expanded earlier in
+// the document.
+type packageRefMarker struct {
+	Ref string `json:"$ref"`
+}
+
+// refDependencies returns the dependencies of root with only the first
+// occurrence of each name@version expanded, in the order they appear in
+// the serialized document, where keys are sorted. Later occurrences are
+// replaced by a {"$ref": "name@version"} marker.
+func refDependencies(root *NpmPackageVersion) map[string]any {
+	seen := map[string]bool{root.Name + "@" + root.Version: true}
+	var expand func(pkg *NpmPackageVersion) map[string]any
+	expand = func(pkg *NpmPackageVersion) map[string]any {
+		deps := make(map[string]any, len(pkg.Dependencies))
+		for _, name := range sortedKeys(pkg.Dependencies) {
+			dep := pkg.Dependencies[name]
+			key := dep.Name + "@" + dep.Version
+			switch {
+			case dep.Excluded || dep.Truncated || dep.Circular || dep.Unresolved != "" || dep.Skipped != "":
+				deps[name] = dep
+			case seen[key]:
+				deps[name] = packageRefMarker{Ref: key}
+			default:
+				seen[key] = true
+				deps[name] = &refPackage{NpmPackageVersion: dep, Dependencies: expand(dep)}
+			}
+		}
+		return deps
+	}
+	return expand(root)
+}
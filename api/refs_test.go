@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestRefsMode(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"b":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"shared": {"1.0.0": deps(map[string]string{"leaf": "^1.0.0"})},
+		"leaf":   {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?refs=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Name         string `json:"name"`
+		Dependencies map[string]struct {
+			Dependencies map[string]json.RawMessage `json:"dependencies"`
+		} `json:"dependencies"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "app", body.Name)
+	assert.JSONEq(t, `{
+		"name": "shared", "version": "1.0.0",
+		"dependencies": {"leaf": {"name": "leaf", "version": "1.0.0", "dependencies": {}}}
+	}`, string(body.Dependencies["a"].Dependencies["shared"]))
+	assert.JSONEq(t, `{"$ref": "shared@1.0.0"}`, string(body.Dependencies["b"].Dependencies["shared"]))
+}
+
+func TestRefsModeKeepsCircularMarker(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"self": "^1.0.0"})},
+		"self": {"1.0.0": deps(map[string]string{"self": "^1.0.0"})},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?refs=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Dependencies map[string]struct {
+			Dependencies map[string]json.RawMessage `json:"dependencies"`
+		} `json:"dependencies"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.JSONEq(t, `{"name": "self", "version": "1.0.0", "circular": true, "dependencies": {}}`,
+		string(body.Dependencies["self"].Dependencies["self"]))
+}
@@ -0,0 +1,246 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// manifest holds the fields of a single published version, beyond its
+// name and version, served by the mock registry.
+type manifest map[string]any
+
+// mockRegistry maps package name to version to manifest.
+type mockRegistry map[string]map[string]manifest
+
+// deps builds a manifest with the given dependencies.
+func deps(d map[string]string) manifest {
+	return manifest{"dependencies": d}
+}
+
+// registryServer is an in-memory npm registry that records the paths it
+// was asked for.
+type registryServer struct {
+	*httptest.Server
+
+	// delay is applied to every response and, when non-zero, status
+	// replaces every response; set them before issuing requests.
+	delay  time.Duration
+	status int
+	// distTags maps package name to its dist-tags; set before issuing
+	// requests.
+	distTags map[string]map[string]string
+	// token, when set, is the bearer token every request must carry; set
+	// before issuing requests.
+	token string
+	// flaky answers that many requests 502 Bad Gateway before serving
+	// normally; set before issuing requests.
+	flaky int
+	// abbreviated serves abbreviated metadata to clients that accept it;
+	// set before issuing requests.
+	abbreviated bool
+
+	mu          sync.Mutex
+	requests    []string
+	inFlight    int
+	maxInFlight int
+	notModified int
+	// abbreviatedServed counts the abbreviated metadata documents served.
+	abbreviatedServed int
+	// authorized lists the requests that carried credentials.
+	authorized []string
+}
+
+// MaxInFlight reports the highest number of concurrent requests served.
+func (rs *registryServer) MaxInFlight() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.maxInFlight
+}
+
+// NotModified reports how many requests were answered 304 Not Modified.
+func (rs *registryServer) NotModified() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.notModified
+}
+
+// AbbreviatedServed reports how many abbreviated metadata documents were
+// served.
+func (rs *registryServer) AbbreviatedServed() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.abbreviatedServed
+}
+
+// Authorized lists the paths of requests that carried credentials.
+func (rs *registryServer) Authorized() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.authorized...)
+}
+
+func (rs *registryServer) Requests() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.requests...)
+}
+
+func newMockRegistry(t *testing.T, pkgs mockRegistry) *registryServer {
+	t.Helper()
+	rs := &registryServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		rs.requests = append(rs.requests, r.URL.Path)
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			rs.authorized = append(rs.authorized, r.URL.Path)
+		}
+		rs.inFlight++
+		rs.maxInFlight = max(rs.maxInFlight, rs.inFlight)
+		rs.mu.Unlock()
+		defer func() {
+			rs.mu.Lock()
+			rs.inFlight--
+			rs.mu.Unlock()
+		}()
+		time.Sleep(rs.delay)
+		rs.mu.Lock()
+		failing := rs.flaky > 0
+		if failing {
+			rs.flaky--
+		}
+		rs.mu.Unlock()
+		if failing {
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		if rs.token != "" && r.Header.Get("Authorization") != "Bearer "+rs.token {
+			http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		if rs.status != 0 {
+			http.Error(w, http.StatusText(rs.status), rs.status)
+			return
+		}
+
+		// Scoped names may arrive as "@scope/name" or "@scope%2fname".
+		path, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		n := 2
+		if strings.HasPrefix(path, "@") {
+			n = 3
+		}
+		parts := strings.SplitN(path, "/", n)
+		if n == 3 {
+			parts = append([]string{parts[0] + "/" + parts[1]}, parts[2:]...)
+		}
+		name := parts[0]
+		versions, ok := pkgs[name]
+		if !ok {
+			http.Error(w, `{"error":"Not found"}`, http.StatusNotFound)
+			return
+		}
+
+		var body any
+		contentType := "application/json"
+		if len(parts) == 1 {
+			abbreviated := rs.abbreviated && strings.Contains(r.Header.Get("Accept"), "application/vnd.npm.install-v1+json")
+			all := map[string]any{}
+			for v, m := range versions {
+				all[v] = versionDoc(name, v, m)
+				if abbreviated {
+					all[v] = abbreviatedDoc(name, v, m)
+				}
+			}
+			if abbreviated {
+				contentType = "application/vnd.npm.install-v1+json"
+				rs.mu.Lock()
+				rs.abbreviatedServed++
+				rs.mu.Unlock()
+			}
+			body = map[string]any{"name": name, "dist-tags": rs.distTags[name], "versions": all}
+		} else {
+			m, ok := versions[parts[1]]
+			if !ok {
+				http.Error(w, `{"error":"Not found"}`, http.StatusNotFound)
+				return
+			}
+			body = versionDoc(name, parts[1], m)
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h := fnv.New64a()
+		h.Write(data)
+		etag := fmt.Sprintf(`"%x"`, h.Sum64())
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			rs.mu.Lock()
+			rs.notModified++
+			rs.mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func versionDoc(name, version string, m manifest) map[string]any {
+	doc := map[string]any{"name": name, "version": version}
+	for k, v := range m {
+		doc[k] = v
+	}
+	return doc
+}
+
+// abbreviatedDoc is versionDoc with only the fields abbreviated metadata
+// keeps.
+func abbreviatedDoc(name, version string, m manifest) map[string]any {
+	doc := map[string]any{"name": name, "version": version}
+	for _, k := range []string{"dependencies", "optionalDependencies", "peerDependencies", "dist", "deprecated", "engines"} {
+		if v, ok := m[k]; ok {
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// getTree requests path from a handler backed by registry and decodes the
+// resolved tree.
+func getTree(t *testing.T, registry *registryServer, path string, opts ...api.Option) *api.NpmPackageVersion {
+	t.Helper()
+	server := httptest.NewServer(api.New(append([]api.Option{api.WithRegistryURL(registry.URL)}, opts...)...))
+	defer server.Close()
+	return getTreeFrom(t, server, path)
+}
+
+// getTreeFrom requests path from server and decodes the resolved tree.
+func getTreeFrom(t *testing.T, server *httptest.Server, path string) *api.NpmPackageVersion {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tree api.NpmPackageVersion
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&tree))
+	return &tree
+}
@@ -0,0 +1,373 @@
+package api_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestRegistryFallback(t *testing.T) {
+	primary := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"public-lib": "^2.0.0"})},
+	})
+	secondary := newMockRegistry(t, mockRegistry{
+		"public-lib": {"2.3.0": {}},
+	})
+
+	server := httptest.NewServer(api.New(api.WithRegistryURLs(primary.URL, secondary.URL)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, "2.3.0", tree.Dependencies["public-lib"].Version)
+	assert.Contains(t, primary.Requests(), "/public-lib")
+	assert.Equal(t, []string{"/public-lib", "/public-lib/2.3.0"}, secondary.Requests())
+}
+
+func TestRegistryFallbackStopsOnServerError(t *testing.T) {
+	primary := newMockRegistry(t, mockRegistry{})
+	primary.status = http.StatusInternalServerError
+	secondary := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {}},
+	})
+
+	server := httptest.NewServer(api.New(api.WithRegistryURLs(primary.URL, secondary.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Empty(t, secondary.Requests())
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	pkgs := mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.2.0": {}},
+	}
+	primary := newMockRegistry(t, mockRegistry{})
+	primary.status = http.StatusBadGateway
+	slow := newMockRegistry(t, pkgs)
+	slow.delay = 200 * time.Millisecond
+	mirror := newMockRegistry(t, pkgs)
+
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(primary.URL),
+		api.WithRegistryMirrors("", slow.URL, mirror.URL),
+		api.WithRegistryTimeout(50*time.Millisecond),
+	))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0?registry=true")
+	assert.Equal(t, mirror.URL, tree.Registry)
+	assert.Equal(t, mirror.URL, tree.Dependencies["lib"].Registry)
+	assert.Contains(t, primary.Requests(), "/app")
+	assert.Contains(t, slow.Requests(), "/app")
+
+	// The registry is left out unless asked for.
+	assert.Empty(t, getTreeFrom(t, server, "/package/app/1.0.0").Registry)
+}
+
+func TestRegistryMirrorsNotConsultedForMissingPackages(t *testing.T) {
+	primary := newMockRegistry(t, mockRegistry{})
+	mirror := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(primary.URL), api.WithRegistryMirrors(primary.URL, mirror.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, mirror.Requests())
+}
+
+func TestRetries(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	registry.flaky = 2
+
+	tree := getTree(t, registry, "/package/app/1.0.0", api.WithRetries(2, time.Millisecond))
+	assert.Equal(t, "1.0.0", tree.Version)
+	assert.Equal(t, []string{"/app", "/app", "/app", "/app/1.0.0"}, registry.Requests())
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	registry.flaky = 3
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithRetries(2, time.Millisecond)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Len(t, registry.Requests(), 3)
+}
+
+func TestRetriesSkipMissingPackages(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithRetries(2, time.Millisecond)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, []string{"/app"}, registry.Requests())
+}
+
+func TestRegistryProxy(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	var (
+		mu      sync.Mutex
+		proxied []string
+	)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.Nil(t, err)
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithProxy(proxyURL)))
+	defer server.Close()
+
+	assert.Equal(t, "1.0.0", getTreeFrom(t, server, "/package/app/1.0.0").Version)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{registry.URL + "/app", registry.URL + "/app/1.0.0"}, proxied)
+}
+
+func TestCompressedRegistryResponses(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept-Encoding"), "gzip")
+		var enc io.WriteCloser
+		if r.URL.Path == "/app" {
+			w.Header().Set("Content-Encoding", "gzip")
+			enc = gzip.NewWriter(w)
+			_, _ = io.WriteString(enc, `{"name":"app","versions":{"1.0.0":{"name":"app","version":"1.0.0"}}}`)
+		} else {
+			w.Header().Set("Content-Encoding", "deflate")
+			enc = zlib.NewWriter(w)
+			_, _ = io.WriteString(enc, `{"name":"app","version":"1.0.0","dependencies":{}}`)
+		}
+		require.Nil(t, enc.Close())
+	}))
+	defer registry.Close()
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, "app", tree.Name)
+	assert.Equal(t, "1.0.0", tree.Version)
+}
+
+func TestDownloadBudget(t *testing.T) {
+	padding := strings.Repeat("x", 10_000)
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": {"description": padding, "dependencies": map[string]string{"lib": "^1.0.0"}}},
+		"lib":  {"1.0.0": {"description": padding, "dependencies": map[string]string{"leaf": "^1.0.0"}}},
+		"leaf": {"1.0.0": {"description": padding}},
+	})
+
+	getTree(t, registry, "/package/app/1.0.0", api.WithDownloadBudget(100_000))
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithDownloadBudget(30_000)))
+	defer server.Close()
+	before := len(registry.Requests())
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, string(body), "registry download budget exceeded: downloaded")
+	assert.NotContains(t, registry.Requests()[before:], "/leaf")
+}
+
+func TestScopedRegistryErrors(t *testing.T) {
+	public := newMockRegistry(t, mockRegistry{
+		"app":         {"1.0.0": deps(map[string]string{"@acme/ui": "^1.0.0"})},
+		"@acme/icons": {"1.0.0": {}},
+	})
+	private := newMockRegistry(t, mockRegistry{
+		"@acme/ui": {"1.0.0": deps(map[string]string{"@acme/icons": "^1.0.0"})},
+	})
+	resolve := func(path string, opts ...api.Option) (int, string) {
+		opts = append([]api.Option{api.WithRegistryURL(public.URL), api.WithScopedRegistry("@acme", private.URL)}, opts...)
+		server := httptest.NewServer(api.New(opts...))
+		defer server.Close()
+		resp, err := server.Client().Get(server.URL + path)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := resolve("/package/app/1.0.0")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "@acme/icons was not found on "+private.URL+", the registry for scope @acme; it may only be published elsewhere")
+	assert.NotContains(t, public.Requests(), "/@acme/icons")
+
+	status, _ = resolve("/package/app/1.0.0", api.WithScopedFallback(true))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, public.Requests(), "/@acme/icons")
+
+	status, body = resolve("/package/@acme%2fghost/1.0.0", api.WithScopedFallback(true))
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Contains(t, body, "@acme/ghost was not found on "+private.URL+", the registry for scope @acme, nor on "+public.URL+"; it is not published on any configured registry")
+}
+
+func TestConcurrentFetchesShareRequests(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.0.0": {}},
+	})
+	registry.delay = 50 * time.Millisecond
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+			if assert.Nil(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{"/app", "/app/1.0.0", "/lib", "/lib/1.0.0"}, registry.Requests())
+}
+
+func TestSharedFetchOutlivesItsFirstCaller(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	registry.delay = 200 * time.Millisecond
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	status := func(query string) int {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0" + query)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	short := make(chan int)
+	go func() { short <- status("?timeout=50ms") }()
+	time.Sleep(20 * time.Millisecond)
+	// The second request shares the first one's fetch of /app, which its
+	// deadline doesn't cut short.
+	assert.Equal(t, http.StatusOK, status(""))
+	assert.Equal(t, http.StatusGatewayTimeout, <-short)
+	assert.Equal(t, []string{"/app", "/app/1.0.0"}, registry.Requests())
+}
+
+func TestSharedFetchCancelledWhenEveryCallerLeaves(t *testing.T) {
+	cancelled := make(chan struct{})
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer registry.Close()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?timeout=50ms")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the registry request outlived its only caller")
+	}
+}
+
+func TestDownloadBudgetAbortsLargeDocuments(t *testing.T) {
+	const size = 64 << 20
+	var mu sync.Mutex
+	written := 0
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"app","description":"`))
+		chunk := []byte(strings.Repeat("x", 64<<10))
+		for n := 0; n < size; n += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			mu.Lock()
+			written += len(chunk)
+			mu.Unlock()
+		}
+		w.Write([]byte(`"}`))
+	}))
+	defer registry.Close()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithDownloadBudget(100_000)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	// The download stops once the budget is spent, not once the document
+	// is complete.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Less(t, written, size/2)
+}
+
+func TestScopedPackagePaths(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"@babel/core":  {"7.0.0": deps(map[string]string{"@babel/types": "^7.0.0"})},
+		"@babel/types": {"7.1.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for _, path := range []string{"/package/@babel/core/7.0.0", "/package/@babel%2Fcore/7.0.0"} {
+		tree := getTreeFrom(t, server, path)
+		assert.Equal(t, "@babel/core", tree.Name, path)
+		assert.Equal(t, "7.1.0", tree.Dependencies["@babel/types"].Version, path)
+	}
+	// Versions of scoped packages come from their metadata.
+	assert.Equal(t, []string{"/@babel/core", "/@babel/types"}, registry.Requests())
+
+	resp, err := server.Client().Get(server.URL + "/package/@babel/core/7.0.0/install-order")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelResolveResolvesRepeatedNames(t *testing.T) {
+	registry := memoryRegistry{
+		"/app":          `{"versions":{"1.0.0":{}}}`,
+		"/app/1.0.0":    `{"name":"app","version":"1.0.0","dependencies":{"a":"^1.0.0","b":"^1.0.0","shared":"^1.0.0"}}`,
+		"/a":            `{"versions":{"1.0.0":{}}}`,
+		"/a/1.0.0":      `{"name":"a","version":"1.0.0","dependencies":{"shared":"^2.0.0"}}`,
+		"/b":            `{"versions":{"1.0.0":{}}}`,
+		"/b/1.0.0":      `{"name":"b","version":"1.0.0","dependencies":{"shared":"^1.0.0"}}`,
+		"/shared":       `{"versions":{"1.5.0":{},"2.1.0":{}}}`,
+		"/shared/1.5.0": `{"name":"shared","version":"1.5.0"}`,
+		"/shared/2.1.0": `{"name":"shared","version":"2.1.0"}`,
+	}
+	s := newBenchServer(registry)
+	s.resolveSem = newSemaphore(8)
+
+	for i := 0; i < 20; i++ {
+		root, err := s.newResolver(resolveOptions{}).resolve(context.Background(), "app", "1.0.0")
+		require.Nil(t, err)
+
+		assert.Equal(t, "1.5.0", root.Dependencies["shared"].Version)
+		assert.Equal(t, "2.1.0", root.Dependencies["a"].Dependencies["shared"].Version)
+		assert.Equal(t, "1.5.0", root.Dependencies["b"].Dependencies["shared"].Version)
+	}
+}
+
+func TestParallelResolveStopsAtCycles(t *testing.T) {
+	registry := memoryRegistry{
+		"/a":       `{"versions":{"1.0.0":{}}}`,
+		"/a/1.0.0": `{"name":"a","version":"1.0.0","dependencies":{"b":"^1.0.0"}}`,
+		"/b":       `{"versions":{"1.0.0":{}}}`,
+		"/b/1.0.0": `{"name":"b","version":"1.0.0","dependencies":{"a":"^1.0.0"}}`,
+	}
+	s := newBenchServer(registry)
+	s.resolveSem = newSemaphore(8)
+
+	root, err := s.newResolver(resolveOptions{}).resolve(context.Background(), "a", "1.0.0")
+	require.Nil(t, err)
+	a := root.Dependencies["b"].Dependencies["a"]
+	assert.True(t, a.Circular)
+	assert.Empty(t, a.Dependencies)
+}
+
+// countingTransport records the most requests it served at once.
+type countingTransport struct {
+	http.RoundTripper
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.inFlight++
+	t.maxInFlight = max(t.maxInFlight, t.inFlight)
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.inFlight--
+		t.mu.Unlock()
+	}()
+	time.Sleep(time.Millisecond)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestParallelResolveBoundsConcurrency(t *testing.T) {
+	transport := &countingTransport{RoundTripper: newBenchRegistry(20, 3, 1)}
+	s := newServer(
+		WithRegistryURL("http://registry.invalid"),
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithResolveConcurrency(3),
+	)
+
+	root, err := s.newResolver(resolveOptions{}).resolve(context.Background(), "root", "^1.0.0")
+	require.Nil(t, err)
+
+	assert.Len(t, root.Dependencies, 20)
+	assert.Equal(t, "1.0.0", root.Dependencies["dep-7-0"].Dependencies["dep-7-1"].Dependencies["dep-7-2"].Version)
+	// Three workers, and the goroutine that started the resolution.
+	assert.LessOrEqual(t, transport.maxInFlight, 4)
+	assert.Greater(t, transport.maxInFlight, 1)
+}
+
+func TestParallelResolveMatchesSequential(t *testing.T) {
+	registry := newBenchRegistry(10, 3, 4)
+	sequential := newBenchServer(registry)
+	parallel := newBenchServer(registry)
+	parallel.resolveSem = newSemaphore(4)
+
+	want, err := sequential.newResolver(resolveOptions{}).resolve(context.Background(), "root", "^1.0.0")
+	require.Nil(t, err)
+	got, err := parallel.newResolver(resolveOptions{}).resolve(context.Background(), "root", "^1.0.0")
+	require.Nil(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestParallelResolveReportsFirstErrorInNameOrder(t *testing.T) {
+	registry := memoryRegistry{
+		"/app":       `{"versions":{"1.0.0":{}}}`,
+		"/app/1.0.0": `{"name":"app","version":"1.0.0","dependencies":{"a":"^1.0.0","b":"^1.0.0","c":"^1.0.0"}}`,
+		"/a":         `{"versions":{"1.0.0":{}}}`,
+		"/a/1.0.0":   `{"name":"a","version":"1.0.0"}`,
+		"/b":         `{"versions":{"1.0.0":{}}}`,
+		"/b/1.0.0":   `{"name":"b","version":"1.0.0","dependencies":{"gone":"^1.0.0"}}`,
+		"/c":         `{"versions":{"1.0.0":{}}}`,
+		"/c/1.0.0":   `{"name":"c","version":"1.0.0","dependencies":{"absent":"^1.0.0"}}`,
+	}
+	s := newBenchServer(registry)
+	s.resolveSem = newSemaphore(4)
+
+	for i := 0; i < 20; i++ {
+		_, err := s.newResolver(resolveOptions{}).resolve(context.Background(), "app", "1.0.0")
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "/gone")
+	}
+}
@@ -0,0 +1,408 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestStopAt(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":      {"1.0.0": deps(map[string]string{"boundary": "^1.0.0", "sibling": "^1.0.0"})},
+		"boundary": {"1.0.0": {}, "1.3.0": deps(map[string]string{"hidden": "^1.0.0"})},
+		"hidden":   {"1.0.0": {}},
+		"sibling":  {"1.0.0": deps(map[string]string{"leaf": "^1.0.0"})},
+		"leaf":     {"1.0.0": {}},
+	})
+
+	tree := getTree(t, registry, "/package/app/1.0.0?stopAt=boundary")
+
+	boundary := tree.Dependencies["boundary"]
+	require.NotNil(t, boundary)
+	assert.Equal(t, "1.3.0", boundary.Version)
+	assert.Empty(t, boundary.Dependencies)
+	assert.Equal(t, "1.0.0", tree.Dependencies["sibling"].Dependencies["leaf"].Version)
+	assert.NotContains(t, registry.Requests(), "/hidden")
+}
+
+func TestMaxRecursionDepth(t *testing.T) {
+	const length = 50
+	pkgs := mockRegistry{}
+	for i := 0; i < length; i++ {
+		next := map[string]string{}
+		if i < length-1 {
+			next[fmt.Sprintf("chain-%d", i+1)] = "^1.0.0"
+		}
+		pkgs[fmt.Sprintf("chain-%d", i)] = map[string]manifest{"1.0.0": deps(next)}
+	}
+	registry := newMockRegistry(t, pkgs)
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithMaxRecursionDepth(10)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/chain-0/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, string(body), "maximum recursion depth of 10 exceeded at chain-11")
+	assert.NotContains(t, registry.Requests(), "/chain-12")
+
+	tree := getTree(t, registry, "/package/chain-0/1.0.0")
+	assert.Equal(t, "chain-1", tree.Dependencies["chain-1"].Name)
+}
+
+func TestExcludeScopes(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":           {"1.0.0": deps(map[string]string{"@types/node": "^20.0.0", "@internal/util": "^1.0.0", "@scoped/lib": "^1.0.0", "lodash": "^4.0.0"})},
+		"@scoped/lib":   {"1.0.0": {}},
+		"lodash":        {"4.17.21": deps(map[string]string{"@types/lodash": "^4.0.0"})},
+		"@types/node":   {"20.0.0": {}},
+		"@types/lodash": {"4.0.0": {}},
+	})
+
+	tree := getTree(t, registry, "/package/app/1.0.0?excludeScopes=@types,internal")
+
+	for _, path := range []*api.NpmPackageVersion{
+		tree.Dependencies["@types/node"],
+		tree.Dependencies["@internal/util"],
+		tree.Dependencies["lodash"].Dependencies["@types/lodash"],
+	} {
+		require.NotNil(t, path)
+		assert.True(t, path.Excluded, path.Name)
+		assert.Empty(t, path.Version, path.Name)
+	}
+	assert.Equal(t, "4.17.21", tree.Dependencies["lodash"].Version)
+	assert.False(t, tree.Dependencies["lodash"].Excluded)
+	for _, req := range registry.Requests() {
+		assert.NotContains(t, req, "@types")
+		assert.NotContains(t, req, "@internal")
+	}
+}
+
+func TestResolutionIsCompleteAndDeterministic(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0", "c": "^1.0.0", "shared": "^1.0.0"})},
+		"a":      {"1.0.0": deps(map[string]string{"shared": "^2.0.0"})},
+		"b":      {"1.0.0": deps(map[string]string{"shared": "^1.0.0"})},
+		"c":      {"1.0.0": deps(map[string]string{"a": "^1.0.0"})},
+		"shared": {"1.5.0": {}, "2.1.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithCacheTTL(0)))
+	defer server.Close()
+
+	first := getTreeFrom(t, server, "/package/app/1.0.0")
+	firstRequests := registry.Requests()
+
+	assert.Equal(t, "1.5.0", first.Dependencies["shared"].Version)
+	assert.Equal(t, "2.1.0", first.Dependencies["a"].Dependencies["shared"].Version)
+	assert.Equal(t, "1.5.0", first.Dependencies["b"].Dependencies["shared"].Version)
+	assert.Equal(t, "2.1.0", first.Dependencies["c"].Dependencies["a"].Dependencies["shared"].Version)
+
+	for i := 0; i < 5; i++ {
+		tree := getTreeFrom(t, server, "/package/app/1.0.0")
+		assert.Equal(t, first, tree)
+		assert.Equal(t, firstRequests, registry.Requests()[len(firstRequests)*(i+1):len(firstRequests)*(i+2)])
+	}
+}
+
+func TestInvalidConstraintSkipsRegistry(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for _, constraint := range []string{">=abc", "^1.0.0%20||%20~", "1.2.3.4"} {
+		resp, err := server.Client().Get(server.URL + "/package/app/" + constraint)
+		require.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Nil(t, err)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, constraint)
+		assert.Contains(t, string(body), "invalid version constraint", constraint)
+	}
+	assert.Empty(t, registry.Requests())
+
+	// Dist-tag names are not rejected up front.
+	resp, err := server.Client().Get(server.URL + "/package/app/latest")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.NotEmpty(t, registry.Requests())
+}
+
+func TestNotFound(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": {}},
+		"broken": {"1.0.0": deps(map[string]string{"app": "^2.0.0"})},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/package/ap/1.0.0")
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Contains(t, p.Detail, "package not found")
+
+	p = getProblem(t, server, "/package/app/^2.0.0")
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, `version not found: no published version of app satisfies "^2.0.0"`, p.Detail)
+
+	// A dependency that cannot be satisfied is the requested package's
+	// fault, not a missing package.
+	assert.Equal(t, http.StatusInternalServerError, getProblem(t, server, "/package/broken/1.0.0").Status)
+}
+
+func TestCircularDependencies(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"a": "^1.0.0", "self": "^1.0.0"})},
+		"a":    {"1.0.0": deps(map[string]string{"b": "^1.0.0"}), "2.0.0": deps(map[string]string{"b": "^1.0.0"})},
+		"b":    {"1.0.0": deps(map[string]string{"a": "^2.0.0", "c": "^1.0.0"})},
+		"c":    {"1.0.0": deps(map[string]string{"b": "^1.0.0"})},
+		"self": {"1.0.0": deps(map[string]string{"self": "^1.0.0"})},
+	})
+
+	tree := getTree(t, registry, "/package/app/1.0.0")
+	// a@2 differs from its ancestor a@1, so only b repeats.
+	b := tree.Dependencies["a"].Dependencies["b"]
+	a2 := b.Dependencies["a"]
+	assert.Equal(t, "2.0.0", a2.Version)
+	assert.False(t, a2.Circular)
+	assert.True(t, a2.Dependencies["b"].Circular)
+	assert.Empty(t, a2.Dependencies["b"].Dependencies)
+	assert.True(t, b.Dependencies["c"].Dependencies["b"].Circular)
+
+	self := tree.Dependencies["self"]
+	assert.False(t, self.Circular)
+	assert.True(t, self.Dependencies["self"].Circular)
+	assert.Equal(t, "1.0.0", self.Dependencies["self"].Version)
+}
+
+func TestDepth(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib":  {"1.0.0": deps(map[string]string{"leaf": "^1.0.0"})},
+		"leaf": {"1.0.0": deps(map[string]string{"deep": "^1.0.0"})},
+		"deep": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0?depth=1")
+	lib := tree.Dependencies["lib"]
+	assert.Equal(t, "1.0.0", lib.Version)
+	assert.False(t, lib.Truncated)
+	leaf := lib.Dependencies["leaf"]
+	assert.True(t, leaf.Truncated)
+	assert.Empty(t, leaf.Version)
+	assert.NotContains(t, registry.Requests(), "/leaf")
+
+	tree = getTreeFrom(t, server, "/package/app/1.0.0?depth=2")
+	assert.Equal(t, "1.0.0", tree.Dependencies["lib"].Dependencies["leaf"].Version)
+	assert.True(t, tree.Dependencies["lib"].Dependencies["leaf"].Dependencies["deep"].Truncated)
+
+	for _, depth := range []string{"0", "-1", "two"} {
+		p := getProblem(t, server, "/package/app/1.0.0?depth="+depth)
+		assert.Equal(t, http.StatusBadRequest, p.Status, depth)
+	}
+}
+
+func TestSeededSelection(t *testing.T) {
+	libVersions := map[string]manifest{}
+	for i := 0; i < 10; i++ {
+		libVersions[fmt.Sprintf("1.%d.0", i)] = manifest{}
+	}
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": libVersions,
+	})
+	selected := func(seed string) string {
+		return getTree(t, registry, "/package/app/1.0.0?seed="+seed).Dependencies["lib"].Version
+	}
+
+	assert.Equal(t, "1.9.0", getTree(t, registry, "/package/app/1.0.0").Dependencies["lib"].Version)
+	assert.Equal(t, selected("42"), selected("42"))
+
+	seen := map[string]bool{}
+	for seed := 0; seed < 20; seed++ {
+		version := selected(fmt.Sprint(seed))
+		assert.Contains(t, libVersions, version)
+		seen[version] = true
+	}
+	assert.Greater(t, len(seen), 1, "different seeds should select different versions")
+}
+
+func TestSelectionOverrides(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"lib": "^1.0.0", "util": "^2.0.0", "pinned": "^3.0.0"})},
+		"lib":    {"1.0.0": {}, "1.5.0": {}},
+		"util":   {"2.0.0": {}, "2.1.0": {}},
+		"pinned": {"3.0.0": {}, "3.1.0": {}, "3.2.0": {}},
+	})
+
+	tree := getTree(t, registry, "/package/app/1.0.0", api.WithSelectionOverrides(map[string]api.SelectionStrategy{
+		"lib":    api.StrategyLowest,
+		"pinned": "3.1.0",
+	}))
+
+	assert.Equal(t, "1.0.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "2.1.0", tree.Dependencies["util"].Version)
+	assert.Equal(t, "3.1.0", tree.Dependencies["pinned"].Version)
+
+	tree = getTree(t, registry, "/package/app/1.0.0",
+		api.WithSelectionStrategy(api.StrategyLowest),
+		api.WithSelectionOverrides(map[string]api.SelectionStrategy{"util": api.StrategyHighest}))
+
+	assert.Equal(t, "1.0.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "2.1.0", tree.Dependencies["util"].Version)
+	assert.Equal(t, "3.0.0", tree.Dependencies["pinned"].Version)
+}
+
+func TestFallbackUnpublished(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "1.2.3"})},
+		"lib": {"1.0.0": {}, "1.4.0": {}, "2.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, err = server.Client().Get(server.URL + "/package/app/1.0.0?fallbackUnpublished=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		api.NpmPackageVersion
+		Warnings []string `json:"warnings"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "1.4.0", body.Dependencies["lib"].Version)
+	assert.Equal(t, []string{"lib@1.2.3 is not available; substituted 1.4.0"}, body.Warnings)
+}
+
+func TestMaxUniquePackages(t *testing.T) {
+	wide := map[string]string{}
+	pkgs := mockRegistry{}
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("leaf-%02d", i)
+		wide[name] = "^1.0.0"
+		pkgs[name] = map[string]manifest{"1.0.0": {}}
+	}
+	pkgs["app"] = map[string]manifest{"1.0.0": deps(wide)}
+	registry := newMockRegistry(t, pkgs)
+
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithMaxUniquePackages(10)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, string(body), "maximum unique packages of 10 exceeded at leaf-09@1.0.0: 11 unique packages resolved")
+	assert.NotContains(t, registry.Requests(), "/leaf-10")
+
+	tree := getTree(t, registry, "/package/app/1.0.0", api.WithMaxUniquePackages(31))
+	assert.Len(t, tree.Dependencies, 30)
+}
+
+func TestStrategyParameter(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"lib": "^1.2.0", "any": "*", "gone": "~2.1.0"})},
+		"lib":    {"1.1.0": {}, "1.2.0": {}, "1.2.5": {}, "1.9.0": {}},
+		"any":    {"0.5.0": {}, "3.0.0": {}},
+		"gone":   {"2.1.3": {}, "2.1.4": {}},
+		"pinned": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(registry.URL),
+		api.WithSelectionOverrides(map[string]api.SelectionStrategy{"any": "3.0.0"}),
+	))
+	defer server.Close()
+
+	tree := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, "1.9.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "2.1.4", tree.Dependencies["gone"].Version)
+
+	tree = getTreeFrom(t, server, "/package/app/1.0.0?strategy=lowest")
+	assert.Equal(t, "1.2.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "2.1.3", tree.Dependencies["gone"].Version)
+	// The server's per-package overrides still apply.
+	assert.Equal(t, "3.0.0", tree.Dependencies["any"].Version)
+
+	// 2.1.0, the version "~2.1.0" is written against, was never published.
+	p := getProblem(t, server, "/package/app/1.0.0?strategy=exact")
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Contains(t, p.Detail, "gone@2.1.0, pinned by")
+	assert.Contains(t, p.Detail, "is not published")
+
+	registry = newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.2.0", "any": "*"})},
+		"lib": {"1.2.0": {}, "1.9.0": {}},
+		"any": {"0.5.0": {}, "3.0.0": {}},
+	})
+	tree = getTree(t, registry, "/package/app/1.0.0?strategy=exact")
+	assert.Equal(t, "1.2.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "0.5.0", tree.Dependencies["any"].Version)
+}
+
+func TestExactStrategyUpperBounds(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":       {"1.0.0": deps(map[string]string{"below": "<2.0.0", "up-to": "<=2.0.0", "up-to-gap": "<=2.0.0"})},
+		"below":     {"1.1.0": {}, "1.5.0": {}, "2.0.0": {}},
+		"up-to":     {"1.1.0": {}, "2.0.0": {}},
+		"up-to-gap": {"1.1.0": {}, "1.9.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	// 2.0.0 is excluded by "<2.0.0", which pins nothing else, so its
+	// lowest compatible version is taken; "<=2.0.0" pins 2.0.0.
+	p := getProblem(t, server, "/package/app/1.0.0?strategy=exact")
+	assert.Contains(t, p.Detail, "up-to-gap@2.0.0, pinned by \"<=2.0.0\", is not published")
+
+	registry = newMockRegistry(t, mockRegistry{
+		"app":   {"1.0.0": deps(map[string]string{"below": "<2.0.0", "up-to": "<=2.0.0"})},
+		"below": {"1.1.0": {}, "1.5.0": {}, "2.0.0": {}},
+		"up-to": {"1.1.0": {}, "2.0.0": {}},
+	})
+	tree := getTree(t, registry, "/package/app/1.0.0?strategy=exact")
+	assert.Equal(t, "1.1.0", tree.Dependencies["below"].Version)
+	assert.Equal(t, "2.0.0", tree.Dependencies["up-to"].Version)
+}
+
+func TestExactStrategyConstraintSpelling(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"lib": "<=1.5.0 >=1.2.0", "tool": "^1.0.0"})},
+		"tool": {"1.0.0": deps(map[string]string{"lib": ">=1.2.0 <=1.5.0"})},
+		"lib":  {"1.2.0": {}, "1.5.0": {}},
+	})
+
+	// The two spellings are equivalent but pin different bounds, whichever
+	// is resolved first.
+	tree := getTree(t, registry, "/package/app/1.0.0?strategy=exact")
+	assert.Equal(t, "1.5.0", tree.Dependencies["lib"].Version)
+	assert.Equal(t, "1.2.0", tree.Dependencies["tool"].Dependencies["lib"].Version)
+}
+
+func TestStrategyParameterErrors(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/package/app/1.0.0?strategy=newest").Status)
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/package/app/1.0.0?strategy=lowest&seed=1").Status)
+}
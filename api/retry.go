@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// getWithRetries is getWithTimeout, retrying transient failures up to the
+// server's retry limit with exponential backoff and jitter.
+func (s *server) getWithRetries(ctx context.Context, url, etag, accept string) (*registryResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := s.getWithTimeout(ctx, url, etag, accept)
+		if err == nil || attempt >= s.retries || !retryable(ctx, err) {
+			return resp, err
+		}
+		delay := s.retryDelay(attempt)
+		s.logger.Warn("Registry request failed, retrying", "url", url, "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns the backoff before retry attempt+1: the base backoff
+// doubled for each earlier attempt, capped, of which a random half is
+// waited, so that replicas retrying together spread out.
+func (s *server) retryDelay(attempt int) time.Duration {
+	delay := s.retryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryable reports whether a failed registry request is worth repeating:
+// it timed out, or the registry answered 429 or a server error. Missing
+// documents, open circuits and requests of a resolution that is already
+// over are not retried.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var regErr *registryError
+	if errors.As(err, &regErr) {
+		return regErr.StatusCode == http.StatusTooManyRequests || regErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// route is an endpoint of the API: how it is served and how the OpenAPI
+// document describes it. Request and response are values of the types
+// the handler decodes and encodes, so the document's schemas follow the
+// code.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	summary string
+	query   []queryParam
+	// request is the JSON body the handler decodes, if any.
+	request any
+	// response is the JSON body of a successful response. Handlers that
+	// answer with something else set mediaType instead.
+	response  any
+	mediaType string
+	// status is that of a successful response, when not 200.
+	status int
+	// deprecated routes are the unversioned aliases of the legacy version.
+	deprecated bool
+	// versioned routes, added since the API was versioned, have no
+	// unversioned alias.
+	versioned bool
+}
+
+// apiVersion is a version of the API, whose routes are served under its
+// prefix. An output change that would break consumers ships as a new
+// version with its own routes, leaving the earlier versions as they were.
+type apiVersion struct {
+	prefix string
+	routes func(s *server) []route
+}
+
+// apiVersions are the versions the server serves.
+var apiVersions = []apiVersion{
+	{prefix: "/v1", routes: (*server).v1Routes},
+}
+
+// legacyVersion is the version the unversioned paths, served before the
+// API was versioned, are deprecated aliases of.
+const legacyVersion = "/v1"
+
+// queryParam documents a query parameter. Values, when set, are the only
+// ones accepted.
+type queryParam struct {
+	name        string
+	kind        string
+	description string
+	values      []string
+}
+
+// resolveParams are the query parameters of every endpoint that resolves
+// a tree, read by parseResolveOptions and resolutionTimeout.
+var resolveParams = []queryParam{
+	{name: "depth", kind: "integer", description: "Leave dependencies more than this many levels below the root unresolved, marked truncated."},
+	{name: "strategy", kind: "string", description: "How versions are selected among those satisfying a range.", values: []string{string(StrategyHighest), string(StrategyLowest), string(StrategyExact)}},
+	{name: "seed", kind: "string", description: "Select a random satisfying version, reproducibly for the same seed."},
+	{name: "stopAt", kind: "string", description: "Leave the dependencies of the named package unresolved."},
+	{name: "excludeScopes", kind: "string", description: "Comma-separated scopes whose packages are left out, marked excluded."},
+	{name: "partialOnTimeout", kind: "boolean", description: "Answer 206 with what was resolved when the resolution times out."},
+	{name: "requireIntegrity", kind: "boolean", description: "Fail if any package version lacks a dist.integrity hash."},
+	{name: "fallbackUnpublished", kind: "boolean", description: "Substitute another release for an unpublished exact version."},
+	{name: "dev", kind: "boolean", description: "Also resolve the root package's devDependencies."},
+	{name: "os", kind: "string", description: "Platform to resolve optional dependencies for, as process.platform names it."},
+	{name: "cpu", kind: "string", description: "Architecture to resolve optional dependencies for, as process.arch names it."},
+	{name: "timeout", kind: "string", description: "Resolution timeout, as a Go duration such as 10s."},
+	{name: "fresh", kind: "boolean", description: "Bypass the caches."},
+	{name: "node", kind: "string", description: "Node.js version to check each package's engines.node range against."},
+	{name: "policy", kind: "string", description: "Check the tree against the license policy: enforce answers 409 if it is violated, report lists the violations.", values: []string{policyEnforce, policyReport}},
+}
+
+// treeParams are the query parameters of endpoints returning a tree.
+var treeParams = []queryParam{
+	{name: "dist", kind: "boolean", description: "Include each package's dist field."},
+	{name: "registry", kind: "boolean", description: "Include the registry each package came from."},
+	{name: "include", kind: "string", description: "Comma-separated fields to include in each package, dist or registry, as the parameters of the same names do."},
+	{name: "audit", kind: "boolean", description: "Scan the tree for known vulnerabilities, listing them on each package with a summary by severity."},
+}
+
+// packageFormats are the values of the package endpoint's format
+// parameter.
+var packageFormats = []string{"nested", "flat", "package-lock", "yarn-lock", "pnpm-lock", "cyclonedx", "spdx", "dot", "mermaid", "csv", "ndjson", "node_modules"}
+
+// routes lists the endpoints the server serves: the unversioned ones,
+// those of every API version under its prefix and the deprecated aliases
+// of the legacy version's.
+func (s *server) routes(metrics *requestMetrics) []route {
+	routes := []route{
+		{method: http.MethodGet, path: "/metrics", handler: metrics.metricsHandler, summary: "Request metrics in the Prometheus text format", mediaType: "text/plain"},
+		{method: http.MethodGet, path: "/openapi.json", handler: s.openAPIHandler, summary: "This OpenAPI document", response: map[string]any{}},
+	}
+	for _, version := range apiVersions {
+		for _, rt := range version.routes(s) {
+			if version.prefix == legacyVersion && !rt.versioned {
+				alias := rt
+				alias.handler = deprecatedAlias(version.prefix, rt.handler)
+				alias.deprecated = true
+				routes = append(routes, alias)
+			}
+			rt.path = version.prefix + rt.path
+			routes = append(routes, rt)
+		}
+	}
+	return routes
+}
+
+// deprecatedAlias serves an unversioned path with the handler of the
+// versioned route it is an alias of, pointing clients at the latter.
+func deprecatedAlias(prefix string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+prefix+r.URL.EscapedPath()+">; rel=\"successor-version\"")
+		handler(w, r)
+	}
+}
+
+// v1Routes lists the endpoints of version 1 of the API.
+func (s *server) v1Routes() []route {
+	packageParams := append(append([]queryParam{
+		{name: "format", kind: "string", description: "Output format; nested, the default, is the JSON tree.", values: packageFormats},
+		{name: "trace", kind: "boolean", description: "List the registry fetches the resolution needed."},
+		{name: "refs", kind: "boolean", description: "Write repeated subtrees once and refer to them elsewhere."},
+		{name: "licenseFilter", kind: "string", description: "List the packages whose license matches this pattern instead of the tree."},
+		{name: "includeUnknown", kind: "boolean", description: "With licenseFilter, also list packages without a known license."},
+	}, treeParams...), resolveParams...)
+	routes := []route{
+		{method: http.MethodGet, path: "/package/{package}/{version}", handler: s.packageHandler, summary: "Resolve the dependency tree of a package version", query: packageParams, response: &treeResponse{}},
+		{method: http.MethodGet, path: "/package/{package}/diff", handler: s.diffHandler, summary: "Compare the trees of two versions of a package", query: append([]queryParam{
+			{name: "from", kind: "string", description: "The version upgraded from."},
+			{name: "to", kind: "string", description: "The version upgraded to."},
+		}, resolveParams...), response: &diffResponse{}, versioned: true},
+		{method: http.MethodGet, path: "/package/{package}/{version}/install-order", handler: s.installOrderHandler, summary: "List a tree's packages with dependencies before their dependents", query: resolveParams, response: &installOrderResponse{}},
+		{method: http.MethodGet, path: "/package/{package}/{version}/attribution", handler: s.attributionHandler, summary: "List the transitive packages each direct dependency brings in alone, and those it shares", query: resolveParams, response: &attributionResponse{}},
+		{method: http.MethodGet, path: "/package/{package}/{version}/stream", handler: s.streamHandler, summary: "Resolve a tree and write it as newline-delimited JSON", query: resolveParams, mediaType: "application/x-ndjson"},
+		{method: http.MethodGet, path: "/package/{package}/{version}/events", handler: s.eventsHandler, summary: "Report a resolution's progress as Server-Sent Events", query: append(append([]queryParam(nil), treeParams...), resolveParams...), mediaType: "text/event-stream"},
+		{method: http.MethodGet, path: "/package/{package}/{version}/maintainers", handler: s.maintainersHandler, summary: "Summarize the maintainers of a tree", query: resolveParams, response: &maintainersResponse{}},
+		{method: http.MethodGet, path: "/package/{package}/{version}/why/{dep...}", handler: s.whyHandler, summary: "List every path from the root of a tree to a dependency", query: resolveParams, response: &whyResponse{}, versioned: true},
+		{method: http.MethodGet, path: "/package/{package}/{version}/size", handler: s.sizeHandler, summary: "Report the tarball and unpacked sizes of a tree", query: append([]queryParam{
+			{name: "top", kind: "integer", description: "How many of the largest packages to list; 10 by default."},
+		}, resolveParams...), response: &sizeResponse{}, versioned: true},
+		{method: http.MethodGet, path: "/package/{package}/{version}/licenses", handler: s.licensesHandler, summary: "List the license of each package in a tree, with a count per license", query: resolveParams, response: &licensesResponse{}, versioned: true},
+		{method: http.MethodGet, path: "/ws", handler: s.socketHandler, summary: "Resolve packages over a WebSocket", status: http.StatusSwitchingProtocols},
+		{method: http.MethodGet, path: "/compare", handler: s.compareHandler, summary: "Compare the trees of two packages", query: append([]queryParam{
+			{name: "a", kind: "string", description: "The first package, as name@version."},
+			{name: "b", kind: "string", description: "The second package, as name@version."},
+		}, resolveParams...), response: &compareResponse{}},
+		{method: http.MethodPost, path: "/resolve", handler: s.manifestHandler, summary: "Resolve the dependencies of a posted package.json", query: append(append([]queryParam(nil), treeParams...), resolveParams...), request: &packageManifest{}, response: &treeResponse{}},
+		{method: http.MethodPost, path: "/packages", handler: s.batchHandler, summary: "Resolve several packages concurrently", query: append(append([]queryParam(nil), treeParams...), resolveParams...), request: []packageRef{}, response: map[string]*batchResult{}, versioned: true},
+		{method: http.MethodPost, path: "/lockfile/analysis", handler: s.lockAnalysisHandler, summary: "Analyze a package-lock.json for outdated, duplicate and deprecated packages", request: &uploadedLock{}, response: &lockAnalysis{}, versioned: true},
+	}
+	if s.adminToken != "" {
+		routes = append(routes,
+			route{method: http.MethodPost, path: "/cache/warm", handler: s.requireAdmin(s.cacheWarmHandler), summary: "Fetch the metadata of packages into the cache", request: &cacheWarmRequest{}, response: &cacheWarmResponse{}},
+			route{method: http.MethodPost, path: "/cache/invalidate", handler: s.requireAdmin(s.cacheInvalidateHandler), summary: "Evict packages and the trees including them from the caches", request: &cacheInvalidateRequest{}, response: &cacheInvalidateResponse{}},
+			route{method: http.MethodGet, path: "/admin/cache", handler: s.requireAdmin(s.cacheStatsHandler), summary: "Report the use of each cache", response: &cacheStatsResponse{}},
+			route{method: http.MethodDelete, path: "/admin/cache", handler: s.requireAdmin(s.cacheFlushHandler), summary: "Empty the in-process and disk caches", response: &cacheFlushResponse{}},
+			route{method: http.MethodDelete, path: "/admin/cache/{package}", handler: s.requireAdmin(s.cachePurgeHandler), summary: "Evict everything cached about a package", response: &cacheInvalidateResponse{}},
+		)
+	}
+	if s.graphql {
+		routes = append(routes,
+			route{method: http.MethodGet, path: "/graphql", handler: s.graphqlHandler, summary: "Run a GraphQL query given as the query parameter", query: []queryParam{
+				{name: "query", kind: "string", description: "The GraphQL query."},
+				{name: "variables", kind: "string", description: "The query's variables, as a JSON object."},
+			}, response: &graphqlResponse{}},
+			route{method: http.MethodPost, path: "/graphql", handler: s.graphqlHandler, summary: "Run a GraphQL query", request: &graphqlRequest{}, response: &graphqlResponse{}},
+		)
+	}
+	return routes
+}
+
+// pattern returns the route's ServeMux pattern.
+func (rt route) pattern() string {
+	return rt.method + " " + rt.path
+}
+
+// pathParams returns the names of the route's path wildcards.
+func (rt route) pathParams() []string {
+	var names []string
+	for _, segment := range strings.Split(rt.path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.TrimSuffix(segment[1:len(segment)-1], "..."))
+		}
+	}
+	return names
+}
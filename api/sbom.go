@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// bomPackages returns the unique installable package versions of a tree,
+// the root among them, keyed by name@version, together with the keys of
+// the package versions each one depends on.
+func bomPackages(root *NpmPackageVersion) (map[string]*NpmPackageVersion, map[string][]string) {
+	packages := map[string]*NpmPackageVersion{}
+	dependsOn := map[string][]string{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		id := pkg.Name + "@" + pkg.Version
+		packages[id] = pkg
+		refs := map[string]bool{}
+		for _, dep := range pkg.Dependencies {
+			if lockable(dep) {
+				refs[dep.Name+"@"+dep.Version] = true
+			}
+		}
+		dependsOn[id] = sortedKeys(refs)
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			dep := pkg.Dependencies[key]
+			if _, seen := packages[dep.Name+"@"+dep.Version]; lockable(dep) && !seen {
+				walk(dep)
+			}
+		}
+	}
+	walk(root)
+	return packages, dependsOn
+}
+
+// distDigest is a digest of a package tarball, hex encoded, with its
+// Subresource Integrity algorithm name, such as "sha512".
+type distDigest struct {
+	algorithm string
+	hex       string
+}
+
+// sriAlgorithms are the hash algorithms npm records integrity with.
+var sriAlgorithms = map[string]bool{"sha1": true, "sha256": true, "sha384": true, "sha512": true}
+
+// distDigests returns the tarball digests of a dist, from its integrity
+// or, failing that, its shasum.
+func distDigests(dist *PackageDist) []distDigest {
+	if dist == nil {
+		return nil
+	}
+	var digests []distDigest
+	for _, sri := range strings.Fields(dist.Integrity) {
+		algorithm, digest, _ := strings.Cut(sri, "-")
+		if !sriAlgorithms[algorithm] {
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(digest); err == nil {
+			digests = append(digests, distDigest{algorithm: algorithm, hex: hex.EncodeToString(sum)})
+		}
+	}
+	if len(digests) == 0 && dist.Shasum != "" {
+		digests = append(digests, distDigest{algorithm: "sha1", hex: dist.Shasum})
+	}
+	return digests
+}
+
+// packageURL returns the purl of an npm package version, such as
+// "pkg:npm/%40babel/core@7.0.0".
+func packageURL(name, version string) string {
+	return "pkg:npm/" + strings.ReplaceAll(name, "@", "%40") + "@" + strings.ReplaceAll(version, "+", "%2B")
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// packageScope returns the scope of a package name, such as "@babel" for
+// "@babel/core", or "" for an unscoped package.
+func packageScope(name string) string {
+	if !strings.HasPrefix(name, "@") {
+		return ""
+	}
+	scope, _, _ := strings.Cut(name, "/")
+	return scope
+}
+
+// registryPath returns the path of a package's metadata on a registry,
+// escaping the slash of a scoped name as registries expect.
+func registryPath(name string) string {
+	return strings.Replace(name, "/", "%2f", 1)
+}
+
+// joinScopedNames lets clients write scoped package names unescaped, as in
+// /v1/package/@babel/core/7.0.0, by escaping the slash between scope and
+// name before the request is routed.
+func joinScopedNames(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		prefix := "/package/@"
+		for _, version := range apiVersions {
+			if strings.HasPrefix(escaped, version.prefix+prefix) {
+				prefix = version.prefix + prefix
+				break
+			}
+		}
+		if rest, ok := strings.CutPrefix(escaped, prefix); ok {
+			scope, name, found := strings.Cut(rest, "/")
+			if found && name != "" && !strings.Contains(strings.ToLower(scope), "%2f") {
+				r = r.WithContext(r.Context())
+				u := *r.URL
+				u.RawPath = prefix + scope + "%2F" + name
+				r.URL = &u
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registriesFor returns the registries to consult, in order, for a
+// package: its scope's registry, if one is configured, followed by the
+// default chain only when scoped fallback is enabled.
+func (s *server) registriesFor(name string) []string {
+	scoped, ok := s.scopeRegistries[packageScope(name)]
+	if !ok {
+		return s.registryURLs
+	}
+	if !s.scopeFallback {
+		return []string{scoped}
+	}
+	return append([]string{scoped}, s.registryURLs...)
+}
+
+// scopedNotFoundError reports a scoped package missing from every
+// registry consulted for it, naming them, so that a package published only
+// publicly or only privately can be told apart from one that does not
+// exist.
+type scopedNotFoundError struct {
+	name       string
+	scope      string
+	registries []string
+	err        error
+}
+
+func (e *scopedNotFoundError) Error() string {
+	if len(e.registries) == 1 {
+		return fmt.Sprintf("%s was not found on %s, the registry for scope %s; it may only be published "+
+			"elsewhere, and other registries are not consulted for this scope unless scoped fallback is enabled",
+			e.name, e.registries[0], e.scope)
+	}
+	return fmt.Sprintf("%s was not found on %s, the registry for scope %s, nor on %s; it is not published on any configured registry",
+		e.name, e.registries[0], e.scope, strings.Join(e.registries[1:], ", "))
+}
+
+func (e *scopedNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// scopedRegistryError reports a failure of a scope's registry other than
+// the package being absent.
+type scopedRegistryError struct {
+	name     string
+	scope    string
+	registry string
+	err      error
+}
+
+func (e *scopedRegistryError) Error() string {
+	return fmt.Sprintf("looking up %s on %s, the registry for scope %s: %v", e.name, e.registry, e.scope, e.err)
+}
+
+func (e *scopedRegistryError) Unwrap() error {
+	return e.err
+}
+
+// explainScopedFailure wraps the error of a failed lookup of a scoped
+// package in one naming the registries consulted.
+func (s *server) explainScopedFailure(name string, registries []string, failedAt int, err error) error {
+	scope := packageScope(name)
+	if _, ok := s.scopeRegistries[scope]; !ok || err == nil {
+		return err
+	}
+	var openErr *errCircuitOpen
+	if errors.As(err, &openErr) || errors.Is(err, errDownloadBudgetExceeded) {
+		return err
+	}
+	if isNotFound(err) {
+		return &scopedNotFoundError{name: name, scope: scope, registries: registries, err: err}
+	}
+	return &scopedRegistryError{name: name, scope: scope, registry: registries[failedAt], err: err}
+}
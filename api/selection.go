@@ -0,0 +1,215 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// SelectionStrategy decides which of the versions compatible with a
+// constraint is selected. Besides the named strategies, a strategy may be
+// an exact version, which is selected if it satisfies the constraint.
+type SelectionStrategy string
+
+const (
+	StrategyHighest SelectionStrategy = "highest"
+	StrategyLowest  SelectionStrategy = "lowest"
+	// StrategyExact selects the version a constraint is written against,
+	// as if it were pinned: 1.2.0 for "^1.2.0".
+	StrategyExact SelectionStrategy = "exact"
+)
+
+// selectVersion picks the concrete version for a constraint, treating a
+// constraint that names one of the package's dist-tags, such as "latest",
+// as that tag's target. Selections are remembered for the rest of the
+// resolution by canonical constraint, so equivalent constraints on the same
+// package are only selected once, except under the exact strategy.
+func (res *resolver) selectVersion(versionConstraint string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	if target, ok := pkgMeta.DistTags[versionConstraint]; ok {
+		return resolveDistTag(versionConstraint, target, pkgMeta)
+	}
+	if isDistTag(versionConstraint) {
+		if versionConstraint != "latest" {
+			return "", fmt.Errorf("%w: %s has no dist-tag %q", errNoCompatibleVersion, pkgMeta.Name, versionConstraint)
+		}
+		// Registries that keep no dist-tags still serve a latest release.
+		versionConstraint = "*"
+	}
+	// The exact strategy pins the version a constraint is written against,
+	// so constraints written differently may select differently however
+	// equivalent they are.
+	key := pkgMeta.Name + "@" + canonicalConstraint(versionConstraint)
+	if res.strategyFor(pkgMeta.Name) == StrategyExact {
+		key = pkgMeta.Name + "@" + versionConstraint
+	}
+	res.mu.Lock()
+	version, ok := res.selected[key]
+	res.mu.Unlock()
+	if ok {
+		return version, nil
+	}
+
+	version, err := res.selectCompatibleVersion(versionConstraint, pkgMeta)
+	if err != nil {
+		return "", err
+	}
+	res.mu.Lock()
+	if res.selected == nil {
+		res.selected = map[string]string{}
+	}
+	res.selected[key] = version
+	res.mu.Unlock()
+	return version, nil
+}
+
+// selectCompatibleVersion picks among the versions satisfying the
+// constraint, as written, since the exact strategy pins the versions it is
+// written against. A per-package strategy override takes precedence over
+// the request's strategy or seed, which take precedence over the server's
+// strategy.
+func (res *resolver) selectCompatibleVersion(versionConstraint string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	if _, overridden := res.strategyOverrides[pkgMeta.Name]; !overridden && res.opts.seed != "" {
+		// Equivalent constraints pick alike, whichever is selected first.
+		return seededCompatibleVersion(res.opts.seed, canonicalConstraint(versionConstraint), pkgMeta)
+	}
+	switch strategy := res.strategyFor(pkgMeta.Name); strategy {
+	case StrategyHighest, "":
+		if res.sortSelection {
+			return highestCompatibleVersion(versionConstraint, pkgMeta)
+		}
+		return maxCompatibleVersion(versionConstraint, pkgMeta)
+	case StrategyLowest:
+		return minCompatibleVersion(versionConstraint, pkgMeta)
+	case StrategyExact:
+		return pinnedCompatibleVersion(versionConstraint, pkgMeta)
+	default:
+		return exactCompatibleVersion(string(strategy), versionConstraint, pkgMeta)
+	}
+}
+
+// strategyFor returns the strategy that selects versions of the named
+// package.
+func (res *resolver) strategyFor(name string) SelectionStrategy {
+	if strategy, ok := res.strategyOverrides[name]; ok {
+		return strategy
+	}
+	if res.opts.strategy != "" {
+		return res.opts.strategy
+	}
+	return res.strategy
+}
+
+// maxCompatibleVersion returns the same version as highestCompatibleVersion
+// with a single pass over the published versions, without collecting and
+// sorting every match.
+func maxCompatibleVersion(constraintStr string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	constraint, err := semver.NewConstraint(constraintStr)
+	if err != nil {
+		return "", err
+	}
+	var best *semver.Version
+	for version := range pkgMeta.Versions {
+		semVer, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if (best == nil || semVer.GreaterThan(best)) && constraint.Check(semVer) {
+			best = semVer
+		}
+	}
+	if best == nil {
+		return "", errNoCompatibleVersion
+	}
+	return best.String(), nil
+}
+
+// minCompatibleVersion returns the lowest published version satisfying
+// the constraint.
+func minCompatibleVersion(constraintStr string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	constraint, err := semver.NewConstraint(constraintStr)
+	if err != nil {
+		return "", err
+	}
+	var best *semver.Version
+	for version := range pkgMeta.Versions {
+		semVer, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if (best == nil || semVer.LessThan(best)) && constraint.Check(semVer) {
+			best = semVer
+		}
+	}
+	if best == nil {
+		return "", errNoCompatibleVersion
+	}
+	return best.String(), nil
+}
+
+// writtenVersionPattern matches the versions a constraint is written
+// against, such as "1.2" in "^1.2" or "2.0.0-rc.1" in ">=2.0.0-rc.1".
+var writtenVersionPattern = regexp.MustCompile(`\d+(\.\d+){0,2}(-[0-9A-Za-z.-]+)?`)
+
+// pinnedCompatibleVersion returns the first version the constraint is
+// written against that it accepts, which must be published. A constraint
+// that names no version it accepts, such as "*" or "<2.0.0", takes its
+// lowest compatible version instead.
+func pinnedCompatibleVersion(constraintStr string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	constraint, err := semver.NewConstraint(constraintStr)
+	if err != nil {
+		return "", err
+	}
+	for _, written := range writtenVersionPattern.FindAllString(constraintStr, -1) {
+		semVer, err := semver.NewVersion(written)
+		if err != nil || !constraint.Check(semVer) {
+			continue
+		}
+		if _, ok := pkgMeta.Versions[semVer.String()]; !ok {
+			return "", fmt.Errorf("%w: %s@%s, pinned by %q, is not published", errNoCompatibleVersion, pkgMeta.Name, semVer, constraintStr)
+		}
+		return semVer.String(), nil
+	}
+	return minCompatibleVersion(constraintStr, pkgMeta)
+}
+
+// exactCompatibleVersion returns pinned if it is published and satisfies
+// the constraint.
+func exactCompatibleVersion(pinned, constraintStr string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	constraint, err := semver.NewConstraint(constraintStr)
+	if err != nil {
+		return "", err
+	}
+	semVer, err := semver.NewVersion(pinned)
+	if err != nil {
+		return "", fmt.Errorf("invalid selection strategy %q for %s", pinned, pkgMeta.Name)
+	}
+	if _, ok := pkgMeta.Versions[semVer.String()]; !ok || !constraint.Check(semVer) {
+		return "", fmt.Errorf("pinned version %s of %s does not satisfy %q", semVer, pkgMeta.Name, constraintStr)
+	}
+	return semVer.String(), nil
+}
+
+// seededCompatibleVersion picks one of the compatible versions, not
+// necessarily the highest, as a function of seed, the package name and the
+// constraint alone, so that the same seed reproduces the same tree
+// regardless of the order in which packages are resolved.
+func seededCompatibleVersion(seed, constraintStr string, pkgMeta *npmPackageMetaResponse) (string, error) {
+	constraint, err := semver.NewConstraint(constraintStr)
+	if err != nil {
+		return "", err
+	}
+	compatible := filterCompatibleVersions(constraint, pkgMeta)
+	if len(compatible) == 0 {
+		return "", errNoCompatibleVersion
+	}
+	sort.Sort(compatible)
+	h := fnv.New64a()
+	for _, part := range []string{seed, pkgMeta.Name, constraintStr} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return compatible[h.Sum64()%uint64(len(compatible))].String(), nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// manyVersions builds a packument with majors × minors × patches releases
+// plus a prerelease of each minor.
+func manyVersions(majors, minors, patches int) *npmPackageMetaResponse {
+	meta := &npmPackageMetaResponse{Versions: map[string]npmPackageResponse{}}
+	for ma := 0; ma < majors; ma++ {
+		for mi := 0; mi < minors; mi++ {
+			for pa := 0; pa < patches; pa++ {
+				meta.Versions[fmt.Sprintf("%d.%d.%d", ma, mi, pa)] = npmPackageResponse{}
+			}
+			meta.Versions[fmt.Sprintf("%d.%d.%d-beta.1", ma, mi, patches)] = npmPackageResponse{}
+		}
+	}
+	meta.Versions["not-a-version"] = npmPackageResponse{}
+	return meta
+}
+
+func TestMaxCompatibleVersionMatchesSort(t *testing.T) {
+	meta := manyVersions(5, 10, 10)
+	for _, constraint := range []string{
+		"*", "^1.2.3", "~2.3.0", ">=1.0.0 <3.0.0", "3.x", "1.2.3", "4.9.10-beta.1",
+		">=4.9.10-beta.0", "^0.0.1", "<0.0.0", "^9.0.0", "1.2.3 || 3.4.5",
+	} {
+		want, wantErr := highestCompatibleVersion(constraint, meta)
+		got, gotErr := maxCompatibleVersion(constraint, meta)
+		assert.Equal(t, want, got, constraint)
+		assert.Equal(t, wantErr, gotErr, constraint)
+	}
+}
+
+func BenchmarkSelectVersionSort(b *testing.B) {
+	meta := manyVersions(20, 20, 10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := highestCompatibleVersion("^10.0.0", meta); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSelectVersionScan(b *testing.B) {
+	meta := manyVersions(20, 20, 10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := maxCompatibleVersion("^10.0.0", meta); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
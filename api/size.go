@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// defaultLargestPackages is how many of the largest packages a size
+	// report lists unless asked for another number.
+	defaultLargestPackages = 10
+	// sizeConcurrency caps the tarball sizes asked for at once.
+	sizeConcurrency = 8
+)
+
+// packageSize is the size of a package version: its tarball, as the
+// registry's Content-Length reports it, and its unpacked size and file
+// count, as its dist metadata records them.
+type packageSize struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	TarballSize  int64  `json:"tarballSize"`
+	UnpackedSize int64  `json:"unpackedSize"`
+	FileCount    int    `json:"fileCount"`
+}
+
+type sizeResponse struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	TarballSize  int64  `json:"tarballSize"`
+	UnpackedSize int64  `json:"unpackedSize"`
+	FileCount    int    `json:"fileCount"`
+	// Largest are the packages with the largest unpacked size.
+	Largest  []packageSize `json:"largest"`
+	Packages []packageSize `json:"packages"`
+	// Unknown are the packages, as name@version, whose tarball size could
+	// not be found out, which the totals leave out.
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// tarballSize asks the registry for the size of a tarball with a HEAD
+// request, which goes through the same limits as the registry requests of
+// resolution.
+func (s *server) tarballSize(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = s.registryRequest(req, func(resp *http.Response) error {
+		size = resp.ContentLength
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// A missing length says nothing of the registry's health, so it is
+	// not the breaker's failure.
+	if size < 0 {
+		return 0, fmt.Errorf("no Content-Length for %s", url)
+	}
+	return size, nil
+}
+
+// sizeTree reports the sizes of the unique package versions of a tree,
+// their totals and the top largest of them.
+func (s *server) sizeTree(ctx context.Context, root *NpmPackageVersion, top int) *sizeResponse {
+	packages, _ := bomPackages(root)
+	ids := sortedKeys(packages)
+	sizes := make([]packageSize, len(ids))
+	known := make([]bool, len(ids))
+	var wg sync.WaitGroup
+	sem := newSemaphore(sizeConcurrency)
+	for i, id := range ids {
+		pkg := packages[id]
+		sizes[i] = packageSize{Name: pkg.Name, Version: pkg.Version}
+		if pkg.Dist == nil {
+			continue
+		}
+		sizes[i].UnpackedSize, sizes[i].FileCount = pkg.Dist.UnpackedSize, pkg.Dist.FileCount
+		if pkg.Dist.Tarball == "" {
+			continue
+		}
+		// Once the request is gone, the remaining sizes are left unknown.
+		if err := sem.acquire(ctx); err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, tarball string) {
+			defer wg.Done()
+			defer sem.release()
+			size, err := s.tarballSize(ctx, tarball)
+			if err != nil {
+				s.logger.Info("Tarball size unknown", "tarball", tarball, "error", err)
+				return
+			}
+			sizes[i].TarballSize, known[i] = size, true
+		}(i, pkg.Dist.Tarball)
+	}
+	wg.Wait()
+
+	resp := &sizeResponse{Name: root.Name, Version: root.Version, Packages: sizes}
+	for i, size := range sizes {
+		resp.TarballSize += size.TarballSize
+		resp.UnpackedSize += size.UnpackedSize
+		resp.FileCount += size.FileCount
+		if !known[i] {
+			resp.Unknown = append(resp.Unknown, ids[i])
+		}
+	}
+	largest := append([]packageSize(nil), sizes...)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].UnpackedSize > largest[j].UnpackedSize })
+	resp.Largest = largest[:min(top, len(largest))]
+	return resp
+}
+
+// sizeHandler reports how much installing a package's tree downloads and
+// takes up on disk, and which packages contribute most. ?top=N sets how
+// many of the largest are listed.
+func (s *server) sizeHandler(w http.ResponseWriter, r *http.Request) {
+	top := defaultLargestPackages
+	if value := r.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s.badRequest(w, r, fmt.Sprintf("Invalid top %q: expected a non-negative integer", value))
+			return
+		}
+		top = n
+	}
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	body := s.sizeTree(r.Context(), rootPkg, top)
+	if r.Context().Err() != nil {
+		s.logger.Info("Client went away during size report", "package", rootPkg.Name, "version", rootPkg.Version)
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, body) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "packages", len(body.Packages))
+	}
+}
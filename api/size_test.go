@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type packageSize struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	TarballSize  int64  `json:"tarballSize"`
+	UnpackedSize int64  `json:"unpackedSize"`
+	FileCount    int    `json:"fileCount"`
+}
+
+func TestSize(t *testing.T) {
+	tarballSizes := map[string]int{"/app-1.0.0.tgz": 40, "/a-1.0.0.tgz": 1200}
+	tarballs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, ok := tarballSizes[r.URL.Path]
+		if r.Method != http.MethodHead || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+	}))
+	defer tarballs.Close()
+	dist := func(tarball string, unpacked, files int) map[string]any {
+		return map[string]any{"tarball": tarballs.URL + tarball, "unpackedSize": unpacked, "fileCount": files}
+	}
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": {"dist": dist("/app-1.0.0.tgz", 100, 2), "dependencies": map[string]string{"a": "^1.0.0", "b": "^1.0.0"}}},
+		"a":   {"1.0.0": {"dist": dist("/a-1.0.0.tgz", 5000, 30), "dependencies": map[string]string{"b": "^1.0.0"}}},
+		"b":   {"1.0.0": {"dist": dist("/missing.tgz", 300, 4)}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0/size?top=2")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		TarballSize  int64         `json:"tarballSize"`
+		UnpackedSize int64         `json:"unpackedSize"`
+		FileCount    int           `json:"fileCount"`
+		Largest      []packageSize `json:"largest"`
+		Packages     []packageSize `json:"packages"`
+		Unknown      []string      `json:"unknown"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, []packageSize{
+		{Name: "a", Version: "1.0.0", TarballSize: 1200, UnpackedSize: 5000, FileCount: 30},
+		{Name: "app", Version: "1.0.0", TarballSize: 40, UnpackedSize: 100, FileCount: 2},
+		{Name: "b", Version: "1.0.0", UnpackedSize: 300, FileCount: 4},
+	}, body.Packages)
+	assert.Equal(t, int64(1240), body.TarballSize)
+	assert.Equal(t, int64(5400), body.UnpackedSize)
+	assert.Equal(t, 36, body.FileCount)
+	assert.Equal(t, []string{"a", "b"}, []string{body.Largest[0].Name, body.Largest[1].Name})
+	assert.Equal(t, []string{"b@1.0.0"}, body.Unknown)
+
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/v1/package/app/1.0.0/size?top=many").Status)
+}
+
+func TestSizeBoundsTarballRequests(t *testing.T) {
+	const width = 30
+	var mu sync.Mutex
+	inFlight, maxInFlight, served := 0, 0, 0
+	tarballs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		served++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer tarballs.Close()
+	rootDeps := map[string]string{}
+	pkgs := mockRegistry{}
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("dep-%d", i)
+		rootDeps[name] = "^1.0.0"
+		pkgs[name] = map[string]manifest{"1.0.0": {"dist": map[string]any{"tarball": tarballs.URL + "/" + name + ".tgz"}}}
+	}
+	pkgs["app"] = map[string]manifest{"1.0.0": deps(rootDeps)}
+	registry := newMockRegistry(t, pkgs)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0/size")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		TarballSize int64 `json:"tarballSize"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, int64(10*width), body.TarballSize)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, width, served)
+	// At most eight tarball sizes are asked for at once.
+	assert.LessOrEqual(t, maxInFlight, 8)
+	assert.Greater(t, maxInFlight, 1)
+}
+
+func TestSizeTarballServerErrorsOpenCircuitBreaker(t *testing.T) {
+	var mu sync.Mutex
+	heads := 0
+	tarballs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		heads++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer tarballs.Close()
+	dist := map[string]any{"tarball": tarballs.URL + "/app-1.0.0.tgz"}
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {"dist": dist}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithCircuitBreaker(2, time.Minute)))
+	defer server.Close()
+
+	getSize := func() {
+		resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0/size")
+		require.Nil(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	getSize()
+	getSize()
+	// The two server errors open the tarball host's breaker, which turns
+	// away the third request.
+	getSize()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, heads)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// socketRequest is a message from a WebSocket client asking for a package
+// to be resolved. Options are the query parameters of the package
+// endpoint, such as "depth" to expand a branch a level at a time.
+type socketRequest struct {
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// socketMessage is a message to a WebSocket client about the request with
+// the same id: a progress message per resolved package, then either the
+// complete tree or a problem.
+type socketMessage struct {
+	ID       json.RawMessage `json:"id,omitempty"`
+	Type     string          `json:"type"`
+	Progress *progressEvent  `json:"progress,omitempty"`
+	Tree     *treeResponse   `json:"tree,omitempty"`
+	Problem  json.RawMessage `json:"problem,omitempty"`
+}
+
+// problemRecorder is the http.ResponseWriter a resolution requested over a
+// WebSocket writes its problem, if any, to.
+type problemRecorder struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *problemRecorder) Header() http.Header            { return rec.header }
+func (rec *problemRecorder) WriteHeader(int)                {}
+func (rec *problemRecorder) Write(data []byte) (int, error) { return rec.body.Write(data) }
+
+// socketHandler serves resolutions over a WebSocket: the client sends
+// socketRequests, one at a time or several in a row, and gets progress as
+// each is resolved and then its tree, all over the one connection. The
+// connection is read while requests are resolved, so that a client
+// closing it, or failing it, cancels the resolution under way.
+func (s *server) socketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, ok := s.upgradeWebSocket(w, r)
+	if !ok {
+		return
+	}
+	// The request's context is not cancelled once the connection is taken
+	// over; the reading goroutine cancels this one when it stops.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		defer cancel()
+		for {
+			data, err := conn.readMessage()
+			if err != nil {
+				s.closeSocket(conn, err)
+				return
+			}
+			select {
+			case messages <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for data := range messages {
+		if err := s.serveSocketRequest(ctx, r, conn, data); err != nil {
+			s.logger.Info("WebSocket write failed", "error", err)
+			conn.conn.Close()
+			return
+		}
+	}
+}
+
+// closeSocket closes the connection after reading from it failed with err,
+// with a close frame if the client broke the protocol or went idle.
+func (s *server) closeSocket(conn *wsConn, err error) {
+	var (
+		protocolErr *wsProtocolError
+		netErr      net.Error
+	)
+	switch {
+	case errors.As(err, &protocolErr):
+		s.logger.Info("Closing WebSocket", "error", err)
+		conn.close(protocolErr.code, protocolErr.reason)
+	case errors.Is(err, errWebSocketClosed):
+	case errors.As(err, &netErr) && netErr.Timeout():
+		conn.close(wsCloseGoingAway, "idle timeout")
+	case errors.Is(err, io.EOF):
+		conn.conn.Close()
+	default:
+		s.logger.Info("WebSocket read failed", "error", err)
+		conn.conn.Close()
+	}
+}
+
+// serveSocketRequest resolves the package a client asked for in data and
+// sends it the results. The resolution ends early once ctx is done.
+func (s *server) serveSocketRequest(ctx context.Context, r *http.Request, conn *wsConn, data []byte) error {
+	send := func(msg socketMessage) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return conn.writeText(data)
+	}
+	var req socketRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Name == "" || req.Version == "" {
+		problem, _ := json.Marshal(newProblem(problemBadRequest, http.StatusBadRequest, "Expected a JSON message with a name and a version"))
+		return send(socketMessage{ID: req.ID, Type: "error", Problem: problem})
+	}
+
+	query := url.Values{}
+	for name, value := range req.Options {
+		query.Set(name, value)
+	}
+	var progress progressCounter
+	var sendErr error
+	ctx = withVisitor(ctx, func(pkg *NpmPackageVersion) {
+		if pkg.Version == "" || sendErr != nil {
+			return
+		}
+		event := progress.next(pkg)
+		sendErr = send(socketMessage{ID: req.ID, Type: "progress", Progress: &event})
+	})
+	rootPkg, res, problem := s.resolvePackage(ctx, r, req.Name, req.Version, query)
+	if sendErr != nil {
+		return sendErr
+	}
+	if rootPkg == nil {
+		if problem == nil {
+			return nil
+		}
+		return send(socketMessage{ID: req.ID, Type: "error", Problem: problem})
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "transport", "websocket")
+	return send(socketMessage{ID: req.ID, Type: "complete", Tree: newTreeResponse(query, rootPkg, res)})
+}
+
+// resolvePackage resolves a package as a request to the package endpoint
+// with query would, for requests naming packages in their body rather
+// than their path. A failed resolution returns the compacted problem
+// details instead, or nothing if the client of r went away.
+func (s *server) resolvePackage(ctx context.Context, r *http.Request, name, version string, query url.Values) (*NpmPackageVersion, *resolver, json.RawMessage) {
+	rec := &problemRecorder{header: http.Header{}}
+	rootPkg, res := s.resolveRequest(ctx, rec, packageRequest(ctx, r, name, version, query))
+	if rootPkg != nil {
+		return rootPkg, res, nil
+	}
+	var problem bytes.Buffer
+	if err := json.Compact(&problem, rec.body.Bytes()); err != nil {
+		return nil, nil, nil
+	}
+	return nil, nil, problem.Bytes()
+}
+
+// packageRequest returns r as a request to the package endpoint for the
+// named package, with query.
+func packageRequest(ctx context.Context, r *http.Request, name, version string, query url.Values) *http.Request {
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL = &url.URL{Path: "/package/" + name + "/" + version, RawQuery: query.Encode()}
+	req.RequestURI = req.URL.RequestURI()
+	req.SetPathValue("package", name)
+	req.SetPathValue("version", version)
+	return req
+}
@@ -0,0 +1,198 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+// wsClient is just enough of a WebSocket client to talk to the server.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.Nil(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// The example key and accept value of RFC 6455.
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &wsClient{conn: conn, r: r}
+}
+
+func (c *wsClient) send(t *testing.T, opcode byte, payload []byte) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | 126}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.Nil(t, err)
+}
+
+func (c *wsClient) receive(t *testing.T) (opcode byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	_, err := io.ReadFull(c.r, header[:])
+	require.Nil(t, err)
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	require.Nil(t, err)
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.r, payload)
+	require.Nil(t, err)
+	return header[0] & 0x0f, payload
+}
+
+type socketMessage struct {
+	ID       json.RawMessage `json:"id"`
+	Type     string          `json:"type"`
+	Progress *struct {
+		Name  string `json:"name"`
+		Depth int    `json:"depth"`
+	} `json:"progress"`
+	Tree    *api.NpmPackageVersion `json:"tree"`
+	Problem *struct {
+		Status int `json:"status"`
+	} `json:"problem"`
+}
+
+func (c *wsClient) receiveMessage(t *testing.T) socketMessage {
+	t.Helper()
+	opcode, payload := c.receive(t)
+	require.Equal(t, byte(0x1), opcode)
+	var msg socketMessage
+	require.Nil(t, json.Unmarshal(payload, &msg))
+	return msg
+}
+
+func TestWebSocketResolvesRequests(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"web": "^1.0.0"})},
+		"web":  {"1.0.0": deps(map[string]string{"http": "^1.0.0"})},
+		"http": {"1.0.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+	client := dialWebSocket(t, server, "/ws")
+
+	client.send(t, 0x1, []byte(`{"id": 1, "name": "app", "version": "1.0.0", "options": {"depth": "1"}}`))
+	first, second := client.receiveMessage(t), client.receiveMessage(t)
+	assert.Equal(t, "progress", first.Type)
+	assert.Equal(t, "app", first.Progress.Name)
+	assert.Equal(t, "web", second.Progress.Name)
+	assert.Equal(t, 1, second.Progress.Depth)
+	complete := client.receiveMessage(t)
+	assert.Equal(t, "complete", complete.Type)
+	assert.JSONEq(t, "1", string(complete.ID))
+	assert.Equal(t, getTreeFrom(t, server, "/package/app/1.0.0?depth=1"), complete.Tree)
+
+	// Expand the truncated branch on the same connection.
+	client.send(t, 0x9, []byte("ping"))
+	opcode, payload := client.receive(t)
+	assert.Equal(t, byte(0xa), opcode)
+	assert.Equal(t, "ping", string(payload))
+	client.send(t, 0x1, []byte(`{"id": "web", "name": "web", "version": "1.0.0"}`))
+	for msg := client.receiveMessage(t); ; msg = client.receiveMessage(t) {
+		assert.JSONEq(t, `"web"`, string(msg.ID))
+		if msg.Type == "complete" {
+			assert.Equal(t, "1.0.0", msg.Tree.Dependencies["http"].Version)
+			break
+		}
+	}
+
+	client.send(t, 0x1, []byte(`{"id": 3, "name": "missing", "version": "1.0.0"}`))
+	failed := client.receiveMessage(t)
+	assert.Equal(t, "error", failed.Type)
+	assert.Equal(t, http.StatusNotFound, failed.Problem.Status)
+
+	client.send(t, 0x1, []byte(`not json`))
+	assert.Equal(t, http.StatusBadRequest, client.receiveMessage(t).Problem.Status)
+
+	client.send(t, 0x8, binary.BigEndian.AppendUint16(nil, 1000))
+	opcode, payload = client.receive(t)
+	assert.Equal(t, byte(0x8), opcode)
+	assert.Equal(t, uint16(1000), binary.BigEndian.Uint16(payload))
+}
+
+func TestWebSocketRejectsPlainRequests(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/ws").Status)
+}
+
+func TestWebSocketRejectsInvalidControlFrames(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	for name, frame := range map[string]func(c *wsClient){
+		"oversized ping": func(c *wsClient) { c.send(t, 0x9, []byte(strings.Repeat("x", 126))) },
+		"fragmented ping": func(c *wsClient) {
+			// A ping without FIN, with an empty masked payload.
+			_, err := c.conn.Write([]byte{0x09, 0x80, 0, 0, 0, 0})
+			require.Nil(t, err)
+		},
+	} {
+		client := dialWebSocket(t, server, "/ws")
+		frame(client)
+		opcode, payload := client.receive(t)
+		assert.Equal(t, byte(0x8), opcode, name)
+		assert.Equal(t, uint16(1002), binary.BigEndian.Uint16(payload), name)
+	}
+}
+
+func TestWebSocketCloseCancelsResolution(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":  {"1.0.0": deps(map[string]string{"web": "^1.0.0"})},
+		"web":  {"1.0.0": deps(map[string]string{"http": "^1.0.0"})},
+		"http": {"1.0.0": {}},
+	})
+	registry.delay = 100 * time.Millisecond
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+	client := dialWebSocket(t, server, "/ws")
+
+	client.send(t, 0x1, []byte(`{"id": 1, "name": "app", "version": "1.0.0"}`))
+	assert.Equal(t, "progress", client.receiveMessage(t).Type)
+	client.send(t, 0x8, binary.BigEndian.AppendUint16(nil, 1000))
+	opcode, _ := client.receive(t)
+	assert.Equal(t, byte(0x8), opcode)
+
+	// The fetch of web under way when the client left is finished, but
+	// resolution goes no further.
+	time.Sleep(300 * time.Millisecond)
+	assert.NotContains(t, registry.Requests(), "/web/1.0.0")
+}
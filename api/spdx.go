@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spdxNoAssertion is SPDX's value for a field the document makes no claim
+// about.
+const spdxNoAssertion = "NOASSERTION"
+
+// spdxDocument is an SPDX 2.3 document, in its JSON encoding.
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	DocumentDescribes []string           `json:"documentDescribes"`
+	Packages          []*spdxPackage     `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxIDUnsafe matches the characters an SPDX identifier may not contain.
+var spdxIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// newSPDXDocument describes the tree as an SPDX document: every unique
+// package version as a package, the root as the one the document
+// describes, and a DEPENDS_ON relationship for every dependency.
+func newSPDXDocument(root *NpmPackageVersion) *spdxDocument {
+	packages, dependsOn := bomPackages(root)
+	ids := map[string]string{}
+	taken := map[string]bool{}
+	for _, key := range sortedKeys(packages) {
+		// Names that differ only in unsafe characters get numbered.
+		base := "SPDXRef-Package-" + strings.Trim(spdxIDUnsafe.ReplaceAllString(key, "-"), "-")
+		id := base
+		for n := 2; taken[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		taken[id] = true
+		ids[key] = id
+	}
+
+	rootID := ids[root.Name+"@"+root.Version]
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              root.Name + "@" + root.Version,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + strings.ReplaceAll(root.Name, "/", "-") + "-" + root.Version + "-" + newUUID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: npm_packages"},
+		},
+		DocumentDescribes: []string{rootID},
+		Relationships:     []spdxRelationship{{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: rootID}},
+	}
+	for _, key := range sortedKeys(packages) {
+		doc.Packages = append(doc.Packages, newSPDXPackage(ids[key], packages[key]))
+		for _, dep := range dependsOn[key] {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: ids[key], RelationshipType: "DEPENDS_ON", RelatedSPDXElement: ids[dep]})
+		}
+	}
+	return doc
+}
+
+func newSPDXPackage(id string, pkg *NpmPackageVersion) *spdxPackage {
+	p := &spdxPackage{
+		Name:             pkg.Name,
+		SPDXID:           id,
+		VersionInfo:      pkg.Version,
+		DownloadLocation: spdxNoAssertion,
+		LicenseConcluded: spdxNoAssertion,
+		LicenseDeclared:  spdxNoAssertion,
+		CopyrightText:    spdxNoAssertion,
+		ExternalRefs: []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  packageURL(pkg.Name, pkg.Version),
+		}},
+	}
+	if pkg.License != "" {
+		p.LicenseDeclared = pkg.License
+	}
+	if pkg.Dist != nil && pkg.Dist.Tarball != "" {
+		p.DownloadLocation = pkg.Dist.Tarball
+	}
+	for _, digest := range distDigests(pkg.Dist) {
+		p.Checksums = append(p.Checksums, spdxChecksum{Algorithm: strings.ToUpper(digest.algorithm), ChecksumValue: digest.hex})
+	}
+	return p
+}
+
+// spdxHandler answers ?format=spdx with the resolved tree as an SPDX
+// document.
+func (s *server) spdxHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	if s.writeJSON(w, status, newSPDXDocument(rootPkg)) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "spdx")
+	}
+}
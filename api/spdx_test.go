@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo"`
+	DownloadLocation string `json:"downloadLocation"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	Checksums        []struct {
+		Algorithm     string `json:"algorithm"`
+		ChecksumValue string `json:"checksumValue"`
+	} `json:"checksums"`
+	ExternalRefs []struct {
+		ReferenceType    string `json:"referenceType"`
+		ReferenceLocator string `json:"referenceLocator"`
+	} `json:"externalRefs"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	SPDXID            string         `json:"SPDXID"`
+	DocumentDescribes []string       `json:"documentDescribes"`
+	Packages          []*spdxPackage `json:"packages"`
+	Relationships     []struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	} `json:"relationships"`
+}
+
+func TestSPDXFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": manifest{"license": "MIT", "dependencies": map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0"}}},
+		"a": {"1.0.0": manifest{
+			"licenses":     []map[string]string{{"type": "MIT"}, {"type": "Apache-2.0"}},
+			"dependencies": map[string]string{"shared": "^1.0.0"},
+			"dist":         map[string]string{"tarball": "https://registry.test/a/-/a-1.0.0.tgz", "integrity": "sha512-AAEC"},
+		}},
+		"@scope/c": {"2.1.0": manifest{"dependencies": map[string]string{"shared": "^1.0.0"}}},
+		"shared":   {"1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=spdx")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var doc spdxDocument
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&doc))
+
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, "SPDXRef-DOCUMENT", doc.SPDXID)
+	assert.Equal(t, []string{"SPDXRef-Package-app-1.0.0"}, doc.DocumentDescribes)
+
+	packages := map[string]*spdxPackage{}
+	for _, pkg := range doc.Packages {
+		assert.Regexp(t, `^SPDXRef-[A-Za-z0-9.-]+$`, pkg.SPDXID)
+		packages[pkg.SPDXID] = pkg
+	}
+	assert.ElementsMatch(t, []string{
+		"SPDXRef-Package-app-1.0.0",
+		"SPDXRef-Package-a-1.0.0",
+		"SPDXRef-Package-scope-c-2.1.0",
+		"SPDXRef-Package-shared-1.2.0",
+	}, keys(packages))
+
+	a := packages["SPDXRef-Package-a-1.0.0"]
+	assert.Equal(t, "(MIT OR Apache-2.0)", a.LicenseDeclared)
+	assert.Equal(t, "https://registry.test/a/-/a-1.0.0.tgz", a.DownloadLocation)
+	assert.Equal(t, "SHA512", a.Checksums[0].Algorithm)
+	assert.Equal(t, "000102", a.Checksums[0].ChecksumValue)
+
+	c := packages["SPDXRef-Package-scope-c-2.1.0"]
+	assert.Equal(t, "@scope/c", c.Name)
+	assert.Equal(t, "NOASSERTION", c.LicenseDeclared)
+	assert.Equal(t, "NOASSERTION", c.DownloadLocation)
+	assert.Equal(t, "purl", c.ExternalRefs[0].ReferenceType)
+	assert.Equal(t, "pkg:npm/%40scope/c@2.1.0", c.ExternalRefs[0].ReferenceLocator)
+
+	var relationships []string
+	for _, rel := range doc.Relationships {
+		relationships = append(relationships, rel.SPDXElementID+" "+rel.RelationshipType+" "+rel.RelatedSPDXElement)
+	}
+	assert.ElementsMatch(t, []string{
+		"SPDXRef-DOCUMENT DESCRIBES SPDXRef-Package-app-1.0.0",
+		"SPDXRef-Package-app-1.0.0 DEPENDS_ON SPDXRef-Package-scope-c-2.1.0",
+		"SPDXRef-Package-app-1.0.0 DEPENDS_ON SPDXRef-Package-a-1.0.0",
+		"SPDXRef-Package-scope-c-2.1.0 DEPENDS_ON SPDXRef-Package-shared-1.2.0",
+		"SPDXRef-Package-a-1.0.0 DEPENDS_ON SPDXRef-Package-shared-1.2.0",
+	}, relationships)
+}
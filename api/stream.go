@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// streamNode is one line of the streamed tree. Nodes are numbered in the
+// order they are written; parentId refers to an earlier line, so the tree
+// can be rebuilt incrementally even where the same name@version appears
+// under several parents.
+type streamNode struct {
+	ID         int    `json:"id"`
+	ParentID   *int   `json:"parentId,omitempty"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Parent     string `json:"parent"`
+	Excluded   bool   `json:"excluded,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Circular   bool   `json:"circular,omitempty"`
+	Dev        bool   `json:"dev,omitempty"`
+	Optional   bool   `json:"optional,omitempty"`
+	Skipped    string `json:"skipped,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	Unresolved string `json:"unresolved,omitempty"`
+}
+
+// nodeStream writes packages as newline-delimited JSON, numbering each
+// package the first time it is written and flushing after every line.
+// It is also the http.ResponseWriter of the request being streamed, so
+// that an error response written after the first line becomes a final
+// {"error": problem} line rather than a second response.
+type nodeStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	enc     *json.Encoder
+
+	mu      sync.Mutex
+	ids     map[*NpmPackageVersion]int
+	started bool
+	err     error
+}
+
+func newNodeStream(w http.ResponseWriter) *nodeStream {
+	flusher, _ := w.(http.Flusher)
+	return &nodeStream{w: w, flusher: flusher, enc: json.NewEncoder(w), ids: map[*NpmPackageVersion]int{}}
+}
+
+func (st *nodeStream) Header() http.Header {
+	return st.w.Header()
+}
+
+func (st *nodeStream) WriteHeader(status int) {
+	if !st.started {
+		st.w.WriteHeader(status)
+	}
+}
+
+func (st *nodeStream) Write(data []byte) (int, error) {
+	if !st.started {
+		return st.w.Write(data)
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, data); err != nil {
+		return 0, err
+	}
+	if _, err := st.w.Write([]byte(`{"error":` + line.String() + "}\n")); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// write writes pkg, required by parent, as the next line. A package
+// written again, as when an optional dependency is skipped after it was
+// selected, keeps its id, and its new line supersedes the earlier one.
+func (st *nodeStream) write(pkg, parent *NpmPackageVersion) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil {
+		return
+	}
+	if !st.started {
+		st.started = true
+		st.w.Header().Set("Content-Type", "application/x-ndjson")
+		st.w.WriteHeader(http.StatusOK)
+	}
+	id, ok := st.ids[pkg]
+	if !ok {
+		id = len(st.ids)
+		st.ids[pkg] = id
+	}
+	line := streamNode{
+		ID:         id,
+		Name:       pkg.Name,
+		Version:    pkg.Version,
+		Excluded:   pkg.Excluded,
+		Truncated:  pkg.Truncated,
+		Circular:   pkg.Circular || circular(pkg),
+		Dev:        pkg.Dev,
+		Optional:   pkg.Optional,
+		Skipped:    pkg.Skipped,
+		Alias:      pkg.Alias,
+		Unresolved: pkg.Unresolved,
+	}
+	if parent != nil {
+		parentID := st.ids[parent]
+		line.ParentID = &parentID
+		line.Parent = parent.Name + "@" + parent.Version
+	}
+	if st.err = st.enc.Encode(line); st.err != nil {
+		return
+	}
+	if st.flusher != nil {
+		st.flusher.Flush()
+	}
+}
+
+// writeTree writes pkg and everything beneath it, parents before their
+// children and siblings in name order.
+func (st *nodeStream) writeTree(pkg, parent *NpmPackageVersion) {
+	st.write(pkg, parent)
+	for _, name := range sortedKeys(pkg.Dependencies) {
+		st.writeTree(pkg.Dependencies[name], pkg)
+	}
+}
+
+// count returns the number of packages written.
+func (st *nodeStream) count() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.ids)
+}
+
+type visitorKey struct{}
+
+// withVisitor returns a context under which a resolution calls visit with
+// each package as soon as its version is selected, before its
+// dependencies are resolved, and with each dependency left out of the
+// tree once it is. Packages are visited parents first.
+func withVisitor(ctx context.Context, visit func(pkg *NpmPackageVersion)) context.Context {
+	return context.WithValue(ctx, visitorKey{}, visit)
+}
+
+// hasVisitor reports whether ctx carries a visitor.
+func hasVisitor(ctx context.Context) bool {
+	_, ok := ctx.Value(visitorKey{}).(func(*NpmPackageVersion))
+	return ok
+}
+
+// visit passes pkg to the visitor carried by ctx, if any.
+func visit(ctx context.Context, pkg *NpmPackageVersion) {
+	if fn, ok := ctx.Value(visitorKey{}).(func(*NpmPackageVersion)); ok {
+		fn(pkg)
+	}
+}
+
+// streamHandler writes the resolved tree as newline-delimited JSON, one
+// node per line, parents before their children and siblings in name order,
+// flushing as it goes instead of buffering one nested document.
+func (s *server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, _ := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+
+	st := newNodeStream(w)
+	st.writeTree(rootPkg, nil)
+	if st.err != nil {
+		s.logger.Error("Error writing response", "error", st.err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "nodes", st.count())
+}
+
+// ndjsonHandler answers ?format=ndjson by streaming each package as
+// newline-delimited JSON while the tree is still being resolved, so the
+// first bytes go out long before a large tree is complete. Lines are as
+// /stream writes them, but in resolution order, and a package is written
+// again if it is later left out of the tree. A tree served from the cache
+// is written as /stream writes it.
+func (s *server) ndjsonHandler(w http.ResponseWriter, r *http.Request) {
+	st := newNodeStream(w)
+	ctx := withVisitor(r.Context(), func(pkg *NpmPackageVersion) { st.write(pkg, pkg.parent) })
+	rootPkg, _ := s.resolveRequest(ctx, st, r)
+	if rootPkg == nil {
+		return
+	}
+	if st.count() == 0 {
+		st.writeTree(rootPkg, nil)
+	}
+	if st.err != nil {
+		s.logger.Error("Error writing response", "error", st.err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "ndjson", "nodes", st.count())
+}
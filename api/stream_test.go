@@ -0,0 +1,60 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestStreamRebuildsTree(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":    {"1.0.0": deps(map[string]string{"web": "^1.0.0", "cli": "^1.0.0"})},
+		"web":    {"1.0.0": deps(map[string]string{"http": "^1.0.0"})},
+		"cli":    {"1.0.0": deps(map[string]string{"http": "^1.0.0", "args": "^2.0.0"})},
+		"http":   {"1.0.0": deps(map[string]string{"buffer": "^1.0.0"})},
+		"args":   {"2.0.0": {}},
+		"buffer": {"1.1.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0/stream")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	nodes := map[int]*api.NpmPackageVersion{}
+	var root *api.NpmPackageVersion
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line struct {
+			ID       int    `json:"id"`
+			ParentID *int   `json:"parentId"`
+			Name     string `json:"name"`
+			Version  string `json:"version"`
+			Parent   string `json:"parent"`
+		}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		node := &api.NpmPackageVersion{Name: line.Name, Version: line.Version, Dependencies: map[string]*api.NpmPackageVersion{}}
+		nodes[line.ID] = node
+		if line.ParentID == nil {
+			assert.Empty(t, line.Parent)
+			root = node
+			continue
+		}
+		parent, ok := nodes[*line.ParentID]
+		require.True(t, ok, "parent of %s written after it", line.Name)
+		assert.Equal(t, parent.Name+"@"+parent.Version, line.Parent)
+		parent.Dependencies[line.Name] = node
+	}
+	require.Nil(t, scanner.Err())
+
+	assert.Equal(t, getTreeFrom(t, server, "/package/app/1.0.0"), root)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// resolutionTimeout returns how long the resolution of r may take: the
+// server's limit, shortened by any ?timeout= the client asks for. Zero
+// means no limit.
+func (s *server) resolutionTimeout(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("timeout")
+	if raw == "" {
+		return s.resolveTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: expected a positive duration such as 30s", raw)
+	}
+	if s.resolveTimeout > 0 && timeout > s.resolveTimeout {
+		timeout = s.resolveTimeout
+	}
+	return timeout, nil
+}
+
+// withResolutionTimeout bounds ctx by timeout, if there is one.
+func withResolutionTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// resolveTimeoutError reports a resolution cut short by its deadline,
+// with how far it got.
+type resolveTimeoutError struct {
+	timeout  time.Duration
+	resolved int
+	err      error
+}
+
+func (e *resolveTimeoutError) Error() string {
+	return fmt.Sprintf("resolution timed out after %s with %d dependencies resolved; "+
+		"retry with partialOnTimeout=true for the partial tree", e.timeout, e.resolved)
+}
+
+func (e *resolveTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// timedOut describes err as a timeout if the resolution's deadline passed.
+func (res *resolver) timedOut(err error, timeout time.Duration) error {
+	if timeout <= 0 || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &resolveTimeoutError{timeout: timeout, resolved: res.log.count(), err: err}
+}
@@ -0,0 +1,105 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func slowRegistry(t *testing.T) *registryServer {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0"})},
+		"a":   {"1.0.0": {}},
+		"b":   {"1.0.0": {}},
+	})
+	registry.delay = 100 * time.Millisecond
+	return registry
+}
+
+func TestPartialOnTimeout(t *testing.T) {
+	registry := slowRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResolveTimeout(250*time.Millisecond)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?partialOnTimeout=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	var body struct {
+		api.NpmPackageVersion
+		Truncated       bool   `json:"truncated"`
+		TruncatedReason string `json:"truncatedReason"`
+		Unresolved      []struct {
+			Name       string `json:"name"`
+			Constraint string `json:"constraint"`
+			Parent     string `json:"parent"`
+		} `json:"unresolved"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, "app", body.Name)
+	assert.Equal(t, "1.0.0", body.Version)
+	assert.True(t, body.Truncated)
+	assert.Equal(t, "timeout", body.TruncatedReason)
+	require.Len(t, body.Unresolved, 2)
+	assert.Equal(t, "a", body.Unresolved[0].Name)
+	assert.Equal(t, "^1.0.0", body.Unresolved[0].Constraint)
+	assert.Equal(t, "app@1.0.0", body.Unresolved[0].Parent)
+	assert.Equal(t, "timeout", body.Dependencies["b"].Unresolved)
+}
+
+func TestTimeoutWithoutPartialFails(t *testing.T) {
+	registry := slowRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResolveTimeout(250*time.Millisecond)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/package/app/1.0.0")
+	assert.Equal(t, http.StatusGatewayTimeout, p.Status)
+	assert.Equal(t, "/problems/resolution-timeout", p.Type)
+	assert.Contains(t, p.Detail, "resolution timed out after 250ms")
+	require.NotNil(t, p.Resolved)
+	assert.Zero(t, *p.Resolved)
+}
+
+func TestTimeoutQueryParameter(t *testing.T) {
+	registry := slowRegistry(t)
+	registry.delay = 300 * time.Millisecond
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithResolveTimeout(500*time.Millisecond)))
+	defer server.Close()
+
+	status := func(query string) int {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0" + query)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusGatewayTimeout, status("?timeout=150ms"))
+	// A client can shorten the server's limit but not extend it.
+	assert.Equal(t, http.StatusGatewayTimeout, status("?timeout=1h"))
+	assert.Equal(t, http.StatusBadRequest, status("?timeout=soon"))
+}
+
+func TestClientCancellationStopsResolution(t *testing.T) {
+	registry := slowRegistry(t)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/package/app/1.0.0", nil)
+	require.Nil(t, err)
+	_, err = server.Client().Do(req)
+	require.NotNil(t, err)
+
+	// The whole tree takes six requests; the walk stops at the second.
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, []string{"/app", "/app/1.0.0"}, registry.Requests())
+}
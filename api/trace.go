@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type traceEntry struct {
+	URL    string `json:"url"`
+	Cached bool   `json:"cached"`
+}
+
+// fetchTrace records, in order, every registry URL a resolution needed.
+type fetchTrace struct {
+	mu      sync.Mutex
+	entries []traceEntry
+}
+
+type fetchTraceKey struct{}
+
+func withFetchTrace(ctx context.Context) (context.Context, *fetchTrace) {
+	trace := &fetchTrace{entries: []traceEntry{}}
+	return context.WithValue(ctx, fetchTraceKey{}, trace), trace
+}
+
+// traceFetches starts recording the registry fetches of res when r asks
+// for a trace, and returns the context the resolution must use.
+func (res *resolver) traceFetches(ctx context.Context, r *http.Request) context.Context {
+	if r.URL.Query().Get("trace") == "true" {
+		ctx, res.trace = withFetchTrace(ctx)
+	}
+	return ctx
+}
+
+// recordFetch appends url to the trace carried by ctx, if any.
+func recordFetch(ctx context.Context, url string, cached bool) {
+	trace, ok := ctx.Value(fetchTraceKey{}).(*fetchTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.entries = append(trace.entries, traceEntry{URL: url, Cached: cached})
+}
+
+func (t *fetchTrace) list() []traceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]traceEntry(nil), t.entries...)
+}
@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type traceEntry struct {
+	URL    string `json:"url"`
+	Cached bool   `json:"cached"`
+}
+
+func TestTrace(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"lib": "^1.0.0"})},
+		"lib": {"1.4.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	getTrace := func() []traceEntry {
+		resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?trace=true")
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body struct {
+			Name  string       `json:"name"`
+			Trace []traceEntry `json:"trace"`
+		}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "app", body.Name)
+		return body.Trace
+	}
+
+	assert.Equal(t, []traceEntry{
+		{URL: registry.URL + "/app"},
+		{URL: registry.URL + "/app/1.0.0"},
+		{URL: registry.URL + "/lib"},
+		{URL: registry.URL + "/lib/1.4.0"},
+	}, getTrace())
+
+	assert.Equal(t, []traceEntry{
+		{URL: registry.URL + "/app", Cached: true},
+		{URL: registry.URL + "/app/1.0.0", Cached: true},
+		{URL: registry.URL + "/lib", Cached: true},
+		{URL: registry.URL + "/lib/1.4.0", Cached: true},
+	}, getTrace())
+}
+
+func TestTraceOmittedByDefault(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]any
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotContains(t, body, "trace")
+}
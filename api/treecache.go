@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type treeCacheEntry struct {
+	tree     *NpmPackageVersion
+	expires  time.Time
+	packages []string
+}
+
+// treeCache holds resolved trees for a limited time. It records, for each
+// package name, the cached trees that include it, so that publishing a new
+// version of a package can evict every resolution it may have changed. A
+// nil treeCache caches nothing.
+type treeCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	entries    map[string]treeCacheEntry
+	dependents map[string]map[string]bool
+	hits       uint64
+	misses     uint64
+}
+
+func newTreeCache(ttl time.Duration) *treeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &treeCache{ttl: ttl, entries: map[string]treeCacheEntry{}, dependents: map[string]map[string]bool{}}
+}
+
+// treeCacheKey identifies a resolution by its root and every option that
+// shapes the resolved tree.
+func treeCacheKey(name, versionConstraint string, opts resolveOptions) string {
+	return strings.Join([]string{
+		name, versionConstraint, opts.stopAt, strings.Join(opts.excludeScopes, ","), opts.seed,
+		strconv.FormatBool(opts.requireIntegrity), strconv.Itoa(opts.maxDepth), strconv.FormatBool(opts.dev), opts.os, opts.cpu, string(opts.strategy),
+	}, "\x00")
+}
+
+func (c *treeCache) get(key string) (*NpmPackageVersion, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		c.misses++
+		c.remove(key)
+		return nil, false
+	}
+	c.hits++
+	return entry.tree, true
+}
+
+// set caches tree, which must not be modified afterwards.
+func (c *treeCache) set(key string, tree *NpmPackageVersion) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	entry := treeCacheEntry{tree: tree, expires: time.Now().Add(c.ttl), packages: treePackages(tree)}
+	c.entries[key] = entry
+	for _, name := range entry.packages {
+		if c.dependents[name] == nil {
+			c.dependents[name] = map[string]bool{}
+		}
+		c.dependents[name][key] = true
+	}
+}
+
+// treePackages returns the names of the packages in tree.
+func treePackages(tree *NpmPackageVersion) []string {
+	names := map[string]bool{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		names[pkg.Name] = true
+		for _, dep := range pkg.Dependencies {
+			walk(dep)
+		}
+	}
+	walk(tree)
+	return sortedKeys(names)
+}
+
+// invalidate evicts every cached tree that includes the named package and
+// returns how many were evicted.
+func (c *treeCache) invalidate(name string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := sortedKeys(c.dependents[name])
+	for _, key := range keys {
+		c.remove(key)
+	}
+	return len(keys)
+}
+
+// clear evicts every cached tree and returns how many there were.
+func (c *treeCache) clear() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = map[string]treeCacheEntry{}
+	c.dependents = map[string]map[string]bool{}
+	return n
+}
+
+func (c *treeCache) stats() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := newCacheStats(len(c.entries), c.hits, c.misses)
+	for _, entry := range c.entries {
+		stats.Bytes += int64(jsonSize(entry.tree))
+	}
+	return stats
+}
+
+// remove drops the entry for key and its reverse edges; c.mu must be held.
+func (c *treeCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	for _, name := range entry.packages {
+		delete(c.dependents[name], key)
+		if len(c.dependents[name]) == 0 {
+			delete(c.dependents, name)
+		}
+	}
+}
+
+type cacheInvalidateRequest struct {
+	Packages []string `json:"packages"`
+}
+
+type cacheInvalidateResponse struct {
+	Packages []string `json:"packages"`
+	Metadata int      `json:"metadata"`
+	Trees    int      `json:"trees"`
+	// SharedTrees counts the trees evicted from the shared cache.
+	SharedTrees int `json:"sharedTrees,omitempty"`
+}
+
+// cacheInvalidateHandler evicts the metadata of the named packages and
+// every cached tree that includes any of them, from memory, disk and the
+// shared cache.
+func (s *server) cacheInvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	var req cacheInvalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Packages) == 0 {
+		s.badRequest(w, r, "Expected a JSON body with a non-empty packages list")
+		return
+	}
+
+	resp := s.invalidatePackages(r.Context(), req.Packages)
+	s.logger.Info("Cache invalidated", "packages", req.Packages, "metadata", resp.Metadata, "trees", resp.Trees)
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// invalidatePackages evicts the metadata and version documents of the
+// named packages, and every tree that includes any of them, from every
+// cache.
+func (s *server) invalidatePackages(ctx context.Context, names []string) *cacheInvalidateResponse {
+	resp := &cacheInvalidateResponse{Packages: names}
+	for _, name := range names {
+		if s.metaCache.delete(name) {
+			resp.Metadata++
+		}
+		s.metaCache.versions.deletePrefix(name + "@")
+		if s.disk != nil {
+			s.disk.delete(metaKey(name))
+		}
+		resp.Trees += s.treeCache.invalidate(name)
+		resp.SharedTrees += s.invalidateShared(ctx, name)
+	}
+	return resp
+}
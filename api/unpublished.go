@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// exactVersion reports whether the constraint names a single version, as
+// lockfiles do, and returns it.
+func exactVersion(constraint string) (*semver.Version, bool) {
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(strings.TrimPrefix(constraint, "="), "v"))
+	return v, err == nil
+}
+
+// resolveVersion selects the version of a package and fetches its
+// manifest. With fallbackUnpublished set, an exact version that is no
+// longer available is replaced by a substitute, with a warning.
+func (res *resolver) resolveVersion(ctx context.Context, name, versionConstraint string, pkgMeta *npmPackageMetaResponse) (string, *npmPackageResponse, error) {
+	requested, exact := exactVersion(versionConstraint)
+	fallback := res.opts.fallbackUnpublished && exact
+
+	version, err := res.selectVersion(versionConstraint, pkgMeta)
+	if err != nil && fallback {
+		version, err = res.substituteUnpublished(name, requested, pkgMeta)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	npmPkg, err := res.fetchPackage(ctx, name, version)
+	if isNotFound(err) && fallback && version == requested.String() {
+		// Still listed in the packument, but the document is gone.
+		if version, err = res.substituteUnpublished(name, requested, pkgMeta); err == nil {
+			npmPkg, err = res.fetchPackage(ctx, name, version)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return version, npmPkg, nil
+}
+
+// substituteUnpublished picks a replacement for an unavailable version:
+// the highest other release of the same major, or else the latest release.
+func (res *resolver) substituteUnpublished(name string, requested *semver.Version, pkgMeta *npmPackageMetaResponse) (string, error) {
+	var best *semver.Version
+	for version := range pkgMeta.Versions {
+		v, err := semver.NewVersion(version)
+		if err != nil || v.Equal(requested) || v.Major() != requested.Major() || v.Prerelease() != "" {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+		}
+	}
+	substitute := pkgMeta.DistTags["latest"]
+	if best != nil {
+		substitute = best.String()
+	}
+	if substitute == "" || substitute == requested.String() {
+		return "", fmt.Errorf("%s@%s is not available and no substitute was found", name, requested)
+	}
+	res.warn(fmt.Sprintf("%s@%s is not available; substituted %s", name, requested, substitute))
+	return substitute, nil
+}
+
+// warn records a warning to return with the resolved tree. Warnings are
+// kept sorted, whatever order they were raised in.
+func (res *resolver) warn(msg string) {
+	res.logger.Warn(msg)
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	i, _ := slices.BinarySearch(res.warnings, msg)
+	res.warnings = slices.Insert(res.warnings, i, msg)
+}
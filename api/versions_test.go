@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestVersionedPaths(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":          {"1.0.0": deps(map[string]string{"@babel/core": "^7.0.0"})},
+		"@babel/core":  {"7.0.0": deps(map[string]string{"@babel/types": "^7.0.0"})},
+		"@babel/types": {"7.1.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	for _, path := range []string{"/v1/package/app/1.0.0", "/v1/package/@babel/core/7.0.0", "/v1/package/@babel%2Fcore/7.0.0"} {
+		resp, err := server.Client().Get(server.URL + path)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Empty(t, resp.Header.Get("Deprecation"), path)
+	}
+	assert.Equal(t, "7.1.0", getTreeFrom(t, server, "/v1/package/@babel/core/7.0.0").Dependencies["@babel/types"].Version)
+
+	// The unversioned paths answer as version 1 does, pointing at it.
+	legacy := getTreeFrom(t, server, "/package/app/1.0.0")
+	assert.Equal(t, getTreeFrom(t, server, "/v1/package/app/1.0.0"), legacy)
+	resp, err := server.Client().Get(server.URL + "/package/@babel/core/7.0.0/install-order")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+	assert.Equal(t, `</v1/package/@babel%2Fcore/7.0.0/install-order>; rel="successor-version"`, resp.Header.Get("Link"))
+
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/v1/package/app").Status)
+	assert.Equal(t, http.StatusBadRequest, getProblem(t, server, "/v2/package/app/1.0.0").Status)
+}
+
+func TestVersionedOpenAPIPaths(t *testing.T) {
+	server := httptest.NewServer(api.New())
+	defer server.Close()
+
+	doc := getOpenAPI(t, server)
+	assert.Contains(t, doc.Paths, "/v1/package/{package}/{version}")
+	assert.Contains(t, doc.Paths, "/v1/compare")
+	assert.NotContains(t, doc.Paths, "/v1/metrics")
+	assert.True(t, doc.Paths["/package/{package}/{version}"]["get"].Deprecated)
+	assert.False(t, doc.Paths["/v1/package/{package}/{version}"]["get"].Deprecated)
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	defaultOSVURL = "https://api.osv.dev"
+	// osvBatchSize is the most queries OSV accepts in one batch.
+	osvBatchSize = 1000
+	// osvConcurrency caps the vulnerability records fetched at once.
+	osvConcurrency = 8
+	// Scanned package versions are cached for auditCacheTTL, as OSV adds
+	// new vulnerabilities over time.
+	auditCacheTTL  = time.Hour
+	auditCacheSize = 10000
+)
+
+// severities are the severities of vulnerabilities, from least to most
+// severe, as npm audit names them.
+var severities = []string{"unknown", "info", "low", "moderate", "high", "critical"}
+
+// vulnerability is a known vulnerability of a package version. FixedIn is
+// the lowest later version no longer affected, if there is one.
+type vulnerability struct {
+	ID       string `json:"id"`
+	Summary  string `json:"summary,omitempty"`
+	Severity string `json:"severity"`
+	FixedIn  string `json:"fixedIn,omitempty"`
+}
+
+// auditSummary counts the vulnerabilities of a tree's package versions by
+// severity; Severity is the highest among them.
+type auditSummary struct {
+	Total      int            `json:"total"`
+	Severity   string         `json:"severity,omitempty"`
+	Severities map[string]int `json:"severities"`
+}
+
+// osvVulnerability is the part of an OSV vulnerability record the audit
+// reads.
+type osvVulnerability struct {
+	ID       string `json:"id"`
+	Summary  string `json:"summary"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Fixed string `json:"fixed"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvQuery struct {
+	Package   osvPackage `json:"package"`
+	Version   string     `json:"version"`
+	PageToken string     `json:"page_token,omitempty"`
+}
+
+type osvPackage struct {
+	Name      string `json:"name"`
+	Ecosystem string `json:"ecosystem"`
+}
+
+type osvBatchResponse struct {
+	Results []struct {
+		Vulns []struct {
+			ID string `json:"id"`
+		} `json:"vulns"`
+		NextPageToken string `json:"next_page_token"`
+	} `json:"results"`
+}
+
+// auditTree scans the tree for known vulnerabilities when the request asks
+// for it with ?audit=true, recording them for the response. It writes a
+// 502 problem, and reports that it did, if the scan fails.
+func (s *server) auditTree(w http.ResponseWriter, r *http.Request, root *NpmPackageVersion, res *resolver) bool {
+	if r.URL.Query().Get("audit") != "true" {
+		return false
+	}
+	vulnerabilities, err := s.scanVulnerabilities(r.Context(), root)
+	if err != nil {
+		s.logger.Error("vulnerability scan failed", "package", root.Name, "version", root.Version, "error", err)
+		s.writeProblem(w, r, newProblem(problemAuditFailed, http.StatusBadGateway, err.Error()))
+		return true
+	}
+	res.vulnerabilities = vulnerabilities
+	return false
+}
+
+// VulnerabilitySource is the database trees are scanned for known
+// vulnerabilities against.
+type VulnerabilitySource string
+
+const (
+	// VulnerabilitySourceOSV queries the OSV API.
+	VulnerabilitySourceOSV VulnerabilitySource = "osv"
+	// VulnerabilitySourceNpm queries the bulk advisory endpoint of each
+	// package's registry, as npm audit does, for registries that mirror
+	// advisories.
+	VulnerabilitySourceNpm VulnerabilitySource = "npm"
+)
+
+// scanVulnerabilities returns the known vulnerabilities of the tree's
+// package versions by name@version, querying the vulnerability source for
+// those not cached.
+func (s *server) scanVulnerabilities(ctx context.Context, root *NpmPackageVersion) (map[string][]vulnerability, error) {
+	packages, _ := bomPackages(root)
+	found := map[string][]vulnerability{}
+	var uncached []*NpmPackageVersion
+	for _, id := range sortedKeys(packages) {
+		pkg := packages[id]
+		if pkg.Name == "" || pkg.Version == "" {
+			continue
+		}
+		if vulns, ok := s.auditCache.get(id); ok {
+			found[id] = vulns
+			continue
+		}
+		uncached = append(uncached, pkg)
+	}
+	if len(uncached) == 0 {
+		return found, nil
+	}
+
+	var (
+		scanned map[string][]vulnerability
+		err     error
+	)
+	switch s.vulnerabilitySource {
+	case VulnerabilitySourceNpm:
+		scanned, err = s.npmAdvisories(ctx, uncached)
+	default:
+		scanned, err = s.osvVulnerabilities(ctx, uncached)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range uncached {
+		key := pkg.Name + "@" + pkg.Version
+		vulns := scanned[key]
+		if vulns == nil {
+			vulns = []vulnerability{}
+		}
+		s.auditCache.set(key, vulns, auditCacheTTL)
+		found[key] = vulns
+	}
+	return found, nil
+}
+
+// osvVulnerabilities returns the vulnerabilities OSV knows of for each
+// package version, by name@version.
+func (s *server) osvVulnerabilities(ctx context.Context, packages []*NpmPackageVersion) (map[string][]vulnerability, error) {
+	queries := make([]osvQuery, len(packages))
+	for i, pkg := range packages {
+		queries[i] = osvQuery{Package: osvPackage{Name: pkg.Name, Ecosystem: "npm"}, Version: pkg.Version}
+	}
+	ids, err := s.queryOSV(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.fetchOSVRecords(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	vulnerabilities := map[string][]vulnerability{}
+	for _, pkg := range packages {
+		key := pkg.Name + "@" + pkg.Version
+		for _, id := range ids[key] {
+			vulnerabilities[key] = append(vulnerabilities[key], newVulnerability(records[id], pkg.Name, pkg.Version))
+		}
+	}
+	return vulnerabilities, nil
+}
+
+// queryOSV asks OSV which vulnerabilities affect each queried package
+// version, in batches, following the pages of versions with many. It
+// returns their IDs by name@version.
+func (s *server) queryOSV(ctx context.Context, queries []osvQuery) (map[string][]string, error) {
+	ids := map[string][]string{}
+	for len(queries) > 0 {
+		batch := queries[:min(len(queries), osvBatchSize)]
+		queries = queries[len(batch):]
+
+		var resp osvBatchResponse
+		if err := s.osvRequest(ctx, http.MethodPost, "/v1/querybatch", map[string]any{"queries": batch}, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Results) != len(batch) {
+			return nil, fmt.Errorf("OSV answered %d queries with %d results", len(batch), len(resp.Results))
+		}
+		for i, result := range resp.Results {
+			key := batch[i].Package.Name + "@" + batch[i].Version
+			for _, v := range result.Vulns {
+				ids[key] = append(ids[key], v.ID)
+			}
+			if result.NextPageToken != "" {
+				next := batch[i]
+				next.PageToken = result.NextPageToken
+				queries = append(queries, next)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// fetchOSVRecords fetches the record of each vulnerability, as the batch
+// query only returns their IDs.
+func (s *server) fetchOSVRecords(ctx context.Context, ids map[string][]string) (map[string]*osvVulnerability, error) {
+	records := map[string]*osvVulnerability{}
+	for _, list := range ids {
+		for _, id := range list {
+			records[id] = nil
+		}
+	}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	sem := newSemaphore(osvConcurrency)
+	for _, id := range sortedKeys(records) {
+		if err := sem.acquire(ctx); err != nil {
+			mu.Lock()
+			firstErr = err
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			defer sem.release()
+			var record osvVulnerability
+			err := s.osvRequest(ctx, http.MethodGet, "/v1/vulns/"+url.PathEscape(id), nil, &record)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			records[id] = &record
+		}(id)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return records, nil
+}
+
+// osvRequest sends a request to the OSV API, encoding body, if any, as
+// JSON, and decodes the answer into out.
+func (s *server) osvRequest(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.osvURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	recordFetch(ctx, req.URL.String(), false)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OSV answered %s %s with status %d", method, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newVulnerability describes an OSV record as it affects a package
+// version.
+func newVulnerability(record *osvVulnerability, name, version string) vulnerability {
+	v := vulnerability{ID: record.ID, Summary: record.Summary, Severity: normalizeSeverity(record.DatabaseSpecific.Severity)}
+	installed, err := semver.NewVersion(version)
+	if err != nil {
+		return v
+	}
+	var fixedIn *semver.Version
+	for _, affected := range record.Affected {
+		if affected.Package.Ecosystem != "npm" || affected.Package.Name != name {
+			continue
+		}
+		for _, rng := range affected.Ranges {
+			for _, event := range rng.Events {
+				fixed, err := semver.NewVersion(event.Fixed)
+				if err != nil || !fixed.GreaterThan(installed) {
+					continue
+				}
+				if fixedIn == nil || fixed.LessThan(fixedIn) {
+					fixedIn = fixed
+				}
+			}
+		}
+	}
+	if fixedIn != nil {
+		v.FixedIn = fixedIn.String()
+	}
+	return v
+}
+
+// normalizeSeverity names a severity as npm audit does. GitHub advisories
+// call it MODERATE, others MEDIUM.
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	if severity == "medium" {
+		severity = "moderate"
+	}
+	if severityRank(severity) < 0 {
+		return "unknown"
+	}
+	return severity
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// summarizeVulnerabilities counts the vulnerabilities of each package
+// version once, however often it appears in the tree.
+func summarizeVulnerabilities(vulnerabilities map[string][]vulnerability) *auditSummary {
+	summary := &auditSummary{Severities: map[string]int{}}
+	for _, severity := range severities {
+		summary.Severities[severity] = 0
+	}
+	for _, vulns := range vulnerabilities {
+		for _, v := range vulns {
+			summary.Total++
+			summary.Severities[v.Severity]++
+			if severityRank(v.Severity) > severityRank(summary.Severity) {
+				summary.Severity = v.Severity
+			}
+		}
+	}
+	return summary
+}
+
+// withVulnerabilities returns a copy of the tree with each node's known
+// vulnerabilities attached.
+func withVulnerabilities(pkg *NpmPackageVersion, vulnerabilities map[string][]vulnerability) *NpmPackageVersion {
+	annotated := *pkg
+	annotated.Vulnerabilities = vulnerabilities[pkg.Name+"@"+pkg.Version]
+	annotated.Dependencies = make(map[string]*NpmPackageVersion, len(pkg.Dependencies))
+	for name, dep := range pkg.Dependencies {
+		annotated.Dependencies[name] = withVulnerabilities(dep, vulnerabilities)
+	}
+	return &annotated
+}
@@ -0,0 +1,319 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type vulnerability struct {
+	ID       string `json:"id"`
+	Summary  string `json:"summary"`
+	Severity string `json:"severity"`
+	FixedIn  string `json:"fixedIn"`
+}
+
+type auditSummary struct {
+	Total      int            `json:"total"`
+	Severity   string         `json:"severity"`
+	Severities map[string]int `json:"severities"`
+}
+
+// osvServer is a mock OSV API knowing the vulnerabilities of package
+// versions, by name@version, and counting the batch queries it answers.
+type osvServer struct {
+	*httptest.Server
+	batches atomic.Int32
+}
+
+var osvRecords = map[string]map[string]any{
+	"GHSA-lodash": {
+		"id": "GHSA-lodash", "summary": "Prototype pollution in lodash",
+		"affected": []map[string]any{{
+			"package": map[string]string{"ecosystem": "npm", "name": "lodash"},
+			"ranges":  []map[string]any{{"type": "SEMVER", "events": []map[string]string{{"introduced": "0"}, {"fixed": "4.17.21"}}}},
+		}},
+		"database_specific": map[string]string{"severity": "HIGH"},
+	},
+	"GHSA-minimist": {
+		"id": "GHSA-minimist", "summary": "Prototype pollution in minimist",
+		"affected": []map[string]any{{
+			"package": map[string]string{"ecosystem": "npm", "name": "minimist"},
+			"ranges": []map[string]any{{"type": "SEMVER", "events": []map[string]string{
+				{"introduced": "0"}, {"fixed": "0.2.4"}, {"introduced": "1.0.0"}, {"fixed": "1.2.6"},
+			}}},
+		}},
+		"database_specific": map[string]string{"severity": "CRITICAL"},
+	},
+	"OSV-minimist": {"id": "OSV-minimist", "database_specific": map[string]string{"severity": "MEDIUM"}},
+}
+
+func newOSVServer(t *testing.T, affected map[string][]string) *osvServer {
+	osv := &osvServer{}
+	osv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/querybatch":
+			osv.batches.Add(1)
+			var body struct {
+				Queries []struct {
+					Package struct {
+						Name      string `json:"name"`
+						Ecosystem string `json:"ecosystem"`
+					} `json:"package"`
+					Version string `json:"version"`
+				} `json:"queries"`
+			}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			results := []map[string]any{}
+			for _, q := range body.Queries {
+				assert.Equal(t, "npm", q.Package.Ecosystem)
+				vulns := []map[string]string{}
+				for _, id := range affected[q.Package.Name+"@"+q.Version] {
+					vulns = append(vulns, map[string]string{"id": id})
+				}
+				results = append(results, map[string]any{"vulns": vulns})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"results": results})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/vulns/"):
+			record, ok := osvRecords[strings.TrimPrefix(r.URL.Path, "/v1/vulns/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(record)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(osv.Close)
+	return osv
+}
+
+func auditRegistry(t *testing.T) *registryServer {
+	return newMockRegistry(t, mockRegistry{
+		"app":      {"1.0.0": deps(map[string]string{"lodash": "^4.17.0", "cli": "^1.0.0"})},
+		"cli":      {"1.0.0": deps(map[string]string{"minimist": "^1.2.0", "lodash": "^4.17.0"})},
+		"lodash":   {"4.17.20": {}},
+		"minimist": {"1.2.5": {}},
+	})
+}
+
+func TestAudit(t *testing.T) {
+	registry := auditRegistry(t)
+	osv := newOSVServer(t, map[string][]string{
+		"lodash@4.17.20": {"GHSA-lodash"},
+		"minimist@1.2.5": {"GHSA-minimist", "OSV-minimist"},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithOSVURL(osv.URL)))
+	defer server.Close()
+
+	type node struct {
+		Vulnerabilities []vulnerability `json:"vulnerabilities"`
+		Dependencies    map[string]node `json:"dependencies"`
+	}
+	var body struct {
+		node
+		Audit auditSummary `json:"audit"`
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?audit=true")
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+		resp.Body.Close()
+	}
+	// The second scan is answered from the cache.
+	assert.EqualValues(t, 1, osv.batches.Load())
+
+	lodash := []vulnerability{{ID: "GHSA-lodash", Summary: "Prototype pollution in lodash", Severity: "high", FixedIn: "4.17.21"}}
+	assert.Empty(t, body.Vulnerabilities)
+	assert.Equal(t, lodash, body.Dependencies["lodash"].Vulnerabilities)
+	assert.Equal(t, lodash, body.Dependencies["cli"].Dependencies["lodash"].Vulnerabilities)
+	assert.Equal(t, []vulnerability{
+		{ID: "GHSA-minimist", Summary: "Prototype pollution in minimist", Severity: "critical", FixedIn: "1.2.6"},
+		{ID: "OSV-minimist", Severity: "moderate"},
+	}, body.Dependencies["cli"].Dependencies["minimist"].Vulnerabilities)
+
+	// lodash is counted once, though it appears twice in the tree.
+	assert.Equal(t, auditSummary{
+		Total:      3,
+		Severity:   "critical",
+		Severities: map[string]int{"unknown": 0, "info": 0, "low": 0, "moderate": 1, "high": 1, "critical": 1},
+	}, body.Audit)
+}
+
+func TestAuditWithoutVulnerabilities(t *testing.T) {
+	registry := auditRegistry(t)
+	osv := newOSVServer(t, nil)
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithOSVURL(osv.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Post(server.URL+"/v1/resolve?audit=true", "application/json", strings.NewReader(`{"dependencies": {"lodash": "^4.17.0"}}`))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Audit *auditSummary `json:"audit"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotNil(t, body.Audit)
+	assert.Equal(t, 0, body.Audit.Total)
+	assert.Empty(t, body.Audit.Severity)
+}
+
+func TestAuditFailure(t *testing.T) {
+	registry := auditRegistry(t)
+	osv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer osv.Close()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL), api.WithOSVURL(osv.URL)))
+	defer server.Close()
+
+	p := getProblem(t, server, "/v1/package/app/1.0.0?audit=true")
+	assert.Equal(t, "/problems/audit-failed", p.Type)
+	assert.Equal(t, http.StatusBadGateway, p.Status)
+
+	// Without ?audit, OSV isn't consulted.
+	tree := getTreeFrom(t, server, "/v1/package/app/1.0.0")
+	assert.Equal(t, "app", tree.Name)
+}
+
+func TestAuditNpmAdvisories(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":      {"1.0.0": deps(map[string]string{"lodash": "4.17.20", "minimist": "~1.2.0"})},
+		"lodash":   {"4.17.20": {}, "4.17.21": {}, "5.0.0-beta.1": {}},
+		"minimist": {"1.2.0": {}},
+	})
+	// The registry mirrors advisories alongside the packages.
+	target, err := url.Parse(registry.URL)
+	require.Nil(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var bulk map[string][]string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/npm/v1/security/advisories/bulk" {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&bulk))
+		json.NewEncoder(w).Encode(map[string]any{
+			"lodash": []map[string]any{{
+				"id": 1106913, "url": "https://github.com/advisories/GHSA-35jh-r3h4-6jhm", "title": "Command Injection in lodash",
+				"severity": "high", "vulnerable_versions": "<4.17.21",
+			}},
+			"minimist": []map[string]any{
+				{"id": 1179, "url": "https://npmjs.com/advisories/1179", "title": "Prototype Pollution", "severity": "low", "vulnerable_versions": "<0.2.1 || >=1.0.0 <1.2.3"},
+				{"id": 1096, "title": "Affects only 0.x", "severity": "critical", "vulnerable_versions": "<1.0.0"},
+			},
+		})
+	}))
+	defer mirror.Close()
+	server := httptest.NewServer(api.New(api.WithRegistryURL(mirror.URL), api.WithVulnerabilitySource(api.VulnerabilitySourceNpm)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/app/1.0.0?audit=true")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Dependencies map[string]struct {
+			Vulnerabilities []vulnerability `json:"vulnerabilities"`
+		} `json:"dependencies"`
+		Audit auditSummary `json:"audit"`
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, map[string][]string{"app": {"1.0.0"}, "lodash": {"4.17.20"}, "minimist": {"1.2.0"}}, bulk)
+	// The fix is the next release, not a prerelease.
+	assert.Equal(t, []vulnerability{
+		{ID: "GHSA-35jh-r3h4-6jhm", Summary: "Command Injection in lodash", Severity: "high", FixedIn: "4.17.21"},
+	}, body.Dependencies["lodash"].Vulnerabilities)
+	// minimist has no release fixing the advisory, and 1096 doesn't affect
+	// the installed version.
+	assert.Equal(t, []vulnerability{{ID: "1179", Summary: "Prototype Pollution", Severity: "low"}}, body.Dependencies["minimist"].Vulnerabilities)
+	assert.Equal(t, 2, body.Audit.Total)
+	assert.Equal(t, "high", body.Audit.Severity)
+}
+
+func TestAuditNpmAdvisoriesFromFallbackRegistry(t *testing.T) {
+	private := newMockRegistry(t, mockRegistry{"@acme/lib": {"1.0.0": {}}})
+	public := newMockRegistry(t, mockRegistry{
+		"@acme/app": {"1.0.0": deps(map[string]string{"@acme/lib": "^1.0.0"})},
+	})
+	// Each registry answers for the packages fetched from it.
+	advisories := func(registry *registryServer, got *map[string][]string) *httptest.Server {
+		target, err := url.Parse(registry.URL)
+		require.Nil(t, err)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/-/npm/v1/security/advisories/bulk" {
+				proxy.ServeHTTP(w, r)
+				return
+			}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(got))
+			w.Write([]byte("{}"))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	var privateBulk, publicBulk map[string][]string
+	privateURL, publicURL := advisories(private, &privateBulk).URL, advisories(public, &publicBulk).URL
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(publicURL),
+		api.WithScopedRegistry("@acme", privateURL),
+		api.WithScopedFallback(true),
+		api.WithVulnerabilitySource(api.VulnerabilitySourceNpm),
+	))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/v1/package/@acme/app/1.0.0?audit=true")
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// @acme/app is only published publicly, so its advisories come from the
+	// public registry rather than the one for its scope.
+	assert.Equal(t, map[string][]string{"@acme/lib": {"1.0.0"}}, privateBulk)
+	assert.Equal(t, map[string][]string{"@acme/app": {"1.0.0"}}, publicBulk)
+}
+
+func TestAuditNpmAdvisoryServerErrorsOpenCircuitBreaker(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{"app": {"1.0.0": {}}})
+	target, err := url.Parse(registry.URL)
+	require.Nil(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	var posts atomic.Int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/npm/v1/security/advisories/bulk" {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		posts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+	server := httptest.NewServer(api.New(
+		api.WithRegistryURL(mirror.URL),
+		api.WithVulnerabilitySource(api.VulnerabilitySourceNpm),
+		api.WithCircuitBreaker(2, time.Minute),
+	))
+	defer server.Close()
+
+	for range 3 {
+		assert.Equal(t, http.StatusBadGateway, getProblem(t, server, "/v1/package/app/1.0.0?audit=true").Status)
+	}
+	// The two server errors open the registry's breaker, which turns away
+	// the third audit.
+	assert.Equal(t, int32(2), posts.Load())
+}
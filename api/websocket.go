@@ -0,0 +1,282 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of the WebSocket protocol (RFC 6455), as much of it as
+// a JSON request/response API needs: text messages, which may be
+// fragmented, pings and the closing handshake. Extensions and
+// subprotocols are not negotiated.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	// wsAcceptGUID is appended to the client's key to compute
+	// Sec-WebSocket-Accept.
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsMaxMessageSize bounds a message from the client; requests are
+	// small.
+	wsMaxMessageSize = 1 << 20
+	// wsMaxControlPayload bounds the payload of a control frame.
+	wsMaxControlPayload = 125
+	// wsIdleTimeout closes connections on which the client sends nothing,
+	// not even a ping, for so long.
+	wsIdleTimeout = 5 * time.Minute
+	// wsWriteTimeout bounds each write, so that a client that stops
+	// reading cannot hold the connection open.
+	wsWriteTimeout = 30 * time.Second
+	// wsCloseTimeout bounds the close frame sent before closing.
+	wsCloseTimeout = time.Second
+
+	wsCloseNormal      = 1000
+	wsCloseGoingAway   = 1001
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+// errWebSocketClosed is returned by readMessage once the client has closed
+// the connection.
+var errWebSocketClosed = errors.New("websocket closed")
+
+// wsProtocolError fails the connection with a close code.
+type wsProtocolError struct {
+	code   int
+	reason string
+}
+
+func (e *wsProtocolError) Error() string {
+	return fmt.Sprintf("websocket protocol error %d: %s", e.code, e.reason)
+}
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	// idleTimeout bounds the wait for each frame from the client, and
+	// writeTimeout each frame written to it.
+	idleTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// mu serializes writes, as pongs are sent while reading.
+	mu sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake of a WebSocket request
+// and takes over its connection. A request that is not a valid handshake
+// gets a 400 problem.
+func (s *server) upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, bool) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket"):
+		s.badRequest(w, r, "Expected a WebSocket upgrade request")
+		return nil, false
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		s.badRequest(w, r, "Unsupported WebSocket version: expected 13")
+		return nil, false
+	case key == "":
+		s.badRequest(w, r, "Missing Sec-WebSocket-Key")
+		return nil, false
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		s.logger.Error("Error taking over WebSocket connection", "error", err)
+		s.writeProblem(w, r, newProblem(problemInternal, http.StatusInternalServerError, ""))
+		return nil, false
+	}
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return &wsConn{conn: conn, rw: rw, idleTimeout: wsIdleTimeout, writeTimeout: wsWriteTimeout}, true
+}
+
+// headerContainsToken reports whether the comma-separated header name
+// lists token, case-insensitively.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text message from the client, answering
+// pings along the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code, "")
+			return nil, errWebSocketClosed
+		case wsOpBinary:
+			return nil, &wsProtocolError{wsCloseUnsupported, "binary messages are not supported"}
+		case wsOpText:
+			if fragmented {
+				return nil, &wsProtocolError{wsCloseProtocol, "expected a continuation frame"}
+			}
+		case wsOpContinuation:
+			if !fragmented {
+				return nil, &wsProtocolError{wsCloseProtocol, "unexpected continuation frame"}
+			}
+		default:
+			return nil, &wsProtocolError{wsCloseProtocol, fmt.Sprintf("unknown opcode %d", opcode)}
+		}
+		if len(message)+len(payload) > wsMaxMessageSize {
+			return nil, &wsProtocolError{wsCloseTooBig, "message too big"}
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+// readFrame reads one frame, unmasking its payload. Clients must mask
+// every frame.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	if c.idleTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout)); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "unmasked client frame"}
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	// Control frames may not be fragmented or carry more than 125 bytes
+	// (RFC 6455, section 5.5).
+	if opcode&0x8 != 0 && (!fin || length > wsMaxControlPayload) {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "invalid control frame"}
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, &wsProtocolError{wsCloseTooBig, "message too big"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes payload as one unmasked, final frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeFrameLocked(opcode, payload, c.writeTimeout)
+}
+
+// writeFrameLocked is writeFrame for callers holding c.mu, giving up after
+// timeout.
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// writeText sends data as a text message.
+func (c *wsConn) writeText(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+// close sends a close frame with code and closes the connection. The
+// frame is skipped if a write is under way, which may be blocked on a
+// client that stopped reading; closing the connection ends that write.
+func (c *wsConn) close(code int, reason string) {
+	if c.mu.TryLock() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		_ = c.writeFrameLocked(wsOpClose, append(payload, reason...), wsCloseTimeout)
+		c.mu.Unlock()
+	}
+	c.conn.Close()
+}
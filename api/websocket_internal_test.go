@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), idleTimeout: 10 * time.Millisecond}
+
+	_, err := conn.readMessage()
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
+
+func TestWebSocketWriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), writeTimeout: 10 * time.Millisecond}
+
+	// The client never reads, so the write gives up.
+	err := conn.writeText([]byte(`{"type":"progress"}`))
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
+
+func TestWebSocketCloseDuringBlockedWrite(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+
+	written := make(chan error)
+	go func() { written <- conn.writeText([]byte(`{"type":"progress"}`)) }()
+	time.Sleep(20 * time.Millisecond)
+	// A write blocked on a client that stopped reading holds the write
+	// lock; closing doesn't wait for it, and ends it.
+	closed := make(chan struct{})
+	go func() {
+		conn.close(wsCloseGoingAway, "idle timeout")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close waited for the blocked write")
+	}
+	assert.NotNil(t, <-written)
+}
@@ -0,0 +1,69 @@
+package api
+
+import "net/http"
+
+// maxWhyPaths bounds the paths a why query lists: a package deep in a
+// large tree can be reached along a great many.
+const maxWhyPaths = 1000
+
+type whyResponse struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Dependency string `json:"dependency"`
+	// Paths lead from the root to each occurrence of the dependency, both
+	// included.
+	Paths [][]packageRef `json:"paths"`
+	// Truncated is set when there were more than maxWhyPaths paths.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// dependencyPaths returns every path from root to a package named name,
+// stopping after limit paths, in the order of a depth-first walk.
+func dependencyPaths(root *NpmPackageVersion, name string, limit int) (paths [][]packageRef, truncated bool) {
+	var path []packageRef
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		if truncated {
+			return
+		}
+		path = append(path, packageRef{Name: pkg.Name, Version: pkg.Version})
+		defer func() { path = path[:len(path)-1] }()
+		if pkg.Name == name && len(path) > 1 {
+			if len(paths) == limit {
+				truncated = true
+				return
+			}
+			paths = append(paths, append([]packageRef(nil), path...))
+		}
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			if dep := pkg.Dependencies[key]; dep.Version != "" {
+				walk(dep)
+			}
+		}
+	}
+	walk(root)
+	return paths, truncated
+}
+
+// whyHandler answers why a package is in a tree: every path from the
+// root to it, for finding what pulls in a bloated or vulnerable
+// transitive dependency.
+func (s *server) whyHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	dependency := r.PathValue("dep")
+	paths, truncated := dependencyPaths(rootPkg, dependency, maxWhyPaths)
+	if paths == nil {
+		paths = [][]packageRef{}
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	body := &whyResponse{Name: rootPkg.Name, Version: rootPkg.Version, Dependency: dependency, Paths: paths, Truncated: truncated}
+	if s.writeJSON(w, status, body) {
+		s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "dependency", dependency, "paths", len(paths))
+	}
+}
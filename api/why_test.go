@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+type packageRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type whyResponse struct {
+	Dependency string         `json:"dependency"`
+	Paths      [][]packageRef `json:"paths"`
+}
+
+func getWhy(t *testing.T, server *httptest.Server, path string) *whyResponse {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var why whyResponse
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&why))
+	return &why
+}
+
+func TestWhy(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app":         {"1.0.0": deps(map[string]string{"a": "^1.0.0", "b": "^1.0.0", "@scope/vuln": "^1.0.0"})},
+		"a":           {"1.0.0": deps(map[string]string{"@scope/vuln": "^1.0.0"})},
+		"b":           {"1.0.0": deps(map[string]string{"c": "^1.0.0"})},
+		"c":           {"1.0.0": deps(map[string]string{"@scope/vuln": "~1.1.0"})},
+		"@scope/vuln": {"1.1.0": {}, "1.2.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	why := getWhy(t, server, "/v1/package/app/1.0.0/why/@scope/vuln")
+	assert.Equal(t, "@scope/vuln", why.Dependency)
+	assert.Equal(t, [][]packageRef{
+		{{"app", "1.0.0"}, {"@scope/vuln", "1.2.0"}},
+		{{"app", "1.0.0"}, {"a", "1.0.0"}, {"@scope/vuln", "1.2.0"}},
+		{{"app", "1.0.0"}, {"b", "1.0.0"}, {"c", "1.0.0"}, {"@scope/vuln", "1.1.0"}},
+	}, why.Paths)
+
+	assert.Equal(t, [][]packageRef{{{"app", "1.0.0"}, {"b", "1.0.0"}, {"c", "1.0.0"}}}, getWhy(t, server, "/v1/package/app/1.0.0/why/c").Paths)
+	assert.Empty(t, getWhy(t, server, "/v1/package/app/1.0.0/why/app").Paths)
+	assert.Empty(t, getWhy(t, server, "/v1/package/app/1.0.0/why/missing").Paths)
+}
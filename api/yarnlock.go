@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const yarnLockHeader = "# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.\n# yarn lockfile v1\n"
+
+// yarnLockEntry is one package version of a classic yarn.lock, under the
+// name@spec pairs that resolve to it.
+type yarnLockEntry struct {
+	specs                []string
+	pkg                  *NpmPackageVersion
+	dependencies         map[string]string
+	optionalDependencies map[string]string
+}
+
+// yarnLock serializes the tree as a classic (v1) yarn.lock: an entry for
+// every package version, keyed by every name@spec in the tree that
+// resolves to it. The root is the project itself and has no entry.
+func yarnLock(root *NpmPackageVersion) string {
+	entries := map[string]*yarnLockEntry{}
+	specs := map[string]bool{}
+	var walk func(pkg *NpmPackageVersion)
+	walk = func(pkg *NpmPackageVersion) {
+		for _, key := range sortedKeys(pkg.Dependencies) {
+			dep := pkg.Dependencies[key]
+			spec := key + "@" + dep.Spec
+			if !lockable(dep) || specs[spec] {
+				continue
+			}
+			specs[spec] = true
+			identity := dep.Name + "@" + dep.Version
+			if entry, ok := entries[identity]; ok {
+				entry.specs = append(entry.specs, spec)
+				continue
+			}
+			entry := &yarnLockEntry{specs: []string{spec}, pkg: dep}
+			for depKey, child := range dep.Dependencies {
+				if child.Optional && !dep.Optional {
+					entry.optionalDependencies = addRange(entry.optionalDependencies, depKey, child.Spec)
+				} else {
+					entry.dependencies = addRange(entry.dependencies, depKey, child.Spec)
+				}
+			}
+			entries[identity] = entry
+			walk(dep)
+		}
+	}
+	walk(root)
+
+	sorted := make([]*yarnLockEntry, 0, len(entries))
+	for _, entry := range entries {
+		sort.Strings(entry.specs)
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].specs[0] < sorted[j].specs[0] })
+	blocks := make([]string, len(sorted))
+	for i, entry := range sorted {
+		blocks[i] = entry.String()
+	}
+	return yarnLockHeader + "\n\n" + strings.Join(blocks, "\n")
+}
+
+func (e *yarnLockEntry) String() string {
+	keys := make([]string, len(e.specs))
+	for i, spec := range e.specs {
+		keys[i] = yarnQuote(spec)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", strings.Join(keys, ", "))
+	fmt.Fprintf(&b, "  version %s\n", yarnQuote(e.pkg.Version))
+	if dist := e.pkg.Dist; dist != nil && dist.Tarball != "" {
+		resolved := dist.Tarball
+		if dist.Shasum != "" {
+			resolved += "#" + dist.Shasum
+		}
+		fmt.Fprintf(&b, "  resolved %s\n", yarnQuote(resolved))
+		if integrity := distIntegrity(dist); integrity != "" {
+			fmt.Fprintf(&b, "  integrity %s\n", yarnQuote(integrity))
+		}
+	}
+	for _, section := range []struct {
+		name   string
+		ranges map[string]string
+	}{{"dependencies", e.dependencies}, {"optionalDependencies", e.optionalDependencies}} {
+		if len(section.ranges) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  %s:\n", section.name)
+		for _, name := range sortedKeys(section.ranges) {
+			fmt.Fprintf(&b, "    %s %s\n", yarnQuote(name), yarnQuote(section.ranges[name]))
+		}
+	}
+	return b.String()
+}
+
+var yarnNeedsQuotes = regexp.MustCompile(`^(true|false)|[:\s\\",\[\]]|^[^a-zA-Z]`)
+
+// yarnQuote quotes a key or value as yarn does: only when it could not be
+// read back unquoted.
+func yarnQuote(s string) string {
+	if yarnNeedsQuotes.MatchString(s) {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// yarnLockHandler answers ?format=yarn-lock with the resolved tree as a
+// classic yarn.lock.
+func (s *server) yarnLockHandler(w http.ResponseWriter, r *http.Request) {
+	rootPkg, res := s.resolveRequest(r.Context(), w, r)
+	if rootPkg == nil {
+		return
+	}
+	status := http.StatusOK
+	if len(res.unresolved) > 0 {
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(yarnLock(rootPkg))); err != nil {
+		s.logger.Error("Error writing response", "error", err)
+		return
+	}
+	s.logger.Info("Successfully handled request", "package", rootPkg.Name, "version", rootPkg.Version, "format", "yarn-lock")
+}
@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zen37/npm_packages/api"
+)
+
+func TestYarnLockFormat(t *testing.T) {
+	registry := newMockRegistry(t, mockRegistry{
+		"app": {"1.0.0": deps(map[string]string{"a": "^1.0.0", "@scope/c": "^2.0.0", "legacy": "npm:shared@1.2.0"})},
+		"a": {"1.0.0": manifest{
+			"dependencies":         map[string]string{"shared": "^1.0.0"},
+			"optionalDependencies": map[string]string{"fsevents": "^2.0.0"},
+			"dist":                 dist("a", "1.0.0"),
+		}},
+		"@scope/c": {"2.1.0": manifest{
+			"dependencies": map[string]string{"shared": "~1.2.0"},
+			"dist":         map[string]string{"tarball": "https://registry.test/@scope/c/-/c-2.1.0.tgz", "shasum": "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"},
+		}},
+		"shared":   {"1.2.0": {}},
+		"fsevents": {"2.3.0": {}},
+	})
+	server := httptest.NewServer(api.New(api.WithRegistryURL(registry.URL)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/package/app/1.0.0?format=yarn-lock")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert.Equal(t, `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@scope/c@^2.0.0":
+  version "2.1.0"
+  resolved "https://registry.test/@scope/c/-/c-2.1.0.tgz#0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"
+  integrity sha1-C+7Hteo/D9vJXQ3UfzxbwnXaijM=
+  dependencies:
+    shared "~1.2.0"
+
+a@^1.0.0:
+  version "1.0.0"
+  resolved "https://registry.test/a/-/a-1.0.0.tgz"
+  integrity sha512-a1.0.0
+  dependencies:
+    shared "^1.0.0"
+  optionalDependencies:
+    fsevents "^2.0.0"
+
+fsevents@^2.0.0:
+  version "2.3.0"
+
+"legacy@npm:shared@1.2.0", shared@^1.0.0, shared@~1.2.0:
+  version "1.2.0"
+`, string(body))
+}
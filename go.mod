@@ -2,11 +2,13 @@ module github.com/zen37/npm_packages
 
 go 1.22.5
 
-require github.com/Masterminds/semver/v3 v3.2.1
+require (
+	github.com/Masterminds/semver/v3 v3.2.1
+	github.com/stretchr/testify v1.9.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
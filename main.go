@@ -1,22 +1,322 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/zen37/npm_packages/api"
 )
 
 func main() {
-	handler := api.New()
+	var opts []api.Option
+	if os.Getenv("LOG_FORMAT") == "json" {
+		opts = append(opts, api.WithLogger(slog.New(slog.NewJSONHandler(os.Stdout, nil))))
+	}
+	if threshold := envInt("LOG_SAMPLE_THRESHOLD"); threshold > 0 {
+		opts = append(opts, api.WithLogSampling(threshold, envInt("LOG_SAMPLE_EVERY")))
+	}
+	if path := os.Getenv("NPMRC"); path != "" {
+		npmrc, err := readNpmrc(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, npmrc...)
+	}
+	// NPM_TOKEN authenticates with the default registries, for private
+	// packages in an organization's registry.
+	if token := os.Getenv("NPM_TOKEN"); token != "" {
+		opts = append(opts, api.WithRegistryToken("", token))
+	}
+	// REGISTRY_URL points at a registry other than registry.npmjs.org,
+	// such as Verdaccio, Nexus, Artifactory or an internal mirror. Further
+	// comma-separated URLs are mirrors, tried in order when the registry
+	// fails with a server error or times out.
+	primary, mirrors, _ := strings.Cut(os.Getenv("REGISTRY_URL"), ",")
+	primary = strings.TrimSpace(primary)
+	if primary != "" {
+		opts = append(opts, api.WithRegistryURL(primary))
+		for _, mirror := range strings.Split(mirrors, ",") {
+			if mirror = strings.TrimSpace(mirror); mirror != "" {
+				opts = append(opts, api.WithRegistryMirrors("", mirror))
+			}
+		}
+	}
+	// REGISTRY_FALLBACK_URLS lists, comma-separated, the registries a
+	// package or version missing from REGISTRY_URL is looked up in, in
+	// order, such as the public registry behind a private one.
+	if fallbacks := os.Getenv("REGISTRY_FALLBACK_URLS"); fallbacks != "" {
+		if primary == "" {
+			fmt.Println("REGISTRY_FALLBACK_URLS needs REGISTRY_URL")
+			os.Exit(1)
+		}
+		chain := []string{primary}
+		for _, fallback := range strings.Split(fallbacks, ",") {
+			if fallback = strings.TrimSpace(fallback); fallback != "" {
+				chain = append(chain, fallback)
+			}
+		}
+		opts = append(opts, api.WithRegistryURLs(chain...))
+	}
+	// Registry requests honour HTTP_PROXY, HTTPS_PROXY and NO_PROXY;
+	// REGISTRY_PROXY overrides them with a proxy for every request.
+	if proxy := os.Getenv("REGISTRY_PROXY"); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			fmt.Println("invalid REGISTRY_PROXY:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithProxy(proxyURL))
+	}
+	if timeout, ok := envDuration("REGISTRY_TIMEOUT"); ok {
+		opts = append(opts, api.WithRegistryTimeout(timeout))
+	}
+	// SCOPED_REGISTRIES routes scopes to their own registries, e.g.
+	// "@acme=https://npm.acme.test,@corp=https://npm.corp.test".
+	for _, route := range strings.Split(os.Getenv("SCOPED_REGISTRIES"), ",") {
+		if scope, url, ok := strings.Cut(strings.TrimSpace(route), "="); ok {
+			opts = append(opts, api.WithScopedRegistry(scope, url))
+		}
+	}
+	opts = append(opts, api.WithScopedFallback(os.Getenv("SCOPED_REGISTRY_FALLBACK") == "true"))
+	if n := envInt("REGISTRY_CONCURRENCY"); n > 0 {
+		opts = append(opts, api.WithConcurrency(n))
+	}
+	if n := envInt("RESOLVE_CONCURRENCY"); n > 0 {
+		opts = append(opts, api.WithResolveConcurrency(n))
+	}
+	// Transient registry failures are retried twice unless
+	// REGISTRY_RETRIES says otherwise.
+	retries := 2
+	if n, err := strconv.Atoi(os.Getenv("REGISTRY_RETRIES")); err == nil {
+		retries = n
+	}
+	backoff, _ := envDuration("REGISTRY_RETRY_BACKOFF")
+	opts = append(opts, api.WithRetries(retries, backoff))
+	if n := envInt("CIRCUIT_BREAKER_THRESHOLD"); n > 0 {
+		cooldown, ok := envDuration("CIRCUIT_BREAKER_COOLDOWN")
+		if !ok {
+			cooldown = 30 * time.Second
+		}
+		opts = append(opts, api.WithCircuitBreaker(n, cooldown))
+	}
+	if n := envInt("REGISTRY_RATE_LIMIT"); n > 0 {
+		opts = append(opts, api.WithRateLimit(float64(n)))
+	}
+
+	if n := envInt("MAX_UNIQUE_PACKAGES"); n > 0 {
+		opts = append(opts, api.WithMaxUniquePackages(n))
+	}
+	if n := envInt("TREE_FLATTEN_THRESHOLD"); n > 0 {
+		opts = append(opts, api.WithFlattenThreshold(n))
+	}
+	if n := envInt("REGISTRY_DOWNLOAD_BUDGET"); n > 0 {
+		opts = append(opts, api.WithDownloadBudget(int64(n)))
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("CACHE_SIZE")); err == nil {
+		opts = append(opts, api.WithCacheSize(n))
+	}
+	if timeout, ok := envDuration("RESOLVE_TIMEOUT"); ok {
+		opts = append(opts, api.WithResolveTimeout(timeout))
+	}
+	if ttl, ok := envDuration("CACHE_TTL"); ok {
+		opts = append(opts, api.WithCacheTTL(ttl))
+	}
+	if ttl, ok := envDuration("NOT_FOUND_CACHE_TTL"); ok {
+		opts = append(opts, api.WithNotFoundCacheTTL(ttl))
+	}
+	if ttl, ok := envDuration("RESULT_CACHE_TTL"); ok {
+		opts = append(opts, api.WithResultCacheTTL(ttl))
+	}
+	if rawURL := os.Getenv("REDIS_URL"); rawURL != "" {
+		addr, password, db, err := parseRedisURL(rawURL)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithRedisCache(addr, password, db))
+	}
+	if dir := os.Getenv("DISK_CACHE_DIR"); dir != "" {
+		ttl, _ := envDuration("DISK_CACHE_TTL")
+		opts = append(opts, api.WithDiskCache(dir, int64(envInt("DISK_CACHE_MAX_BYTES")), ttl))
+	}
+	minTTL, _ := envDuration("CACHE_TTL_MIN")
+	maxTTL, _ := envDuration("CACHE_TTL_MAX")
+	opts = append(opts, api.WithCacheTTLBounds(minTTL, maxTTL))
+
+	opts = append(opts, api.WithAdminToken(os.Getenv("ADMIN_TOKEN")))
+	opts = append(opts, api.WithProfiling(os.Getenv("ENABLE_PPROF") == "true"))
+	opts = append(opts, api.WithGraphQL(os.Getenv("ENABLE_GRAPHQL") == "true"))
+	if os.Getenv("VERSION_SELECTION") == "sort" {
+		opts = append(opts, api.WithSortedVersionSelection())
+	}
+	if strategy := os.Getenv("SELECTION_STRATEGY"); strategy != "" {
+		opts = append(opts, api.WithSelectionStrategy(api.SelectionStrategy(strategy)))
+	}
+	if path := os.Getenv("SELECTION_OVERRIDES_FILE"); path != "" {
+		overrides, err := readSelectionOverrides(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithSelectionOverrides(overrides))
+	}
+	// LICENSE_POLICY_FILE names a JSON file such as {"denied": ["GPL-*"]}
+	// that requests check their trees against with ?policy=.
+	if path := os.Getenv("LICENSE_POLICY_FILE"); path != "" {
+		policy, err := readLicensePolicy(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithLicensePolicy(policy))
+	}
+	// VULNERABILITY_SOURCE selects what ?audit=true scans against: osv, the
+	// default, or npm, the registries' bulk advisory endpoint.
+	switch source := api.VulnerabilitySource(os.Getenv("VULNERABILITY_SOURCE")); source {
+	case "":
+	case api.VulnerabilitySourceOSV, api.VulnerabilitySourceNpm:
+		opts = append(opts, api.WithVulnerabilitySource(source))
+	default:
+		fmt.Printf("invalid VULNERABILITY_SOURCE %q: expected osv or npm\n", source)
+		os.Exit(1)
+	}
+	if osvURL := os.Getenv("OSV_URL"); osvURL != "" {
+		opts = append(opts, api.WithOSVURL(osvURL))
+	}
+
+	handler, grpcHandler := api.NewWithGRPC(opts...)
 	port := os.Getenv("PORT") // Use environment variable for the port
 	if port == "" {
 		port = "3003" // Default to port ... if not set
 	}
+	// GRPC_PORT serves the gRPC service of proto/resolver.proto, and only
+	// it, on a second port, over TLS with GRPC_TLS_CERT and GRPC_TLS_KEY,
+	// as HTTP/2 needs.
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		cert, key := os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY")
+		if cert == "" || key == "" {
+			fmt.Println("GRPC_PORT needs GRPC_TLS_CERT and GRPC_TLS_KEY")
+			os.Exit(1)
+		}
+		go func() {
+			fmt.Printf("gRPC server running on 0.0.0.0:%s\n", grpcPort)
+			if err := http.ListenAndServeTLS(":"+grpcPort, cert, key, grpcHandler); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+	}
 	fmt.Printf("Server running on http://0.0.0.0:%s/\n", port)
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
+
+// envInt returns the integer value of the named environment variable, or
+// zero if it is unset or malformed.
+func envInt(name string) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// envDuration parses the named environment variable as a duration such as
+// "90s" and reports whether it was set and valid.
+func envDuration(name string) (time.Duration, bool) {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+// parseRedisURL parses a URL such as "redis://:password@host:6379/2" into
+// an address, password and database number.
+func parseRedisURL(rawURL string) (addr, password string, db int, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return "", "", 0, fmt.Errorf("invalid REDIS_URL %q", rawURL)
+	}
+	addr = u.Host
+	if u.Port() == "" {
+		addr += ":6379"
+	}
+	password, _ = u.User.Password()
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if db, err = strconv.Atoi(path); err != nil {
+			return "", "", 0, fmt.Errorf("invalid database in REDIS_URL %q", rawURL)
+		}
+	}
+	return addr, password, db, nil
+}
+
+// readNpmrc reads the registry settings of an .npmrc file: registry,
+// @scope:registry and //registry/:_authToken lines, with ${VAR}
+// references expanded from the environment. Other settings are ignored.
+func readNpmrc(path string) ([]api.Option, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var opts []api.Option
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("parsing %s: malformed line %q", path, line)
+		}
+		key, value = strings.TrimSpace(key), os.ExpandEnv(strings.Trim(strings.TrimSpace(value), `"`))
+		switch {
+		case key == "registry":
+			opts = append(opts, api.WithRegistryURL(value))
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry"):
+			opts = append(opts, api.WithScopedRegistry(strings.TrimSuffix(key, ":registry"), value))
+		case strings.HasPrefix(key, "//") && strings.HasSuffix(key, ":_authToken"):
+			opts = append(opts, api.WithRegistryToken(strings.TrimSuffix(key, ":_authToken"), value))
+		}
+	}
+	return opts, nil
+}
+
+// readSelectionOverrides reads a JSON object mapping package names to
+// selection strategies, such as {"lodash": "lowest", "react": "16.13.0"}.
+func readSelectionOverrides(path string) (map[string]api.SelectionStrategy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]api.SelectionStrategy
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// readLicensePolicy reads a license policy: a JSON object listing the
+// allowed and denied licenses.
+func readLicensePolicy(path string) (api.LicensePolicy, error) {
+	var policy api.LicensePolicy
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return policy, nil
+}